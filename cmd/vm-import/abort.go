@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- cancelling queued imports on abort ---------*/
+
+// queuedImport is an HC3 import task this run queued.
+type queuedImport struct {
+	vm, task, uuid string
+	cl             *cluster
+}
+
+var (
+	queuedMu sync.Mutex
+	queued   []queuedImport
+)
+
+// trackImport remembers a queued import, to offer cancelling it if the run
+// is interrupted before the cluster has finished it.
+func trackImport(vm, task, uuid string, cl *cluster) {
+	queuedMu.Lock()
+	defer queuedMu.Unlock()
+	queued = append(queued, queuedImport{vm, task, uuid, cl})
+}
+
+// abortImports runs as the interrupted run winds down: it asks each
+// cluster which of the imports this run queued are still going and, as
+// -abort-imports says or the operator answers, deletes the VMs they are
+// creating, which makes HC3 drop the import.
+func abortImports() {
+	if interrupted() == nil || *abortImportsFlag == "keep" {
+		return
+	}
+	queuedMu.Lock()
+	all := queued
+	queuedMu.Unlock()
+	if len(all) == 0 {
+		return
+	}
+	// runCtx is cancelled by now
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var running []queuedImport
+	for _, q := range all {
+		st, err := hc3Client(q.cl, checkTimeout).Task(ctx, q.task)
+		if err != nil {
+			slog.Warn("cannot check import task", "vm", q.vm, "cluster", q.cl.label(), "task", q.task, "err", err)
+			continue
+		}
+		if !st.State.Done() {
+			slog.Warn("HC3 import still running", "vm", q.vm, "cluster", q.cl.label(), "task", q.task, "uuid", q.uuid, "progress", st.ProgressPercent)
+			running = append(running, q)
+		}
+	}
+	if len(running) == 0 {
+		return
+	}
+
+	ok := *abortImportsFlag == "cancel"
+	if *abortImportsFlag == "ask" && interactive() {
+		promptMu.Lock()
+		fmt.Fprintf(stdout{}, "Cancel %d running import(s) and delete the VMs being created? (y/N): ", len(running))
+		resp, _ := stdin.ReadString('\n')
+		promptMu.Unlock()
+		ok = strings.HasPrefix(strings.ToLower(strings.TrimSpace(resp)), "y")
+	}
+	if !ok {
+		slog.Warn("leaving the imports to finish on HC3 (-abort-imports cancel drops them)")
+		return
+	}
+	for _, q := range running {
+		err := hc3Client(q.cl, hc3.DefaultTimeout).DeleteVM(ctx, q.uuid)
+		audit("cancel-import", q.vm, err, auditEntry{TaskTag: q.task, UUID: q.uuid})
+		if err != nil {
+			slog.Error("cannot cancel import", "vm", q.vm, "cluster", q.cl.label(), "task", q.task, "uuid", q.uuid, "err", err)
+			continue
+		}
+		slog.Info("import cancelled, partial VM deleted", "vm", q.vm, "cluster", q.cl.label(), "task", q.task, "uuid", q.uuid)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- OVF annotation ---------*/
+
+// sourceAnnotation returns the annotation of vm's OVF – VMware's notes
+// field, where teams keep owners and contacts – or "" without an OVF or
+// annotation.
+func sourceAnnotation(vm string) (string, error) {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return "", err
+	}
+	return strings.TrimSpace(env.Annotation), nil
+}
+
+// copyAnnotation waits for HC3 to finish the import q and appends note to
+// the new VM's description, unless it is there already, so what the
+// source's notes said is not lost in the migration.
+func copyAnnotation(ctx context.Context, q queuedImport, note string) error {
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	if err := c.WaitTask(ctx, q.task, taskPollInterval); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	v, err := c.VM(ctx, q.uuid)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(v.Description, note) {
+		return nil
+	}
+	desc := note
+	if d := strings.TrimSpace(v.Description); d != "" {
+		desc = d + "\n\n" + note
+	}
+	task, err := c.SetDescription(ctx, q.uuid, desc)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	audit("annotation", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return err
+	}
+	vmLog(q.vm).Info("📝 OVF annotation copied to the description", "uuid", q.uuid, "chars", len(note))
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*--------- -ansible ---------*/
+
+// With -ansible the only thing on stdout is one JSON object in the shape
+// Ansible expects from a module – changed, failed, msg – with the same per
+// VM under "vms", so the binary can be wrapped as a module. Logs and the
+// usual recap go to stderr. -n is Ansible's check mode: changed then says
+// what a real run would change.
+
+// ansibleVM is one VM's entry in the -ansible result.
+type ansibleVM struct {
+	Changed  bool     `json:"changed"`
+	Failed   bool     `json:"failed"`
+	Msg      string   `json:"msg"`
+	TaskTag  string   `json:"task_tag,omitempty"`
+	UUID     string   `json:"vm_uuid,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Class    string   `json:"error_class,omitempty"`
+}
+
+// ansibleResult is the object printed at the end of an -ansible run.
+type ansibleResult struct {
+	Changed bool                  `json:"changed"`
+	Failed  bool                  `json:"failed"`
+	Msg     string                `json:"msg"`
+	VMs     map[string]*ansibleVM `json:"vms,omitempty"`
+}
+
+var ansibleRuns struct {
+	sync.Mutex
+	m map[string]*ansibleVM
+}
+
+// modifyingSteps are the pipeline steps after which the staging dir or the
+// cluster has been changed.
+var modifyingSteps = map[string]bool{"delete": true, "copy": true, "tags": true, "clones": true, "import": true}
+
+// ansibleRecord notes the outcome of run r for the -ansible result.
+func ansibleRecord(r *runRecord, err error) {
+	if !*ansible {
+		return
+	}
+	v := &ansibleVM{Failed: err != nil, TaskTag: r.TaskTag, UUID: r.CreatedUUID, Warnings: r.Warnings, Class: r.ErrorClass}
+	if *dryRun {
+		v.Changed = err == nil
+	} else {
+		v.Changed = len(r.Disks) > 0
+		for _, s := range r.Steps {
+			v.Changed = v.Changed || modifyingSteps[s.Name]
+		}
+	}
+	switch {
+	case err != nil:
+		v.Msg = err.Error()
+	case *dryRun:
+		v.Msg = "would be staged"
+	default:
+		v.Msg = strings.TrimPrefix(summary(notification{VM: r.VM, Duration: r.End.Sub(r.Start), TaskTag: r.TaskTag, CreatedUUID: r.CreatedUUID}), "✅ ")
+	}
+	ansibleRuns.Lock()
+	defer ansibleRuns.Unlock()
+	if ansibleRuns.m == nil {
+		ansibleRuns.m = map[string]*ansibleVM{}
+	}
+	ansibleRuns.m[r.VM] = v
+}
+
+// printAnsible writes the -ansible result for the batch vms. VMs without a
+// recorded run were skipped as unchanged.
+func printAnsible(vms []string) {
+	ansibleRuns.Lock()
+	defer ansibleRuns.Unlock()
+	res := ansibleResult{VMs: map[string]*ansibleVM{}}
+	failed := 0
+	for _, vm := range vms {
+		v := ansibleRuns.m[vm]
+		if v == nil {
+			v = &ansibleVM{Msg: "unchanged since the last successful run"}
+		}
+		res.VMs[vm] = v
+		res.Changed = res.Changed || v.Changed
+		if v.Failed {
+			failed++
+		}
+	}
+	res.Failed = failed > 0
+	switch {
+	case failed > 0:
+		res.Msg = fmt.Sprintf("%d of %d VM(s) failed", failed, len(vms))
+	case len(vms) == 0:
+		res.Msg = "no VMs selected"
+	default:
+		res.Msg = fmt.Sprintf("%d VM(s) ok", len(vms))
+	}
+	writeAnsible(res)
+}
+
+// ansibleFatal reports a run that failed before any VM was processed,
+// with the key-value pairs of the fatal log record in msg.
+func ansibleFatal(msg string, args ...any) {
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "err" {
+			msg += fmt.Sprintf(": %v", args[i+1])
+		} else {
+			msg += fmt.Sprintf(" (%v=%v)", args[i], args[i+1])
+		}
+	}
+	writeAnsible(ansibleResult{Failed: true, Msg: msg})
+}
+
+func writeAnsible(res ansibleResult) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.Encode(res)
+}
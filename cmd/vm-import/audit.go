@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*--------- audit log ---------*/
+
+// auditEntry is one destructive operation as appended to the audit file:
+// a staged file deleted or rewritten, or an import started on HC3.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Operator string    `json:"operator"`
+	Host     string    `json:"host"`
+	Action   string    `json:"action"` // delete, overwrite-xml, restore-xml, undo, delta-sync, import
+	VM       string    `json:"vm"`
+	Paths    []string  `json:"paths,omitempty"`
+	TaskTag  string    `json:"taskTag,omitempty"`
+	UUID     string    `json:"uuid,omitempty"`
+	Outcome  string    `json:"outcome"` // ok or failed
+	Error    string    `json:"error,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// auditFile is -audit-log, else audit.jsonl in the state directory.
+func auditFile() (string, error) {
+	if *auditLog != "" {
+		return *auditLog, nil
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// operator names who is running the tool: the invoking user behind sudo,
+// else the current user.
+func operator() string {
+	if u := os.Getenv("SUDO_USER"); u != "" {
+		return u
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// audit records action on vm with its outcome err. The file is only ever
+// opened for appending; failing to write it is logged loudly but does not
+// stop the run. Dry runs change nothing and are not recorded.
+func audit(action, vm string, err error, e auditEntry) {
+	if *dryRun {
+		return
+	}
+	e.Time, e.Operator, e.Action, e.VM, e.Outcome = time.Now().UTC(), operator(), action, vm, "ok"
+	e.Host, _ = os.Hostname()
+	if err != nil {
+		e.Outcome, e.Error = "failed", err.Error()
+	}
+	if werr := appendAudit(e); werr != nil {
+		slog.Error("writing audit log", "action", action, "vm", vm, "err", werr)
+	}
+}
+
+func appendAudit(e auditEntry) error {
+	file, err := auditFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
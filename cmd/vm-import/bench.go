@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/transfer"
+)
+
+/*--------- bench: storage and API throughput ---------*/
+
+const (
+	// benchSize is how much bench reads from a source disk and writes to
+	// the staging dir in each measurement.
+	benchSize int64 = 1 << 30
+	// benchCalls is how many API requests bench times per cluster.
+	benchCalls = 20
+)
+
+// benchStreams are the numbers of parallel writes bench times, to show
+// whether more than one copy at a time pays off.
+var benchStreams = []int{1, 2, 4}
+
+// runBench implements "vm-import bench [flags]": it takes the flags of a
+// run and measures the paths a migration depends on – reading the largest
+// source disk in -ovadir, writing to the staging dir through its backend
+// with one and more streams at once, and the latency of each cluster's API
+// – so -parallel and -max-copies can be sized and cutover windows planned
+// from real numbers, with the run's -copy-buffer, -fsync-interval and
+// priority. What it writes is removed again.
+func runBench(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if err := setupContainer(); err != nil {
+		return fmt.Errorf("reading configuration from the environment: %w", err)
+	}
+	if err := setupLogging(); err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	var err error
+	if copyBuffer, err = parseSize(*copyBufFlag); err != nil {
+		return fmt.Errorf("-copy-buffer: %w", err)
+	}
+	if fsyncInterval, err = parseSize(*fsyncFlag); err != nil {
+		return fmt.Errorf("-fsync-interval: %w", err)
+	}
+	if err := setupPriority(); err != nil {
+		return err
+	}
+	trapSignals()
+	defer startDemo()()
+	if err := setupClusters(); err != nil {
+		return fmt.Errorf("setting up target clusters: %w", err)
+	}
+	if ova, err = newOVASource(); err != nil {
+		return fmt.Errorf("opening OVA source: %w", err)
+	}
+	if stage, err = newStager(); err != nil {
+		return fmt.Errorf("opening staging backend: %w", err)
+	}
+	defer stage.Close()
+
+	failed := 0
+	fmt.Fprintf(stdout{}, "read from %s\n", redact(*ovaDir))
+	readRate, err := benchRead()
+	if err != nil {
+		fmt.Fprintf(stdout{}, "  ❌ %v\n", err)
+		failed++
+	}
+	fmt.Fprintf(stdout{}, "write to %s\n", redact(*scaleDir))
+	var writeRate float64
+	for _, n := range benchStreams {
+		rate, err := benchWrite(n)
+		if err != nil {
+			fmt.Fprintf(stdout{}, "  ❌ %d stream(s): %v\n", n, err)
+			failed++
+			break
+		}
+		fmt.Fprintf(stdout{}, "  %d stream(s): %s/s\n", n, humanBytes(int64(rate)))
+		writeRate = max(writeRate, rate)
+	}
+	if err := interrupted(); err != nil {
+		return err
+	}
+	for _, cl := range allClusters() {
+		fmt.Fprintf(stdout{}, "API of cluster %s (%s)\n", cl.label(), cl.api())
+		if err := benchAPI(cl); err != nil {
+			fmt.Fprintf(stdout{}, "  ❌ %v\n", err)
+			failed++
+		}
+	}
+	rate := writeRate
+	if readRate < rate {
+		rate = readRate
+	}
+	if rate > 0 {
+		fmt.Fprintf(stdout{}, "\ncopying 1 TiB takes about %s at the slower of reading and writing\n",
+			time.Duration(float64(1<<40)/rate*float64(time.Second)).Round(time.Minute))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d measurement(s) failed", failed)
+	}
+	return nil
+}
+
+// benchRead reads up to benchSize of the largest source disk of the VMs
+// a run would offer, dropped from the page cache first where it can be,
+// and returns the rate in bytes a second.
+func benchRead() (float64, error) {
+	src, err := largestSourceDisk()
+	if err != nil {
+		return 0, err
+	}
+	in, err := ova.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	cached := " (may be read from the page cache)"
+	if f, ok := in.(*os.File); ok && canDropCache {
+		fadviseDontNeed(f, 0, 0)
+		cached = ""
+	}
+	t := time.Now()
+	n, err := io.Copy(io.Discard, transfer.ContextReader(runCtx, io.LimitReader(in, benchSize)))
+	d := time.Since(t)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%s is empty", src)
+	}
+	rate := float64(n) / max(d.Seconds(), 1e-3)
+	fmt.Fprintf(stdout{}, "  %s/s, %s of %s%s\n", humanBytes(int64(rate)), humanBytes(n), src, cached)
+	return rate, nil
+}
+
+// largestSourceDisk returns the largest source disk of -vms, else of the
+// exports in -ovadir.
+func largestSourceDisk() (string, error) {
+	var vms []string
+	if *vmsFlag != "" {
+		vms = strings.Split(*vmsFlag, ",")
+	} else {
+		dirs, err := vmDirs()
+		if err != nil {
+			return "", err
+		}
+		for _, d := range dirs {
+			if ok, _ := isExport(d); ok {
+				vms = append(vms, d)
+			}
+		}
+	}
+	var largest string
+	var largestSize int64 = -1
+	for _, vm := range vms {
+		vm = strings.TrimSpace(vm)
+		srcs, err := sourceDisks(vm)
+		if err != nil {
+			continue
+		}
+		for _, s := range srcs {
+			name := path.Join(vm, s)
+			if size, err := ova.Size(name); err == nil && size > largestSize {
+				largest, largestSize = name, size
+			}
+		}
+	}
+	if largest == "" {
+		return "", fmt.Errorf("no source disk to read in %s", *ovaDir)
+	}
+	return largest, nil
+}
+
+// benchWrite writes benchSize to the staging dir in n files at once,
+// removes them again and returns the rate of all together in bytes a
+// second.
+func benchWrite(n int) (float64, error) {
+	block := make([]byte, 1<<20)
+	rand.Read(block) // incompressible, so -compress does not flatter it
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	t := time.Now()
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := partName("vm-import-bench")
+			r := io.LimitReader(repeatReader(block), benchSize/int64(n))
+			errs[i] = stage.Put(name, transfer.ContextReader(runCtx, r))
+			if stage.Exists(name) {
+				stage.Remove(name)
+			}
+		}()
+	}
+	wg.Wait()
+	d := time.Since(t)
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return float64(benchSize) / max(d.Seconds(), 1e-3), nil
+}
+
+// repeatReader reads b over and over.
+type repeatReader []byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r)
+	}
+	return n, nil
+}
+
+// benchAPI times benchCalls logged-in requests to cl's API, one after the
+// other, and prints their latency.
+func benchAPI(cl *cluster) error {
+	c := hc3Client(cl, checkTimeout)
+	var took []time.Duration
+	for range benchCalls {
+		ctx, cancel := context.WithTimeout(runCtx, checkTimeout)
+		t := time.Now()
+		err := c.Ping(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		took = append(took, time.Since(t))
+	}
+	slices.Sort(took)
+	ms := func(d time.Duration) string { return d.Round(10 * time.Microsecond).String() }
+	fmt.Fprintf(stdout{}, "  %d requests: min %s, median %s, p95 %s, max %s\n", len(took),
+		ms(took[0]), ms(took[len(took)/2]), ms(took[len(took)*95/100]), ms(took[len(took)-1]))
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- disk capacity ---------*/
+
+// undersizedDisk is a disk of the Scale XML whose <capacity> is smaller
+// than the virtual size of the image staged for it.
+type undersizedDisk struct {
+	index int    // among the Scale XML's disks, which HC3 creates in order
+	uuid  string // the Scale disk's UUID
+	want  int64  // the staged image's virtual size
+}
+
+// undersizedDisks compares the virtual size of each qcow2 image staged for
+// vm with the capacity of its disk in the staged Scale XML name and
+// returns the disks HC3 would create too small, warning about each: the
+// guest would boot to a truncated file system. Images whose size cannot
+// be read (e.g. staged remotely) and disks without a <capacity> are
+// skipped.
+func undersizedDisks(vm, name string) ([]undersizedDisk, error) {
+	doc, err := readScaleXML(name)
+	if err != nil {
+		return nil, err
+	}
+	var out []undersizedDisk
+	i := 0
+	for _, d := range doc.FindAll("disk") {
+		if d.Attr("device") != "disk" && d.Attr("device") != "" {
+			continue
+		}
+		s, c := d.Find("source"), d.Find("capacity")
+		if s == nil || c == nil {
+			i++
+			continue
+		}
+		u := path.Base(s.Attr("name"))
+		have, _ := strconv.ParseInt(c.InnerText(), 10, 64)
+		if want := qcow2Size(path.Join(vm, u+".qcow2")); have > 0 && want > have {
+			vmLog(vm).Warn("Scale disk smaller than the staged image – growing it after the import",
+				"uuid", u, "capacity", humanBytes(have), "image", humanBytes(want))
+			out = append(out, undersizedDisk{i, u, want})
+		}
+		i++
+	}
+	return out, nil
+}
+
+// growDisks waits for HC3 to finish the import q and grows the new VM's
+// disks that undersizedDisks found too small, pairing them with HC3's
+// disks by slot order. A disk that cannot be paired or grown is warned
+// about, not failed on.
+func growDisks(ctx context.Context, q queuedImport, disks []undersizedDisk) error {
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	if err := c.WaitTask(ctx, q.task, taskPollInterval); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	v, err := c.VM(ctx, q.uuid)
+	if err != nil {
+		return err
+	}
+	var devs []hc3.BlockDevice
+	for _, d := range v.BlockDevs {
+		if d.Type != hc3.CDROM {
+			devs = append(devs, d)
+		}
+	}
+	sort.SliceStable(devs, func(i, j int) bool { return devs[i].Slot < devs[j].Slot })
+	lg := vmLog(q.vm)
+	for _, d := range disks {
+		if d.index >= len(devs) {
+			lg.Warn("cannot find the imported disk to grow – grow it by hand", "uuid", d.uuid, "to", humanBytes(d.want))
+			continue
+		}
+		dev := devs[d.index]
+		if dev.Capacity >= d.want {
+			continue
+		}
+		task, err := c.ResizeDisk(ctx, dev.UUID, d.want)
+		if err == nil {
+			err = c.WaitTask(ctx, task, taskPollInterval)
+		}
+		audit("grow-disk", q.vm, err, auditEntry{TaskTag: task, UUID: dev.UUID})
+		if err != nil {
+			lg.Warn("cannot grow the imported disk – grow it by hand", "disk", dev.UUID, "to", humanBytes(d.want), "err", err)
+			continue
+		}
+		lg.Info("⤢ disk grown", "disk", dev.UUID, "from", humanBytes(dev.Capacity), "to", humanBytes(d.want))
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*--------- incremental sync with changed block tracking ---------*/
+
+// cbtStateFile, in a VM's export directory, records the change ID of each
+// disk as of the snapshot its raw image there was last brought up to.
+const cbtStateFile = ".vm-import-cbt.json"
+
+// cbtSnapshot names the short-lived snapshot a CBT sync reads disks from.
+const cbtSnapshot = "vm-import-cbt"
+
+// cbtChunk is the most read from a datastore in one request.
+const cbtChunk = 64 << 20
+
+type cbtState struct {
+	Disks map[string]string `json:"disks"` // device key → change ID
+}
+
+// vsDisk is a VirtualDisk of a VM or snapshot's config.hardware.device.
+type vsDisk struct {
+	Type     string `xml:"type,attr"`
+	Key      string `xml:"key"`
+	Capacity int64  `xml:"capacityInBytes"`
+	Backing  struct {
+		FileName string `xml:"fileName"`
+		ChangeID string `xml:"changeId"`
+	} `xml:"backing"`
+}
+
+type diskArea struct {
+	Start  int64 `xml:"start"`
+	Length int64 `xml:"length"`
+}
+
+// syncCBT brings the raw disk images in dir up to date with the named VM,
+// which may be running, for seed copies and a short final sync at
+// cutover: it turns on changed block tracking if needed, snapshots the VM,
+// reads the blocks changed since the change IDs of the last sync (all
+// allocated blocks the first time) from the snapshot's frozen disks
+// through the datastore's HTTP interface, writes them into the images,
+// and removes the snapshot again. The images are <vm>-diskN.raw next to a
+// generated OVF, converted to qcow2 when staged.
+func (c *vsphereClient) syncCBT(name, dir string) error {
+	vm, err := c.findVM(name, "config.changeTrackingEnabled")
+	if err != nil {
+		return err
+	}
+	if vm.prop("config.changeTrackingEnabled") != "true" {
+		slog.Info("enabling changed block tracking", "vm", name)
+		var res struct {
+			Returnval moRef `xml:"Body>ReconfigVM_TaskResponse>returnval"`
+		}
+		req := fmt.Sprintf(`<ReconfigVM_Task xmlns="urn:vim25">%s<spec><changeTrackingEnabled>true</changeTrackingEnabled></spec></ReconfigVM_Task>`, this(vm.Obj))
+		if err := c.call(runCtx, req, &res); err != nil {
+			return fmt.Errorf("enable changed block tracking: %w", err)
+		}
+		if _, err := c.waitTask(res.Returnval); err != nil {
+			return fmt.Errorf("enable changed block tracking: %w", err)
+		}
+	}
+	dc, err := c.datacenterOf(vm.Obj)
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Returnval moRef `xml:"Body>CreateSnapshot_TaskResponse>returnval"`
+	}
+	req := fmt.Sprintf(`<CreateSnapshot_Task xmlns="urn:vim25">%s<name>%s</name><description>changed block sync by vm-import</description><memory>false</memory><quiesce>false</quiesce></CreateSnapshot_Task>`,
+		this(vm.Obj), cbtSnapshot)
+	if err := c.call(runCtx, req, &res); err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	id, err := c.waitTask(res.Returnval)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	snap := moRef{Type: "VirtualMachineSnapshot", Value: id}
+	defer c.removeSnapshot(name, snap)
+
+	objs, err := c.retrieve(fmt.Sprintf(`<propSet><type>VirtualMachineSnapshot</type><pathSet>config.hardware.device</pathSet></propSet>`+
+		`<objectSet><obj type="VirtualMachineSnapshot">%s</obj><skip>false</skip></objectSet>`, xmlText(snap.Value)))
+	if err != nil {
+		return err
+	}
+	if len(objs) == 0 {
+		return fmt.Errorf("snapshot %s vanished", snap.Value)
+	}
+	var devs struct {
+		Devices []vsDisk `xml:"VirtualDevice"`
+	}
+	if err := xml.Unmarshal([]byte("<d>"+objs[0].prop("config.hardware.device")+"</d>"), &devs); err != nil {
+		return fmt.Errorf("read the snapshot's disks: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	old := readCBTState(dir)
+	state := cbtState{Disks: map[string]string{}}
+	var files strings.Builder
+	n := 0
+	for _, d := range devs.Devices {
+		if d.Type != "VirtualDisk" {
+			continue
+		}
+		n++
+		file := fmt.Sprintf("%s-disk%d.raw", name, n)
+		dst := filepath.Join(dir, file)
+		since := "*"
+		if id := old.Disks[d.Key]; id != "" && fileExists(dst) {
+			since = id
+		}
+		pulled, err := c.pullChanges(vm.Obj, snap, d, dc, dst, since)
+		if err != nil && since != "*" {
+			slog.Warn("cannot sync only the changed blocks – reading the whole disk", "vm", name, "file", file, "err", err)
+			since = "*"
+			pulled, err = c.pullChanges(vm.Obj, snap, d, dc, dst, since)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if since == "*" {
+			slog.Info("✓ disk read", "vm", name, "file", file, "bytes", pulled)
+		} else {
+			slog.Info("Δ changed blocks synced", "vm", name, "file", file, "bytes", pulled, "of", d.Capacity)
+		}
+		state.Disks[d.Key] = d.Backing.ChangeID
+		fmt.Fprintf(&files, `<ovfFiles><deviceId>%s</deviceId><path>%s</path><size>%d</size></ovfFiles>`,
+			xmlText(d.Key), xmlText(file), d.Capacity)
+	}
+	if n == 0 {
+		return fmt.Errorf("the snapshot has no disks")
+	}
+	if err := c.writeDescriptor(vm.Obj, name, dir, files.String()); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(state, "", "  ")
+	return os.WriteFile(filepath.Join(dir, cbtStateFile), b, 0o644)
+}
+
+// readCBTState returns the change IDs recorded in dir, none if there are
+// none or they cannot be read.
+func readCBTState(dir string) cbtState {
+	var st cbtState
+	if b, err := os.ReadFile(filepath.Join(dir, cbtStateFile)); err == nil {
+		json.Unmarshal(b, &st)
+	}
+	return st
+}
+
+// pullChanges writes the areas of disk d that changed since the change ID
+// since ("*": all allocated areas, into an emptied image) into the raw
+// image dst, reading them from the disk's file as of snapshot snap, and
+// returns how many bytes it read.
+func (c *vsphereClient) pullChanges(vm, snap moRef, d vsDisk, dc, dst, since string) (int64, error) {
+	src, err := c.datastoreURL(dc, d.Backing.FileName)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if since == "*" {
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+	}
+	if err := f.Truncate(d.Capacity); err != nil {
+		return 0, err
+	}
+	var pulled int64
+	for off := int64(0); off < d.Capacity; {
+		var res struct {
+			Returnval struct {
+				StartOffset int64      `xml:"startOffset"`
+				Length      int64      `xml:"length"`
+				Areas       []diskArea `xml:"changedArea"`
+			} `xml:"Body>QueryChangedDiskAreasResponse>returnval"`
+		}
+		req := fmt.Sprintf(`<QueryChangedDiskAreas xmlns="urn:vim25">%s<snapshot type="VirtualMachineSnapshot">%s</snapshot><deviceKey>%s</deviceKey><startOffset>%d</startOffset><changeId>%s</changeId></QueryChangedDiskAreas>`,
+			this(vm), xmlText(snap.Value), xmlText(d.Key), off, xmlText(since))
+		if err := c.call(runCtx, req, &res); err != nil {
+			return pulled, fmt.Errorf("query changed areas: %w", err)
+		}
+		for _, a := range res.Returnval.Areas {
+			// in pieces, each read well within the client's timeout
+			for end := a.Start + a.Length; a.Start < end; a.Start += cbtChunk {
+				piece := diskArea{a.Start, end - a.Start}
+				if piece.Length > cbtChunk {
+					piece.Length = cbtChunk
+				}
+				n, err := c.fetchRange(src, piece, io.NewOffsetWriter(f, piece.Start))
+				pulled += n
+				if err != nil {
+					return pulled, err
+				}
+			}
+		}
+		if res.Returnval.Length <= 0 {
+			break
+		}
+		off = res.Returnval.StartOffset + res.Returnval.Length
+	}
+	return pulled, f.Close()
+}
+
+// datastoreURL returns the HTTP URL of the flat extent of the VMDK file, a
+// "[datastore] dir/disk.vmdk" path, in datacenter dc.
+func (c *vsphereClient) datastoreURL(dc, file string) (string, error) {
+	ds, p, ok := strings.Cut(strings.TrimPrefix(file, "["), "] ")
+	if !ok || !strings.HasSuffix(p, ".vmdk") {
+		return "", fmt.Errorf("unexpected disk file %q", file)
+	}
+	u := url.URL{Scheme: c.sdk.Scheme, Host: c.sdk.Host, Path: "/folder/" + strings.TrimSuffix(p, ".vmdk") + "-flat.vmdk"}
+	u.RawQuery = url.Values{"dcPath": {dc}, "dsName": {ds}}.Encode()
+	return u.String(), nil
+}
+
+// fetchRange copies area a of the datastore file at src to w.
+func (c *vsphereClient) fetchRange(src string, a diskArea, w io.Writer) (int64, error) {
+	req, err := http.NewRequestWithContext(runCtx, "GET", src, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", a.Start, a.Start+a.Length-1))
+	resp, err := c.dl.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("read disk area: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("read disk area: HTTP %d (the datastore must serve the disk's -flat.vmdk)", resp.StatusCode)
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err == nil && n != a.Length {
+		err = fmt.Errorf("read disk area: got %d of %d bytes", n, a.Length)
+	}
+	return n, err
+}
+
+// datacenterOf returns the name of the datacenter holding vm, for
+// datastore URLs, by walking up its folders to a datacenter's VM folder.
+func (c *vsphereClient) datacenterOf(vm moRef) (string, error) {
+	dcs, err := c.objects("Datacenter", "vmFolder")
+	if err != nil {
+		return "", err
+	}
+	if len(dcs) == 1 {
+		return dcs[0].prop("name"), nil
+	}
+	byFolder := map[string]string{}
+	for _, d := range dcs {
+		byFolder[d.prop("vmFolder")] = d.prop("name")
+	}
+	cur := vm
+	for range 64 {
+		objs, err := c.retrieve(fmt.Sprintf(`<propSet><type>%s</type><pathSet>parent</pathSet></propSet>`+
+			`<objectSet><obj type="%s">%s</obj><skip>false</skip></objectSet>`, cur.Type, cur.Type, xmlText(cur.Value)))
+		if err != nil {
+			return "", err
+		}
+		if len(objs) == 0 || objs[0].prop("parent") == "" {
+			break
+		}
+		parent := objs[0].prop("parent")
+		if dc, ok := byFolder[parent]; ok {
+			return dc, nil
+		}
+		cur = moRef{Type: "Folder", Value: parent}
+	}
+	return "", fmt.Errorf("cannot tell the datacenter of VM %s", vm.Value)
+}
+
+// waitTask polls a vSphere task until it has finished and returns its
+// result's text, e.g. a created snapshot's ID.
+func (c *vsphereClient) waitTask(task moRef) (string, error) {
+	spec := fmt.Sprintf(`<propSet><type>Task</type><pathSet>info.state</pathSet><pathSet>info.result</pathSet><pathSet>info.error</pathSet></propSet>`+
+		`<objectSet><obj type="Task">%s</obj><skip>false</skip></objectSet>`, xmlText(task.Value))
+	for {
+		objs, err := c.retrieve(spec)
+		if err != nil {
+			return "", err
+		}
+		if len(objs) == 0 {
+			return "", fmt.Errorf("task %s vanished", task.Value)
+		}
+		switch objs[0].prop("info.state") {
+		case "success":
+			return objs[0].prop("info.result"), nil
+		case "error":
+			var f struct {
+				Msg string `xml:"localizedMessage"`
+			}
+			xml.Unmarshal([]byte("<e>"+objs[0].prop("info.error")+"</e>"), &f)
+			return "", errors.New(cmp.Or(f.Msg, "task failed"))
+		}
+		select {
+		case <-runCtx.Done():
+			return "", context.Cause(runCtx)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// removeSnapshot deletes the CBT sync's snapshot, consolidating the
+// changes written meanwhile back into the VM's disks. Failing to is only
+// warned about: the next sync would create another.
+func (c *vsphereClient) removeSnapshot(vm string, snap moRef) {
+	var res struct {
+		Returnval moRef `xml:"Body>RemoveSnapshot_TaskResponse>returnval"`
+	}
+	err := c.call(runCtx, fmt.Sprintf(`<RemoveSnapshot_Task xmlns="urn:vim25">%s<removeChildren>false</removeChildren><consolidate>true</consolidate></RemoveSnapshot_Task>`, this(snap)), &res)
+	if err == nil {
+		_, err = c.waitTask(res.Returnval)
+	}
+	if err != nil {
+		slog.Warn("cannot remove the changed block sync's snapshot – remove it by hand", "vm", vm, "snapshot", cbtSnapshot, "err", err)
+	}
+}
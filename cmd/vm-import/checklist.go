@@ -0,0 +1,162 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- post-migration checklist ---------*/
+
+// checkItem is one line of the post-migration checklist of an imported VM.
+type checkItem struct {
+	VM     string `json:"vm"` // the imported VM, a copy's own name with -copies
+	Item   string `json:"item"`
+	Result string `json:"result"` // pass, fail or skip
+	Detail string `json:"detail,omitempty"`
+}
+
+// checklist compares the VM HC3 created for the import q with what its
+// staged Scale XML and the run's flags asked for – disks and their size,
+// NIC VLANs and MACs, machine type, tags, power state and the guest
+// agent – for the -checklist sign-off evidence. Failed items are warned
+// about but do not fail the VM.
+func checklist(ctx context.Context, vm string, q queuedImport) ([]checkItem, error) {
+	doc, err := readScaleXML(xmlPath(q.vm))
+	if err != nil {
+		return nil, err
+	}
+	v, err := hc3Client(q.cl, hc3.DefaultTimeout).VM(ctx, q.uuid)
+	if err != nil {
+		return nil, err
+	}
+	var out []checkItem
+	add := func(item string, ok bool, detail string, args ...any) {
+		res := "pass"
+		if !ok {
+			res = "fail"
+			vmLog(q.vm).Warn("checklist item failed", "item", item, "detail", fmt.Sprintf(detail, args...))
+		}
+		out = append(out, checkItem{q.vm, item, res, fmt.Sprintf(detail, args...)})
+	}
+	skip := func(item, why string) { out = append(out, checkItem{q.vm, item, "skip", why}) }
+
+	// disks, in slot order as HC3 creates them from the XML
+	var want []int64
+	for _, d := range doc.FindAll("disk") {
+		if dev := d.Attr("device"); dev == "disk" || dev == "" {
+			var c int64
+			if n := d.Find("capacity"); n != nil {
+				c, _ = strconv.ParseInt(n.InnerText(), 10, 64)
+			}
+			want = append(want, c)
+		}
+	}
+	var have []hc3.BlockDevice
+	for _, d := range v.BlockDevs {
+		if d.Type != hc3.CDROM {
+			have = append(have, d)
+		}
+	}
+	sort.SliceStable(have, func(i, j int) bool { return have[i].Slot < have[j].Slot })
+	add("disks attached", len(have) == len(want), "%d of %d", len(have), len(want))
+	var small []string
+	for i, d := range have {
+		if i < len(want) && want[i] > 0 && d.Capacity < want[i] {
+			small = append(small, fmt.Sprintf("disk %d: %s, want %s", i+1, humanBytes(d.Capacity), humanBytes(want[i])))
+		}
+	}
+	add("disks sized", len(small) == 0, "%s", strings.Join(small, "; "))
+
+	// NICs, in order
+	ifcs := doc.FindAll("interface")
+	var vlans, macs []string
+	for i, ifc := range ifcs {
+		if i >= len(v.NetDevs) {
+			break
+		}
+		nd := v.NetDevs[i]
+		wantVLAN := 0
+		if t := ifc.Find("tag"); t != nil {
+			wantVLAN, _ = strconv.Atoi(t.Attr("id"))
+		}
+		if nd.VLAN != wantVLAN {
+			vlans = append(vlans, fmt.Sprintf("NIC %d on VLAN %d, want %d", i+1, nd.VLAN, wantVLAN))
+		}
+		if m := ifc.Child("mac"); m != nil && !strings.EqualFold(m.Attr("address"), nd.MACAddress) {
+			macs = append(macs, fmt.Sprintf("NIC %d has %s, want %s", i+1, nd.MACAddress, m.Attr("address")))
+		}
+	}
+	add("NICs attached", len(v.NetDevs) == len(ifcs), "%d of %d", len(v.NetDevs), len(ifcs))
+	add("NIC VLANs", len(vlans) == 0, "%s", strings.Join(vlans, "; "))
+	add("NIC MACs", len(macs) == 0, "%s", strings.Join(macs, "; "))
+
+	if t := machineTypeFor(vm); t != "" {
+		add("machine type", v.MachineType == t, "%s, want %s", v.MachineType, t)
+	} else {
+		skip("machine type", "none asked for; "+v.MachineType)
+	}
+
+	if meta := doc.Find("scale-metadata"); meta != nil {
+		var missing []string
+		for _, t := range meta.FindAll("tag") {
+			if n := t.Attr("name"); n != "" && !slices.Contains(v.TagList(), n) {
+				missing = append(missing, n)
+			}
+		}
+		add("tags", len(missing) == 0, "%s", strings.Join(missing, ", "))
+	}
+
+	running := *powerOn && !*smokeTestFlag && !*asTemplate
+	wantState := "SHUTOFF"
+	if running {
+		wantState = "RUNNING"
+	}
+	add("power state", v.State == wantState, "%s, want %s", v.State, wantState)
+
+	if running && *waitGuest > 0 {
+		add("guest heartbeat", v.GuestUp(), "guest agent %s", cmp.Or(v.GuestAgentState, "silent"))
+	} else {
+		skip("guest heartbeat", "not waited for (-power-on and -wait-guest)")
+	}
+	return out, nil
+}
+
+// writeChecklist writes the checklists of the batch's imported VMs to
+// file, as JSON for a .json file, else as a Markdown table per VM to paste
+// into the change record.
+func writeChecklist(file string, runs []*runRecord) error {
+	var items []checkItem
+	for _, r := range runs {
+		items = append(items, r.Checklist...)
+	}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		b, err := json.MarshalIndent(map[string]any{"generated": time.Now(), "items": items}, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(file, append(b, '\n'), 0o644)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Post-migration checklist, %s\n", time.Now().Format("2006-01-02 15:04"))
+	vm := ""
+	for _, it := range items {
+		if it.VM != vm {
+			vm = it.VM
+			fmt.Fprintf(&b, "\n## %s\n\n| Item | Result | Detail |\n|---|---|---|\n", vm)
+		}
+		res := map[string]string{"pass": "✅ pass", "fail": "❌ fail", "skip": "– skip"}[it.Result]
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", it.Item, res, strings.ReplaceAll(it.Detail, "|", `\|`))
+	}
+	return os.WriteFile(file, []byte(b.String()), 0o644)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*--------- staged checksums ---------*/
+
+// checksumsName is the per-VM checksum manifest in the staging dir, one
+// line per staged image and the Scale XML: <sha256>  <size>  <name>.
+const checksumsName = "CHECKSUMS"
+
+// summer is implemented by backends that can hash a staged file where it
+// is, without copying it back.
+type summer interface {
+	SHA256(name string) (string, error)
+	Size(name string) (int64, error)
+}
+
+func (l localStager) SHA256(name string) (string, error) {
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *sshStager) SHA256(name string) (string, error) {
+	out, err := s.run("sha256sum < "+shellQuote(s.path(name)), nil)
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	return sum, nil
+}
+
+// checksumEntry is one line of a CHECKSUMS file.
+type checksumEntry struct {
+	sum  string
+	size int64
+	name string
+}
+
+// stagedFiles returns what a VM's CHECKSUMS covers in dir: its images and
+// its Scale XML.
+func stagedFiles(dir string) ([]string, error) {
+	files, err := stage.Glob(path.Join(dir, "*.qcow2"))
+	if err != nil {
+		return nil, err
+	}
+	if stage.Exists(xmlPath(dir)) {
+		files = append(files, xmlPath(dir))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// checksumFile hashes the staged file name, reusing known, the checksums
+// of plain copies taken while they were staged, by file name.
+func checksumFile(name string, known map[string]string) (checksumEntry, error) {
+	e := checksumEntry{sum: known[path.Base(name)], name: path.Base(name)}
+	size, err := stage.(summer).Size(name)
+	if err != nil {
+		return e, err
+	}
+	e.size = size
+	if e.sum == "" {
+		e.sum, err = stage.(summer).SHA256(name)
+	}
+	return e, err
+}
+
+// writeChecksums writes dir's CHECKSUMS after staging. The checksums of
+// disks rec copied plainly are reused; the others are read back from the
+// backend. Backends that cannot hash in place get none.
+func writeChecksums(dir string, rec *runRecord) error {
+	if !*checksums {
+		return nil
+	}
+	if _, ok := stage.(summer); !ok {
+		vmLog(dir).Debug("no CHECKSUMS: the staging backend cannot hash files in place")
+		return nil
+	}
+	if *dryRun {
+		vmLog(dir).Info("[dry-run] write " + checksumsName)
+		return nil
+	}
+	known := map[string]string{}
+	if path.Base(dir) == path.Base(rec.VM) {
+		for _, d := range rec.Disks {
+			if d.Mode == "copy" && d.SHA256 != "" {
+				known[d.Target] = d.SHA256
+			}
+		}
+	}
+	files, err := stagedFiles(dir)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# vm-import %s, %s: sha256, size, file\n", dir, time.Now().Format(time.RFC3339))
+	for _, f := range files {
+		e, err := checksumFile(f, known)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.Base(f), err)
+		}
+		fmt.Fprintf(&b, "%s  %d  %s\n", e.sum, e.size, e.name)
+	}
+	if err := putStaged(path.Join(dir, checksumsName), &b); err != nil {
+		return err
+	}
+	vmLog(dir).Debug("wrote "+checksumsName, "files", len(files))
+	return nil
+}
+
+// readChecksums parses dir's CHECKSUMS.
+func readChecksums(dir string) ([]checksumEntry, error) {
+	data, err := stage.ReadFile(path.Join(dir, checksumsName))
+	if err != nil {
+		return nil, err
+	}
+	var out []checksumEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("%s:%d: want \"<sha256>  <size>  <file>\"", path.Join(dir, checksumsName), n)
+		}
+		size, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path.Join(dir, checksumsName), n, err)
+		}
+		out = append(out, checksumEntry{f[0], size, f[2]})
+	}
+	return out, sc.Err()
+}
+
+// runVerifyStaging implements "vm-import verify-staging [flags] [vm ...]":
+// it checks the staged files of each VM – every VM directory with a
+// CHECKSUMS by default – against it, so staging done days before can be
+// trusted when the import is triggered. Missing and changed files and
+// images the manifest does not list fail it.
+func runVerifyStaging(args []string) error {
+	fs := flag.NewFlagSet("verify-staging", flag.ExitOnError)
+	fs.StringVar(scaleDir, "scaledir", *scaleDir, "Staging directory to verify")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vm-import verify-staging [-scaledir dir] [vm ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	var err error
+	if stage, err = newStager(); err != nil {
+		return err
+	}
+	defer stage.Close()
+	if _, ok := stage.(summer); !ok {
+		return fmt.Errorf("the staging backend cannot hash files in place")
+	}
+
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		all, err := stage.Glob("*")
+		if err != nil {
+			return err
+		}
+		for _, d := range all {
+			if stage.Exists(path.Join(d, checksumsName)) {
+				dirs = append(dirs, d)
+			}
+		}
+		if len(dirs) == 0 {
+			return fmt.Errorf("no VM directory in %s has a %s", *scaleDir, checksumsName)
+		}
+	}
+	bad := 0
+	for _, dir := range dirs {
+		fmt.Fprintln(stdout{}, dir)
+		want, err := readChecksums(dir)
+		if err != nil {
+			fmt.Fprintf(stdout{}, "  ❌ %v\n", err)
+			bad++
+			continue
+		}
+		listed := map[string]bool{}
+		for _, w := range want {
+			listed[w.name] = true
+			name := path.Join(dir, w.name)
+			if !stage.Exists(name) {
+				fmt.Fprintf(stdout{}, "  ❌ %s missing\n", w.name)
+				bad++
+				continue
+			}
+			have, err := checksumFile(name, nil)
+			switch {
+			case err != nil:
+				fmt.Fprintf(stdout{}, "  ❌ %s: %v\n", w.name, err)
+			case have.size != w.size:
+				fmt.Fprintf(stdout{}, "  ❌ %s is %s, was %s\n", w.name, humanBytes(have.size), humanBytes(w.size))
+			case have.sum != w.sum:
+				fmt.Fprintf(stdout{}, "  ❌ %s changed (sha256 %s, was %s)\n", w.name, have.sum, w.sum)
+			default:
+				fmt.Fprintf(stdout{}, "  ✅ %s\n", w.name)
+				continue
+			}
+			bad++
+		}
+		files, err := stagedFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if !listed[path.Base(f)] {
+				fmt.Fprintf(stdout{}, "  ❌ %s staged since, not in %s\n", path.Base(f), checksumsName)
+				bad++
+			}
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d problem(s) found", bad)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*--------- source cleanup ---------*/
+
+// cleanupSource removes the export of vm from -ovadir, or archives it
+// below -archive-dir, once its import has completed and been verified.
+// Only a local -ovadir can be cleaned up; main checks that up front.
+func cleanupSource(vm string) error {
+	dir := ova.(localSource).path(vm)
+	// a symlinked export is removed or archived where it is, and the link
+	// after it, so the space on its volume is freed
+	link := ""
+	if fi, err := os.Lstat(dir); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		link, dir = dir, real
+	}
+	if *archiveDir == "" {
+		err := os.RemoveAll(dir)
+		audit("cleanup-source", vm, err, auditEntry{Paths: []string{dir}})
+		if err != nil {
+			return err
+		}
+		vmLog(vm).Info("🗑 removed source export", "dir", dir)
+		return removeLink(link)
+	}
+	if err := archiveSource(vm, dir); err != nil {
+		return err
+	}
+	if err := removeLink(link); err != nil {
+		return err
+	}
+	if err := pruneArchive(); err != nil {
+		vmLog(vm).Warn("cannot prune the archive", "err", err)
+	}
+	return nil
+}
+
+// removeLink removes the symlink to a cleaned-up export, if there was one.
+func removeLink(link string) error {
+	if link == "" {
+		return nil
+	}
+	return os.Remove(link)
+}
+
+// archiveLayout names the dated folders of -archive-dir.
+const archiveLayout = "2006-01-02"
+
+// archiveSource moves the export dir of vm to <-archive-dir>/<date>/<vm>,
+// or with -archive-compress packs it into <vm>.tar.gz there.
+func archiveSource(vm, dir string) error {
+	now := time.Now()
+	dst := filepath.Join(*archiveDir, now.Format(archiveLayout), vm)
+	if *archiveCompress {
+		dst += ".tar.gz"
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		dst = strings.TrimSuffix(dst, ".tar.gz") + "-" + now.Format("150405")
+		if *archiveCompress {
+			dst += ".tar.gz"
+		}
+	}
+	var err error
+	if *archiveCompress {
+		if err = tarDir(dir, dst); err == nil {
+			err = os.RemoveAll(dir)
+		}
+	} else {
+		err = moveDir(dir, dst)
+	}
+	audit("cleanup-source", vm, err, auditEntry{Paths: []string{dir, dst}})
+	if err != nil {
+		return err
+	}
+	vmLog(vm).Info("📦 archived source export", "from", dir, "to", dst)
+	return nil
+}
+
+// pruneArchive removes the dated folders of -archive-dir that are older
+// than -archive-retention.
+func pruneArchive() error {
+	if *archiveRetention <= 0 {
+		return nil
+	}
+	ents, err := os.ReadDir(*archiveDir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-*archiveRetention)
+	var errs []error
+	for _, e := range ents {
+		day, err := time.ParseInLocation(archiveLayout, e.Name(), time.Local)
+		// a folder covers its whole day
+		if err != nil || !e.IsDir() || !day.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+		p := filepath.Join(*archiveDir, e.Name())
+		if err := os.RemoveAll(p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		slog.Info("🗑 pruned archived exports", "dir", p)
+	}
+	return errors.Join(errs...)
+}
+
+// moveDir renames src to dst, copying and then removing the tree when they
+// are on different filesystems.
+func moveDir(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	err := os.Rename(src, dst)
+	var le *os.LinkError
+	if err == nil || !errors.As(err, &le) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("copy to %s: %w", dst, err)
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies the directories and regular files below src to dst.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case !d.Type().IsRegular():
+			return fmt.Errorf("%s is not a regular file", p)
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// tarDir packs the tree src into the gzip-compressed tarball dst, below a
+// top-level directory named like src.
+func tarDir(src, dst string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", p)
+		}
+		rel, _ := filepath.Rel(filepath.Dir(src), p)
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil || d.IsDir() {
+			return err
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+/*--------- clone fan-out ---------*/
+
+// cloneDir names the staging directory of copy k (2…-copies) of vm; copy 1
+// is vm itself.
+func cloneDir(vm string, k int) string { return fmt.Sprintf("%s-%d", vm, k) }
+
+// copyName returns the HC3 name of copy k of vm: its target name (or vm)
+// with -k appended. It is derived from vm rather than the staged Scale
+// XML, which already carries the suffix after an earlier run.
+func copyName(vm string, k int) (string, error) {
+	base, err := targetName(vm)
+	if base == "" {
+		base = path.Base(vm)
+	}
+	return fmt.Sprintf("%s-%d", base, k), err
+}
+
+// stageClones stages copies 2…-copies of vm, each in its own directory
+// <vm>-<k> with a Scale XML derived from vm's: the name <name>-<k> and
+// fresh VM and disk UUIDs. The disks staged for vm (uuids, staged from
+// srcFiles) are hard-linked into place when staging locally, and staged
+// from the source again otherwise. Each directory is locked while it is
+// staged, as vm's is. It returns the clones' directories.
+func stageClones(ctx context.Context, vm string, srcFiles, uuids []string) ([]string, error) {
+	lg := vmLog(vm)
+	var clones []string
+	for k := 2; k <= *copies; k++ {
+		if err := context.Cause(ctx); err != nil {
+			return clones, err
+		}
+		dir := cloneDir(vm, k)
+		release, err := lockVM(dir, *waitLock)
+		if err != nil {
+			return clones, err
+		}
+		defer release() // held until all copies are staged
+		doc, err := readScaleXML(xmlPath(vm))
+		if err != nil {
+			return clones, err
+		}
+		if err := removeStaleParts(dir); err != nil {
+			return clones, err
+		}
+		if err := deleteQcow2(dir, nil); err != nil {
+			return clones, err
+		}
+		renamed := map[string]string{}
+		for i, u := range uuids {
+			renamed[u] = newUUID()
+			staged := path.Join(vm, u+".qcow2")
+			dst := path.Join(dir, renamed[u]+".qcow2")
+			if *dryRun {
+				lg.Info("[dry-run] clone disk", "copy", k, "src", path.Base(staged), "dst", dst)
+				continue
+			}
+			if err := cloneDisk(ctx, path.Join(vm, srcFiles[i]), staged, dst); err != nil {
+				return clones, fmt.Errorf("copy %d: %w", k, err)
+			}
+		}
+		setUUIDs(doc, vm, renamed)
+		name, err := copyName(vm, k)
+		if err != nil {
+			return clones, err
+		}
+		top := doc.First()
+		n := top.Child("name")
+		if n == nil {
+			n = top.Add("name")
+		}
+		n.SetText(name)
+
+		xmlName := xmlPath(dir)
+		if *dryRun {
+			lg.Info("[dry-run] would stage copy", "copy", k, "name", name, "file", xmlName)
+		} else {
+			err = putStaged(xmlName, bytes.NewReader(doc.Bytes()))
+			audit("overwrite-xml", dir, err, auditEntry{Paths: []string{under(*scaleDir, xmlName)}})
+			if err != nil {
+				return clones, err
+			}
+			lg.Info("⧉ staged copy", "copy", k, "name", name, "dir", dir)
+		}
+		clones = append(clones, dir)
+	}
+	return clones, nil
+}
+
+// cloneDisk puts a copy of the staged image staged at dst: a hard link on
+// the local backend (no extra space or time), else the source disk src
+// staged once more.
+func cloneDisk(ctx context.Context, src, staged, dst string) error {
+	if ls, ok := stage.(localStager); ok {
+		if err := os.MkdirAll(filepath.Dir(ls.path(dst)), 0o755); err != nil {
+			return err
+		}
+		err := os.Link(ls.path(staged), ls.path(dst))
+		if err == nil {
+			return nil
+		}
+		vmLog(path.Dir(staged)).Debug("hard link failed – staging the disk again", "dst", dst, "err", err)
+	}
+	if needsConversion(src) {
+		return convertSlots.do(ctx, func() error {
+			_, err := convertDisk(ctx, src, dst)
+			return err
+		})
+	}
+	return copySlots.do(ctx, func() error {
+		_, err := copyFile(ctx, src, dst)
+		return err
+	})
+}
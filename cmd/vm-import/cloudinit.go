@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- cloud-init data ---------*/
+
+// cloudInit returns the cloud-init data to import vm as target t named
+// name with, from the manifest's userData/metaData files for vm or else
+// -user-data and -meta-data, plus the netplan or ifcfg -net-recipe, or nil
+// when there is no user-data. Without meta-data the instance ID and
+// hostname are derived from name, so cloud-init runs once on first boot.
+func cloudInit(vm, t, name string) (*hc3.CloudInitData, error) {
+	userFile, metaFile := *userData, *metaData
+	if v := plan.vm(vm); v != nil {
+		if v.UserData != "" {
+			userFile = v.UserData
+		}
+		if v.MetaData != "" {
+			metaFile = v.MetaData
+		}
+	}
+	var recipe string
+	if netRecipes[*netRecipe] == "cloud-init" {
+		nics, err := guestNICs(vm, t)
+		if err != nil {
+			return nil, fmt.Errorf("network recipe: %w", err)
+		}
+		if len(nics) > 0 {
+			recipe = netCloudConfig(nics)
+		}
+	}
+	if userFile == "" && recipe == "" {
+		return nil, nil
+	}
+	var user []byte
+	var err error
+	if userFile != "" {
+		if user, err = os.ReadFile(userFile); err != nil {
+			return nil, fmt.Errorf("cloud-init user-data: %w", err)
+		}
+	}
+	if user, err = withUserData(user, recipe); err != nil {
+		return nil, err
+	}
+	meta := []byte(fmt.Sprintf("instance-id: %s-%s\nlocal-hostname: %s\n", name, batchID, name))
+	if metaFile != "" {
+		if meta, err = os.ReadFile(metaFile); err != nil {
+			return nil, fmt.Errorf("cloud-init meta-data: %w", err)
+		}
+	}
+	return &hc3.CloudInitData{
+		UserData: base64.StdEncoding.EncodeToString(user),
+		MetaData: base64.StdEncoding.EncodeToString(meta),
+	}, nil
+}
+
+// checkCloudInit makes sure the cloud-init files from the flags and the
+// manifest are readable before anything is staged.
+func checkCloudInit() error {
+	files := []string{*userData, *metaData}
+	if *metaData != "" && *userData == "" {
+		return fmt.Errorf("-meta-data needs -user-data")
+	}
+	if plan != nil {
+		for _, v := range plan.VMs {
+			files = append(files, v.UserData, v.MetaData)
+		}
+	}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- target clusters ---------*/
+
+// cluster is an HC3 cluster VMs can be imported into. Clusters are named
+// with -cluster name=URL, in a -clusters file or in the manifest's
+// "clusters"; a VM goes to the manifest's "cluster" for it or for the
+// batch, else to the unnamed default cluster at -api. Settings a cluster
+// leaves empty fall back to the flags.
+type cluster struct {
+	Name           string  `json:"-"`
+	API            string  `json:"api"`                      // REST base URL
+	User           string  `json:"user,omitempty"`           // -user
+	Password       string  `json:"password,omitempty"`       // -pass
+	PasswordFile   string  `json:"passwordFile,omitempty"`   // read the password from here instead
+	CA             string  `json:"ca,omitempty"`             // -api-ca
+	Share          string  `json:"share,omitempty"`          // -share
+	SMBUser        string  `json:"smbUser,omitempty"`        // -smb-user
+	SMBPassword    string  `json:"smbPassword,omitempty"`    // -smb-pass, or a reference as it takes
+	ExportProtocol string  `json:"exportProtocol,omitempty"` // -export-protocol
+	Rate           float64 `json:"rate,omitempty"`           // -api-rate
+	MaxImports     int     `json:"maxImports,omitempty"`     // -max-cluster-imports
+	NonSequential  *bool   `json:"nonSequential,omitempty"`  // -nonsequential-writes
+	Streams        int     `json:"streams,omitempty"`        // -import-streams
+
+	roots   *x509.CertPool   // from CA, or nil to skip verification
+	limiter *hc3.RateLimiter // shared by all calls to the cluster, nil if unlimited
+	tasks   limiter          // a slot per unfinished import task, nil if unlimited
+}
+
+var (
+	clusterFlags stringList
+	clusters     = map[string]*cluster{}
+	apiRoots     *x509.CertPool // from -api-ca
+	defaultCl    = &cluster{}
+)
+
+// defaultCluster is -api, read when used so -demo can point it elsewhere.
+func defaultCluster() *cluster { return defaultCl }
+
+// api returns the cluster's REST base URL; with -demo every cluster is
+// the mock.
+func (c *cluster) api() string {
+	if c.API == "" || *demo {
+		return *apiURL
+	}
+	return c.API
+}
+
+// user and password are the cluster's API credentials; the mock of -demo
+// checks -user and -pass.
+func (c *cluster) user() string {
+	if *demo {
+		return *apiUser
+	}
+	return cmp.Or(c.User, *apiUser)
+}
+
+func (c *cluster) password() string {
+	if *demo {
+		return *apiPass
+	}
+	return cmp.Or(c.Password, *apiPass)
+}
+
+// share is the prefix HC3 reads the staged VMs from.
+func (c *cluster) share() string { return cmp.Or(c.Share, *share) }
+
+// smbShare returns the cluster's SMB share, with -smb-user and -smb-pass
+// (or its own smbUser and smbPassword) as the credentials when given,
+// else those of the share URI.
+func (c *cluster) smbShare() (*url.URL, error) {
+	u, err := smbShare(c.share())
+	if err != nil {
+		return nil, err
+	}
+	user := strings.Replace(cmp.Or(c.SMBUser, *smbUser), `\`, ";", 1)
+	if user == "" {
+		return u, nil
+	}
+	pass, err := secret(cmp.Or(c.SMBPassword, *smbPass))
+	if err != nil {
+		return nil, fmt.Errorf("SMB password: %w", err)
+	}
+	u.User = url.UserPassword(user, pass)
+	return u, nil
+}
+
+func (c *cluster) protocol() string { return cmp.Or(c.ExportProtocol, *exportProto) }
+
+func (c *cluster) caRoots() *x509.CertPool {
+	if c.roots != nil {
+		return c.roots
+	}
+	return apiRoots
+}
+
+// rate is the most API calls a second to send the cluster, 0 for no limit.
+func (c *cluster) rate() float64 { return cmp.Or(c.Rate, *apiRate) }
+
+// nonSequential is whether HC3 may write the cluster's imported disks out
+// of order.
+func (c *cluster) nonSequential() bool {
+	if c.NonSequential != nil {
+		return *c.NonSequential
+	}
+	return *nonSeqWrites
+}
+
+// streams is how many parallel streams HC3 reads each imported disk with,
+// 0 for its default.
+func (c *cluster) streams() int { return cmp.Or(c.Streams, *importStreams) }
+
+// label names the cluster in logs and records.
+func (c *cluster) label() string {
+	if c.Name == "" {
+		return "default"
+	}
+	return c.Name
+}
+
+// setupClusters collects the clusters from -cluster, -clusters and the
+// manifest, loads their CA certificates and password files, and checks
+// that every cluster the manifest routes a VM to is defined.
+func setupClusters() error {
+	if *apiCA != "" {
+		var err error
+		if apiRoots, err = loadCA(*apiCA); err != nil {
+			return fmt.Errorf("-api-ca: %w", err)
+		}
+	}
+	if *clustersFile != "" {
+		data, err := os.ReadFile(*clustersFile)
+		if err != nil {
+			return err
+		}
+		var m map[string]*cluster
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("parse %s: %w", *clustersFile, err)
+		}
+		if err := addClusters(m); err != nil {
+			return fmt.Errorf("%s: %w", *clustersFile, err)
+		}
+	}
+	for _, f := range clusterFlags {
+		name, u, ok := strings.Cut(f, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("-cluster %q: want name=https://host", f)
+		}
+		if err := addCluster(&cluster{Name: name, API: u}); err != nil {
+			return err
+		}
+	}
+	if plan != nil {
+		if err := addClusters(plan.Clusters); err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+	}
+	for _, c := range allClusters() {
+		switch {
+		case c.rate() < 0:
+			return fmt.Errorf("cluster %s: negative API rate %g", c.label(), c.rate())
+		case c.rate() > 0:
+			c.limiter = hc3.NewRateLimiter(c.rate(), *apiBurst)
+		}
+		c.tasks = newLimiter(cmp.Or(c.MaxImports, *maxClusterImports))
+	}
+	if plan == nil {
+		return nil
+	}
+	if _, err := namedCluster(plan.Cluster); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	for _, v := range plan.VMs {
+		if _, err := namedCluster(v.Cluster); err != nil {
+			return fmt.Errorf("manifest VM %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// addClusters adds the clusters of a -clusters file or manifest, named by
+// their keys.
+func addClusters(m map[string]*cluster) error {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if m[name] == nil {
+			return fmt.Errorf("cluster %s: no settings", name)
+		}
+		c := *m[name]
+		c.Name = name
+		if err := addCluster(&c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addCluster(c *cluster) error {
+	if _, dup := clusters[c.Name]; dup {
+		return fmt.Errorf("cluster %s defined twice", c.Name)
+	}
+	u, err := url.Parse(c.API)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("cluster %s: API URL %q is not an http(s) URL", c.Name, c.API)
+	}
+	if c.PasswordFile != "" {
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+		c.Password = strings.TrimRight(string(b), "\r\n")
+	}
+	if c.CA != "" {
+		if c.roots, err = loadCA(c.CA); err != nil {
+			return fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+	}
+	switch c.protocol() {
+	case "smb", "nfs":
+	default:
+		return fmt.Errorf("cluster %s: unknown export protocol %q", c.Name, c.protocol())
+	}
+	clusters[c.Name] = c
+	return nil
+}
+
+// loadCA reads a PEM bundle of CA certificates.
+func loadCA(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+// namedCluster returns the cluster called name, the default one for "".
+func namedCluster(name string) (*cluster, error) {
+	if name == "" {
+		return defaultCluster(), nil
+	}
+	c, ok := clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster %q (define it with -cluster or in the manifest's clusters)", name)
+	}
+	return c, nil
+}
+
+// clusterFor returns the cluster vm is imported into.
+func clusterFor(vm string) (*cluster, error) {
+	name := ""
+	if plan != nil {
+		name = plan.Cluster
+	}
+	if v := plan.vm(vm); v != nil && v.Cluster != "" {
+		name = v.Cluster
+	}
+	return namedCluster(name)
+}
+
+// hc3Client returns a client for cluster cl with the given request
+// timeout.
+func hc3Client(cl *cluster, timeout time.Duration) *hc3.Client {
+	opts := []hc3.Option{hc3.WithCredentials(cl.user(), cl.password()), hc3.WithTimeout(timeout), hc3.WithTransport(debugRT)}
+	if roots := cl.caRoots(); roots != nil {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		opts = append([]hc3.Option{hc3.WithHTTPClient(&http.Client{Transport: tr})}, opts...)
+	}
+	if cl.limiter != nil {
+		opts = append(opts, hc3.WithRateLimiter(cl.limiter))
+	}
+	return hc3.New(cl.api(), opts...)
+}
+
+// allClusters returns the default cluster and every named one.
+func allClusters() []*cluster {
+	out := []*cluster{defaultCluster()}
+	names := make([]string, 0, len(clusters))
+	for name := range clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, clusters[name])
+	}
+	return out
+}
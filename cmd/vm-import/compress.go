@@ -0,0 +1,43 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+/*--------- in-flight compression ---------*/
+
+// compressStream returns a reader yielding r compressed with codec, plus a
+// wait func reporting the compressor's outcome; close the reader before
+// calling wait. gzip is done in-process; zstd uses the local zstd binary.
+func compressStream(codec string, r io.Reader) (io.ReadCloser, func() error, error) {
+	switch codec {
+	case "gzip":
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			zw, _ := gzip.NewWriterLevel(pw, gzip.BestSpeed)
+			_, err := io.Copy(zw, r)
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+			pw.CloseWithError(err)
+			done <- err
+		}()
+		return pr, func() error { return <-done }, nil
+	case "zstd":
+		cmd := exec.Command("zstd", "-q", "-c", "-T0")
+		cmd.Stdin = r
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, fmt.Errorf("zstd: %w", err)
+		}
+		return out, cmd.Wait, nil
+	}
+	return nil, nil, fmt.Errorf("unknown compression %q", codec)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- concurrency limits ---------*/
+
+// limiter caps how many goroutines hold it at once; a nil limiter lets
+// everyone through.
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// do runs fn while holding a slot; it gives up waiting for one once ctx
+// is done.
+func (l limiter) do(ctx context.Context, fn func() error) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.release()
+	return fn()
+}
+
+// acquire takes a slot, for holding it beyond a function call; it gives
+// up waiting once ctx is done.
+func (l limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// release gives back a slot taken with acquire.
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// The pipeline's bottlenecks are different resources, so each gets its own
+// limit across all VMs of a run, independent of -parallel: qemu-img
+// conversions are CPU bound, copies (and delta syncs) storage bound, and
+// imports bound by how much the cluster ingests at once.
+var convertSlots, copySlots, importSlots limiter
+
+// promptMu keeps parallel VMs from asking questions at the same time.
+var promptMu sync.Mutex
+
+// stdin is shared by every prompt, so answers typed ahead or piped in are
+// not lost in the buffer of a reader used for an earlier question.
+var stdin = bufio.NewReader(os.Stdin)
+
+func setupLimits() {
+	convertSlots = newLimiter(*maxConvert)
+	copySlots = newLimiter(*maxCopy)
+	importSlots = newLimiter(*maxImport)
+}
+
+// runBatch processes vms, -parallel of them at a time, and returns how
+// many failed. VMs of a higher manifest priority are processed – imported
+// and verified as far as the flags say – before lower ones start, so
+// critical VMs go first even in parallel runs. A VM the manifest says
+// comes after others of the batch only starts once they have been
+// processed and HC3 has finished importing them, and fails if they did
+// not make it or only come in a later wave. No new VM is started once the
+// run is interrupted.
+func runBatch(vms []string) (failed int) {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, max(*parallel, 1))
+	)
+	for i := range vms {
+		vms[i] = strings.TrimSpace(vms[i])
+	}
+	// each VM's channel is closed once it is through, ok[vm] says how
+	done, ok := map[string]chan struct{}{}, map[string]bool{}
+	for _, vm := range vms {
+		done[vm] = make(chan struct{})
+	}
+	needed := map[string]bool{} // VMs others wait for
+	for _, vm := range vms {
+		for _, d := range plan.after(vm) {
+			if _, in := done[d]; in {
+				needed[d] = true
+			} else {
+				slog.Warn("prerequisite not in this batch – taken as migrated", "vm", vm, "after", d)
+			}
+		}
+	}
+	fail := func(vm string, err error) {
+		slog.Error("VM failed", "vm", vm, "err", err)
+		mu.Lock()
+		failed++
+		mu.Unlock()
+	}
+	waves := plan.waves(vms)
+	waveOf := map[string]int{}
+	for w, wave := range waves {
+		for _, vm := range wave {
+			waveOf[vm] = w
+		}
+	}
+	left := len(vms)
+	for w, wave := range waves {
+		if len(waves) > 1 {
+			slog.Info("▶ priority wave", "wave", w+1, "of", len(waves), "vms", strings.Join(wave, ","))
+		}
+		for _, vm := range plan.orderByDeps(wave) {
+			sem <- struct{}{}
+			if interrupted() != nil {
+				slog.Warn("stopping – VMs left unprocessed", "count", left)
+				wg.Wait()
+				return failed
+			}
+			left--
+			wg.Add(1)
+			go func(vm string) {
+				var good bool
+				defer func() {
+					mu.Lock()
+					ok[vm] = good
+					mu.Unlock()
+					close(done[vm])
+					<-sem
+					wg.Done()
+				}()
+				for _, d := range plan.after(vm) {
+					if ch, in := done[d]; in {
+						if waveOf[d] > w {
+							// a prerequisite without a manifest priority, selected with -vms
+							fail(vm, fmt.Errorf("prerequisite %s is in the later priority wave %d", d, waveOf[d]+1))
+							return
+						}
+						<-ch // started earlier, so it does not wait for our slot
+						mu.Lock()
+						dok := ok[d]
+						mu.Unlock()
+						if !dok {
+							fail(vm, fmt.Errorf("prerequisite %s did not make it", d))
+							return
+						}
+					}
+				}
+				if err := processVM(runCtx, vm, *autoImp); err != nil {
+					fail(vm, err)
+					return
+				}
+				if needed[vm] && !*dryRun {
+					if err := waitImports(runCtx, importsOf(vm)); err != nil {
+						fail(vm, err)
+						return
+					}
+				}
+				good = true
+			}(vm)
+		}
+		wg.Wait()
+	}
+	return failed
+}
+
+// taskPollInterval is how often a cluster is asked whether an import task
+// holding one of its -max-cluster-imports slots has finished.
+const taskPollInterval = 10 * time.Second
+
+// acquireTask waits for one of the cluster's import task slots before vm
+// is imported into it.
+func (c *cluster) acquireTask(ctx context.Context, vm string) error {
+	if c.tasks == nil {
+		return nil
+	}
+	select {
+	case c.tasks <- struct{}{}:
+		return nil
+	default:
+	}
+	vmLog(vm).Info("⏳ waiting for an import slot", "cluster", c.label(), "limit", cap(c.tasks))
+	return c.tasks.acquire(ctx)
+}
+
+// releaseTaskWhenDone polls the cluster in the background until the import
+// task of vm has finished there, then frees its slot and its place under
+// the -throttle imports limit, emits its "task" event and records its end
+// in tasks.json. The task runs on the cluster regardless of the VM's own
+// context, so only the end of the run stops the polling.
+func (c *cluster) releaseTaskWhenDone(vm, task string) {
+	go func() {
+		defer c.tasks.release()
+		defer releaseImportGate()
+		for {
+			st, err := hc3Client(c, checkTimeout).Task(runCtx, task)
+			switch {
+			case errors.Is(err, hc3.ErrNotFound):
+				return
+			case err != nil:
+				slog.Debug("polling import task", "vm", vm, "cluster", c.label(), "task", task, "err", err)
+			case st.State == hc3.TaskError:
+				slog.Warn("HC3 import failed", "vm", vm, "cluster", c.label(), "task", task, "msg", st.FormattedMessage)
+				emit(event{Event: "task", VM: vm, Task: task, State: "failed", Error: st.FormattedMessage})
+				recordTaskState(vm, task, "failed", c)
+				return
+			case st.State.Done():
+				slog.Info("✅ HC3 import finished", "vm", vm, "cluster", c.label(), "task", task)
+				emit(event{Event: "task", VM: vm, Task: task, State: "finished"})
+				recordTaskState(vm, task, "finished", c)
+				return
+			}
+			select {
+			case <-runCtx.Done():
+				return
+			case <-time.After(taskPollInterval):
+			}
+		}
+	}()
+}
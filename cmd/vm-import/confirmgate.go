@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+/*--------- typed confirmation of large or destructive batches ---------*/
+
+// confirmBatch makes the operator type a confirmation phrase before a
+// batch of more than -confirm-over VMs, or one that deletes dummy VMs,
+// changes anything, after summing up how many staged files it deletes
+// and overwrites. Unattended runs fail instead, unless
+// -i-know-what-im-doing is set.
+func confirmBatch(vms []string) error {
+	if *confirmOver <= 0 || *iKnow || *dryRun {
+		return nil
+	}
+	if len(vms) <= *confirmOver && !*deleteDummyFlag {
+		return nil
+	}
+	var deleted, overwritten int
+	for _, vm := range vms {
+		vm = strings.TrimSpace(vm)
+		for k := 1; k <= max(*copies, 1); k++ {
+			dir := vm
+			if k > 1 {
+				dir = cloneDir(vm, k)
+			}
+			staged, _ := stage.Glob(path.Join(dir, "*.qcow2"))
+			if *delta {
+				overwritten += len(staged)
+			} else {
+				deleted += len(staged)
+			}
+		}
+		if stage.Exists(xmlPath(vm)) {
+			overwritten++
+		}
+	}
+	summary := fmt.Sprintf("%d VM(s): %d staged image(s) deleted, %d file(s) overwritten", len(vms), deleted, overwritten)
+	if *trashKeep > 0 && deleted > 0 {
+		summary += " (deleted images go to the trash)"
+	}
+	if *deleteDummyFlag {
+		summary += fmt.Sprintf(", %d dummy VM(s) deleted on HC3 once imported", len(vms))
+	}
+	phrase := fmt.Sprintf("migrate %d VMs", len(vms))
+	if !interactive() {
+		return fmt.Errorf("%s – pass -i-know-what-im-doing to run it unattended", summary)
+	}
+	promptMu.Lock()
+	fmt.Fprintf(stdout{}, "⚠ %s.\nType %q to go ahead: ", summary, phrase)
+	resp, _ := stdin.ReadString('\n')
+	promptMu.Unlock()
+	if strings.TrimSpace(resp) != phrase {
+		return fmt.Errorf("not confirmed")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*--------- -container ---------*/
+
+// envPrefix starts the environment variables -container reads flags from.
+const envPrefix = "VMIMPORT_"
+
+// Exit statuses, so a scheduler can tell a broken setup from VMs that
+// failed. Flag errors exit with 2 as usual.
+const (
+	exitFatal       = 1   // configuration or setup failed; nothing was done
+	exitVMsFailed   = 3   // the batch ran, but at least one VM failed
+	exitInterrupted = 130 // stopped by SIGINT/SIGTERM
+)
+
+// exitCode is the status main exits with once its deferred cleanup ran.
+var exitCode int
+
+// containerMode reports whether to run as a container or Kubernetes Job:
+// -container, or VMIMPORT_CONTAINER set to true.
+func containerMode() bool {
+	if *container {
+		return true
+	}
+	on, _ := strconv.ParseBool(os.Getenv(envPrefix + "CONTAINER"))
+	return on
+}
+
+// setupContainer applies -container: every flag not given on the command
+// line is taken from VMIMPORT_<NAME> (upper case, "-" as "_", e.g.
+// VMIMPORT_MAX_COPIES), or read from the file VMIMPORT_<NAME>_FILE names,
+// as for mounted secrets; repeatable flags take one value per line. Logs
+// default to JSON on stdout.
+func setupContainer() error {
+	if !containerMode() {
+		return nil
+	}
+	*container = true
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var errs []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		v, ok, err := envFlag(f.Name)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		if !ok {
+			return
+		}
+		vals := []string{v}
+		if _, repeated := f.Value.(*stringList); repeated {
+			vals = strings.FieldsFunc(v, func(r rune) bool { return r == '\n' || r == '\r' })
+		}
+		for _, v := range vals {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s%s: %v", envPrefix, envName(f.Name), err))
+			}
+		}
+		given[f.Name] = true
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	if !given["log-format"] {
+		*logFormat = "json"
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// envFlag looks up the value for flag name in the environment, a
+// _FILE variable winning over the plain one. A trailing newline in the
+// file is dropped.
+func envFlag(name string) (string, bool, error) {
+	key := envPrefix + envName(name)
+	if file := os.Getenv(key + "_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", key, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	}
+	v, ok := os.LookupEnv(key)
+	return v, ok, nil
+}
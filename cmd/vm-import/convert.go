@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/transfer"
+)
+
+/*--------- disk conversion ---------*/
+
+// needsConversion reports whether a source disk must go through qemu-img
+// rather than being copied byte for byte.
+func needsConversion(src string) bool { return convertFormat(src) != "" }
+
+// convertFormat maps a source disk extension to its qemu-img format name.
+func convertFormat(src string) string {
+	switch strings.ToLower(path.Ext(src)) {
+	case ".vhdx":
+		return "vhdx"
+	case ".vhd":
+		return "vpc"
+	case ".raw", ".img":
+		return "raw"
+	}
+	return ""
+}
+
+// convertDisk converts a local source disk to qcow2 at name in the staging
+// backend: under a partName renamed into place once synced when staging
+// locally, else through a temporary file. It returns the CPU time
+// qemu-img took.
+func convertDisk(ctx context.Context, src, name string) (time.Duration, error) {
+	ls, ok := ova.(localSource)
+	if !ok {
+		return 0, fmt.Errorf("converting %s needs a local -ovadir", path.Base(src))
+	}
+	in := ls.path(src)
+	format := convertFormat(src)
+
+	dst, tmp := "", ""
+	st, local := stage.(localStager)
+	if local {
+		dst = st.path(partName(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return 0, err
+		}
+	} else {
+		f, err := os.CreateTemp("", "convert-*.qcow2")
+		if err != nil {
+			return 0, err
+		}
+		f.Close()
+		dst, tmp = f.Name(), f.Name()
+		defer os.Remove(tmp)
+	}
+
+	cmd := exec.CommandContext(ctx, "qemu-img", "convert", "-f", format, "-O", "qcow2", in, dst)
+	var errb bytes.Buffer
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		os.Remove(dst)
+		if cerr := context.Cause(ctx); cerr != nil {
+			return 0, cerr
+		}
+		return 0, fmt.Errorf("qemu-img convert %s: %v: %s", path.Base(src), err, strings.TrimSpace(errb.String()))
+	}
+	cpu := cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	dropCached(in)
+	if local {
+		err := syncFile(dst)
+		if err == nil {
+			dropCached(dst)
+			err = os.Rename(dst, st.path(name))
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+		return cpu, err
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return cpu, err
+	}
+	defer f.Close()
+	return cpu, putStaged(name, transfer.ContextReader(ctx, throttled(ctx, f)))
+}
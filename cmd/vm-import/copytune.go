@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"gcosmiclentil89/ScaleVMFromOVA/transfer"
+)
+
+/*--------- copy tuning ---------*/
+
+// readAheadChunk is the size -read-ahead reads the source in unless
+// -copy-buffer is larger.
+const readAheadChunk = 1 << 20
+
+// readingAhead returns the source disk r read -read-ahead ahead in the
+// background, or r itself without it. Closing it stops the reading.
+func readingAhead(r io.Reader) io.ReadCloser {
+	if readAheadSize == 0 {
+		return io.NopCloser(r)
+	}
+	size := max(int(copyBuffer), readAheadChunk)
+	return transfer.ReadAhead(r, size, int(readAheadSize))
+}
+
+// syncWriter flushes f to disk every time -fsync-interval more bytes have
+// been written to it, so the page cache never holds much of a copy
+// unwritten, which a crash would lose and the flush at the end of a large
+// image would stall on. With -drop-cache the flushed pages are then
+// dropped from the cache.
+type syncWriter struct {
+	f            *os.File
+	every, since int64
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	n, err := s.f.Write(p)
+	if s.since += int64(n); err == nil && s.since >= s.every {
+		s.since = 0
+		if err = s.f.Sync(); err == nil && *dropCache {
+			fadviseDontNeed(s.f, 0, 0)
+		}
+	}
+	return n, err
+}
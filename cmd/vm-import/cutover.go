@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+/*--------- cutover: shut down, final sync, import, power on, verify ---------*/
+
+// cutover is set by "vm-import cutover [flags]", a run that shuts the
+// source VMs down in vSphere before their final sync, then imports, starts
+// and waits for each guest, the riskiest part of a migration as one
+// command.
+var cutover bool
+
+// setupCutover checks and completes the flags of a cutover run: it needs
+// -vsphere, and implies -import, -power-on and -wait-guest.
+func setupCutover() error {
+	if !cutover {
+		return nil
+	}
+	if *vsURL == "" {
+		return fmt.Errorf("needs -vsphere to shut the source VMs down")
+	}
+	if *watch || *listen != "" {
+		return fmt.Errorf("not with -watch or -listen")
+	}
+	if !*vsCBT {
+		slog.Warn("without -vsphere-cbt the final sync exports each VM whole")
+	}
+	*autoImp = true
+	*powerOn = true
+	if *waitGuest == 0 {
+		*waitGuest = smokeWait
+	}
+	return nil
+}
+
+// shutdownSources shuts the named VMs down in vSphere through their guests,
+// for a cutover, after the operator has confirmed it in an interactive
+// run, and waits up to -shutdown-timeout for each to be powered off.
+func shutdownSources(names []string) error {
+	if !cutover {
+		return nil
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("cutover needs -vms or -manifest")
+	}
+	if *dryRun {
+		for _, n := range names {
+			slog.Info("[dry-run] shut down in vSphere", "vm", n)
+		}
+		return nil
+	}
+	if interactive() {
+		promptMu.Lock()
+		fmt.Fprintf(stdout{}, "Shut down %s in vSphere for the cutover? (y/N): ", strings.Join(names, ", "))
+		resp, _ := stdin.ReadString('\n')
+		promptMu.Unlock()
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(resp)), "y") {
+			return fmt.Errorf("not confirmed")
+		}
+	}
+	c, err := newVSphereClient(*vsURL, *vsUser, *vsPass)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if err := c.shutdownVM(n, *vsShutdown); err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+	}
+	return nil
+}
+
+// shutdownVM asks the named VM's guest to shut down through VMware Tools
+// and waits up to timeout for the VM to be powered off.
+func (c *vsphereClient) shutdownVM(name string, timeout time.Duration) error {
+	vm, err := c.findVM(name, "runtime.powerState", "guest.toolsRunningStatus")
+	if err != nil {
+		return err
+	}
+	if vm.prop("runtime.powerState") == "poweredOff" {
+		slog.Info("source already powered off", "vm", name)
+		return nil
+	}
+	if vm.prop("guest.toolsRunningStatus") != "guestToolsRunning" {
+		return fmt.Errorf("VMware Tools are not running, so the guest cannot be shut down cleanly – shut it down by hand")
+	}
+	slog.Info("⏻ shutting down the source", "vm", name)
+	if err := c.call(runCtx, fmt.Sprintf(`<ShutdownGuest xmlns="urn:vim25">%s</ShutdownGuest>`, this(vm.Obj)), nil); err != nil {
+		return fmt.Errorf("shut down: %w", err)
+	}
+	spec := fmt.Sprintf(`<propSet><type>VirtualMachine</type><pathSet>runtime.powerState</pathSet></propSet>`+
+		`<objectSet><obj type="VirtualMachine">%s</obj><skip>false</skip></objectSet>`, xmlText(vm.Obj.Value))
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-runCtx.Done():
+			return interrupted()
+		case <-time.After(5 * time.Second):
+		}
+		objs, err := c.retrieve(spec)
+		if err != nil {
+			return err
+		}
+		if len(objs) > 0 && objs[0].prop("runtime.powerState") == "poweredOff" {
+			slog.Info("✓ source powered off", "vm", name)
+			return nil
+		}
+	}
+	return fmt.Errorf("still running after -shutdown-timeout %s", timeout)
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*--------- daemon mode ---------*/
+
+// job is one VM migration submitted to the daemon. Jobs run one at a time
+// in submission order, as processVM works on the shared staging backend.
+type job struct {
+	ID       int       `json:"id"`
+	VM       string    `json:"vm"`
+	Import   bool      `json:"import"` // import via the Scale API afterwards
+	State    string    `json:"state"`  // queued, running, done, failed, cancelled
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	StartAt  time.Time `json:"start_at"` // not before this (zero: as soon as possible)
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Progress *progress `json:"progress,omitempty"`
+
+	log    *jobLog
+	ctx    context.Context // processVM's, so cancelling stops the job mid-step
+	cancel context.CancelCauseFunc
+}
+
+// errJobCancelled is the cause of a job's context once it is cancelled
+// through the API.
+var errJobCancelled = errors.New("job cancelled")
+
+// jobContext returns a job's context: a child of runCtx, so a signal
+// stops the running job too.
+func jobContext() (context.Context, context.CancelCauseFunc) {
+	return context.WithCancelCause(runCtx)
+}
+
+// progress tracks the disk a running job is copying.
+type progress struct {
+	mu           sync.Mutex
+	disk         string
+	copied, size int64
+}
+
+func (p *progress) MarshalJSON() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return json.Marshal(map[string]any{"disk": p.disk, "copied": p.copied, "size": p.size})
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.copied += int64(len(b))
+	p.mu.Unlock()
+	return len(b), nil
+}
+
+// jobLog is the console output captured while a job ran.
+type jobLog struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (l *jobLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	l.buf = append(l.buf, b...)
+	l.mu.Unlock()
+	return len(b), nil
+}
+
+func (l *jobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return string(l.buf)
+}
+
+// jobQueue holds every job the daemon has seen and feeds queued ones to
+// a single worker. With a file set, every change is persisted there.
+type jobQueue struct {
+	mu     sync.Mutex
+	jobs   []*job
+	nextID int
+	wake   chan struct{}
+	file   string
+	idle   chan struct{} // closed when the worker stops after a signal
+
+	saveErr error // from the last save, for /readyz
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{nextID: 1, wake: make(chan struct{}, 1), idle: make(chan struct{})}
+}
+
+func (q *jobQueue) submit(vm string, imp bool, at time.Time) *job {
+	ctx, cancel := jobContext()
+	q.mu.Lock()
+	j := &job{ID: q.nextID, VM: vm, Import: imp, State: "queued", Created: time.Now(), StartAt: at,
+		log: &jobLog{}, ctx: ctx, cancel: cancel}
+	q.nextID++
+	q.jobs = append(q.jobs, j)
+	q.save()
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return j
+}
+
+// snapshot returns a copy of job id, or false if there is none.
+func (q *jobQueue) snapshot(id int) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return *j, true
+		}
+	}
+	return job{}, false
+}
+
+// retry queues a fresh job for the VM of a finished job id.
+func (q *jobQueue) retry(id int) (*job, error) {
+	j, ok := q.snapshot(id)
+	if !ok {
+		return nil, errNoJob
+	}
+	if j.State == "queued" || j.State == "running" {
+		return nil, fmt.Errorf("job %d is still %s", id, j.State)
+	}
+	return q.submit(j.VM, j.Import, time.Time{}), nil
+}
+
+func (q *jobQueue) list() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]job, len(q.jobs))
+	for i, j := range q.jobs {
+		out[i] = *j
+	}
+	return out
+}
+
+// cancel stops a queued job outright and aborts a running one wherever it
+// is: copy, conversion, hook, settle wait or API call. Finished jobs are
+// left alone.
+func (q *jobQueue) cancel(id int) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.ID != id {
+			continue
+		}
+		switch j.State {
+		case "queued":
+			j.State, j.Finished = "cancelled", time.Now()
+		case "running":
+		default:
+			return *j, fmt.Errorf("job %d already %s", id, j.State)
+		}
+		j.cancel(errJobCancelled)
+		q.save()
+		return *j, nil
+	}
+	return job{}, errNoJob
+}
+
+var errNoJob = errors.New("no such job")
+
+// next blocks until a queued job is due inside a maintenance window and
+// marks it running. It returns nil once the run is interrupted.
+func (q *jobQueue) next() *job {
+	for {
+		now := time.Now()
+		q.mu.Lock()
+		if inWindow(now) {
+			for _, j := range q.jobs {
+				if j.State == "queued" && !j.StartAt.After(now) {
+					j.State, j.Started = "running", now
+					q.save()
+					q.mu.Unlock()
+					return j
+				}
+			}
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.wake:
+		case <-time.After(30 * time.Second):
+		case <-runCtx.Done():
+			return nil
+		}
+	}
+}
+
+// requeue puts a job interrupted by a signal back in the queue, so it
+// runs again when the daemon restarts.
+func (q *jobQueue) requeue(j *job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.State, j.Started, j.Progress = "queued", time.Time{}, nil
+	q.save()
+}
+
+func (q *jobQueue) finish(j *job, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.Finished = time.Now()
+	switch {
+	case j.ctx.Err() != nil:
+		j.State = "cancelled"
+	case err != nil:
+		j.State, j.Error = "failed", err.Error()
+	default:
+		j.State = "done"
+	}
+	j.cancel(nil)
+	q.save()
+}
+
+func (q *jobQueue) work() {
+	defer close(q.idle)
+	for {
+		j := q.next()
+		if j == nil {
+			return
+		}
+		current.Store(j)
+		err := processVM(j.ctx, j.VM, j.Import)
+		current.Store(nil)
+		if interrupted() != nil {
+			q.requeue(j)
+			return
+		}
+		switch {
+		case errors.Is(err, errJobCancelled):
+			slog.Warn("job cancelled", "job", j.ID, "vm", j.VM)
+		case err != nil:
+			slog.Error("job failed", "job", j.ID, "vm", j.VM, "err", err)
+		}
+		q.finish(j, err)
+	}
+}
+
+// current is the job processVM is working on in daemon mode, or nil.
+var current atomic.Pointer[job]
+
+// jobReader wraps the reader for source disk name so the current daemon
+// job reports its progress; outside daemon mode it returns r unchanged.
+func jobReader(name string, r io.Reader) io.Reader {
+	j := current.Load()
+	if j == nil {
+		return r
+	}
+	size, _ := ova.Size(name)
+	p := &progress{disk: path.Base(name), size: size}
+	queue.mu.Lock()
+	j.Progress = p
+	queue.mu.Unlock()
+	return io.TeeReader(r, p)
+}
+
+/*--------- REST API ---------*/
+
+// uiHTML is the single-page web UI served at the daemon root.
+//
+//go:embed ui.html
+var uiHTML []byte
+
+// queue is the daemon's job queue, or nil outside daemon mode.
+var queue *jobQueue
+
+// serveDaemon runs the web UI at / and the REST control API on addr until
+// it fails:
+//
+//	GET    /api/v1/vms              VMs ready to process
+//	GET    /api/v1/jobs             all jobs
+//	POST   /api/v1/jobs             submit {"vm": "name", "import": true, "start_at": "Sat 22:00"} (or {"vms": [...]})
+//	GET    /api/v1/jobs/{id}        one job
+//	GET    /api/v1/jobs/{id}/log    its console output
+//	POST   /api/v1/jobs/{id}/retry  queue the job's VM again
+//	DELETE /api/v1/jobs/{id}        cancel a job
+//	GET    /healthz                 liveness
+//	GET    /readyz                  readiness: HC3 API, OVA and staging dirs, share, queue
+func serveDaemon(addr, token string) error {
+	if err := captureOutput(); err != nil {
+		return err
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	q := newJobQueue()
+	if err := q.loadJobs(filepath.Join(dir, "jobs.json")); err != nil {
+		return err
+	}
+	queue = q
+	go q.work()
+	if *watch {
+		go func() {
+			if err := watchOVAs(*watchInterval, func(vm string) { q.submit(vm, *autoImp, time.Time{}) }); err != nil {
+				slog.Warn("watch stopped", "err", err)
+			}
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/vms", func(w http.ResponseWriter, r *http.Request) {
+		vms, err := discoverVMs()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"vms": vms})
+	})
+	mux.HandleFunc("GET /api/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"jobs": q.list()})
+	})
+	mux.HandleFunc("POST /api/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			VM      string   `json:"vm"`
+			VMs     []string `json:"vms"`
+			Import  bool     `json:"import"`
+			StartAt string   `json:"start_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.VM != "" {
+			req.VMs = append([]string{req.VM}, req.VMs...)
+		}
+		if len(req.VMs) == 0 {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("no vm given"))
+			return
+		}
+		var at time.Time
+		if req.StartAt != "" {
+			var err error
+			if at, err = parseStartAt(req.StartAt, time.Now()); err != nil {
+				httpError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		var out []job
+		for _, vm := range req.VMs {
+			out = append(out, *q.submit(strings.TrimSpace(vm), req.Import || *autoImp, at))
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"jobs": out})
+	})
+	mux.HandleFunc("GET /api/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		j, ok := q.snapshot(id)
+		if !ok {
+			httpError(w, http.StatusNotFound, errNoJob)
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	})
+	mux.HandleFunc("GET /api/v1/jobs/{id}/log", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		j, ok := q.snapshot(id)
+		if !ok {
+			httpError(w, http.StatusNotFound, errNoJob)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, j.log.String())
+	})
+	mux.HandleFunc("POST /api/v1/jobs/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		j, err := q.retry(id)
+		switch {
+		case errors.Is(err, errNoJob):
+			httpError(w, http.StatusNotFound, err)
+		case err != nil:
+			httpError(w, http.StatusConflict, err)
+		default:
+			writeJSON(w, http.StatusCreated, *j)
+		}
+	})
+	mux.HandleFunc("DELETE /api/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		j, err := q.cancel(id)
+		switch {
+		case errors.Is(err, errNoJob):
+			httpError(w, http.StatusNotFound, err)
+		case err != nil:
+			httpError(w, http.StatusConflict, err)
+		default:
+			writeJSON(w, http.StatusAccepted, j)
+		}
+	})
+
+	mux.HandleFunc("GET /healthz", healthz(q))
+	mux.HandleFunc("GET /readyz", readyz(q))
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(uiHTML)
+	})
+
+	if *grpcListen != "" {
+		go func() {
+			if err := serveGRPC(*grpcListen, token, q); err != nil {
+				fatal("serving gRPC", "addr", *grpcListen, "err", err)
+			}
+		}()
+	}
+
+	var h http.Handler = mux
+	if token != "" {
+		h = requireToken(token, mux)
+	}
+	srv := &http.Server{Addr: addr, Handler: h}
+	go func() {
+		<-runCtx.Done()
+		<-q.idle
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	slog.Info("🛰  daemon listening", "addr", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// captureOutput routes everything printed to stdout through a pipe so the
+// lines a job prints also land in its log, while still reaching the
+// terminal.
+func captureOutput() error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadBytes('\n')
+			orig.Write(line)
+			if j := current.Load(); j != nil {
+				j.log.Write(line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// requireToken rejects API requests without "Authorization: Bearer <token>".
+// The UI page itself is static and asks the user for the token; the health
+// checks stay open for probes.
+func requireToken(token string, next http.Handler) http.Handler {
+	open := map[string]bool{"/": true, "/healthz": true, "/readyz": true}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !open[r.URL.Path] && r.Header.Get("Authorization") != "Bearer "+token {
+			httpError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
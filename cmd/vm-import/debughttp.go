@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+)
+
+/*--------- HTTP debug tracing ---------*/
+
+// maxDebugBody caps how much of a textual body -debug-http prints; binary
+// bodies such as disk downloads are never dumped.
+const maxDebugBody = 64 << 10
+
+// debugTransport dumps every request and response to stderr, redacted so
+// the output can be shared with support.
+type debugTransport struct{ base http.RoundTripper }
+
+// debugRT wraps rt for -debug-http; without the flag it returns rt as is.
+// A nil rt means http.DefaultTransport.
+func debugRT(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if !*debugHTTP {
+		return rt
+	}
+	return debugTransport{rt}
+}
+
+func (d debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	withBody := textual(req.Header) && req.ContentLength >= 0 && req.ContentLength <= maxDebugBody
+	if dump, err := httputil.DumpRequestOut(req, withBody); err == nil {
+		printDump("→", dump)
+	}
+	resp, err := d.base.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(stderr{}, "← %s %s: %v\n\n", req.Method, redact(req.URL.String()), err)
+		return nil, err
+	}
+	dump, _ := httputil.DumpResponse(resp, false)
+	if textual(resp.Header) {
+		head := make([]byte, maxDebugBody+1)
+		n, _ := io.ReadFull(resp.Body, head)
+		head = head[:n]
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+		if n > maxDebugBody {
+			head = append(head[:maxDebugBody], "\n… (truncated)"...)
+		}
+		dump = append(dump, head...)
+	}
+	printDump("←", dump)
+	return resp, nil
+}
+
+func textual(h http.Header) bool {
+	mt, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "json") || strings.HasSuffix(mt, "xml") ||
+		mt == "application/x-www-form-urlencoded"
+}
+
+func printDump(dir string, dump []byte) {
+	s := strings.ReplaceAll(redact(string(dump)), "\r\n", "\n")
+	fmt.Fprintf(stderr{}, "%s %s\n\n", dir, strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n  "))
+}
+
+var redactions = []struct {
+	re   *regexp.Regexp
+	with string
+}{
+	{regexp.MustCompile(`(?im)^((?:Proxy-)?Authorization|Cookie|Set-Cookie|X-Amz-Security-Token): .*$`), "$1: REDACTED"},
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://)[^/@\s"'<>]+@`), "${1}REDACTED@"},
+	{regexp.MustCompile(`(?i)(<(?:\w+:)?password>)[^<]*(</)`), "${1}REDACTED$2"},
+	{regexp.MustCompile(`(?i)("(?:password|pass|secret|token)"\s*:\s*)"[^"]*"`), `$1"REDACTED"`},
+	{regexp.MustCompile(`(?i)(X-Amz-Signature=|X-Amz-Credential=)[^&\s]+`), "${1}REDACTED"},
+}
+
+// redact blanks credentials: auth and cookie headers, userinfo in URIs
+// (SMB share prefixes carry domain;user:password), and password fields in
+// XML and JSON bodies.
+func redact(s string) string {
+	for _, r := range redactions {
+		s = r.re.ReplaceAllString(s, r.with)
+	}
+	return s
+}
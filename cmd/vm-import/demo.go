@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/base64"
+	"log/slog"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3/hc3test"
+)
+
+/*--------- -demo ---------*/
+
+// startDemo points -api at an in-process mock HC3 cluster when -demo is
+// set, so the whole pipeline – imports included – runs without a cluster.
+// The returned func lists what the mock received and stops it.
+func startDemo() (stop func()) {
+	if !*demo {
+		return func() {}
+	}
+	srv := hc3test.NewServer(hc3test.WithCredentials(*apiUser, *apiPass))
+	*apiURL = srv.URL
+	slog.Warn("demo mode – imports go to a mock HC3 API, not a cluster", "api", srv.URL)
+	return func() {
+		for _, r := range srv.Imports() {
+			name := strings.TrimSuffix(r.Source.DefinitionFileName, ".xml")
+			if r.Template != nil && r.Template.Name != "" {
+				name = r.Template.Name
+			}
+			slog.Info("demo: mock HC3 received import", "name", name, "pathURI", redact(r.Source.PathURI))
+			if r.Template != nil && r.Template.CloudInitData != nil {
+				meta, _ := base64.StdEncoding.DecodeString(r.Template.CloudInitData.MetaData)
+				slog.Info("demo: with cloud-init data", "name", name, "metaData", string(meta))
+			}
+		}
+		for _, iso := range srv.ISOs() {
+			slog.Info("demo: mock HC3 received ISO", "name", iso.Name, "size", iso.Size)
+		}
+		for _, vm := range srv.VMs() {
+			for _, d := range vm.BlockDevs {
+				slog.Info("demo: mock HC3 attached device", "vm", vm.Name, "type", d.Type, "path", d.Path)
+			}
+		}
+		for _, s := range srv.Snapshots() {
+			slog.Info("demo: mock HC3 took snapshot", "vm", s.DomainUUID, "label", s.Label)
+		}
+		srv.Close()
+	}
+}
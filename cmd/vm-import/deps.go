@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*--------- dependencies between VMs ---------*/
+
+var (
+	importsMu   sync.Mutex
+	importsByVM = map[string][]queuedImport{} // the imports each VM of the run queued
+)
+
+// recordImports remembers the imports queued for vm, for its dependents
+// to wait on.
+func recordImports(vm string, qs []queuedImport) {
+	importsMu.Lock()
+	defer importsMu.Unlock()
+	importsByVM[vm] = qs
+}
+
+// importsOf returns the imports queued for vm.
+func importsOf(vm string) []queuedImport {
+	importsMu.Lock()
+	defer importsMu.Unlock()
+	return importsByVM[vm]
+}
+
+// after returns the VMs the manifest says vm waits for.
+func (m *manifest) after(vm string) []string {
+	if v := m.vm(vm); v != nil {
+		out := make([]string, len(v.After))
+		for i, d := range v.After {
+			out[i] = normalizeName(d)
+		}
+		return out
+	}
+	return nil
+}
+
+// checkDeps checks the manifest's "after" lists: no VM waits for itself,
+// directly or through others, nor for a VM of a lower priority, which
+// would only be started after it.
+func (m *manifest) checkDeps() error {
+	for _, v := range m.VMs {
+		for _, d := range v.After {
+			if dv := m.vm(d); dv != nil && dv.Priority < v.Priority {
+				return fmt.Errorf("%s (priority %d) waits for %s of the lower priority %d", v.Name, v.Priority, d, dv.Priority)
+			}
+		}
+	}
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(vm string, path []string) error
+	visit = func(vm string, path []string) error {
+		switch state[vm] {
+		case 1:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, vm), " → "))
+		case 2:
+			return nil
+		}
+		state[vm] = 1
+		for _, d := range m.after(vm) {
+			if err := visit(d, append(path, vm)); err != nil {
+				return err
+			}
+		}
+		state[vm] = 2
+		return nil
+	}
+	for _, v := range m.VMs {
+		if err := visit(v.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderByDeps orders a wave so every VM comes after the VMs of the wave
+// it waits for, keeping the given order otherwise. checkDeps has ruled out
+// cycles.
+func (m *manifest) orderByDeps(wave []string) []string {
+	var out []string
+	done := map[string]bool{}
+	var add func(vm string)
+	add = func(vm string) {
+		if done[vm] {
+			return
+		}
+		done[vm] = true
+		for _, d := range m.after(vm) {
+			if slices.Contains(wave, d) {
+				add(d)
+			}
+		}
+		out = append(out, vm)
+	}
+	for _, vm := range wave {
+		add(vm)
+	}
+	return out
+}
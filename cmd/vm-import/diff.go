@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*--------- unified diff ---------*/
+
+// unifiedDiff returns a unified diff (3 lines of context) turning a into
+// b, labelled with the two names; "" when they are equal. It diffs by line
+// with a plain LCS table, which is fine for files the size of a Scale XML.
+func unifiedDiff(nameA, nameB string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	x, y := splitLines(string(a)), splitLines(string(b))
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	type line struct {
+		op   byte // ' ', '-' or '+'
+		text string
+		i, j int // lines of x and y before this one
+	}
+	var ops []line
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, line{' ', x[i], i, j})
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, line{'-', x[i], i, j})
+			i++
+		default:
+			ops = append(ops, line{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var b2 strings.Builder
+	fmt.Fprintf(&b2, "--- %s\n+++ %s\n", nameA, nameB)
+	for k := 0; k < len(ops); {
+		if ops[k].op == ' ' {
+			k++
+			continue
+		}
+		// a hunk runs from context lines before this change to context
+		// lines after the last change less than 2*context lines away
+		lo := max(k-context, 0)
+		hi := k
+		for n := k; n < len(ops); n++ {
+			if ops[n].op != ' ' {
+				hi = n
+			} else if n-hi > 2*context {
+				break
+			}
+		}
+		hi = min(hi+context+1, len(ops))
+		var na, nb int
+		for _, o := range ops[lo:hi] {
+			if o.op != '+' {
+				na++
+			}
+			if o.op != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(&b2, "@@ -%s +%s @@\n", hunkRange(ops[lo].i, na), hunkRange(ops[lo].j, nb))
+		for _, o := range ops[lo:hi] {
+			b2.WriteByte(o.op)
+			b2.WriteString(o.text)
+			if !strings.HasSuffix(o.text, "\n") {
+				b2.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = hi
+	}
+	return b2.String()
+}
+
+// splitLines splits s after each newline, keeping them.
+func splitLines(s string) []string {
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
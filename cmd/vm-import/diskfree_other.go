@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+func diskFree(dir string) (int64, error) {
+	return 0, errors.New("free-space check not supported on this platform")
+}
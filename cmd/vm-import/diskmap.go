@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+/*--------- disk mapping ---------*/
+
+// confirmBelow is the pairing confidence from which on no confirmation is
+// needed, as set by -confirm-pairing.
+var confirmBelow = confLow
+
+// mapDisks pairs the VM's source disks with the Scale disk UUIDs and
+// returns the pairs as two slices of equal length; an empty UUID asks for
+// a new Scale disk. A mapping file (see
+// readMapping) decides when there is one; else -pairing proposes pairs.
+// When the counts differ, or with -confirm-pairing the proposal is not
+// sure enough, guessing could put a data disk where the boot disk belongs,
+// so an interactive run asks the operator and any other run fails.
+func mapDisks(vm string, srcFiles, uuids []string) ([]string, []string, error) {
+	m, file, err := readMapping(vm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m != nil {
+		vmLog(vm).Debug("disk mapping from file", "file", file)
+		return applyMapping(file, m, srcFiles, uuids)
+	}
+	prop, conf := pairDisks(vm, srcFiles, uuids)
+	reportPairing(vm, srcFiles, uuids, prop, conf)
+	var why string
+	switch {
+	case len(srcFiles) != len(uuids) && !(*addDisks && len(srcFiles) > len(uuids)):
+		vmLog(vm).Warn("disk count mismatch", "source", len(srcFiles), "scale", len(uuids))
+		why = fmt.Sprintf("disk count mismatch (%d source, %d Scale disks)", len(srcFiles), len(uuids))
+	case conf < confirmBelow:
+		vmLog(vm).Warn("disk pairing needs confirmation", "confidence", conf)
+		why = fmt.Sprintf("%s-confidence disk pairing", conf)
+	default:
+		var src, dst []string
+		for i, j := range prop {
+			if j >= 0 {
+				src, dst = append(src, srcFiles[i]), append(dst, uuids[j])
+			} else if *addDisks {
+				src, dst = append(src, srcFiles[i]), append(dst, "")
+			}
+		}
+		return src, dst, nil
+	}
+	if !interactive() {
+		return nil, nil, fmt.Errorf("%s – run interactively or write %s.mapping.yaml to map the disks", why, vm)
+	}
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	return promptMapping(vm, srcFiles, uuids, prop, stdin)
+}
+
+// reportPairing logs the proposed pairs with their sizes and confidence.
+func reportPairing(vm string, srcFiles, uuids []string, prop []int, conf confidence) {
+	lg := vmLog(vm)
+	if len(srcFiles) > 1 || len(uuids) > 1 {
+		lg.Info("⇄ disk pairing", "strategy", *pairing, "confidence", conf)
+	}
+	src, dst := sourceDiskInfo(vm, srcFiles), scaleDiskInfo(vm, uuids)
+	size := func(n int64) string {
+		if n <= 0 {
+			return "?"
+		}
+		return humanBytes(n)
+	}
+	for i, j := range prop {
+		if j < 0 {
+			to := "(none)"
+			if *addDisks {
+				to = "(new disk)"
+			}
+			lg.Debug("disk pairing", "src", srcFiles[i], "uuid", to)
+			continue
+		}
+		lg.Debug("disk pairing", "src", srcFiles[i], "uuid", uuids[j], "src_size", size(src[i].capacity),
+			"scale_size", size(dst[j].capacity), "confidence", sizeConfidence(src[i].capacity, dst[j].capacity))
+	}
+}
+
+// readMapping reads <vm>.mapping.yaml from the VM's staging directory,
+// else its OVA directory, returning nil when there is none. The file maps
+// source disk hrefs to Scale disk UUIDs, to new (a disk added to the Scale
+// XML) or to skip, one per line:
+//
+//	# centos7.mapping.yaml
+//	centos7-disk1.vmdk: 6f1c2d3e-…
+//	centos7-disk2.vmdk: new
+//	centos7-disk3.vmdk: skip
+//
+// Only this flat subset of YAML is understood.
+func readMapping(vm string) (map[string]string, string, error) {
+	if replayed != nil && replayed.VM == vm {
+		return replayed.Mapping, "the mapping of the run of " + replayed.Start.Local().Format("2006-01-02 15:04"), nil
+	}
+	name := path.Join(vm, path.Base(vm)+".mapping.yaml")
+	var data []byte
+	var file string
+	if stage.Exists(name) {
+		b, err := stage.ReadFile(name)
+		if err != nil {
+			return nil, "", err
+		}
+		data, file = b, under(*scaleDir, name)
+	} else if ms, _ := ova.Glob(name); len(ms) > 0 {
+		f, err := ova.Open(name)
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		data, file = b, under(*ovaDir, name)
+	} else {
+		return nil, "", nil
+	}
+	m := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.Index(line, " #"); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		k, v = unquote(strings.TrimSpace(k)), unquote(strings.TrimSpace(v))
+		if !ok || k == "" || v == "" {
+			return nil, "", fmt.Errorf("%s:%d: want \"<href>: <uuid|new|skip>\"", file, i+1)
+		}
+		if _, dup := m[k]; dup {
+			return nil, "", fmt.Errorf("%s:%d: %s mapped twice", file, i+1, k)
+		}
+		m[k] = v
+	}
+	return m, file, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// applyMapping pairs the source disks as the mapping file says. Every
+// source disk must be listed, and every entry must name a source disk and
+// a Scale disk (once), new or skip, so stale files fail instead of mapping
+// wrongly.
+func applyMapping(file string, m map[string]string, srcFiles, uuids []string) ([]string, []string, error) {
+	isSrc, isUUID, taken := map[string]bool{}, map[string]bool{}, map[string]string{}
+	for _, f := range srcFiles {
+		isSrc[f] = true
+	}
+	for _, u := range uuids {
+		isUUID[u] = true
+	}
+	for k := range m {
+		if !isSrc[k] {
+			return nil, nil, fmt.Errorf("%s: %s is not a disk of this export", file, k)
+		}
+	}
+	var src, dst []string
+	for _, f := range srcFiles {
+		u, ok := m[f]
+		switch {
+		case !ok:
+			return nil, nil, fmt.Errorf("%s: source disk %s is not mapped (map it to a UUID, new or skip)", file, f)
+		case u == "skip":
+			continue
+		case u == "new":
+			src, dst = append(src, f), append(dst, "")
+			continue
+		case !isUUID[u]:
+			return nil, nil, fmt.Errorf("%s: %s is not a disk UUID in the Scale XML", file, u)
+		case taken[u] != "":
+			return nil, nil, fmt.Errorf("%s: %s and %s both map to %s", file, taken[u], f, u)
+		}
+		taken[u] = f
+		src, dst = append(src, f), append(dst, u)
+	}
+	return src, dst, nil
+}
+
+// promptMapping lets the operator assign each source disk to a Scale disk
+// (a, b, …), to a new one or skip it, offering the proposed pairing prop
+// as defaults.
+func promptMapping(vm string, srcFiles, uuids []string, prop []int, in *bufio.Reader) ([]string, []string, error) {
+	out := stdout{}
+	letter := func(i int) string { return string(rune('a' + i)) }
+	size := func(n int64) string {
+		if n <= 0 {
+			return "size unknown"
+		}
+		return humanBytes(n)
+	}
+	srcInfo, dstInfo := sourceDiskInfo(vm, srcFiles), scaleDiskInfo(vm, uuids)
+	fmt.Fprintf(out, "\n%s: %d source disk(s), %d in the Scale XML – map them:\n", vm, len(srcFiles), len(uuids))
+	for i, u := range uuids {
+		fmt.Fprintf(out, "  %s) %s (%s)\n", letter(i), u, size(dstInfo[i].capacity))
+	}
+	for {
+		var src, dst []string
+		used := map[int]bool{}
+		for i, f := range srcFiles {
+			c := srcInfo[i].capacity
+			if c <= 0 {
+				c, _ = ova.Size(path.Join(vm, f))
+			}
+			def := "s"
+			if j := prop[i]; j >= 0 && !used[j] {
+				def = letter(j)
+			} else if *addDisks {
+				def = "+"
+			}
+			for {
+				fmt.Fprintf(out, "  %d) %s (%s) → Scale disk [a-%s, +=new, s=skip] (%s): ", i+1, f, size(c), letter(len(uuids)-1), def)
+				line, err := in.ReadString('\n')
+				if err == io.EOF && line == "" {
+					return nil, nil, fmt.Errorf("no disk mapping given")
+				}
+				ans := strings.ToLower(strings.TrimSpace(line))
+				if ans == "" {
+					ans = def
+				}
+				if ans == "s" {
+					break
+				}
+				if ans == "+" {
+					src, dst = append(src, f), append(dst, "")
+					break
+				}
+				j := -1
+				if len(ans) == 1 {
+					j = int(ans[0] - 'a')
+				}
+				if j < 0 || j >= len(uuids) {
+					fmt.Fprintln(out, "     no such Scale disk")
+					continue
+				}
+				if used[j] {
+					fmt.Fprintln(out, "     already taken by another disk")
+					continue
+				}
+				used[j] = true
+				src, dst = append(src, f), append(dst, uuids[j])
+				break
+			}
+		}
+		fmt.Fprintln(out, "  mapping:")
+		for i := range src {
+			to := dst[i]
+			if to == "" {
+				to = "(new disk)"
+			}
+			fmt.Fprintf(out, "    %s → %s\n", src[i], to)
+		}
+		for j, u := range uuids {
+			if !used[j] {
+				if *pruneDisks {
+					fmt.Fprintf(out, "    (nothing) → %s – dropped from the Scale XML\n", u)
+				} else {
+					fmt.Fprintf(out, "    (nothing) → %s – fails without -prune-disks\n", u)
+				}
+			}
+		}
+		fmt.Fprint(out, "  Use this mapping? [Y/n/q]: ")
+		line, err := in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, nil, fmt.Errorf("no disk mapping given")
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "", "y", "yes":
+			for i := range src {
+				vmLog(vm).Info("disk mapped", "src", src[i], "uuid", dst[i])
+			}
+			return src, dst, nil
+		case "q":
+			return nil, nil, fmt.Errorf("disk mapping aborted")
+		}
+		fmt.Fprintln(out, "  again:")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- -n: the import request ---------*/
+
+// dryRunImport builds the import request of each of vm's targets as a
+// real run would, checks that its pathURI and definition file name point
+// at a Scale XML staged in the target's directory, and prints the body
+// that would be posted to cl, credentials redacted. Nothing is queued,
+// except with -demo, where the request goes to the mock to see it
+// accepted.
+func dryRunImport(ctx context.Context, vm string, targets []string, cl *cluster) error {
+	for k, t := range targets {
+		name, err := targetName(vm)
+		if *copies > 1 {
+			name, err = copyName(vm, k+1)
+		}
+		if err != nil {
+			return err
+		}
+		ci, err := cloudInit(vm, t, cmp.Or(name, t))
+		if err != nil {
+			return err
+		}
+		body, err := importRequest(ctx, t, name, ci, cl)
+		if err != nil {
+			return fmt.Errorf("%s: import request: %w", t, err)
+		}
+		if err := checkImportRequest(t, body, k == 0); err != nil {
+			return fmt.Errorf("%s: import request: %w", t, err)
+		}
+		var b bytes.Buffer
+		json.Indent(&b, body, "", "  ")
+		vmLog(t).Info("[dry-run] would post the import request", "cluster", cl.label(), "api", cl.api()+"/rest/v1/VirDomain/import")
+		promptMu.Lock() // keep parallel VMs' requests apart
+		fmt.Fprintln(stdout{}, redact(b.String()))
+		promptMu.Unlock()
+		if *demo {
+			out, err := hc3Client(cl, hc3.DefaultTimeout).ImportJSON(ctx, body)
+			if err != nil {
+				return fmt.Errorf("%s: the mock rejected the import request: %w", t, err)
+			}
+			vmLog(t).Info("[dry-run] the mock accepted the import request", "task", out.TaskTag)
+		}
+	}
+	return nil
+}
+
+// checkImportRequest checks that the import request body of vm names a
+// pathURI ending in vm's directory and, if vm is staged, a definition file
+// that is there. Copies are only staged in a real run.
+func checkImportRequest(vm string, body json.RawMessage, staged bool) error {
+	var req hc3.ImportRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return err
+	}
+	src := req.Source
+	if src.PathURI == "" || src.DefinitionFileName == "" {
+		return fmt.Errorf("no source pathURI or definitionFileName")
+	}
+	u, err := url.Parse(src.PathURI)
+	if err != nil {
+		return fmt.Errorf("pathURI does not parse")
+	}
+	if path.Base(strings.TrimRight(u.Path, "/")) != path.Base(vm) {
+		return fmt.Errorf("pathURI %s does not end in the VM's directory %s", redact(src.PathURI), vm)
+	}
+	if def := path.Join(vm, src.DefinitionFileName); staged && !stage.Exists(def) {
+		return fmt.Errorf("definition file %s is not staged", def)
+	}
+	return nil
+}
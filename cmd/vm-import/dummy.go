@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- removing the dummy VM ---------*/
+
+// dummyVM is the VM on the cluster whose export a Scale XML is, as the XML
+// names it before it is rewritten.
+type dummyVM struct{ uuid, name string }
+
+// readDummy reads which VM the Scale XML name was exported from.
+func readDummy(name string) (dummyVM, error) {
+	doc, err := readScaleXML(name)
+	if err != nil {
+		return dummyVM{}, err
+	}
+	var d dummyVM
+	top := doc.First()
+	if u := top.Child("uuid"); u != nil {
+		d.uuid = strings.TrimSpace(u.InnerText())
+	}
+	if n := top.Child("name"); n != nil {
+		d.name = strings.TrimSpace(n.InnerText())
+	}
+	return d, nil
+}
+
+// deleteDummy removes the dummy VM d of vm from cluster cl once its
+// replacement has been imported, so the migration doesn't leave two VMs
+// holding resources. It only deletes a VM that still has the name from the
+// Scale XML, is not running and is none of the VMs just created.
+func deleteDummy(ctx context.Context, vm string, d dummyVM, cl *cluster, created []string) error {
+	if d.uuid == "" {
+		return fmt.Errorf("the Scale XML names no VM UUID")
+	}
+	if slices.Contains(created, d.uuid) {
+		return fmt.Errorf("VM %s is one of the imported VMs", d.uuid)
+	}
+	c := hc3Client(cl, hc3.DefaultTimeout)
+	v, err := c.VM(ctx, d.uuid)
+	if errors.Is(err, hc3.ErrNotFound) {
+		vmLog(vm).Info("dummy VM is not on the cluster any more", "uuid", d.uuid)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.Name != d.name:
+		return fmt.Errorf("VM %s is called %q on the cluster, not %q as in the Scale XML", d.uuid, v.Name, d.name)
+	case v.State == "RUNNING":
+		return fmt.Errorf("dummy VM %s (%s) is running", d.name, d.uuid)
+	}
+	err = c.DeleteVM(ctx, d.uuid)
+	audit("delete-dummy", vm, err, auditEntry{UUID: d.uuid})
+	if err != nil {
+		return err
+	}
+	vmLog(vm).Info("🗑 deleted dummy VM", "name", d.name, "uuid", d.uuid, "cluster", cl.label())
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- failure classes ---------*/
+
+// Failure classes of a VM, recorded as errorClass in the history, report
+// and progress events so automation can branch on them.
+const (
+	classDiscovery  = "discovery"  // finding the export, its OVF and disks
+	classTransfer   = "transfer"   // staging: free space, deleting, copying
+	classConversion = "conversion" // qemu-img converting a disk
+	classXML        = "xml"        // reading, editing or validating the Scale XML
+	classAPI        = "api"        // HC3 REST calls: import and what follows
+	classOther      = "other"
+)
+
+// classExit is the exit status of a run whose failed VMs all
+// failed with the same class; mixed classes exit with exitVMsFailed.
+var classExit = map[string]int{
+	classDiscovery:  4,
+	classTransfer:   5,
+	classConversion: 6,
+	classXML:        7,
+	classAPI:        8,
+}
+
+// classedError is an error of a known failure class.
+type classedError struct {
+	class string
+	err   error
+}
+
+func (e *classedError) Error() string { return e.err.Error() }
+func (e *classedError) Unwrap() error { return e.err }
+
+// classed marks err as of class, unless it is nil or already classed.
+func classed(class string, err error) error {
+	var ce *classedError
+	if err == nil || errors.As(err, &ce) {
+		return err
+	}
+	return &classedError{class, err}
+}
+
+// stepClass is the class of a failure in an unclassed error's pipeline
+// step, as named by runRecord.step; before the first step it is discovery.
+var stepClass = map[string]string{
+	"":               classDiscovery,
+	"settle":         classDiscovery,
+	"space-check":    classTransfer,
+	"delete":         classTransfer,
+	"copy":           classTransfer,
+	"clones":         classTransfer,
+	"checksums":      classTransfer,
+	"cleanup-source": classTransfer,
+	"tags":           classXML,
+	"import":         classAPI,
+	"machine-type":   classAPI,
+	"annotation":     classAPI,
+	"unattend":       classAPI,
+	"virtio-iso":     classAPI,
+	"grow-disks":     classAPI,
+	"power-on":       classAPI,
+	"template":       classAPI,
+	"checklist":      classAPI,
+	"delete-dummy":   classAPI,
+}
+
+// errorClass returns the failure class of err, which happened in the
+// pipeline step named step: its own if classed, api for an HC3 API
+// error, else the step's. It returns "" for a nil err.
+func errorClass(err error, step string) string {
+	var ce *classedError
+	var ae *hc3.APIError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &ce):
+		return ce.class
+	case errors.As(err, &ae):
+		return classAPI
+	case stepClass[step] != "":
+		return stepClass[step]
+	}
+	return classOther
+}
+
+// batchExitCode returns the exit status of a run that had failed VMs:
+// that of their class if they share one, else exitVMsFailed.
+func batchExitCode() int {
+	batchMu.Lock()
+	defer batchMu.Unlock()
+	class := ""
+	for _, r := range batch {
+		switch {
+		case r.ErrorClass == "":
+		case class == "":
+			class = r.ErrorClass
+		case r.ErrorClass != class:
+			return exitVMsFailed
+		}
+	}
+	if c, ok := classExit[class]; ok {
+		return c
+	}
+	return exitVMsFailed
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*--------- estimate: transfer time and space per VM ---------*/
+
+// estimateSample is how much of a source disk the estimate copies into the
+// staging dir to measure throughput.
+const estimateSample int64 = 256 << 20
+
+// runEstimate implements "vm-import estimate [flags]": it takes the flags
+// of a run and, for the VMs it would process, sums the source disks, times
+// copying a sample of the largest one into the staging dir, and prints the
+// copy and conversion time and staging space each VM is projected to
+// need, for planning cutover windows. Conversions are projected from the
+// rate of those in the run history, or the sample's without any.
+func runEstimate(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if err := setupContainer(); err != nil {
+		return fmt.Errorf("reading configuration from the environment: %w", err)
+	}
+	if err := setupLogging(); err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	if *manifestPath != "" {
+		var err error
+		if plan, err = loadManifest(*manifestPath); err != nil {
+			return fmt.Errorf("loading manifest: %w", err)
+		}
+	}
+	var err error
+	if ova, err = newOVASource(); err != nil {
+		return fmt.Errorf("opening OVA source: %w", err)
+	}
+	if stage, err = newStager(); err != nil {
+		return fmt.Errorf("opening staging backend: %w", err)
+	}
+	defer stage.Close()
+
+	var vms []string
+	switch {
+	case *vmsFlag != "":
+		vms = strings.Split(*vmsFlag, ",")
+	case plan != nil:
+		vms = plan.names()
+	default:
+		if vms, err = discoverVMs(); err != nil {
+			return fmt.Errorf("discovering VMs: %w", err)
+		}
+	}
+	if len(vms) == 0 {
+		return fmt.Errorf("no valid VM dirs in %s", *ovaDir)
+	}
+
+	type disk struct {
+		size    int64
+		convert bool
+	}
+	disks := map[string][]disk{}
+	var largestVM, largest string
+	var largestSize int64
+	for i, vm := range vms {
+		vm = strings.TrimSpace(vm)
+		vms[i] = vm
+		srcs, err := sourceDisks(vm)
+		if err != nil {
+			return fmt.Errorf("%s: %w", vm, err)
+		}
+		for _, s := range srcs {
+			name := path.Join(vm, s)
+			size, err := ova.Size(name)
+			if err != nil {
+				return fmt.Errorf("%s: %w", vm, err)
+			}
+			disks[vm] = append(disks[vm], disk{size, needsConversion(name)})
+			if size > largestSize {
+				largestVM, largest, largestSize = vm, name, size
+			}
+		}
+	}
+	if largest == "" {
+		return fmt.Errorf("no source disks to measure")
+	}
+
+	copyRate, err := sampleCopy(largestVM, largest)
+	if err != nil {
+		return fmt.Errorf("measuring throughput to the staging dir: %w", err)
+	}
+	fmt.Fprintf(stdout{}, "copy throughput to %s: %s/s (sample of %s)\n", redact(*scaleDir), humanBytes(int64(copyRate)), largest)
+	convRate, err := historyConvertRate()
+	if err != nil {
+		return err
+	}
+	if convRate > 0 {
+		fmt.Fprintf(stdout{}, "conversion throughput: %s/s (from the run history)\n", humanBytes(int64(convRate)))
+	} else {
+		convRate = copyRate
+		fmt.Fprintln(stdout{}, "conversion throughput: as the copy (no conversions in the run history)")
+	}
+	fmt.Fprintln(stdout{})
+
+	eta := func(n int64, rate float64) time.Duration {
+		return time.Duration(float64(n) / rate * float64(time.Second)).Round(time.Second)
+	}
+	var totalSize int64
+	var totalCopy, totalConv time.Duration
+	fmt.Fprintf(stdout{}, "%-24s %5s %10s %10s %10s\n", "VM", "disks", "space", "copy", "convert")
+	for _, vm := range vms {
+		var size, copied, converted int64
+		for _, d := range disks[vm] {
+			size += d.size
+			if d.convert {
+				converted += d.size
+			} else {
+				copied += d.size
+			}
+		}
+		tc, tv := eta(copied, copyRate), eta(converted, convRate)
+		totalSize += size
+		totalCopy += tc
+		totalConv += tv
+		fmt.Fprintf(stdout{}, "%-24s %5d %10s %10s %10s\n", vm, len(disks[vm]), humanBytes(size), tc, tv)
+	}
+	fmt.Fprintf(stdout{}, "%d VM(s): %s of staging space, %s copying and %s converting one at a time\n",
+		len(vms), humanBytes(totalSize), totalCopy, totalConv)
+	if sc, ok := stage.(spaceChecker); ok {
+		if free, err := sc.Free(vms[0]); err == nil && free < totalSize {
+			fmt.Fprintf(stdout{}, "⚠ the staging dir has %s free\n", humanBytes(free))
+		}
+	}
+	return nil
+}
+
+// sampleCopy copies up to estimateSample bytes of the source disk src into
+// vm's staging dir, removes the copy again and returns the rate in bytes a
+// second.
+func sampleCopy(vm, src string) (float64, error) {
+	in, err := ova.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	name := path.Join(vm, ".estimate-sample.tmp")
+	var n atomic.Int64
+	t := time.Now()
+	err = stage.Put(name, io.TeeReader(io.LimitReader(in, estimateSample), countWriter{&n}))
+	d := time.Since(t)
+	stage.Remove(name)
+	if err != nil {
+		return 0, err
+	}
+	if n.Load() == 0 {
+		return 0, fmt.Errorf("%s is empty", src)
+	}
+	return float64(n.Load()) / max(d.Seconds(), 1e-3), nil
+}
+
+// historyConvertRate returns the bytes a second of the conversions in the
+// run history, 0 without any.
+func historyConvertRate() (float64, error) {
+	runs, err := readHistory()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	var d time.Duration
+	for _, r := range runs {
+		for _, dk := range r.Disks {
+			if dk.Mode == "convert" && dk.Duration > 0 {
+				size += dk.Size
+				d += dk.Duration
+			}
+		}
+	}
+	if d == 0 {
+		return 0, nil
+	}
+	return float64(size) / d.Seconds(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+/*--------- progress events ---------*/
+
+// event is one line of -progress-format=json output, and the body of a
+// -progress-webhook POST.
+type event struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"` // vm-started, stage-started, stage-finished, disk-started, bytes, task, vm-finished, batch-finished
+	VM      string    `json:"vm,omitempty"`
+	Stage   string    `json:"stage,omitempty"`
+	Disk    string    `json:"disk,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Percent int       `json:"percent,omitempty"`
+	Task    string    `json:"task,omitempty"`
+	State   string    `json:"state,omitempty"`
+	UUID    string    `json:"uuid,omitempty"`
+	Outcome string    `json:"outcome,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Class   string    `json:"errorClass,omitempty"` // of a failed VM, on vm-finished
+	Seconds float64   `json:"seconds,omitempty"`
+	VMs     int       `json:"vms,omitempty"`
+	Failed  int       `json:"failed,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"` // logged for the VM, on vm-finished
+}
+
+// eventOut is the real stdout; with JSON progress events the console log
+// moves to stderr so stdout carries nothing but events.
+var (
+	eventOut = os.Stdout
+	eventMu  sync.Mutex
+)
+
+func jsonEvents() bool { return *progressFormat == "json" }
+
+// events reports whether progress events go anywhere.
+func events() bool { return jsonEvents() || *progressHook != "" }
+
+// emit writes e as one NDJSON line when -progress-format=json and queues
+// it for the -progress-webhook.
+func emit(e event) { send(e, jsonEvents(), *progressHook != "") }
+
+// send writes e to stdout and queues it for the webhook as asked.
+func send(e event, out, hook bool) {
+	if !out && !hook {
+		return
+	}
+	e.Time = time.Now().UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if out {
+		eventMu.Lock()
+		eventOut.Write(append(b, '\n'))
+		eventMu.Unlock()
+	}
+	if hook {
+		queueProgressHook(b)
+	}
+}
+
+// finished fills in the outcome fields shared by the *-finished events.
+func (e event) finished(err error, d time.Duration) event {
+	e.Outcome, e.Seconds = "ok", d.Seconds()
+	if err != nil {
+		e.Outcome, e.Error = "failed", err.Error()
+	}
+	return e
+}
+
+// eventReader emits "bytes" events while the source disk src of vm is
+// read: on stdout at most once a second, to the webhook every 10%, and
+// to both once at the end.
+func eventReader(vm, src string, r io.Reader) io.Reader {
+	if !events() {
+		return r
+	}
+	size, _ := ova.Size(src)
+	return &byteEvents{r: r, e: event{Event: "bytes", VM: vm, Disk: path.Base(src), Total: size}}
+}
+
+type byteEvents struct {
+	r    io.Reader
+	e    event
+	last time.Time
+	sent int64
+	step int // tenths of the disk last sent to the webhook
+}
+
+func (b *byteEvents) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.e.Bytes += int64(n)
+	if b.e.Total > 0 {
+		b.e.Percent = int(b.e.Bytes * 100 / b.e.Total)
+	}
+	end := err == io.EOF && b.e.Bytes != b.sent
+	out := jsonEvents() && (end || time.Since(b.last) >= time.Second)
+	hook := *progressHook != "" && (end || b.e.Percent/10 > b.step)
+	if out || hook {
+		b.last, b.sent, b.step = time.Now(), b.e.Bytes, b.e.Percent/10
+		send(b.e, out, hook)
+	}
+	return n, err
+}
+
+// diskMode names how the source disk src is staged at dst, for the
+// disk-started event: convert, delta or copy.
+func diskMode(src, dst string) string {
+	switch {
+	case needsConversion(src):
+		return "convert"
+	case *delta && stage.Exists(dst):
+		return "delta"
+	}
+	return "copy"
+}
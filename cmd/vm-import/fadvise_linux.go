@@ -0,0 +1,19 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// canDropCache reports whether fadviseDontNeed does anything here.
+const canDropCache = true
+
+// fadviseDontNeed asks the kernel to drop n bytes of f from offset off –
+// the whole file for n 0 – from the page cache. Dirty pages stay until
+// written back, so written files are flushed first.
+func fadviseDontNeed(f *os.File, off, n int64) {
+	const dontNeed = 4 // POSIX_FADV_DONTNEED
+	syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), uintptr(off), uintptr(n), dontNeed, 0, 0)
+}
@@ -0,0 +1,9 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "os"
+
+const canDropCache = false
+
+func fadviseDontNeed(f *os.File, off, n int64) {}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*--------- HTTP(S) OVA sources ---------*/
+
+// fetchOVAs downloads every OVA given via -url or a manifest "url" field
+// into <ovadir>/<vm>/ and unpacks it, so the VM is discovered like any
+// other export.
+func fetchOVAs() error {
+	var jobs []manifestVM
+	for _, u := range urlFlags {
+		jobs = append(jobs, manifestVM{Name: vmNameFromURL(u), URL: u})
+	}
+	if plan != nil {
+		for _, v := range plan.VMs {
+			if v.URL != "" {
+				jobs = append(jobs, v)
+			}
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	if _, ok := ova.(localSource); !ok {
+		return fmt.Errorf("downloading OVAs needs a local -ovadir")
+	}
+	for _, j := range jobs {
+		if err := fetchOVA(j); err != nil {
+			return fmt.Errorf("%s: %w", j.Name, err)
+		}
+	}
+	return nil
+}
+
+func fetchOVA(j manifestVM) error {
+	dir := filepath.Join(*ovaDir, normalizeName(j.Name))
+	base := path.Base(j.URL)
+	if u, err := url.Parse(j.URL); err == nil {
+		base = path.Base(u.Path)
+	}
+	dst := filepath.Join(dir, base)
+
+	if *dryRun {
+		slog.Info("[dry-run] download", "url", j.URL, "dst", dst)
+		return nil
+	}
+	if fileExists(dst) {
+		slog.Info("✓ already downloaded", "file", base)
+	} else {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := download(j.URL, dst, j.SHA256); err != nil {
+			return err
+		}
+	}
+	if !strings.EqualFold(filepath.Ext(dst), ".ova") {
+		return nil
+	}
+	if err := extractOVA(dst, dir); err != nil {
+		return fmt.Errorf("extract %s: %w", base, err)
+	}
+	return verifyOVFManifest(dir)
+}
+
+// download fetches u into dst, resuming a previous dst+".part" with a
+// Range request where the server allows, and checks the SHA-256 if one
+// was given.
+func download(u, dst, wantSHA string) error {
+	part := dst + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	have, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(runCtx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if have > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+	}
+	resp, err := (&http.Client{Transport: debugRT(nil)}).Do(req)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		slog.Info("⟳ resuming download", "file", path.Base(dst), "offset", have)
+	case http.StatusOK:
+		if have > 0 {
+			slog.Info("⟳ server ignored resume – restarting", "file", path.Base(dst))
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		h.Reset()
+	case http.StatusRequestedRangeNotSatisfiable:
+		// .part is already complete
+	default:
+		return fmt.Errorf("download %s: HTTP %d", u, resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		slog.Info("⟳ downloading", "url", u)
+		if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+			return fmt.Errorf("download interrupted (rerun to resume): %w", err)
+		}
+	}
+	if wantSHA != "" {
+		if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, wantSHA) {
+			f.Close()
+			os.Remove(part)
+			return fmt.Errorf("checksum mismatch for %s: got %s, want %s", path.Base(dst), got, wantSHA)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Info("✓ downloaded", "file", path.Base(dst))
+	return os.Rename(part, dst)
+}
+
+// extractOVA unpacks the flat tar archive of an OVA into dir.
+func extractOVA(src, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Base(hdr.Name)
+		if name != path.Clean(hdr.Name) || name == ".." || name == "." {
+			return fmt.Errorf("unexpected entry %q in OVA", hdr.Name)
+		}
+		dst := filepath.Join(dir, name)
+		if fi, err := os.Stat(dst); err == nil && fi.Size() == hdr.Size {
+			continue
+		}
+		out, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		slog.Info("✓ extracted", "file", name)
+	}
+}
+
+// verifyOVFManifest checks the digests listed in the OVA's .mf file, if
+// it carries one ("SHA256(disk1.vmdk)= …").
+func verifyOVFManifest(dir string) error {
+	mfs := mustGlob(filepath.Join(dir, "*.mf"))
+	if len(mfs) == 0 {
+		return nil
+	}
+	f, err := os.Open(mfs[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		algo, rest, ok := strings.Cut(sc.Text(), "(")
+		if !ok {
+			continue
+		}
+		name, want, ok := strings.Cut(rest, ")=")
+		if !ok {
+			continue
+		}
+		var h hash.Hash
+		switch strings.ToUpper(algo) {
+		case "SHA1":
+			h = sha1.New()
+		case "SHA256":
+			h = sha256.New()
+		default:
+			continue
+		}
+		in, err := os.Open(filepath.Join(dir, path.Base(name)))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(h, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, strings.TrimSpace(want)) {
+			return fmt.Errorf("%s: %s mismatch against %s", name, algo, filepath.Base(mfs[0]))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	slog.Info("✓ verified", "manifest", filepath.Base(mfs[0]))
+	return nil
+}
+
+// vmNameFromURL derives a VM name from the last path element of u.
+func vmNameFromURL(u string) string {
+	p := u
+	if pu, err := url.Parse(u); err == nil {
+		p = pu.Path
+	}
+	b := path.Base(p)
+	return strings.TrimSuffix(b, path.Ext(b))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+/*--------- source fingerprints ---------*/
+
+// sourceFingerprint identifies the state of vm's export: a hash of its OVF
+// descriptor and of the name, size and modification time of each source
+// disk. Runs record it so -changed-only can tell a refreshed export from
+// one already migrated.
+func sourceFingerprint(vm string, srcFiles []string) (string, error) {
+	h := sha256.New()
+	desc, err := ovfFile(vm)
+	if err != nil {
+		return "", err
+	}
+	if desc != "" {
+		f, err := ova.Open(desc)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, s := range srcFiles {
+		name := path.Join(vm, s)
+		size, err := ova.Size(name)
+		if err != nil {
+			return "", err
+		}
+		mod, err := ova.ModTime(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "\n%s\t%d\t%s", s, size, mod.UTC().Format(time.RFC3339Nano))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lastFingerprint returns the source fingerprint of vm's last successful
+// run, or "" if there is none.
+func lastFingerprint(vm string) (string, error) {
+	runs, err := readHistory()
+	if err != nil {
+		return "", err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if r := runs[i]; r.VM == vm && r.Outcome == "ok" {
+			return r.Fingerprint, nil
+		}
+	}
+	return "", nil
+}
+
+// unchanged reports whether vm's export is the one its last successful run
+// migrated, for -changed-only.
+func unchanged(vm string) (bool, error) {
+	last, err := lastFingerprint(vm)
+	if err != nil || last == "" {
+		return false, err
+	}
+	srcFiles, err := sourceDisks(vm)
+	if err != nil {
+		return false, err
+	}
+	fp, err := sourceFingerprint(vm, srcFiles)
+	return fp == last, err
+}
+
+// lastSeed returns vm's last successful run that staged its disks without
+// importing them – the seed copy a cutover builds on – or nil if there is
+// none, or the VM has been imported since.
+func lastSeed(vm string) (*runRecord, error) {
+	runs, err := readHistory()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		if r.VM != vm || r.Outcome != "ok" {
+			continue
+		}
+		if r.TaskTag != "" || len(r.Disks) == 0 || r.Fingerprint == "" {
+			return nil, nil
+		}
+		return &r, nil
+	}
+	return nil, nil
+}
+
+// checkSeed compares vm's source fingerprint fp with that of its seed copy
+// and returns "unchanged" or "changed", or "" without a seed. A changed
+// source is warned about, so the VMs needing their disks synced again
+// before the final import stand out in the run's warnings and report.
+func checkSeed(vm, fp string) string {
+	seed, err := lastSeed(vm)
+	if err != nil {
+		vmLog(vm).Warn("cannot look up the seed copy", "err", err)
+		return ""
+	}
+	if seed == nil {
+		return ""
+	}
+	seeded := seed.Start.Local().Format("2006-01-02 15:04")
+	if seed.Fingerprint != fp {
+		vmLog(vm).Warn("source changed since it was seeded – its staged disks are synced again", "seeded", seeded)
+		return "changed"
+	}
+	vmLog(vm).Info("🌱 source unchanged since it was seeded", "seeded", seeded)
+	return "unchanged"
+}
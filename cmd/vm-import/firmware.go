@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*--------- firmware, secure boot and vTPM ---------*/
+
+// firmwareMachineType returns the HC3 machine type matching the firmware
+// vm's OVF asks for – UEFI with a vTPM, or UEFI, secure boot included –
+// or "" for BIOS guests and exports without an OVF.
+func firmwareMachineType(vm string) string {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return ""
+	}
+	switch fw := env.Firmware(); {
+	case fw.TPM:
+		return machineTypes["uefi-tpm"]
+	case fw.EFI:
+		return machineTypes["uefi"]
+	}
+	return ""
+}
+
+// checkFirmware looks at the firmware vm's OVF asks for before anything is
+// staged, so an import that cannot boot is caught early. It warns when the
+// machine type asked for does not match it. A Windows guest with a vTPM is
+// blocking: HC3 gives the VM a new, empty vTPM, so BitLocker will ask for
+// the recovery key on first boot. An interactive run asks the operator to
+// go ahead, any other run fails unless -accept-tpm-reset or the manifest's
+// acceptTPMReset says the keys are at hand.
+func checkFirmware(vm string) error {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return err
+	}
+	fw := env.Firmware()
+	if !fw.EFI {
+		return nil
+	}
+	lg := vmLog(vm)
+	lg.Info("🔐 source firmware", "uefi", fw.EFI, "secure_boot", fw.SecureBoot, "vtpm", fw.TPM)
+	if t := explicitMachineType(vm); t != "" {
+		switch {
+		case !strings.Contains(t, "uefi"):
+			lg.Warn("the source boots UEFI but the machine type asked for does not – the guest will likely not boot", "machine_type", t)
+		case fw.TPM && !strings.Contains(t, "tpm"):
+			lg.Warn("the source has a vTPM but the machine type asked for has none", "machine_type", t)
+		}
+	}
+	if !fw.TPM || !env.OS.Windows() || *acceptTPMReset {
+		return nil
+	}
+	if v := plan.vm(vm); v != nil && v.AcceptTPMReset {
+		return nil
+	}
+	why := "the source's vTPM cannot be carried over; a BitLocker-protected guest will ask for its recovery key on first boot"
+	lg.Warn("vTPM contents will be lost", "why", why)
+	if !interactive() {
+		return fmt.Errorf("%s – have the recovery keys at hand or suspend BitLocker, then accept with -accept-tpm-reset or the manifest's acceptTPMReset", why)
+	}
+	promptMu.Lock()
+	fmt.Fprintf(stdout{}, "%s: %s.\nImport it anyway? (y/N): ", vm, why)
+	resp, _ := stdin.ReadString('\n')
+	promptMu.Unlock()
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(resp)), "y") {
+		return fmt.Errorf("vTPM reset not accepted")
+	}
+	return nil
+}
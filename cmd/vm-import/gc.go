@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/*--------- gc: orphaned staged images ---------*/
+
+// runGC implements "vm-import gc": it lists the qcow2 images in the
+// staging dir that no Scale XML next to them references any more –
+// leftovers of renamed dummy VMs, pruned disks and aborted runs – and
+// with -delete removes them, holding the staging lock and that of each VM
+// directory it removes from, so no run is staging there at the same time;
+// directories in use are skipped.
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	fs.StringVar(scaleDir, "scaledir", *scaleDir, "Staging directory to clean up")
+	del := fs.Bool("delete", false, "Remove the orphaned images instead of only listing them")
+	fs.IntVar(depth, "depth", *depth, "Look for VM directories this many levels below -scaledir, as staged with -depth")
+	fs.StringVar(stateDirFlag, "state-dir", "", "State directory holding the audit log (default $XDG_STATE_HOME/vm-import)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vm-import gc [-scaledir dir] [-depth n] [-delete] [-state-dir dir]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	var err error
+	if stage, err = newStager(); err != nil {
+		return err
+	}
+	defer stage.Close()
+	if *del {
+		release, err := acquireLock(lockName, false)
+		if err != nil {
+			return fmt.Errorf("locking staging dir: %w", err)
+		}
+		defer release()
+	}
+
+	orphans, err := orphanedImages()
+	if err != nil {
+		return err
+	}
+	sc, _ := stage.(spaceChecker)
+	var total int64
+	removed, busy := 0, map[string]bool{}
+	var locked string
+	var release func()
+	var refs map[string]bool
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+	for _, p := range orphans {
+		if *del && path.Dir(p) != locked {
+			// a run may be staging the VM, and reference the image when done
+			if release != nil {
+				release()
+				release = nil
+			}
+			locked = path.Dir(p)
+			if release, err = lockVM(locked, false); err != nil {
+				fmt.Fprintf(stdout{}, "skipped  %s  (%v)\n", locked, err)
+				busy[locked] = true
+				continue
+			}
+			if refs, err = xmlReferences(locked); err != nil {
+				return err
+			}
+		}
+		if busy[path.Dir(p)] || *del && (refs[strings.TrimSuffix(path.Base(p), ".qcow2")] || !stage.Exists(p)) {
+			continue
+		}
+		size := "size unknown"
+		if sc != nil {
+			if n, err := sc.Size(p); err == nil {
+				total += n
+				size = humanBytes(n)
+			}
+		}
+		if !*del {
+			fmt.Fprintf(stdout{}, "orphaned  %s  %s\n", p, size)
+			continue
+		}
+		err := stage.Remove(p)
+		audit("gc", path.Dir(p), err, auditEntry{Paths: []string{under(*scaleDir, p)}})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout{}, "removed  %s  %s\n", p, size)
+		removed++
+	}
+	switch {
+	case len(orphans) == 0:
+		fmt.Fprintln(stdout{}, "no orphaned images")
+	case *del:
+		fmt.Fprintf(stdout{}, "%d image(s) removed, %s freed\n", removed, humanBytes(total))
+	default:
+		fmt.Fprintf(stdout{}, "%d orphaned image(s), %s (remove them with -delete)\n", len(orphans), humanBytes(total))
+	}
+	return nil
+}
+
+// orphanedImages returns the staged <uuid>.qcow2 files whose UUID no Scale
+// XML in the same directory references. Backups of the XML don't count:
+// restoring one may need its disks staged again.
+func orphanedImages() ([]string, error) {
+	dirs, err := stagedDirs()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, dir := range dirs {
+		images, err := stage.Glob(path.Join(dir, "*.qcow2"))
+		if err != nil || len(images) == 0 {
+			continue // not a directory, or nothing staged
+		}
+		refs, err := xmlReferences(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range images {
+			if !refs[strings.TrimSuffix(path.Base(p), ".qcow2")] {
+				out = append(out, p)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// stagedDirs lists the entries of the staging dir up to -depth levels
+// down, where VMs named like esx1/web01 are staged, without the trash.
+func stagedDirs() ([]string, error) {
+	var out []string
+	level, err := stage.Glob("*") // wildcards only work in the last element
+	for d := 1; err == nil && len(level) > 0; d++ {
+		level = slices.DeleteFunc(level, func(p string) bool { return p == trashDir })
+		out = append(out, level...)
+		if d >= *depth {
+			break
+		}
+		var next []string
+		for _, dir := range level {
+			if sub, err := stage.Glob(path.Join(dir, "*")); err == nil {
+				next = append(next, sub...) // none for files
+			}
+		}
+		level = next
+	}
+	return out, err
+}
+
+// xmlReferences returns the disk UUIDs of all Scale XMLs in dir.
+func xmlReferences(dir string) (map[string]bool, error) {
+	xmls, err := stage.Glob(path.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string]bool{}
+	for _, x := range xmls {
+		uuids, err := uuidsFromScaleXML(x)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", x, err)
+		}
+		for _, u := range uuids {
+			refs[u] = true
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*--------- gRPC control interface ---------*/
+
+// The daemon's gRPC service (proto/vmimport/v1/jobs.proto) mirrors the
+// REST job API. It is served by net/http, whose HTTP/2 support needs TLS:
+// with -grpc-cert/-grpc-key unset a self-signed certificate is made up at
+// start and its fingerprint logged for clients to pin.
+
+const grpcService = "/vmimport.v1.Jobs/"
+
+// gRPC status codes used here.
+const (
+	grpcOK                 = 0
+	grpcCancelled          = 1
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is a failed call's status.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e grpcError) Error() string { return e.msg }
+
+func grpcErr(code int, err error) error { return grpcError{code, err.Error()} }
+
+// jobStates numbers job states as the JobState enum does.
+var jobStates = map[string]uint64{"queued": 1, "running": 2, "done": 3, "failed": 4, "cancelled": 5}
+
+func encodeJob(j job) pbMsg {
+	var m pbMsg
+	m.int(1, int64(j.ID))
+	m.string(2, j.VM)
+	m.bool(3, j.Import)
+	m.uint(4, jobStates[j.State])
+	m.string(5, j.Error)
+	m.time(6, j.Created)
+	m.time(7, j.StartAt)
+	m.time(8, j.Started)
+	m.time(9, j.Finished)
+	if p := j.Progress; p != nil {
+		var pm pbMsg
+		p.mu.Lock()
+		pm.string(1, p.disk)
+		pm.int(2, p.copied)
+		pm.int(3, p.size)
+		p.mu.Unlock()
+		m.msg(10, pm)
+	}
+	return m
+}
+
+func encodeJobs(jobs []job) pbMsg {
+	var m pbMsg
+	for _, j := range jobs {
+		m.msg(1, encodeJob(j))
+	}
+	return m
+}
+
+// jobID reads the id field shared by the Get/Cancel/Retry/Watch requests.
+func jobID(in []byte) (int, error) {
+	fs, err := pbDecode(in)
+	if err != nil {
+		return 0, grpcErr(grpcInvalidArgument, err)
+	}
+	for _, f := range fs {
+		if f.num == 1 && f.wire == 0 {
+			return int(f.v), nil
+		}
+	}
+	return 0, grpcErr(grpcInvalidArgument, fmt.Errorf("no job id given"))
+}
+
+// queueErr maps the job queue's errors to gRPC statuses as the REST API
+// maps them to HTTP ones.
+func queueErr(err error) error {
+	if errors.Is(err, errNoJob) {
+		return grpcErr(grpcNotFound, err)
+	}
+	return grpcErr(grpcFailedPrecondition, err)
+}
+
+// grpcHandler serves the Jobs service for q.
+type grpcHandler struct {
+	q     *jobQueue
+	token string
+}
+
+// unary answers the single-response methods.
+func (g grpcHandler) unary(method string, in []byte) (pbMsg, error) {
+	q := g.q
+	switch method {
+	case "ListVMs":
+		vms, err := discoverVMs()
+		if err != nil {
+			return nil, grpcErr(grpcInternal, err)
+		}
+		var m pbMsg
+		for _, vm := range vms {
+			m.bytes(1, []byte(vm))
+		}
+		return m, nil
+	case "ListJobs":
+		return encodeJobs(q.list()), nil
+	case "GetJob", "GetJobLog":
+		id, err := jobID(in)
+		if err != nil {
+			return nil, err
+		}
+		j, ok := q.snapshot(id)
+		if !ok {
+			return nil, grpcErr(grpcNotFound, errNoJob)
+		}
+		if method == "GetJob" {
+			return encodeJob(j), nil
+		}
+		var m pbMsg
+		m.string(1, j.log.String())
+		return m, nil
+	case "SubmitJobs":
+		fs, err := pbDecode(in)
+		if err != nil {
+			return nil, grpcErr(grpcInvalidArgument, err)
+		}
+		var (
+			vms     []string
+			imp     bool
+			startAt string
+		)
+		for _, f := range fs {
+			switch {
+			case f.num == 1 && f.wire == 2:
+				vms = append(vms, string(f.data))
+			case f.num == 2 && f.wire == 0:
+				imp = f.v != 0
+			case f.num == 3 && f.wire == 2:
+				startAt = string(f.data)
+			}
+		}
+		if len(vms) == 0 {
+			return nil, grpcErr(grpcInvalidArgument, fmt.Errorf("no vm given"))
+		}
+		var at time.Time
+		if startAt != "" {
+			if at, err = parseStartAt(startAt, time.Now()); err != nil {
+				return nil, grpcErr(grpcInvalidArgument, err)
+			}
+		}
+		var out []job
+		for _, vm := range vms {
+			out = append(out, *q.submit(strings.TrimSpace(vm), imp || *autoImp, at))
+		}
+		return encodeJobs(out), nil
+	case "CancelJob":
+		id, err := jobID(in)
+		if err != nil {
+			return nil, err
+		}
+		j, err := q.cancel(id)
+		if err != nil {
+			return nil, queueErr(err)
+		}
+		return encodeJob(j), nil
+	case "RetryJob":
+		id, err := jobID(in)
+		if err != nil {
+			return nil, err
+		}
+		j, err := q.retry(id)
+		if err != nil {
+			return nil, queueErr(err)
+		}
+		return encodeJob(*j), nil
+	}
+	return nil, grpcError{grpcUnimplemented, "unknown method " + method}
+}
+
+// watch streams job id through send until it has finished.
+func (g grpcHandler) watch(ctx context.Context, in []byte, send func(pbMsg) error) error {
+	id, err := jobID(in)
+	if err != nil {
+		return err
+	}
+	var last pbMsg
+	for {
+		j, ok := g.q.snapshot(id)
+		if !ok {
+			return grpcErr(grpcNotFound, errNoJob)
+		}
+		if m := encodeJob(j); !bytes.Equal(m, last) {
+			if err := send(m); err != nil {
+				return err
+			}
+			last = m
+		}
+		switch j.State {
+		case "done", "failed", "cancelled":
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return grpcErr(grpcCancelled, ctx.Err())
+		case <-runCtx.Done():
+			return grpcErr(grpcUnavailable, fmt.Errorf("daemon shutting down"))
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (g grpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ct := r.Header.Get("Content-Type")
+	if r.Method != "POST" || r.ProtoMajor != 2 || (ct != "application/grpc" && ct != "application/grpc+proto") {
+		http.Error(w, "gRPC (HTTP/2, application/grpc) only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	err := g.call(w, r)
+	code, msg := grpcOK, ""
+	if err != nil {
+		var ge grpcError
+		if !errors.As(err, &ge) {
+			ge = grpcError{grpcInternal, err.Error()}
+		}
+		code, msg = ge.code, ge.msg
+		slog.Debug("gRPC call failed", "method", r.URL.Path, "code", code, "err", msg)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+// call reads the request message and writes the response message(s).
+func (g grpcHandler) call(w http.ResponseWriter, r *http.Request) error {
+	if g.token != "" && r.Header.Get("Authorization") != "Bearer "+g.token {
+		return grpcError{grpcUnauthenticated, "missing or wrong bearer token"}
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, grpcService)
+	if !ok {
+		return grpcError{grpcUnimplemented, "unknown service " + r.URL.Path}
+	}
+	in, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	send := func(m pbMsg) error {
+		if err := writeGRPCMessage(w, m); err != nil {
+			return err
+		}
+		w.(http.Flusher).Flush()
+		return nil
+	}
+	if method == "WatchJob" {
+		return g.watch(r.Context(), in, send)
+	}
+	out, err := g.unary(method, in)
+	if err != nil {
+		return err
+	}
+	return send(out)
+}
+
+// maxGRPCMessage bounds request messages; ours are a few bytes.
+const maxGRPCMessage = 1 << 20
+
+// readGRPCMessage reads one length-prefixed message.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, grpcErr(grpcInvalidArgument, fmt.Errorf("reading request: %w", err))
+	}
+	if hdr[0] != 0 {
+		return nil, grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxGRPCMessage {
+		return nil, grpcError{grpcInvalidArgument, "request message too large"}
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, grpcErr(grpcInvalidArgument, fmt.Errorf("reading request: %w", err))
+	}
+	return b, nil
+}
+
+func writeGRPCMessage(w io.Writer, m pbMsg) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(m)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(m)
+	return err
+}
+
+// serveGRPC runs the gRPC service for q on addr until the daemon stops.
+func serveGRPC(addr, token string, q *jobQueue) error {
+	cert, err := grpcCertificate()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: grpcHandler{q, token}, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	go func() {
+		<-runCtx.Done()
+		<-q.idle
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	sum := sha256.Sum256(cert.Certificate[0])
+	slog.Info("🛰  gRPC listening", "addr", addr, "cert_sha256", hex.EncodeToString(sum[:]))
+	if err := srv.ServeTLS(ln, "", ""); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// grpcCertificate loads -grpc-cert/-grpc-key, or makes up a self-signed
+// certificate for this host valid for a year.
+func grpcCertificate() (tls.Certificate, error) {
+	if *grpcCert != "" || *grpcKey != "" {
+		return tls.LoadX509KeyPair(*grpcCert, *grpcKey)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	host, _ := os.Hostname()
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "vm-import " + host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{host, "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	slog.Warn("no -grpc-cert given – serving gRPC with a self-signed certificate")
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
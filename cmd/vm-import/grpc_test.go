@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fields decodes m into a map by field number, failing on bad input.
+func fields(t *testing.T, m []byte) map[int]pbField {
+	t.Helper()
+	fs, err := pbDecode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[int]pbField{}
+	for _, f := range fs {
+		out[f.num] = f
+	}
+	return out
+}
+
+func TestPBRoundTrip(t *testing.T) {
+	var sub pbMsg
+	sub.string(1, "inner")
+	var m pbMsg
+	m.uint(1, 300)
+	m.int(2, 1<<40)
+	m.bool(3, true)
+	m.string(4, "héllo")
+	m.bytes(5, []byte{0, 1, 2})
+	m.msg(6, sub)
+	m.msg(7, nil)
+
+	fs := fields(t, m)
+	if len(fs) != 7 {
+		t.Fatalf("%d fields, want 7", len(fs))
+	}
+	if fs[1].v != 300 || fs[2].v != 1<<40 || fs[3].v != 1 {
+		t.Errorf("varints %d %d %d", fs[1].v, fs[2].v, fs[3].v)
+	}
+	if string(fs[4].data) != "héllo" || !bytes.Equal(fs[5].data, []byte{0, 1, 2}) {
+		t.Errorf("length-delimited fields %q %v", fs[4].data, fs[5].data)
+	}
+	if got := fields(t, fs[6].data)[1].data; string(got) != "inner" {
+		t.Errorf("sub-message field %q", got)
+	}
+	if f, ok := fs[7]; !ok || f.wire != 2 || len(f.data) != 0 {
+		t.Errorf("empty sub-message %+v, set %v", f, ok)
+	}
+}
+
+func TestPBOmitsZeroValues(t *testing.T) {
+	var m pbMsg
+	m.uint(1, 0)
+	m.int(2, 0)
+	m.bool(3, false)
+	m.string(4, "")
+	m.time(5, time.Time{})
+	if len(m) != 0 {
+		t.Errorf("zero values encoded as % x", []byte(m))
+	}
+}
+
+func TestPBTime(t *testing.T) {
+	tm := time.Unix(1700000000, 123456789)
+	var m pbMsg
+	m.time(1, tm)
+	ts := fields(t, fields(t, m)[1].data)
+	if ts[1].v != 1700000000 || ts[2].v != 123456789 {
+		t.Errorf("timestamp %d.%09d", ts[1].v, ts[2].v)
+	}
+}
+
+func TestPBDecodeSkipsFixed(t *testing.T) {
+	m := []byte{1<<3 | 1, 1, 2, 3, 4, 5, 6, 7, 8, 2<<3 | 5, 1, 2, 3, 4, 3<<3 | 0, 42}
+	fs := fields(t, m)
+	if len(fs) != 1 || fs[3].v != 42 {
+		t.Errorf("decoded %+v, want only field 3", fs)
+	}
+}
+
+func TestPBDecodeMalformed(t *testing.T) {
+	for _, b := range [][]byte{
+		{0x80},                 // truncated key
+		{1 << 3},               // missing varint
+		{1<<3 | 2, 5, 'a'},     // short length-delimited
+		{1<<3 | 1, 1, 2, 3},    // short fixed64
+		{1<<3 | 3},             // group wire type
+		{1<<3 | 2, 0x80, 0x80}, // truncated length
+	} {
+		if _, err := pbDecode(b); !errors.Is(err, errBadProto) {
+			t.Errorf("% x: %v", b, err)
+		}
+	}
+}
+
+func TestEncodeJob(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	m := encodeJob(job{ID: 7, VM: "web01", Import: true, State: "failed", Error: "boom", Created: created,
+		Progress: &progress{disk: "disk1.vmdk", copied: 10, size: 20}})
+	fs := fields(t, m)
+	if fs[1].v != 7 || string(fs[2].data) != "web01" || fs[3].v != 1 || fs[4].v != jobStates["failed"] || string(fs[5].data) != "boom" {
+		t.Errorf("job fields %+v", fs)
+	}
+	if _, ok := fs[7]; ok {
+		t.Error("zero start_at encoded")
+	}
+	if ts := fields(t, fs[6].data); ts[1].v != 1700000000 {
+		t.Errorf("created %d", ts[1].v)
+	}
+	p := fields(t, fs[10].data)
+	if string(p[1].data) != "disk1.vmdk" || p[2].v != 10 || p[3].v != 20 {
+		t.Errorf("progress %+v", p)
+	}
+}
+
+func TestJobID(t *testing.T) {
+	var m pbMsg
+	m.int(1, 42)
+	if id, err := jobID(m); err != nil || id != 42 {
+		t.Errorf("jobID = %d, %v", id, err)
+	}
+	var bad grpcError
+	if _, err := jobID(nil); !errors.As(err, &bad) || bad.code != grpcInvalidArgument {
+		t.Errorf("empty request: %v", err)
+	}
+	if _, err := jobID([]byte{0x80}); !errors.As(err, &bad) || bad.code != grpcInvalidArgument {
+		t.Errorf("malformed request: %v", err)
+	}
+}
+
+func TestGRPCMessageFraming(t *testing.T) {
+	var m pbMsg
+	m.string(2, "payload")
+	var buf bytes.Buffer
+	if err := writeGRPCMessage(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeGRPCMessage(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readGRPCMessage(&buf)
+	if err != nil || !bytes.Equal(got, m) {
+		t.Errorf("first message % x, %v", got, err)
+	}
+	if got, err := readGRPCMessage(&buf); err != nil || len(got) != 0 {
+		t.Errorf("empty message % x, %v", got, err)
+	}
+
+	var bad grpcError
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		code int
+	}{
+		{"compressed", []byte{1, 0, 0, 0, 0}, grpcUnimplemented},
+		{"too large", []byte{0, 0xff, 0xff, 0xff, 0xff}, grpcInvalidArgument},
+		{"short header", []byte{0, 0}, grpcInvalidArgument},
+		{"short body", []byte{0, 0, 0, 0, 4, 'a'}, grpcInvalidArgument},
+	} {
+		if _, err := readGRPCMessage(bytes.NewReader(tc.in)); !errors.As(err, &bad) || bad.code != tc.code {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+	"gcosmiclentil89/ScaleVMFromOVA/scalexml"
+)
+
+/*--------- hardware sync ---------*/
+
+// memUnits are the libvirt memory units as multipliers of a byte.
+var memUnits = map[string]int64{
+	"b": 1, "bytes": 1,
+	"kb": 1e3, "k": 1 << 10, "kib": 1 << 10,
+	"mb": 1e6, "m": 1 << 20, "mib": 1 << 20,
+	"gb": 1e9, "g": 1 << 30, "gib": 1 << 30,
+	"tb": 1e12, "t": 1 << 40, "tib": 1 << 40,
+}
+
+// syncHardware sets the Scale XML's <vcpu> and <memory> (and
+// <currentMemory>, and the CPU topology, when present) to the OVF's CPU
+// count and memory size, so the dummy VM need not be sized by hand.
+func syncHardware(doc *scalexml.Node, vm string) error {
+	env, err := readOVF(vm)
+	if err != nil {
+		return err
+	}
+	lg := vmLog(vm)
+	if env == nil {
+		lg.Warn("-sync-hardware needs an OVF – keeping the Scale XML's CPU and memory")
+		return nil
+	}
+	cpus, mem := env.Hardware()
+	return setHardware(doc, vm, cpus, mem)
+}
+
+// resizeHardware applies the -cpu and -memory overrides, or the manifest's
+// cpus and memory for vm, after any -sync-hardware, so VMs can be
+// right-sized as they are migrated.
+func resizeHardware(doc *scalexml.Node, vm string) error {
+	cpus, mem := *cpuFlag, memSize
+	if v := plan.vm(vm); v != nil {
+		if v.CPUs > 0 {
+			cpus = v.CPUs
+		}
+		if v.Memory != "" {
+			var err error
+			if mem, err = parseSize(v.Memory); err != nil {
+				return fmt.Errorf("manifest memory: %w", err)
+			}
+		}
+	}
+	return setHardware(doc, vm, cpus, mem)
+}
+
+// setHardware sets the Scale XML's <vcpu> and <memory> (and
+// <currentMemory>, and the CPU topology, when present) to cpus and mem
+// bytes, leaving either alone when it is 0.
+func setHardware(doc *scalexml.Node, vm string, cpus int, mem int64) error {
+	lg := vmLog(vm)
+	top := doc.First()
+	if cpus > 0 {
+		n := top.Child("vcpu")
+		if n == nil {
+			n = top.Add("vcpu")
+		}
+		if old := n.InnerText(); old != strconv.Itoa(cpus) {
+			lg.Info("✎ vCPUs", "from", old, "to", cpus)
+			n.SetText(strconv.Itoa(cpus))
+		}
+		if t := top.Find("topology"); t != nil {
+			cores, _ := strconv.Atoi(t.Attr("cores"))
+			threads, _ := strconv.Atoi(t.Attr("threads"))
+			if cores < 1 || threads < 1 || cpus%(cores*threads) != 0 {
+				cores, threads = 1, 1
+			}
+			if t.Attr("sockets") != strconv.Itoa(cpus/(cores*threads)) {
+				t.SetAttr("sockets", strconv.Itoa(cpus/(cores*threads)))
+				t.SetAttr("cores", strconv.Itoa(cores))
+				t.SetAttr("threads", strconv.Itoa(threads))
+			}
+		}
+	}
+	if mem > 0 {
+		changed := false
+		for _, name := range []string{"memory", "currentMemory"} {
+			n := top.Child(name)
+			if n == nil {
+				if name == "currentMemory" {
+					continue
+				}
+				n = top.Add(name)
+			}
+			c, err := setMemory(n, mem)
+			if err != nil {
+				return err
+			}
+			changed = changed || c
+		}
+		if changed {
+			lg.Info("✎ memory", "to", humanBytes(mem))
+		}
+	}
+	return nil
+}
+
+// parseSize parses a size such as 8GiB, 512M or 4096 (MiB), as -memory
+// and -max-disk-size take them, into bytes, with the libvirt units.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ ")
+	unit := strings.ToLower(strings.TrimSpace(s[len(num):]))
+	if unit == "" {
+		unit = "mib"
+	}
+	mul, ok := memUnits[unit]
+	n, err := strconv.ParseInt(num, 10, 64)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q (want e.g. 8GiB, 512M or 4096 for MiB)", s)
+	}
+	return n * mul, nil
+}
+
+// setMemory writes size into a libvirt memory element, in its own unit
+// when size is a whole number of them, else in KiB, and reports whether
+// that changed it.
+func setMemory(n *scalexml.Node, size int64) (bool, error) {
+	unit := strings.ToLower(n.Attr("unit"))
+	if unit == "" {
+		unit = "kib"
+	}
+	mul, ok := memUnits[unit]
+	if !ok {
+		return false, fmt.Errorf("<%s unit=%q>: unknown unit", n.Name(), n.Attr("unit"))
+	}
+	changed := false
+	if size%mul != 0 {
+		mul = 1 << 10
+		n.SetAttr("unit", "KiB")
+		changed = true
+	}
+	v := strconv.FormatInt(size/mul, 10)
+	if n.InnerText() != v {
+		n.SetText(v)
+		changed = true
+	}
+	return changed, nil
+}
+
+/*--------- boot order ---------*/
+
+// libvirtBoot names the boot devices in <os><boot dev>.
+var libvirtBoot = map[string]string{"disk": "hd", "cdrom": "cdrom", "network": "network", "floppy": "fd"}
+
+// parseBootOrder checks a -boot-order list such as disk,cdrom,network.
+func parseBootOrder(s string) ([]string, error) {
+	var order []string
+	for _, d := range strings.Split(s, ",") {
+		dev, ok := ovf.BootDevices[strings.ToLower(strings.TrimSpace(d))]
+		if !ok {
+			return nil, fmt.Errorf("unknown boot device %q (want disk, cdrom, network or floppy)", d)
+		}
+		order = append(order, dev)
+	}
+	return order, nil
+}
+
+// setBootOrder writes the boot order – -boot-order, or the OVF's with
+// -boot-order=ovf – into the Scale XML. Definitions that give devices
+// their own <boot order> get those renumbered, disks, CD-ROMs, NICs and
+// floppies in the order asked; others get <os><boot dev> entries.
+func setBootOrder(doc *scalexml.Node, vm string) error {
+	order := bootList
+	if *bootFlag == "ovf" {
+		var err error
+		if order, err = ovfBootOrder(vm); err != nil {
+			return err
+		}
+		if len(order) == 0 {
+			vmLog(vm).Warn("no boot order in the OVF – keeping the Scale XML's")
+			return nil
+		}
+	}
+	if len(order) == 0 {
+		return nil
+	}
+	lg := vmLog(vm)
+	top := doc.First()
+	devs := top.Child("devices")
+	if devs != nil && len(devs.FindAll("boot")) > 0 {
+		for _, b := range devs.FindAll("boot") {
+			b.Parent().Remove(b)
+		}
+		n := 1
+		for _, dev := range order {
+			for _, el := range bootable(devs, dev) {
+				el.Add("boot", xml.Attr{Name: xml.Name{Local: "order"}, Value: strconv.Itoa(n)})
+				n++
+			}
+		}
+		lg.Info("✎ boot order", "devices", strings.Join(order, ","))
+		return nil
+	}
+	osEl := top.Child("os")
+	if osEl == nil {
+		osEl = top.Add("os")
+	}
+	for _, b := range osEl.FindAll("boot") {
+		osEl.Remove(b)
+	}
+	for _, dev := range order {
+		osEl.Add("boot", xml.Attr{Name: xml.Name{Local: "dev"}, Value: libvirtBoot[dev]})
+	}
+	lg.Info("✎ boot order", "devices", strings.Join(order, ","))
+	return nil
+}
+
+// bootable returns the device elements of one boot device class.
+func bootable(devs *scalexml.Node, dev string) []*scalexml.Node {
+	if dev == "network" {
+		return devs.FindAll("interface")
+	}
+	want := map[string]string{"disk": "disk", "cdrom": "cdrom", "floppy": "floppy"}[dev]
+	var out []*scalexml.Node
+	for _, d := range devs.FindAll("disk") {
+		if d.Attr("device") == want || want == "disk" && d.Attr("device") == "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+/*--------- clock ---------*/
+
+// setClock writes the guest clock offset – -clock, or with -clock=ovf the
+// one the OVF hints at (see ovf.Envelope.Clock) – into the Scale XML's
+// <clock offset>, so a Windows guest keeping local time does not come up
+// skewed by the time zone.
+func setClock(doc *scalexml.Node, vm string) error {
+	want := *clockFlag
+	if want == "ovf" {
+		env, err := readOVF(vm)
+		if err != nil {
+			return err
+		}
+		if want = ""; env != nil {
+			want = env.Clock()
+		}
+		if want == "" {
+			vmLog(vm).Debug("no clock hint in the OVF – keeping the Scale XML's")
+			return nil
+		}
+	}
+	if want == "" {
+		return nil
+	}
+	top := doc.First()
+	c := top.Child("clock")
+	if c == nil {
+		c = top.Add("clock")
+	}
+	if old := c.Attr("offset"); old != want {
+		vmLog(vm).Info("✎ clock", "from", old, "to", want)
+		c.SetAttr("offset", want)
+	}
+	return nil
+}
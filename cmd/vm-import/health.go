@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+/*--------- daemon health checks ---------*/
+
+// checkTimeout bounds each readiness check, so a hung mount or API makes
+// the daemon unready instead of hanging the probe.
+const checkTimeout = 5 * time.Second
+
+// healthz reports liveness: the daemon answers and its queue worker runs.
+// It fails only once the worker has stopped, so a restart is warranted.
+func healthz(q *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-q.idle:
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "stopped", "error": "queue worker has stopped"})
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+		}
+	}
+}
+
+// readyz reports whether the daemon can do work right now: the HC3 API
+// answers, the OVA and staging directories are readable, the share HC3
+// imports from is reachable and the job queue is being persisted. Every
+// check is listed with its result; any failure makes the answer 503.
+func readyz(q *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]func() error{
+			"api":     checkAPI,
+			"ovadir":  func() error { _, err := ova.Dirs(""); return err },
+			"staging": func() error { _, err := stage.Glob("*"); return err },
+			"share":   checkShare,
+			"queue":   q.health,
+		}
+		type result struct {
+			name string
+			err  error
+		}
+		results := make(chan result, len(checks))
+		for name, check := range checks {
+			go func() {
+				done := make(chan error, 1)
+				go func() { done <- check() }()
+				select {
+				case err := <-done:
+					results <- result{name, err}
+				case <-time.After(checkTimeout):
+					results <- result{name, fmt.Errorf("no answer within %s", checkTimeout)}
+				}
+			}()
+		}
+		status, out := http.StatusOK, map[string]string{}
+		for range checks {
+			res := <-results
+			out[res.name] = "ok"
+			if res.err != nil {
+				status, out[res.name] = http.StatusServiceUnavailable, res.err.Error()
+			}
+		}
+		writeJSON(w, status, map[string]any{"ready": status == http.StatusOK, "checks": out})
+	}
+}
+
+// checkAPI pings the REST API of every target cluster with the configured
+// credentials.
+func checkAPI() error {
+	var errs []error
+	for _, cl := range allClusters() {
+		if err := hc3Client(cl, checkTimeout).Ping(runCtx); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cl.label(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkShare makes sure the shares the clusters read the staged VMs from
+// answer.
+func checkShare() error {
+	var errs []error
+	for _, cl := range allClusters() {
+		if err := checkClusterShare(cl); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cl.label(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkClusterShare dials the NFS server for the nfs export protocol, else
+// the SMB server on 445.
+func checkClusterShare(cl *cluster) error {
+	if cl.protocol() == "nfs" {
+		return checkNFSExport(cl.share())
+	}
+	u, err := smbShare(cl.share())
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "445")
+	}
+	c, err := net.DialTimeout("tcp", addr, checkTimeout)
+	if err != nil {
+		return fmt.Errorf("SMB server %s unreachable: %w", u.Hostname(), err)
+	}
+	return c.Close()
+}
+
+// health fails when the worker has stopped or the queue could not be
+// saved, since jobs would then be lost on restart.
+func (q *jobQueue) health() error {
+	select {
+	case <-q.idle:
+		return fmt.Errorf("queue worker has stopped")
+	default:
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.saveErr != nil {
+		return fmt.Errorf("saving job queue: %w", q.saveErr)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+)
+
+/*--------- job history ---------*/
+
+// runRecord is one processVM run as appended to history.jsonl.
+type runRecord struct {
+	VM          string             `json:"vm"`
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Outcome     string             `json:"outcome"` // ok or failed
+	Error       string             `json:"error,omitempty"`
+	ErrorClass  string             `json:"errorClass,omitempty"` // discovery, transfer, conversion, xml, api or other
+	Disks       []diskRecord       `json:"disks,omitempty"`
+	Steps       []stepRecord       `json:"steps,omitempty"`
+	TaskTag     string             `json:"taskTag,omitempty"`
+	CreatedUUID string             `json:"createdUUID,omitempty"`
+	Cluster     string             `json:"cluster,omitempty"`        // named target cluster; empty for -api
+	GuestIPs    []string           `json:"guestIPs,omitempty"`       // reported by the guest agents with -wait-guest
+	SmokeTest   string             `json:"smokeTest,omitempty"`      // passed or failed, with -smoke-test
+	Consoles    []string           `json:"consoles,omitempty"`       // of the VMs started with -power-on
+	SourceNet   *ovf.GuestNetwork  `json:"sourceNetwork,omitempty"`  // as the source OVF recorded it
+	SourceClock string             `json:"sourceClock,omitempty"`    // utc or localtime, as the source OVF hints
+	SourceState string             `json:"sourceState,omitempty"`    // running, off or suspended when the run started
+	Passthrough []ovf.Passthrough  `json:"passthrough,omitempty"`    // source PCI/vGPU devices HC3 lacks
+	RDMs        []string           `json:"rdms,omitempty"`           // source raw device mappings left out
+	Legacy      []ovf.LegacyDevice `json:"skippedDevices,omitempty"` // source floppy, serial and parallel devices
+	Duration    time.Duration      `json:"duration"`
+	Warnings    []string           `json:"warnings,omitempty"`
+	Fingerprint string             `json:"fingerprint,omitempty"` // of the source export, see sourceFingerprint
+	SinceSeed   string             `json:"sinceSeed,omitempty"`   // unchanged or changed, the source against its seed copy
+	Checklist   []checkItem        `json:"checklist,omitempty"`   // with -checklist, per imported VM
+	Options     []string           `json:"options,omitempty"`     // the flags given, for reimport; see runOptions
+	Tags        []string           `json:"tags,omitempty"`        // expanded
+	Mapping     map[string]string  `json:"mapping,omitempty"`     // source disk href to Scale disk UUID, new or skip
+
+	span  *span // the run's trace span, and the step currently open below it
+	open  *span
+	stage string // name of the open step, for progress events
+}
+
+type diskRecord struct {
+	Source   string        `json:"source"`
+	Target   string        `json:"target"`
+	Size     int64         `json:"size"`
+	Mode     string        `json:"mode"` // copy, convert or delta
+	Duration time.Duration `json:"duration"`
+	SHA256   string        `json:"sha256,omitempty"`  // of the source, with -report or, for copies, -checksums
+	CPU      time.Duration `json:"cpu,omitempty"`     // qemu-img's user and system time, for conversions
+	Written  int64         `json:"written,omitempty"` // bytes a delta sync rewrote
+}
+
+type stepRecord struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+}
+
+func newRunRecord(vm string) *runRecord {
+	emit(event{Event: "vm-started", VM: vm})
+	return &runRecord{VM: vm, Start: time.Now(), Options: runOptions(), span: startSpan("processVM", nil, "vm", vm, "dry_run", *dryRun)}
+}
+
+// step starts timing the named pipeline step, traced as a child span of
+// the run; call the result when done.
+func (r *runRecord) step(name string) func() {
+	t := time.Now()
+	r.open, r.stage = startSpan(name, r.span), name
+	emit(event{Event: "stage-started", VM: r.VM, Stage: name})
+	vmLog(r.VM).Debug("step started", "step", name)
+	return func() {
+		r.Steps = append(r.Steps, stepRecord{name, time.Since(t)})
+		vmLog(r.VM).Debug("step done", "step", name, "took", time.Since(t).Round(time.Millisecond))
+		r.open.finish(nil)
+		r.open, r.stage = nil, ""
+		emit(event{Event: "stage-finished", VM: r.VM, Stage: name}.finished(nil, time.Since(t)))
+	}
+}
+
+func (r *runRecord) disk(src, dst, mode string, t time.Time, sum string) {
+	size, _ := ova.Size(src)
+	r.Disks = append(r.Disks, diskRecord{Source: path.Base(src), Target: path.Base(dst), Size: size, Mode: mode, Duration: time.Since(t), SHA256: sum})
+	if s := startSpan(mode, r.open, "src", path.Base(src), "dst", path.Base(dst), "bytes", size); s != nil {
+		s.start = t
+		s.finish(nil)
+	}
+}
+
+// save finishes the record with err, ends its trace and appends it to the
+// history file. Dry runs are traced but not recorded.
+func (r *runRecord) save(err error) {
+	r.open.finish(err)
+	r.span.set("task", r.TaskTag, "uuid", r.CreatedUUID)
+	r.span.finish(err)
+	traces.flush()
+	r.Warnings = warningsFor(r.VM)
+	r.End = time.Now()
+	r.ErrorClass = errorClass(err, r.stage)
+	ansibleRecord(r, err)
+	if r.stage != "" {
+		emit(event{Event: "stage-finished", VM: r.VM, Stage: r.stage}.finished(err, 0))
+	}
+	emit(event{Event: "vm-finished", VM: r.VM, Task: r.TaskTag, UUID: r.CreatedUUID, Warnings: r.Warnings, Class: r.ErrorClass}.finished(err, time.Since(r.Start)))
+	if *dryRun {
+		return
+	}
+	r.Duration = r.End.Sub(r.Start)
+	r.Outcome = "ok"
+	if err != nil {
+		r.Outcome, r.Error = "failed", err.Error()
+	}
+	if err := appendHistory(r); err != nil {
+		slog.Warn("recording history", "err", err)
+	}
+	batchMu.Lock()
+	batch = append(batch, r)
+	batchMu.Unlock()
+	notify(runNotification(r))
+}
+
+// batch holds the runs since the last endBatch.
+var (
+	batch   []*runRecord
+	batchMu sync.Mutex
+)
+
+func historyFile() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+func appendHistory(r *runRecord) error {
+	file, err := historyFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readHistory() ([]runRecord, error) {
+	file, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []runRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		var r runRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		out = append(out, r)
+	}
+	return out, sc.Err()
+}
+
+/*--------- report command ---------*/
+
+// runReport implements "vm-import report [flags]", listing recorded runs
+// with their per-step timings.
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	since := fs.String("since", "", "Only runs starting at or after this: 2006-01-02[T15:04], a duration like 36h, or 7d")
+	until := fs.String("until", "", "Only runs starting before this (same formats as -since)")
+	vm := fs.String("vm", "", "Only runs for this VM")
+	failed := fs.Bool("failed", false, "Only failed runs")
+	asJSON := fs.Bool("json", false, "Print the matching records as JSON")
+	fs.StringVar(stateDirFlag, "state-dir", "", "State directory holding history.jsonl (default $XDG_STATE_HOME/vm-import)")
+	fs.Parse(args)
+
+	now := time.Now()
+	from, err := parseWhen(*since, now)
+	if err != nil {
+		return err
+	}
+	to, err := parseWhen(*until, now)
+	if err != nil {
+		return err
+	}
+	runs, err := readHistory()
+	if err != nil {
+		return err
+	}
+	var sel []runRecord
+	for _, r := range runs {
+		if r.Start.Before(from) || !to.IsZero() && !r.Start.Before(to) ||
+			*vm != "" && r.VM != *vm || *failed && r.Outcome != "failed" {
+			continue
+		}
+		sel = append(sel, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sel)
+	}
+	if len(sel) == 0 {
+		fmt.Fprintln(stdout{}, "no matching runs")
+		return nil
+	}
+	var total time.Duration
+	var bytes int64
+	for _, r := range sel {
+		var size int64
+		for _, d := range r.Disks {
+			size += d.Size
+		}
+		total += r.Duration
+		bytes += size
+		fmt.Fprintf(stdout{}, "%s  %-24s %-6s %9s  %d disk(s) %s", r.Start.Local().Format("2006-01-02 15:04"), r.VM, r.Outcome,
+			r.Duration.Round(time.Second), len(r.Disks), humanBytes(size))
+		if r.TaskTag != "" {
+			fmt.Fprintf(stdout{}, "  task %s (UUID %s)", r.TaskTag, r.CreatedUUID)
+		}
+		if r.SmokeTest != "" {
+			fmt.Fprintf(stdout{}, "  smoke test %s", r.SmokeTest)
+		}
+		fmt.Fprintln(stdout{})
+		var steps []string
+		for _, s := range r.Steps {
+			steps = append(steps, fmt.Sprintf("%s %s", s.Name, s.Duration.Round(time.Second)))
+		}
+		if len(steps) > 0 {
+			fmt.Fprintf(stdout{}, "    %s\n", strings.Join(steps, " · "))
+		}
+		for _, d := range r.Disks {
+			fmt.Fprintf(stdout{}, "    %s → %s  %s %s in %s\n", d.Source, d.Target, d.Mode, humanBytes(d.Size), d.Duration.Round(time.Second))
+		}
+		if n := r.SourceNet; n != nil {
+			fmt.Fprintf(stdout{}, "    source network: %s\n", describeNet(*n))
+		}
+		if r.SourceClock != "" {
+			fmt.Fprintf(stdout{}, "    source clock: %s\n", r.SourceClock)
+		}
+		if r.SourceState == "running" || r.SourceState == "suspended" {
+			fmt.Fprintf(stdout{}, "    ⚠ source was %s – the export may be stale\n", r.SourceState)
+		}
+		if r.SinceSeed != "" {
+			fmt.Fprintf(stdout{}, "    source %s since seeding\n", r.SinceSeed)
+		}
+		for _, d := range r.Passthrough {
+			fmt.Fprintf(stdout{}, "    ⚠ passthrough device not migrated: %s\n", d)
+		}
+		for _, d := range r.Legacy {
+			fmt.Fprintf(stdout{}, "    skipped device: %s\n", d)
+		}
+		for _, f := range r.RDMs {
+			fmt.Fprintf(stdout{}, "    ⚠ raw device mapping not migrated: %s (migrate the LUN's data separately)\n", f)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(stdout{}, "    error: %s\n", r.Error)
+		}
+	}
+	fmt.Fprintf(stdout{}, "%d run(s), %s staged, %s total\n", len(sel), humanBytes(bytes), total.Round(time.Second))
+	return nil
+}
+
+// parseWhen reads a -since/-until bound: a date, a date and time, a Go
+// duration or a number of days ("7d") before now. Empty means unbounded.
+func parseWhen(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(d); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
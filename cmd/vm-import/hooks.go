@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*--------- stage hooks ---------*/
+
+// hookStages are the points in processVM a -hook can attach to. The
+// convert and copy hooks run once per disk.
+var hookStages = []string{
+	"pre-delete", "post-delete",
+	"pre-convert", "post-convert",
+	"pre-copy", "post-copy",
+	"pre-tags", "post-tags",
+	"pre-import", "post-import",
+}
+
+// hooks maps a stage to its commands, in the order given.
+var hooks map[string][]string
+
+// parseHooks reads the repeated -hook stage=command flags.
+func parseHooks(specs []string) (map[string][]string, error) {
+	out := map[string][]string{}
+	for _, spec := range specs {
+		st, cmd, ok := strings.Cut(spec, "=")
+		st, cmd = strings.TrimSpace(st), strings.TrimSpace(cmd)
+		if !ok || cmd == "" {
+			return nil, fmt.Errorf("-hook %q: want stage=command", spec)
+		}
+		known := false
+		for _, s := range hookStages {
+			known = known || s == st
+		}
+		if !known {
+			return nil, fmt.Errorf("-hook %q: unknown stage %q (have %s)", spec, st, strings.Join(hookStages, ", "))
+		}
+		out[st] = append(out[st], cmd)
+	}
+	return out, nil
+}
+
+// hookWaitDelay is how long a cancelled hook's output may still be read.
+const hookWaitDelay = 2 * time.Second
+
+// runHooks runs the commands for stage through the shell with the VM's
+// details in VMIMPORT_* environment variables, plus kv pairs such as
+// "SRC", path. A failing hook fails the stage; dry runs only list them.
+func runHooks(ctx context.Context, stage, vm string, kv ...string) error {
+	cmds := hooks[stage]
+	if len(cmds) == 0 {
+		return nil
+	}
+	base := os.Environ()
+	env := append(base,
+		"VMIMPORT_STAGE="+stage,
+		"VMIMPORT_VM="+vm,
+		"VMIMPORT_OVA_DIR="+under(*ovaDir, vm),
+		"VMIMPORT_STAGING_DIR="+under(*scaleDir, vm),
+		"VMIMPORT_XML="+under(*scaleDir, xmlPath(vm)),
+	)
+	for i := 0; i+1 < len(kv); i += 2 {
+		env = append(env, "VMIMPORT_"+kv[i]+"="+kv[i+1])
+	}
+	for _, c := range cmds {
+		if *dryRun {
+			vmLog(vm).Info("[dry-run] hook", "stage", stage, "cmd", c)
+			continue
+		}
+		vmLog(vm).Info("↪ hook", "stage", stage, "cmd", c)
+		vmLog(vm).Log(ctx, levelTrace, "hook environment", "vars", strings.Join(env[len(base):], " "))
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", c)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", c)
+		}
+		cmd.Env = env
+		cmd.Stdout, cmd.Stderr = stdout{}, os.Stderr
+		cmd.WaitDelay = hookWaitDelay // a killed shell's children may keep stdout open
+		killTree(cmd)
+		if err := cmd.Run(); err != nil {
+			if cerr := context.Cause(ctx); cerr != nil {
+				err = cerr
+			}
+			return fmt.Errorf("%s hook %q: %w", stage, c, err)
+		}
+	}
+	return nil
+}
+
+// diskHookEnv describes disk i for the convert and copy hooks.
+func diskHookEnv(i int, src, dst, uuid string) []string {
+	return []string{
+		"DISK_INDEX", strconv.Itoa(i),
+		"SRC", under(*ovaDir, src),
+		"DST", under(*scaleDir, dst),
+		"DISK_UUID", uuid,
+	}
+}
+
+// under joins rel onto root, which may be a URL such as s3:// or ssh://,
+// or a local directory – a drive or UNC path on Windows – in which case
+// the result uses the platform's separators.
+func under(root, rel string) string {
+	if strings.Contains(root, "://") {
+		return strings.TrimRight(root, "/") + "/" + rel
+	}
+	return filepath.Join(root, filepath.FromSlash(rel))
+}
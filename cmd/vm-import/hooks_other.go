@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killTree is a no-op here: cancelling cmd kills the shell only.
+func killTree(cmd *exec.Cmd) {}
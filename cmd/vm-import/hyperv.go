@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*--------- Hyper-V source adapter ---------*/
+
+// A Hyper-V export directory looks like
+//
+//	<vm>/Virtual Machines/<GUID>.vmcx (or <GUID>.xml on 2012-era hosts)
+//	<vm>/Virtual Hard Disks/*.vhdx
+//
+// The disks are converted to qcow2 with qemu-img while staging.
+
+const hvDiskDir = "Virtual Hard Disks"
+
+func isHyperVExport(vm string) bool {
+	return len(hypervDiskFiles(vm)) > 0
+}
+
+func hypervDiskFiles(vm string) []string {
+	var out []string
+	for _, pat := range []string{"*.vhdx", "*.vhd", "*.VHDX", "*.VHD"} {
+		m, _ := ova.Glob(path.Join(vm, hvDiskDir, pat))
+		out = append(out, m...)
+	}
+	sort.Strings(out)
+	return out
+}
+
+type hvConfig struct {
+	CPUs     int
+	MemoryMB int
+	Disks    []string // VHD(X) base names in controller order
+}
+
+// hypervConfig reads the legacy XML configuration if the export has one.
+// .vmcx files are a binary format and are not parsed; callers fall back to
+// name order for the disks and leave sizing to the dummy VM.
+func hypervConfig(vm string) (hvConfig, bool) {
+	var cfg hvConfig
+	m, _ := ova.Glob(path.Join(vm, "Virtual Machines", "*.xml"))
+	if len(m) == 0 {
+		return cfg, false
+	}
+	f, err := ova.Open(m[0])
+	if err != nil {
+		return cfg, false
+	}
+	defer f.Close()
+
+	var stack []string
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cfg, false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			v := strings.TrimSpace(string(t))
+			if v == "" || len(stack) < 2 {
+				continue
+			}
+			leaf, parent := stack[len(stack)-1], stack[len(stack)-2]
+			switch {
+			case parent == "processors" && leaf == "count":
+				fmt.Sscan(v, &cfg.CPUs)
+			case parent == "bank" && leaf == "size":
+				fmt.Sscan(v, &cfg.MemoryMB)
+			case leaf == "pathname":
+				ext := strings.ToLower(filepath.Ext(v))
+				if ext == ".vhdx" || ext == ".vhd" {
+					cfg.Disks = append(cfg.Disks, v[strings.LastIndexAny(v, `\/`)+1:])
+				}
+			}
+		}
+	}
+	return cfg, true
+}
+
+// hypervDisks returns the export's disks relative to the VM directory, in
+// the order the configuration attaches them when that is known.
+func hypervDisks(vm string) []string {
+	files := hypervDiskFiles(vm)
+	cfg, ok := hypervConfig(vm)
+	if ok {
+		vmLog(vm).Info("Hyper-V config", "vcpu", cfg.CPUs, "memory_mib", cfg.MemoryMB)
+	} else {
+		vmLog(vm).Warn("Hyper-V config not readable (.vmcx) – disks in name order, sizing from the dummy VM")
+	}
+	rank := map[string]int{}
+	for i, d := range cfg.Disks {
+		if _, dup := rank[strings.ToLower(d)]; !dup {
+			rank[strings.ToLower(d)] = i
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		ri, iok := rank[strings.ToLower(path.Base(files[i]))]
+		rj, jok := rank[strings.ToLower(path.Base(files[j]))]
+		if iok != jok {
+			return iok
+		}
+		return iok && ri < rj
+	})
+	out := make([]string, len(files))
+	for i, f := range files {
+		out[i] = strings.TrimPrefix(f, vm+"/")
+	}
+	return out
+}
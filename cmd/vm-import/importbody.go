@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- custom import request body ---------*/
+
+// importBodyTmpl is the -import-body template, nil to send the built-in
+// import request.
+var importBodyTmpl []byte
+
+// loadImportBody reads the -import-body template and renders it once up
+// front, so a typo fails the run before anything is staged.
+func loadImportBody() error {
+	if *importBody == "" {
+		return nil
+	}
+	b, err := os.ReadFile(*importBody)
+	if err != nil {
+		return err
+	}
+	importBodyTmpl = b
+	_, err = renderImportBody("vm", "smb://host/share/vm", "", nil, defaultCluster())
+	return err
+}
+
+// renderImportBody fills in the -import-body template for the staged vm,
+// read by HC3 from uri, and returns the import request body. Besides the
+// tag placeholders it knows {{pathuri}}, {{share}}, {{definition}} (the
+// Scale XML's file name), {{format}} and {{name}}, JSON-escaped to go in
+// strings, and {{nonsequential}} and {{streams}}, the bare values of
+// -nonsequential-writes and -import-streams. The template, name and ci
+// of the run are added as the body's "template" unless it has one.
+func renderImportBody(vm, uri, name string, ci *hc3.CloudInitData, cl *cluster) (json.RawMessage, error) {
+	vars := map[string]string{
+		"pathuri":    uri,
+		"share":      cl.share(),
+		"definition": path.Base(xmlPath(vm)),
+		"format":     "qcow2",
+		"name":       name,
+	}
+	raw := map[string]string{
+		"nonsequential": strconv.FormatBool(cl.nonSequential()),
+		"streams":       strconv.Itoa(cl.streams()),
+	}
+	var bad string
+	out := reTagVar.ReplaceAllFunc(importBodyTmpl, func(m []byte) []byte {
+		key := string(reTagVar.FindSubmatch(m)[1])
+		if v, ok := raw[key]; ok {
+			return []byte(v)
+		}
+		v, ok := vars[key]
+		switch {
+		case ok:
+		case tagVars[key] != nil:
+			v = tagVars[key](vm)
+		default:
+			bad = key
+			return m
+		}
+		b, _ := json.Marshal(v)
+		return b[1 : len(b)-1]
+	})
+	if bad != "" {
+		return nil, fmt.Errorf("%s: unknown placeholder {{%s}} (have pathuri, share, definition, format, name, nonsequential, streams, %s)",
+			*importBody, bad, strings.Join(slices.Sorted(maps.Keys(tagVars)), ", "))
+	}
+	var body map[string]any
+	if err := json.Unmarshal(out, &body); err != nil {
+		return nil, fmt.Errorf("%s: not a JSON object once filled in: %w", *importBody, err)
+	}
+	if _, ok := body["template"]; !ok && (name != "" || ci != nil) {
+		body["template"] = hc3.ImportTemplate{Name: name, CloudInitData: ci}
+	}
+	return json.Marshal(body)
+}
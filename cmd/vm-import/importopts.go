@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- newer import parameters ---------*/
+
+// importOption is an import request parameter only newer HyperCore
+// versions know, sent when its flag is set and the cluster is new enough.
+type importOption struct {
+	flag  string // that asks for it
+	since string // first HyperCore version that knows it
+	on    func() bool
+	set   func(*hc3.ImportSource)
+}
+
+var importOptions = []importOption{
+	{"compact-disks", "9.2", func() bool { return *compactDisks }, func(s *hc3.ImportSource) { s.PerformDiskCompaction = true }},
+	{"preserve-macs", "9.4", func() bool { return *preserveMACs }, func(s *hc3.ImportSource) { s.PreserveMacAddress = true }},
+}
+
+var (
+	versionMu sync.Mutex
+	versions  = map[*cluster]string{} // HyperCore version by cluster, "" if unreadable
+)
+
+// hyperCoreVersion returns the cluster's HyperCore version, asked once a
+// run, or "" if it cannot be read.
+func (c *cluster) hyperCoreVersion(ctx context.Context) string {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+	if v, ok := versions[c]; ok {
+		return v
+	}
+	info, err := hc3Client(c, hc3.DefaultTimeout).Cluster(ctx)
+	if err != nil {
+		slog.Warn("cannot read the cluster's HyperCore version, sending the import options asked for regardless", "cluster", c.label(), "err", err)
+	}
+	versions[c] = info.IcosVersion
+	slog.Debug("HyperCore version", "cluster", c.label(), "version", info.IcosVersion)
+	return info.IcosVersion
+}
+
+// applyImportOptions sets the newer import parameters the flags ask for
+// on src, leaving out with a warning those cluster cl is too old for.
+func applyImportOptions(ctx context.Context, vm string, cl *cluster, src *hc3.ImportSource) {
+	for _, o := range importOptions {
+		if !o.on() {
+			continue
+		}
+		if v := cl.hyperCoreVersion(ctx); v != "" && !hc3.AtLeast(v, o.since) {
+			vmLog(vm).Warn("HyperCore too old for -"+o.flag+", importing without it", "cluster", cl.label(), "version", v, "needs", o.since)
+			continue
+		}
+		o.set(src)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*--------- ISO 9660 images ---------*/
+
+const isoSector = 2048
+
+// isoFile is a file in the root directory of an ISO image.
+type isoFile struct {
+	name string
+	data []byte
+}
+
+// writeISO writes a plain ISO 9660 image labelled label with files in its
+// root directory, enough for a guest to read small configuration files
+// off a CD drive. Names are upper-cased, as the standard wants; Windows
+// and Linux match them case-insensitively.
+//
+// The layout is fixed: the volume descriptors in sectors 16 and 17, the
+// path tables in 18 and 19, the root directory in 20 and the files after
+// it.
+func writeISO(w io.Writer, label string, files []isoFile) error {
+	files = slices.Clone(files)
+	slices.SortFunc(files, func(a, b isoFile) int { return strings.Compare(isoName(a.name), isoName(b.name)) })
+	const rootSector = 20
+	now := time.Now().UTC()
+
+	root := new(bytes.Buffer)
+	next := uint32(rootSector + 1)
+	root.Write(isoDirRecord("\x00", rootSector, isoSector, true, now))
+	root.Write(isoDirRecord("\x01", rootSector, isoSector, true, now))
+	for _, f := range files {
+		root.Write(isoDirRecord(isoName(f.name)+";1", next, uint32(len(f.data)), false, now))
+		next += sectors(len(f.data))
+	}
+	if root.Len() > isoSector {
+		return fmt.Errorf("too many files for an ISO root directory")
+	}
+
+	pvd := make([]byte, isoSector)
+	pvd[0], pvd[6] = 1, 1
+	copy(pvd[1:], "CD001")
+	copy(pvd[8:40], isoPad("", 32))
+	copy(pvd[40:72], isoPad(strings.ToUpper(label), 32))
+	bothEndian32(pvd[80:], next)
+	bothEndian16(pvd[120:], 1) // volume set size
+	bothEndian16(pvd[124:], 1) // volume sequence number
+	bothEndian16(pvd[128:], isoSector)
+	bothEndian32(pvd[132:], 10) // path table size: the root only
+	binary.LittleEndian.PutUint32(pvd[140:], 18)
+	binary.BigEndian.PutUint32(pvd[148:], 19)
+	copy(pvd[156:190], isoDirRecord("\x00", rootSector, isoSector, true, now))
+	copy(pvd[190:813], isoPad("", 813-190)) // volume set to bibliographic file IDs
+	copy(pvd[574:702], isoPad("VM-IMPORT", 128))
+	stamp := now.Format("20060102150405") + "00\x00"
+	copy(pvd[813:], stamp)
+	copy(pvd[830:], stamp)
+	copy(pvd[847:], "0000000000000000\x00")
+	copy(pvd[864:], "0000000000000000\x00")
+	pvd[881] = 1
+
+	term := make([]byte, isoSector)
+	term[0], term[6] = 255, 1
+	copy(term[1:], "CD001")
+
+	pathL, pathM := make([]byte, isoSector), make([]byte, isoSector)
+	for _, pt := range [][]byte{pathL, pathM} {
+		pt[0] = 1 // name length
+		pt[6] = 1 // parent: itself
+	}
+	binary.LittleEndian.PutUint32(pathL[2:], rootSector)
+	binary.BigEndian.PutUint32(pathM[2:], rootSector)
+	pathM[6], pathM[7] = 0, 1
+
+	out := []io.Reader{
+		bytes.NewReader(make([]byte, 16*isoSector)), // system area
+		bytes.NewReader(pvd), bytes.NewReader(term),
+		bytes.NewReader(pathL), bytes.NewReader(pathM),
+		bytes.NewReader(padSector(root.Bytes())),
+	}
+	for _, f := range files {
+		out = append(out, bytes.NewReader(padSector(f.data)))
+	}
+	_, err := io.Copy(w, io.MultiReader(out...))
+	return err
+}
+
+// isoDirRecord encodes a directory record for name at sector extent.
+func isoDirRecord(name string, extent, size uint32, dir bool, t time.Time) []byte {
+	n := 33 + len(name)
+	n += n % 2
+	r := make([]byte, n)
+	r[0] = byte(n)
+	bothEndian32(r[2:], extent)
+	bothEndian32(r[10:], size)
+	r[18], r[19], r[20] = byte(t.Year()-1900), byte(t.Month()), byte(t.Day())
+	r[21], r[22], r[23] = byte(t.Hour()), byte(t.Minute()), byte(t.Second())
+	if dir {
+		r[25] = 2
+	}
+	bothEndian16(r[28:], 1)
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+// isoName maps name to the characters ISO 9660 allows in file names.
+func isoName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func isoPad(s string, n int) string { return (s + strings.Repeat(" ", n))[:n] }
+
+func sectors(n int) uint32 { return uint32((n + isoSector - 1) / isoSector) }
+
+func padSector(b []byte) []byte {
+	return append(b[:len(b):len(b)], make([]byte, int(sectors(len(b)))*isoSector-len(b))...)
+}
+
+func bothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
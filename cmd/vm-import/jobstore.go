@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+/*--------- persistent job queue ---------*/
+
+// stateDir returns the directory the daemon keeps its state in: -state-dir,
+// else $XDG_STATE_HOME/vm-import, else ~/.local/state/vm-import –
+// %LocalAppData%\vm-import on Windows.
+func stateDir() (string, error) {
+	if *stateDirFlag != "" {
+		return *stateDirFlag, nil
+	}
+	if d := os.Getenv("LocalAppData"); d != "" && runtime.GOOS == "windows" {
+		return filepath.Join(d, "vm-import"), nil
+	}
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return filepath.Join(d, "vm-import"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "vm-import"), nil
+}
+
+// loadJobs restores the queue saved in file. Jobs that were running when
+// the daemon stopped are queued again so they restart from the top.
+func (q *jobQueue) loadJobs(file string) error {
+	q.file = file
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []*job
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	resumed := 0
+	for _, j := range saved {
+		j.Progress = nil
+		j.log = &jobLog{}
+		j.ctx, j.cancel = jobContext()
+		if j.State == "running" {
+			j.State = "queued"
+		}
+		if j.State == "queued" {
+			resumed++
+		}
+		if j.ID >= q.nextID {
+			q.nextID = j.ID + 1
+		}
+	}
+	q.jobs = saved
+	if resumed > 0 {
+		slog.Info("↻ resuming queued jobs", "count", resumed, "file", file)
+	}
+	return nil
+}
+
+// save writes the queue to its file, replacing it atomically. Callers hold
+// q.mu. A failed save is reported but does not stop the daemon.
+func (q *jobQueue) save() {
+	if q.file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.file), 0o755)
+	}
+	if err == nil {
+		tmp := q.file + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, q.file)
+		}
+	}
+	if err != nil {
+		slog.Warn("saving job queue", "err", err)
+	}
+	q.saveErr = err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path"
+)
+
+/*--------- -keep-existing ---------*/
+
+// qcow2Backup holds the staged images of a VM set aside as .bak while its
+// disks are copied again, for -keep-existing.
+type qcow2Backup struct {
+	vm    string
+	files []string // staged names, without .bak
+	done  bool
+}
+
+// backupQcow2 renames the staged images in dir not in keep to <name>.bak,
+// as deleteQcow2 would remove them, so a failed copy can put them back.
+func backupQcow2(dir string, keep map[string]bool) (*qcow2Backup, error) {
+	staged, err := stage.Glob(path.Join(dir, "*.qcow2"))
+	if err != nil {
+		return nil, err
+	}
+	b := &qcow2Backup{vm: dir}
+	for _, p := range staged {
+		if keep[path.Base(p)] {
+			vmLog(dir).Debug("keeping for delta sync", "file", path.Base(p))
+			continue
+		}
+		if *dryRun {
+			vmLog(dir).Info("[dry-run] set aside", "file", path.Base(p), "as", path.Base(p)+".bak")
+			continue
+		}
+		if stage.Exists(p + ".bak") { // left by a run that died mid-copy
+			stage.Remove(p + ".bak")
+		}
+		if err := stage.Rename(p, p+".bak"); err != nil {
+			b.restore()
+			return nil, err
+		}
+		b.files = append(b.files, p)
+		vmLog(dir).Info("📦 set aside until the copy succeeds", "file", path.Base(p), "as", path.Base(p)+".bak")
+	}
+	return b, nil
+}
+
+// restore puts the images set aside back in place of whatever the failed
+// copy left. It does nothing once drop has run, or on a nil backup.
+func (b *qcow2Backup) restore() {
+	if b == nil || b.done {
+		return
+	}
+	b.done = true
+	for _, p := range b.files {
+		if stage.Exists(p) {
+			stage.Remove(p)
+		}
+		if err := stage.Rename(p+".bak", p); err != nil {
+			vmLog(b.vm).Error("cannot restore the previous staged image", "file", path.Base(p)+".bak", "err", err)
+			continue
+		}
+		vmLog(b.vm).Info("↩ restored the previous staged image", "file", path.Base(p))
+	}
+}
+
+// drop removes the images set aside, once the new ones are staged.
+func (b *qcow2Backup) drop() error {
+	if b == nil || b.done {
+		return nil
+	}
+	b.done = true
+	for _, p := range b.files {
+		err := removeStaged(p + ".bak")
+		audit("delete", b.vm, err, auditEntry{Paths: []string{under(*scaleDir, p+".bak")}})
+		if err != nil {
+			return err
+		}
+		vmLog(b.vm).Info("🗑 removed", "file", path.Base(p)+".bak")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+)
+
+/*--------- floppy, serial and parallel devices ---------*/
+
+// withoutFloppies drops floppy images from vm's source disk files – those
+// backing a floppy drive of the OVF, and any .flp file – so they are not
+// paired with Scale disks.
+func withoutFloppies(vm string, files []string) ([]string, error) {
+	env, err := readOVF(vm)
+	if err != nil {
+		return nil, err
+	}
+	images := map[string]bool{}
+	if env != nil {
+		for _, d := range env.LegacyDevices() {
+			if d.Href == "" {
+				continue
+			}
+			if f, err := hrefFile(vm, d.Href); err == nil {
+				images[f] = true
+			}
+		}
+	}
+	var out []string
+	for _, f := range files {
+		if !images[f] && !strings.EqualFold(path.Ext(f), ".flp") {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// sourceLegacy returns the floppy drives and serial and parallel ports of
+// vm's OVF, which are not carried over, for the run history and report.
+// It is nil when there is no OVF or no such device.
+func sourceLegacy(vm string) ([]ovf.LegacyDevice, error) {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return nil, err
+	}
+	devs := env.LegacyDevices()
+	for _, d := range devs {
+		vmLog(vm).Info("⏭ legacy device skipped", "device", d.String())
+	}
+	return devs, nil
+}
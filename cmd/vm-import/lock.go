@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+/*--------- instance locking ---------*/
+
+// lockName is the lock of the whole staging dir, held by gc -delete and
+// undo and while a run empties old trash, in each VM's directory the lock
+// of that VM (see lockVM), and in each run's trash directory that of the
+// run, held while it lasts.
+const lockName = ".vm-import.lock"
+
+// errLocked is returned by TryLock when another instance holds the lock.
+var errLocked = errors.New("locked")
+
+// lockingStager is implemented by staging backends that can hold an
+// exclusive lock on a name below the staging root.
+type lockingStager interface {
+	TryLock(name, owner string) (unlock func() error, holder string, err error)
+}
+
+// acquireLock takes the staging lock called name, either failing fast when
+// another instance holds it or, with wait set, queueing until it is free.
+func acquireLock(name string, wait bool) (func(), error) {
+	ls, ok := stage.(lockingStager)
+	if !ok {
+		return func() {}, nil
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("pid %d on %s since %s", os.Getpid(), host, time.Now().Format(time.RFC3339))
+
+	announced := false
+	for {
+		unlock, holder, err := ls.TryLock(name, owner)
+		if err == nil {
+			return func() {
+				if err := unlock(); err != nil {
+					slog.Warn("releasing lock", "lock", name, "err", err)
+				}
+			}, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, err
+		}
+		if holder == "" {
+			holder = "another process"
+		}
+		if !wait {
+			return nil, fmt.Errorf("staging dir is in use (%s held by %s); rerun with -wait-lock to queue", name, holder)
+		}
+		if !announced {
+			slog.Info("⏳ waiting for lock", "lock", name, "holder", holder)
+			announced = true
+		}
+		select {
+		case <-runCtx.Done():
+			return nil, interrupted()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// lockVM takes the lock of vm's staging directory, so no other instance –
+// nor another job of this one – stages its disks, rewrites its Scale XML
+// or cleans it up meanwhile, while other VMs proceed in parallel. With
+// wait set it queues behind the holder. Dry runs change nothing and lock
+// nothing.
+func lockVM(vm string, wait bool) (func(), error) {
+	if *dryRun {
+		return func() {}, nil
+	}
+	release, err := acquireLock(path.Join(vm, lockName), wait)
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", vm, err)
+	}
+	return release, nil
+}
@@ -0,0 +1,30 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TryLock falls back to an exclusively created lock file; remove it by
+// hand if an instance crashed while holding it.
+func (l localStager) TryLock(name, owner string) (func() error, string, error) {
+	p := l.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, "", err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			b, _ := os.ReadFile(p)
+			return nil, strings.TrimSpace(string(b)), errLocked
+		}
+		return nil, "", err
+	}
+	f.WriteString(owner + "\n")
+	f.Close()
+	return func() error { return os.Remove(p) }, "", nil
+}
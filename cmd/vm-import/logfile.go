@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------- log file with rotation ---------*/
+
+// rotatingFile is an append-only log file that is renamed to
+// <name>.<timestamp> once it would grow past maxSize. Rotated files older
+// than maxAge, or beyond the newest maxBackups, are deleted.
+type rotatingFile struct {
+	mu         sync.Mutex
+	name       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(name string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{name: name, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.prune()
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	backup := r.name + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(r.name, backup); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes rotated files past maxAge or maxBackups; failures only
+// leave extra files behind.
+func (r *rotatingFile) prune() {
+	old, _ := filepath.Glob(r.name + ".*")
+	sort.Sort(sort.Reverse(sort.StringSlice(old))) // newest first
+	for i, p := range old {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if r.maxBackups > 0 && i >= r.maxBackups || r.maxAge > 0 && time.Since(fi.ModTime()) > r.maxAge {
+			os.Remove(p)
+		}
+	}
+}
+
+/*--------- fan-out handler ---------*/
+
+// multiHandler sends each record to every handler that accepts its level.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// fileHandler opens -log-file for the logger. The file always gets
+// timestamps: JSON with -log-format=json, logfmt otherwise.
+func fileHandler(level slog.Level) (slog.Handler, error) {
+	if *logMaxSize < 0 || *logMaxBackups < 0 {
+		return nil, fmt.Errorf("-log-max-size and -log-max-backups must not be negative")
+	}
+	f, err := openRotatingFile(*logFile, *logMaxSize<<20, *logMaxAge, *logMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(*logFormat, "json") {
+		return slog.NewJSONHandler(f, opts), nil
+	}
+	return slog.NewTextHandler(f, opts), nil
+}
+
+// vmLogs holds the loggers of VMs that have their own log file.
+var vmLogs = struct {
+	sync.Mutex
+	m map[string]*slog.Logger
+}{m: map[string]*slog.Logger{}}
+
+// vmLogDir is where per-VM log files go: -vm-log-dir, else "logs" when
+// VMs run in parallel, else nowhere.
+func vmLogDir() string {
+	if *vmLogDirFlag == "" && *parallel > 1 {
+		return "logs"
+	}
+	return *vmLogDirFlag
+}
+
+// openVMLog gives vm a log file <dir>/<vm>-<timestamp>.log with its full
+// log stream, debug lines included, for as long as it is processed. Call
+// the result to close it.
+func openVMLog(vm string) (func(), error) {
+	dir := vmLogDir()
+	if dir == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s.log", strings.ReplaceAll(vm, "/", "_"), time.Now().Format("20060102-150405")))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var fh slog.Handler = slog.NewTextHandler(f, opts)
+	if strings.EqualFold(*logFormat, "json") {
+		fh = slog.NewJSONHandler(f, opts)
+	}
+	l := slog.New(multiHandler{slog.Default().Handler(), fh}).With("vm", vm)
+	vmLogs.Lock()
+	vmLogs.m[vm] = l
+	vmLogs.Unlock()
+	l.Debug("log file opened", "file", name)
+	return func() {
+		vmLogs.Lock()
+		delete(vmLogs.m, vm)
+		vmLogs.Unlock()
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*--------- logging ---------*/
+
+// vmLog returns the logger for messages about vm, carrying its "vm" field
+// and writing to the VM's own log file while it has one.
+func vmLog(vm string) *slog.Logger {
+	vmLogs.Lock()
+	defer vmLogs.Unlock()
+	if l := vmLogs.m[vm]; l != nil {
+		return l
+	}
+	return slog.With("vm", vm)
+}
+
+// levelTrace is below debug, for the chattiest details shown with -vv.
+const levelTrace = slog.LevelDebug - 4
+
+// setupLogging installs the default logger for -log-format and -log-level,
+// copying records to -log-file and syslog or the journal when set. -v, -vv
+// and -q override the level on the console only.
+func setupLogging() error {
+	if *progressFormat != "" && *progressFormat != "json" {
+		return fmt.Errorf("unknown -progress-format %q", *progressFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("unknown -log-level %q", *logLevel)
+	}
+	console := level
+	switch {
+	case *quiet && (*verbose || *veryVerbose):
+		return fmt.Errorf("-q cannot be combined with -v or -vv")
+	case *quiet:
+		console = slog.LevelError
+	case *veryVerbose:
+		console = levelTrace
+	case *verbose:
+		console = slog.LevelDebug
+	}
+	if *noColor {
+		os.Setenv("NO_COLOR", "1")
+	}
+	var h slog.Handler
+	switch *logFormat {
+	case "console", "":
+		h = &consoleHandler{level: console, mu: &sync.Mutex{}, prefixVM: *parallel > 1}
+	case "text":
+		h = slog.NewTextHandler(stdout{}, &slog.HandlerOptions{Level: console})
+	case "json":
+		h = slog.NewJSONHandler(stdout{}, &slog.HandlerOptions{Level: console})
+	default:
+		return fmt.Errorf("unknown -log-format %q", *logFormat)
+	}
+	if *logFile != "" {
+		fh, err := fileHandler(level)
+		if err != nil {
+			return fmt.Errorf("opening -log-file: %w", err)
+		}
+		h = multiHandler{h, fh}
+	}
+	if *syslogAddr != "" || *journald {
+		sh, err := systemLogHandler(level)
+		if err != nil {
+			return err
+		}
+		h = multiHandler{h, sh}
+	}
+	slog.SetDefault(slog.New(multiHandler{h, warnCollector{}}))
+	return nil
+}
+
+// stdout writes to whatever os.Stdout is at the time, so output captured
+// by the daemon for its job logs includes log lines. With JSON progress
+// events or -ansible it writes to stderr, leaving stdout to the JSON.
+// With plainOutput, symbols are spelled in ASCII.
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	if jsonEvents() || *ansible {
+		return writePlain(os.Stderr, p)
+	}
+	return writePlain(os.Stdout, p)
+}
+
+// consoleHandler prints records the way a person at the terminal wants
+// them: the message, then key=value fields, without timestamps. Warnings
+// and errors get a marker unless the message already starts with one.
+// With emit set, each line goes there instead of stdout, unmarked. With
+// prefixVM set, lines about a VM start with "[vm] " so interleaved
+// output from -parallel runs can be told apart.
+type consoleHandler struct {
+	level    slog.Leveler
+	attrs    []slog.Attr
+	group    string
+	mu       *sync.Mutex
+	emit     func(level slog.Level, line string) error
+	prefixVM bool
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, l slog.Level) bool { return l >= h.level.Level() }
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	msg := r.Message
+	skip := func(a slog.Attr) bool { return h.prefixVM && a.Key == "vm" }
+	if h.prefixVM {
+		for _, a := range h.attrs {
+			if skip(a) {
+				fmt.Fprintf(&b, "[%s] ", a.Value)
+			}
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			if skip(a) {
+				fmt.Fprintf(&b, "[%s] ", a.Value)
+			}
+			return true
+		})
+	}
+	switch {
+	case h.emit != nil:
+	case r.Level >= slog.LevelError && !strings.HasPrefix(msg, "❌"):
+		b.WriteString("❌ ")
+	case r.Level >= slog.LevelWarn && r.Level < slog.LevelError && !strings.HasPrefix(msg, "⚠️"):
+		b.WriteString("⚠️  ")
+	case r.Level < slog.LevelDebug:
+		b.WriteString("·· ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("· ")
+	}
+	b.WriteString(msg)
+	for _, a := range h.attrs {
+		if !skip(a) {
+			writeAttr(&b, "", a)
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if !skip(a) {
+			writeAttr(&b, h.group, a)
+		}
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.emit != nil {
+		return h.emit(r.Level, b.String())
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(stdout{}, b.String())
+	return err
+}
+
+func writeAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	key := a.Key
+	if group != "" {
+		key = group + "." + key
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, g := range a.Value.Group() {
+			writeAttr(b, key, g)
+		}
+		return
+	}
+	v := a.Value.String()
+	if strings.ContainsAny(v, " \t\"=") || v == "" {
+		v = fmt.Sprintf("%q", v)
+	}
+	fmt.Fprintf(b, " %s=%s", key, v)
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(append([]slog.Attr{}, h.attrs...), prefixed(h.group, attrs)...)
+	return &c
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	if c.group != "" {
+		name = c.group + "." + name
+	}
+	c.group = name
+	return &c
+}
+
+func prefixed(group string, attrs []slog.Attr) []slog.Attr {
+	if group == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = slog.Attr{Key: group + "." + a.Key, Value: a.Value}
+	}
+	return out
+}
+
+// fatal logs msg with err at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	if *ansible {
+		ansibleFatal(msg, args...)
+	}
+	os.Exit(exitFatal)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- machine type ---------*/
+
+// machineTypes maps the -machine-type choices to HC3 machine types.
+// Values naming an HC3 machine type, e.g. scale-uefi-tpm-compatible-9.3,
+// are passed through as they are.
+var machineTypes = map[string]string{
+	"bios":     "scale-bios-9.3",
+	"uefi":     "scale-uefi-9.3",
+	"uefi-tpm": "scale-uefi-tpm-9.3",
+}
+
+// checkMachineType checks -machine-type and the manifest's machineType
+// entries.
+func checkMachineType() error {
+	if _, err := hc3MachineType(*machineType); err != nil {
+		return fmt.Errorf("-machine-type: %w", err)
+	}
+	if plan == nil {
+		return nil
+	}
+	for _, v := range plan.VMs {
+		if _, err := hc3MachineType(v.MachineType); err != nil {
+			return fmt.Errorf("manifest VM %s: machineType: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// hc3MachineType returns the HC3 machine type for a -machine-type value,
+// "" for none.
+func hc3MachineType(s string) (string, error) {
+	if s == "" || strings.HasPrefix(s, "scale-") {
+		return s, nil
+	}
+	if t, ok := machineTypes[strings.ToLower(s)]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("want bios, uefi, uefi-tpm or an HC3 machine type such as scale-uefi-9.3, not %q", s)
+}
+
+// machineTypeFor returns the HC3 machine type vm is to get – the
+// manifest's machineType, else -machine-type, else what its OVF's
+// firmware needs (see firmwareMachineType) – or "" to keep the one HC3
+// chose on import.
+func machineTypeFor(vm string) string {
+	if t := explicitMachineType(vm); t != "" {
+		return t
+	}
+	return firmwareMachineType(vm)
+}
+
+// explicitMachineType returns the machine type asked for vm by the
+// manifest or -machine-type, or "".
+func explicitMachineType(vm string) string {
+	s := *machineType
+	if v := plan.vm(vm); v != nil {
+		s = cmp.Or(v.MachineType, s)
+	}
+	t, _ := hc3MachineType(s) // checked by checkMachineType
+	return t
+}
+
+// setMachineType waits for HC3 to finish the import q and sets the new VM
+// to machine type t, for guests that need a vTPM or were converted to UEFI
+// by hand, whatever the import made of them.
+func setMachineType(ctx context.Context, q queuedImport, t string) error {
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	if err := c.WaitTask(ctx, q.task, taskPollInterval); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	v, err := c.VM(ctx, q.uuid)
+	if err != nil {
+		return err
+	}
+	if v.MachineType == t {
+		return nil
+	}
+	task, err := c.SetMachineType(ctx, q.uuid, t)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	audit("machine-type", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return err
+	}
+	vmLog(q.vm).Info("✎ machine type", "uuid", q.uuid, "from", v.MachineType, "to", t)
+	return nil
+}
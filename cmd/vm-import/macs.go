@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- MAC address conflicts ---------*/
+
+// checkMACs looks for the MAC addresses the staged Scale XML of t gives
+// its NICs – kept from the source, set for -net-recipe or the dummy VM's –
+// among the NICs of the VMs already on cluster cl, skipping the dummy VM
+// (by UUID), which the definition came from. A MAC taken on the same VLAN
+// fails the import, as duplicate MACs cause outages that are miserable to
+// debug after cutover; on another VLAN it is only warned about. A cluster
+// that cannot be asked is warned about too.
+func checkMACs(ctx context.Context, t string, cl *cluster, dummy string) error {
+	doc, err := readScaleXML(xmlPath(t))
+	if err != nil {
+		return err
+	}
+	want := map[string]int{} // MAC → VLAN
+	for _, ifc := range doc.FindAll("interface") {
+		m := ifc.Child("mac")
+		if m == nil {
+			continue
+		}
+		mac, err := net.ParseMAC(m.Attr("address"))
+		if err != nil {
+			continue
+		}
+		vlan := 0
+		if v := ifc.Child("vlan"); v != nil && v.Child("tag") != nil {
+			vlan, _ = strconv.Atoi(v.Child("tag").Attr("id"))
+		}
+		want[mac.String()] = vlan
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	lg := vmLog(t)
+	vms, err := hc3Client(cl, hc3.DefaultTimeout).VMs(ctx)
+	if err != nil {
+		lg.Warn("cannot check the cluster for MAC address conflicts", "err", err)
+		return nil
+	}
+	var clash []string
+	for _, v := range vms {
+		if v.UUID == dummy {
+			continue
+		}
+		for _, n := range v.NetDevs {
+			mac, err := net.ParseMAC(n.MACAddress)
+			if err != nil {
+				continue
+			}
+			vlan, ok := want[mac.String()]
+			switch {
+			case !ok:
+			case n.VLAN == vlan:
+				clash = append(clash, fmt.Sprintf("%s (VM %s, VLAN %d)", mac, v.Name, vlan))
+			default:
+				lg.Warn("MAC address already used on another VLAN", "mac", mac.String(), "vm", v.Name, "vlan", n.VLAN)
+			}
+		}
+	}
+	if len(clash) > 0 {
+		return fmt.Errorf("MAC address(es) already on the cluster: %s – change them in the Scale XML or the -network-map", strings.Join(clash, ", "))
+	}
+	return nil
+}
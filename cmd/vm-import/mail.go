@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+/*--------- email notifications ---------*/
+
+// sendMail emails n to -mail-to through -smtp.
+func sendMail(n notification) error {
+	var to []string
+	for _, a := range strings.Split(*mailTo, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			to = append(to, a)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("-smtp set but -mail-to is empty")
+	}
+	host, port, err := net.SplitHostPort(*smtpAddr)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", *mailFrom)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Summary))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(n.Summary + "\r\n")
+	for _, v := range n.VMs {
+		b.WriteString("\r\n  " + v.Summary)
+	}
+	if len(n.VMs) > 0 {
+		b.WriteString("\r\n")
+	}
+	msg := []byte(b.String())
+
+	var auth smtp.Auth
+	if *smtpUser != "" {
+		auth = smtp.PlainAuth("", *smtpUser, *smtpPass, host)
+	}
+	if port != "465" {
+		return smtp.SendMail(*smtpAddr, auth, *mailFrom, to, msg)
+	}
+
+	conn, err := tls.Dial("tcp", *smtpAddr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(*mailFrom); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
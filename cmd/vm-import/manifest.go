@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+/*--------- batch manifest ---------*/
+
+// manifest describes a batch of VMs to process, loaded from -manifest.
+//
+//	{"tags": ["wave-1"],
+//	 "clusters": {"dr": {"api": "https://hc3-dr.example.com"}},
+//	 "vms": [{"name": "appliance", "url": "https://…/appliance.ova", "sha256": "…",
+//	          "tags": ["dmz"], "targetName": "prod-appliance", "cluster": "dr"}]}
+type manifest struct {
+	Tags     []string            `json:"tags,omitempty"`     // for every VM, after -tag
+	Clusters map[string]*cluster `json:"clusters,omitempty"` // besides those given with -cluster
+	Cluster  string              `json:"cluster,omitempty"`  // for every VM without its own (default: -api)
+	VMs      []manifestVM        `json:"vms"`
+}
+
+type manifestVM struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url,omitempty"`    // fetch the OVA from here first
+	SHA256 string   `json:"sha256,omitempty"` // expected digest of the download
+	Tags   []string `json:"tags,omitempty"`   // for this VM, after the batch tags
+
+	TargetName string `json:"targetName,omitempty"` // name on HC3, instead of -target-name
+	OVF        string `json:"ovf,omitempty"`        // descriptor to use, instead of -ovf-name
+	Cluster    string `json:"cluster,omitempty"`    // import into this cluster
+	SmokeCheck string `json:"smokeCheck,omitempty"` // address for -smoke-test, instead of -smoke-check
+	UserData   string `json:"userData,omitempty"`   // cloud-init user-data file, instead of -user-data
+	MetaData   string `json:"metaData,omitempty"`   // cloud-init meta-data file, instead of -meta-data
+
+	Unattend     string            `json:"unattend,omitempty"`     // unattend.xml template, instead of -unattend
+	UnattendVars map[string]string `json:"unattendVars,omitempty"` // values for the template's {{placeholders}}, e.g. ip
+
+	CPUs   int    `json:"cpus,omitempty"`   // vCPUs on HC3, instead of -cpu
+	Memory string `json:"memory,omitempty"` // memory size on HC3, e.g. 8GiB, instead of -memory
+
+	MachineType    string `json:"machineType,omitempty"`    // e.g. uefi-tpm, instead of -machine-type or the OVF's firmware
+	AcceptTPMReset bool   `json:"acceptTPMReset,omitempty"` // the vTPM may be lost: as -accept-tpm-reset
+
+	Priority int      `json:"priority,omitempty"` // higher first: a batch's VMs of one priority finish before lower ones start
+	After    []string `json:"after,omitempty"`    // VMs of the batch to import (and with -power-on start) successfully first
+
+	DriversInjected bool `json:"driversInjected,omitempty"` // virtio drivers are in the guest already: no -virtio-iso
+}
+
+// plan is the loaded -manifest, or nil.
+var plan *manifest
+
+func loadManifest(p string) (*manifest, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+	for i, v := range m.VMs {
+		if v.Name == "" && v.URL == "" {
+			return nil, fmt.Errorf("%s: entry %d has neither name nor url", p, i+1)
+		}
+		if _, err := parseSize(v.Memory); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", p, i+1, err)
+		}
+		if v.CPUs < 0 {
+			return nil, fmt.Errorf("%s: entry %d: negative cpus", p, i+1)
+		}
+		if v.Name == "" {
+			m.VMs[i].Name = vmNameFromURL(v.URL)
+		}
+	}
+	if err := m.checkDeps(); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return &m, nil
+}
+
+// vm returns the manifest entry for name, as given or normalized by
+// -name-rules, or nil.
+func (m *manifest) vm(name string) *manifestVM {
+	if m == nil {
+		return nil
+	}
+	for i := range m.VMs {
+		if m.VMs[i].Name == name || normalizeName(m.VMs[i].Name) == name {
+			return &m.VMs[i]
+		}
+	}
+	return nil
+}
+
+// names returns the VM names in manifest order.
+func (m *manifest) names() []string {
+	out := make([]string, len(m.VMs))
+	for i, v := range m.VMs {
+		out[i] = v.Name
+	}
+	return out
+}
+
+// waves splits vms into groups of equal manifest priority, highest first,
+// each in the order given. VMs the manifest does not list have priority 0;
+// without a manifest all are one group.
+func (m *manifest) waves(vms []string) [][]string {
+	prio := func(vm string) int {
+		if v := m.vm(vm); v != nil {
+			return v.Priority
+		}
+		return 0
+	}
+	sorted := slices.Clone(vms)
+	slices.SortStableFunc(sorted, func(a, b string) int { return cmp.Compare(prio(b), prio(a)) })
+	var out [][]string
+	for i, vm := range sorted {
+		if i == 0 || prio(vm) != prio(sorted[i-1]) {
+			out = append(out, nil)
+		}
+		out[len(out)-1] = append(out[len(out)-1], vm)
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*--------- VM name rules ---------*/
+
+// nameRules are the steps of -name-rules, applied in order to each
+// element of a VM name.
+var nameRules []func(string) string
+
+// asciiFold spells the accented Latin letters of common European names
+// in ASCII, for the ascii rule; other non-ASCII characters are dropped.
+var asciiFold = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "Ä", "Ae", "Ö", "Oe", "Ü", "Ue", "ß", "ss",
+	"æ", "ae", "Æ", "Ae", "ø", "o", "Ø", "O", "å", "a", "Å", "A", "œ", "oe", "Œ", "Oe",
+	"à", "a", "á", "a", "â", "a", "ã", "a", "À", "A", "Á", "A", "Â", "A", "Ã", "A",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "È", "E", "É", "E", "Ê", "E", "Ë", "E",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "Ì", "I", "Í", "I", "Î", "I", "Ï", "I",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "Ò", "O", "Ó", "O", "Ô", "O", "Õ", "O",
+	"ù", "u", "ú", "u", "û", "u", "Ù", "U", "Ú", "U", "Û", "U",
+	"ç", "c", "Ç", "C", "ñ", "n", "Ñ", "N", "ý", "y", "ÿ", "y", "Ý", "Y",
+	"č", "c", "Č", "C", "š", "s", "Š", "S", "ž", "z", "Ž", "Z", "ł", "l", "Ł", "L",
+)
+
+// setupNameRules parses -name-rules: comma-separated steps of spaces=X
+// (runs of white space become X), ascii, strip (only ASCII letters, digits,
+// '-', '_' and '.' are kept), lower and max=N (at most N bytes).
+func setupNameRules() error {
+	if *nameRulesFl == "" {
+		return nil
+	}
+	for _, r := range strings.Split(*nameRulesFl, ",") {
+		k, v, hasV := strings.Cut(strings.TrimSpace(r), "=")
+		switch {
+		case k == "spaces" && hasV:
+			nameRules = append(nameRules, func(s string) string { return strings.Join(strings.Fields(s), v) })
+		case k == "ascii" && !hasV:
+			nameRules = append(nameRules, func(s string) string {
+				return strings.Map(func(r rune) rune {
+					if r > unicode.MaxASCII {
+						return -1
+					}
+					return r
+				}, asciiFold.Replace(s))
+			})
+		case k == "strip" && !hasV:
+			nameRules = append(nameRules, func(s string) string {
+				return strings.Map(func(r rune) rune {
+					if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) || strings.ContainsRune("-_.", r) {
+						return r
+					}
+					return -1
+				}, s)
+			})
+		case k == "lower" && !hasV:
+			nameRules = append(nameRules, strings.ToLower)
+		case k == "max" && hasV:
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("max=%s: want a length of at least 1", v)
+			}
+			nameRules = append(nameRules, func(s string) string {
+				for len(s) > n {
+					_, size := utf8.DecodeLastRuneInString(s)
+					s = s[:len(s)-size]
+				}
+				return s
+			})
+		default:
+			return fmt.Errorf("unknown rule %q (have spaces=X, ascii, strip, lower and max=N)", r)
+		}
+	}
+	return nil
+}
+
+// normalizeName applies -name-rules to each element of the VM name s,
+// e.g. "esx1/Web Server 03" to "esx1/web-server-03".
+func normalizeName(s string) string {
+	if len(nameRules) == 0 {
+		return s
+	}
+	el := strings.Split(strings.TrimSpace(s), "/")
+	for i := range el {
+		for _, r := range nameRules {
+			el[i] = r(el[i])
+		}
+	}
+	return strings.Join(el, "/")
+}
+
+// normalizeVMs returns the selected VMs vms under their normalized names,
+// logging each that changes, as a preview of the directories and HC3
+// names the run will use. Names that would collide are an error.
+func normalizeVMs(vms []string) ([]string, error) {
+	if len(nameRules) == 0 {
+		return vms, nil
+	}
+	var out []string
+	from := map[string]string{}
+	for _, vm := range vms {
+		vm = strings.TrimSpace(vm)
+		n := normalizeName(vm)
+		if prev, dup := from[n]; dup && prev != vm {
+			return nil, fmt.Errorf("%q and %q are both named %q by -name-rules", prev, vm, n)
+		}
+		from[n] = vm
+		if n != vm {
+			slog.Info("✎ VM name normalized", "from", vm, "to", n)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// checkVMNames fails if a selected VM's name cannot be a directory on
+// every staging backend and in an SMB URI: empty elements, . or ..,
+// control characters, \ : * ? " < > |, a trailing space or dot, or more
+// than 255 bytes.
+func checkVMNames(vms []string) error {
+	var bad []string
+	for _, vm := range vms {
+		vm = strings.TrimSpace(vm)
+		why := ""
+		if !utf8.ValidString(vm) {
+			why = "not valid UTF-8"
+		}
+		for _, el := range strings.Split(vm, "/") {
+			switch {
+			case why != "":
+			case el == "" || el == "." || el == "..":
+				why = fmt.Sprintf("has an element %q", el)
+			case len(el) > 255:
+				why = "has an element longer than 255 bytes"
+			case strings.IndexFunc(el, unicode.IsControl) >= 0:
+				why = "has a control character"
+			case strings.ContainsAny(el, `\:*?"<>|`):
+				why = fmt.Sprintf("has one of \\ : * ? \" < > | in %q", el)
+			case strings.HasSuffix(el, " ") || strings.HasSuffix(el, "."):
+				why = fmt.Sprintf("has %q ending in a space or dot", el)
+			}
+		}
+		if why != "" {
+			bad = append(bad, fmt.Sprintf("%q %s", vm, why))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s – rename them or set -name-rules, e.g. spaces=-,ascii,strip", strings.Join(bad, "; "))
+	}
+	return nil
+}
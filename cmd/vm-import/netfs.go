@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+)
+
+/*--------- network-backed directories ---------*/
+
+// netCopyBuffer is the buffer size for writes to network-backed staging:
+// fewer, larger writes than io.Copy's 32 KiB make better use of NFS and
+// SMB round trips.
+const netCopyBuffer = 1 << 20
+
+// netCopies is how many copies run at once into network-backed staging
+// when -max-copies is not set; more mostly contend for the same link.
+const netCopies = 2
+
+// checkNetworkDirs detects a local -ovadir or -scaledir that is really a
+// network mount (NFS, CIFS/SMB, UNC paths on Windows, …), says so, and
+// tunes the transfer for it: larger write buffers and, unless -max-copies
+// is given, a cap on concurrent copies into the staging directory.
+func checkNetworkDirs() {
+	if l, ok := ova.(localSource); ok {
+		if fs, err := networkFS(l.root); err != nil {
+			slog.Debug("checking OVA dir filesystem", "dir", l.root, "err", err)
+		} else if fs != "" {
+			slog.Info("OVA dir is on a network filesystem – reads are bound by the network, not the local disk", "dir", l.root, "fs", fs)
+		}
+	}
+	l, ok := stage.(localStager)
+	if !ok {
+		return
+	}
+	fs, err := networkFS(l.root)
+	if err != nil {
+		slog.Debug("checking staging dir filesystem", "dir", l.root, "err", err)
+		return
+	}
+	if fs == "" {
+		return
+	}
+	l.netFS = fs
+	stage = l
+	slog.Warn("staging dir is on a network filesystem – expect network, not local disk, speeds", "dir", l.root, "fs", fs)
+	if *delta {
+		slog.Warn("-delta reads every staged image back over the network to compare it", "fs", fs)
+	}
+	if *maxCopy == 0 && *parallel > netCopies {
+		copySlots = newLimiter(netCopies)
+		slog.Info("limiting concurrent copies into network staging", "copies", netCopies, "override", "-max-copies")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// networkFS names the network filesystem dir is on, from the statfs magic
+// number; "" for local filesystems.
+func networkFS(dir string) (string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return "", err
+	}
+	switch uint32(st.Type) {
+	case 0x6969:
+		return "nfs", nil
+	case 0xFF534D42, 0xFE534D42, 0x517B:
+		return "cifs", nil
+	case 0x65735546:
+		return "fuse", nil // sshfs, s3fs and friends, or a local FUSE filesystem
+	case 0x00C36400:
+		return "ceph", nil
+	case 0x01021997:
+		return "9p", nil
+	case 0x5346414F:
+		return "afs", nil
+	}
+	return "", nil
+}
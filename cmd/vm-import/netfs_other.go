@@ -0,0 +1,6 @@
+//go:build !linux && !windows
+
+package main
+
+// networkFS cannot tell network filesystems apart on this platform.
+func networkFS(dir string) (string, error) { return "", nil }
@@ -0,0 +1,35 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var getDriveType = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDriveTypeW")
+
+const driveRemote = 4
+
+// networkFS reports "smb" for UNC paths and mapped network drives, ""
+// for local drives.
+func networkFS(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	vol := filepath.VolumeName(abs)
+	if strings.HasPrefix(vol, `\\`) {
+		return "smb", nil
+	}
+	p, err := syscall.UTF16PtrFromString(vol + `\`)
+	if err != nil {
+		return "", err
+	}
+	if r, _, _ := getDriveType.Call(uintptr(unsafe.Pointer(p))); r == driveRemote {
+		return "smb", nil
+	}
+	return "", nil
+}
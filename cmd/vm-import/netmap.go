@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+	"gcosmiclentil89/ScaleVMFromOVA/scalexml"
+)
+
+/*--------- network mapping ---------*/
+
+// netMap is the -network-map file: how the source VM's NICs are set up on
+// HC3, by the name of the network they were connected to.
+//
+//	{"networks": {"VM Network": {"vlan": 10, "model": "virtio", "mac": "keep", "address": "keep",
+//	                             "gateway": "10.0.5.1", "dns": ["10.0.0.53"]},
+//	              "DMZ": {"vlan": 20, "address": "dhcp"},
+//	              "*": {"vlan": 0}},
+//	 "models": {"vmxnet3": "virtio", "e1000": "e1000"}}
+//
+// The address settings are for -net-recipe, which sets the NICs up inside
+// the guest.
+type netMap struct {
+	Networks map[string]netRule `json:"networks"` // "*" matches any other network
+	Models   map[string]string  `json:"models"`   // source adapter type → HC3 NIC model
+}
+
+type netRule struct {
+	VLAN  *int   `json:"vlan,omitempty"`  // 802.1Q tag; 0 for untagged
+	Model string `json:"model,omitempty"` // overrides models
+	MAC   string `json:"mac,omitempty"`   // keep: the source NIC's MAC address
+
+	Address string   `json:"address,omitempty"` // keep the source's address, or dhcp
+	Prefix  int      `json:"prefix,omitempty"`  // of kept addresses; default 24
+	Gateway string   `json:"gateway,omitempty"`
+	DNS     []string `json:"dns,omitempty"`
+}
+
+// nets is the loaded -network-map, or nil.
+var nets *netMap
+
+func loadNetMap(p string) (*netMap, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var m netMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+	for name, r := range m.Networks {
+		if r.VLAN != nil && (*r.VLAN < 0 || *r.VLAN > 4094) {
+			return nil, fmt.Errorf("%s: network %q: VLAN %d out of range 0-4094", p, name, *r.VLAN)
+		}
+		if r.MAC != "" && r.MAC != "keep" {
+			return nil, fmt.Errorf("%s: network %q: mac must be keep, not %q", p, name, r.MAC)
+		}
+		switch r.Address {
+		case "", "keep", "dhcp":
+		default:
+			return nil, fmt.Errorf("%s: network %q: address must be keep or dhcp, not %q", p, name, r.Address)
+		}
+		if r.Prefix < 0 || r.Prefix > 32 {
+			return nil, fmt.Errorf("%s: network %q: prefix %d out of range 0-32", p, name, r.Prefix)
+		}
+		for _, a := range append([]string{r.Gateway}, r.DNS...) {
+			if a != "" && net.ParseIP(a) == nil {
+				return nil, fmt.Errorf("%s: network %q: %q is not an IP address", p, name, a)
+			}
+		}
+	}
+	return &m, nil
+}
+
+// rule returns what to do for a NIC of adapter type model connected to
+// network; ok is false when the map says nothing about it.
+func (m *netMap) rule(network, model string) (r netRule, ok bool) {
+	r, ok = m.Networks[network]
+	if !ok {
+		r, ok = m.Networks["*"]
+	}
+	if r.Model == "" {
+		for k, v := range m.Models {
+			if strings.EqualFold(k, model) {
+				r.Model, ok = v, true
+			}
+		}
+	}
+	return r, ok
+}
+
+// setNICs applies the network map to the Scale XML's <interface>s, which
+// are paired with the OVF's NICs in order: the VLAN tag goes into
+// <vlan><tag id>, the model into <model type>, a kept MAC address into
+// <mac address>.
+func setNICs(doc *scalexml.Node, vm string) error {
+	if nets == nil {
+		return nil
+	}
+	env, err := readOVF(vm)
+	if err != nil {
+		return err
+	}
+	lg := vmLog(vm)
+	if env == nil {
+		lg.Warn("-network-map needs an OVF – leaving the NICs as they are")
+		return nil
+	}
+	nics := ovfNICs(env)
+	ifaces := doc.FindAll("interface")
+	if len(nics) != len(ifaces) {
+		lg.Warn("NIC count mismatch – mapping the first ones", "source", len(nics), "scale", len(ifaces))
+	}
+	for i := 0; i < min(len(nics), len(ifaces)); i++ {
+		nic, ifc := nics[i], ifaces[i]
+		r, ok := nets.rule(nic.Connection, nic.ResourceSubType)
+		if !ok {
+			lg.Debug("no network mapping for NIC", "nic", i+1, "network", nic.Connection, "type", nic.ResourceSubType)
+			continue
+		}
+		if r.VLAN != nil {
+			setVLAN(ifc, *r.VLAN)
+		}
+		if r.Model != "" {
+			m := ifc.Child("model")
+			if m == nil {
+				m = ifc.Add("model")
+			}
+			m.SetAttr("type", r.Model)
+		}
+		if r.MAC == "keep" {
+			if mac, err := net.ParseMAC(strings.TrimSpace(nic.Address)); err == nil {
+				m := ifc.Child("mac")
+				if m == nil {
+					m = ifc.Add("mac")
+				}
+				m.SetAttr("address", mac.String())
+			} else {
+				lg.Warn("no MAC address in the OVF to keep", "nic", i+1, "network", nic.Connection)
+			}
+		}
+		if *netRecipe != "" && r.Address != "" {
+			// the recipe finds the NIC in the guest by its MAC
+			if m := ifc.Child("mac"); m == nil {
+				ifc.Add("mac").SetAttr("address", newMAC())
+			} else if m.Attr("address") == "" {
+				m.SetAttr("address", newMAC())
+			}
+		}
+		lg.Info("✎ NIC", "nic", i+1, "network", nic.Connection, "vlan", vlanString(r.VLAN), "model", r.Model)
+	}
+	return nil
+}
+
+// ovfNICs returns the network adapters of the OVF's hardware section.
+func ovfNICs(env *ovf.Envelope) []ovf.Item {
+	var nics []ovf.Item
+	for _, it := range env.Items {
+		if it.ResourceType == "10" {
+			nics = append(nics, it)
+		}
+	}
+	return nics
+}
+
+// addNICs makes sure the Scale XML has an <interface> for each of the
+// OVF's NICs, so the VM does not come up with fewer network connections.
+// With -add-nics the missing ones are added, modelled on the last
+// interface without its MAC, address, target or boot order, for setNICs
+// to give their VLANs and models; otherwise the shortfall is warned about.
+func addNICs(doc *scalexml.Node, vm string) error {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return err
+	}
+	nics, ifaces := ovfNICs(env), doc.FindAll("interface")
+	missing := len(nics) - len(ifaces)
+	if missing <= 0 {
+		return nil
+	}
+	lg := vmLog(vm)
+	if !*addNICsFlag {
+		lg.Warn("the source has more NICs than the Scale XML – use -add-nics to add them", "source", len(nics), "scale", len(ifaces))
+		return nil
+	}
+	if len(ifaces) == 0 {
+		return fmt.Errorf("no interface in the Scale XML to model the %d new NIC(s) on", missing)
+	}
+	after := ifaces[len(ifaces)-1]
+	for i := len(ifaces); i < len(nics); i++ {
+		ifc := ifaces[len(ifaces)-1].Clone()
+		for _, name := range []string{"mac", "address", "target", "boot"} {
+			for _, c := range ifc.FindAll(name) {
+				c.Parent().Remove(c)
+			}
+		}
+		after.Parent().InsertAfter(after, ifc)
+		after = ifc
+		lg.Info("✚ added NIC to Scale XML", "nic", i+1, "network", nics[i].Connection)
+	}
+	return nil
+}
+
+// setVLAN sets an interface's VLAN tag, dropping <vlan> for untagged (0).
+func setVLAN(ifc *scalexml.Node, id int) {
+	v := ifc.Child("vlan")
+	if id == 0 {
+		if v != nil {
+			ifc.Remove(v)
+		}
+		return
+	}
+	if v == nil {
+		v = ifc.Add("vlan")
+	}
+	tags := v.FindAll("tag")
+	for _, t := range tags[min(1, len(tags)):] { // trunks become a single tag
+		v.Remove(t)
+	}
+	if len(tags) == 0 {
+		v.Add("tag")
+	}
+	v.Child("tag").SetAttr("id", strconv.Itoa(id))
+}
+
+func vlanString(v *int) string {
+	switch {
+	case v == nil:
+		return "unchanged"
+	case *v == 0:
+		return "untagged"
+	}
+	return strconv.Itoa(*v)
+}
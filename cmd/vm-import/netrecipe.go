@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+/*--------- guest network recipes ---------*/
+
+// netRecipes are the built-in ways of setting up the guest's NICs on
+// first boot: netplan and ifcfg files written by cloud-init, and netsh
+// commands run by Windows setup from the unattend ISO.
+var netRecipes = map[string]string{
+	"netplan": "cloud-init",
+	"ifcfg":   "cloud-init",
+	"netsh":   "unattend",
+}
+
+// guestNIC is how a NIC is to be set up inside the guest, found by its
+// MAC address.
+type guestNIC struct {
+	mac     string
+	dhcp    bool
+	addr    *net.IPNet // when static
+	gateway string
+	dns     []string
+}
+
+// newMAC returns a random MAC address in Scale's range, for the NICs a
+// recipe needs to find in the guest.
+func newMAC() string {
+	var b [3]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("7c:4c:58:%02x:%02x:%02x", b[0], b[1], b[2])
+}
+
+// guestNICs pairs the NICs of vm's OVF with the <interface>s of the
+// staged Scale XML of its target t, as setNICs does, and returns those
+// the network map gives an address: "dhcp", or "keep" for the source's.
+// The source addresses are those the OVF records (see sourceNetwork),
+// taken to belong to the NICs in order.
+func guestNICs(vm, t string) ([]guestNIC, error) {
+	if *netRecipe == "" || nets == nil {
+		return nil, nil
+	}
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return nil, err
+	}
+	doc, err := readScaleXML(xmlPath(t))
+	if err != nil {
+		return nil, err
+	}
+	ips := env.GuestNetwork().IPs
+	lg := vmLog(vm)
+	var out []guestNIC
+	ifaces := doc.FindAll("interface")
+	i := 0
+	for _, it := range env.Items {
+		if it.ResourceType != "10" {
+			continue
+		}
+		if i++; i > len(ifaces) {
+			break
+		}
+		r, ok := nets.rule(it.Connection, it.ResourceSubType)
+		if !ok || r.Address == "" {
+			continue
+		}
+		n := guestNIC{gateway: r.Gateway, dns: r.DNS}
+		if m := ifaces[i-1].Child("mac"); m != nil {
+			n.mac = m.Attr("address")
+		}
+		if n.mac == "" {
+			lg.Warn("no MAC address in the Scale XML – NIC left to the guest", "nic", i)
+			continue
+		}
+		switch r.Address {
+		case "dhcp":
+			n.dhcp = true
+		case "keep":
+			if i > len(ips) {
+				lg.Warn("the OVF records no address for NIC – left to the guest", "nic", i, "network", it.Connection)
+				continue
+			}
+			prefix := r.Prefix
+			if prefix == 0 {
+				prefix = 24
+			}
+			_, cidr, err := net.ParseCIDR(ips[i-1] + "/" + strconv.Itoa(prefix))
+			if err != nil {
+				return nil, err
+			}
+			cidr.IP = net.ParseIP(ips[i-1])
+			n.addr = cidr
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// netplanConfig renders nics as a netplan file.
+func netplanConfig(nics []guestNIC) string {
+	var b strings.Builder
+	b.WriteString("network:\n  version: 2\n  ethernets:\n")
+	for i, n := range nics {
+		fmt.Fprintf(&b, "    vm-import%d:\n      match:\n        macaddress: %q\n", i+1, n.mac)
+		if n.dhcp {
+			b.WriteString("      dhcp4: true\n")
+			continue
+		}
+		fmt.Fprintf(&b, "      dhcp4: false\n      addresses: [%q]\n", n.addr)
+		if n.gateway != "" {
+			fmt.Fprintf(&b, "      routes:\n        - to: default\n          via: %s\n", n.gateway)
+		}
+		if len(n.dns) > 0 {
+			fmt.Fprintf(&b, "      nameservers:\n        addresses: [%s]\n", strings.Join(n.dns, ", "))
+		}
+	}
+	return b.String()
+}
+
+// ifcfgFile renders nic as a Red Hat style ifcfg file, which
+// NetworkManager matches to the device by HWADDR.
+func ifcfgFile(nic guestNIC, name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "NAME=%s\nTYPE=Ethernet\nHWADDR=%s\nONBOOT=yes\n", name, nic.mac)
+	if nic.dhcp {
+		b.WriteString("BOOTPROTO=dhcp\n")
+		return b.String()
+	}
+	ones, _ := nic.addr.Mask.Size()
+	fmt.Fprintf(&b, "BOOTPROTO=none\nIPADDR=%s\nPREFIX=%d\n", nic.addr.IP, ones)
+	if nic.gateway != "" {
+		fmt.Fprintf(&b, "GATEWAY=%s\n", nic.gateway)
+	}
+	for i, d := range nic.dns {
+		fmt.Fprintf(&b, "DNS%d=%s\n", i+1, d)
+	}
+	return b.String()
+}
+
+// netCloudConfig renders the netplan or ifcfg recipe for nics as
+// cloud-config that writes the files and applies them.
+func netCloudConfig(nics []guestNIC) string {
+	var b strings.Builder
+	b.WriteString("#cloud-config\nwrite_files:\n")
+	file := func(path, content string) {
+		fmt.Fprintf(&b, "  - path: %s\n    permissions: \"0600\"\n    content: |\n", path)
+		for _, l := range strings.SplitAfter(strings.TrimSuffix(content, "\n"), "\n") {
+			b.WriteString("      " + l)
+		}
+		b.WriteString("\n")
+	}
+	// the recipe replaces cloud-init's own DHCP fallback from now on
+	file("/etc/cloud/cloud.cfg.d/99-vm-import-network.cfg", "network: {config: disabled}\n")
+	switch *netRecipe {
+	case "netplan":
+		file("/etc/netplan/90-vm-import.yaml", netplanConfig(nics))
+		b.WriteString("runcmd:\n  - [netplan, apply]\n")
+	case "ifcfg":
+		for i, n := range nics {
+			name := fmt.Sprintf("vm-import%d", i+1)
+			file("/etc/sysconfig/network-scripts/ifcfg-"+name, ifcfgFile(n, name))
+		}
+		b.WriteString("runcmd:\n  - [sh, -c, \"nmcli connection reload && nmcli networking off && nmcli networking on || systemctl restart network\"]\n")
+	}
+	return b.String()
+}
+
+// netshCommands renders nics as RunSynchronousCommand elements for the
+// specialize pass of an unattend.xml: each finds its adapter by MAC and
+// sets it up with netsh.
+func netshCommands(nics []guestNIC) string {
+	var b bytes.Buffer
+	for i, n := range nics {
+		mac := strings.ToUpper(strings.ReplaceAll(n.mac, ":", "-"))
+		cmds := []string{fmt.Sprintf("$n=(Get-NetAdapter | ? MacAddress -eq '%s').Name", mac)}
+		if n.dhcp {
+			cmds = append(cmds, `netsh interface ipv4 set address name="$n" source=dhcp`)
+		} else {
+			gw := ""
+			if n.gateway != "" {
+				gw = " " + n.gateway
+			}
+			cmds = append(cmds, fmt.Sprintf(`netsh interface ipv4 set address name="$n" static %s %s%s`, n.addr.IP, net.IP(n.addr.Mask), gw))
+		}
+		for j, d := range n.dns {
+			if j == 0 {
+				cmds = append(cmds, fmt.Sprintf(`netsh interface ipv4 set dnsservers name="$n" static %s primary`, d))
+			} else {
+				cmds = append(cmds, fmt.Sprintf(`netsh interface ipv4 add dnsservers name="$n" %s index=%d`, d, j+1))
+			}
+		}
+		fmt.Fprintf(&b, "        <RunSynchronousCommand wcm:action=\"add\">\n          <Order>%d</Order>\n          <Description>vm-import NIC %d</Description>\n          <Path>", i+1, i+1)
+		xml.EscapeText(&b, []byte(`powershell -NoProfile -Command "`+strings.ReplaceAll(strings.Join(cmds, "; "), `"`, `\"`)+`"`))
+		b.WriteString("</Path>\n        </RunSynchronousCommand>\n")
+	}
+	return b.String()
+}
+
+// netshUnattend is the unattend.xml used for the netsh recipe without
+// -unattend: it only runs the {{netsh}} commands.
+const netshUnattend = `<?xml version="1.0" encoding="utf-8"?>
+<unattend xmlns="urn:schemas-microsoft-com:unattend" xmlns:wcm="http://schemas.microsoft.com/WMIConfig/2002/State">
+  <settings pass="specialize">
+    <component name="Microsoft-Windows-Deployment" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <RunSynchronous>
+{{netsh}}      </RunSynchronous>
+    </component>
+  </settings>
+</unattend>
+`
+
+// withUserData joins the user's cloud-init user-data and the recipe's
+// cloud-config into one multipart document, as cloud-init reads it.
+// Either may be empty.
+func withUserData(user []byte, recipe string) ([]byte, error) {
+	if recipe == "" {
+		return user, nil
+	}
+	if len(user) == 0 {
+		return []byte(recipe), nil
+	}
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n", w.Boundary())
+	for _, part := range [][]byte{user, []byte(recipe)} {
+		typ := "text/plain"
+		switch {
+		case bytes.HasPrefix(part, []byte("#cloud-config")):
+			typ = "text/cloud-config"
+		case bytes.HasPrefix(part, []byte("#!")):
+			typ = "text/x-shellscript"
+		}
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {typ}})
+		if err != nil {
+			return nil, err
+		}
+		pw.Write(part)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
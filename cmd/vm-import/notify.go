@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*--------- webhook notifications ---------*/
+
+// notification is what a -notify webhook is told about one VM ("vm") or
+// a finished batch ("batch"). Generic webhooks receive it as JSON; Slack
+// and Teams get its summary as message text.
+type notification struct {
+	Event       string        `json:"event"`
+	VM          string        `json:"vm,omitempty"`
+	Outcome     string        `json:"outcome"` // ok or failed
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"-"`
+	Seconds     float64       `json:"durationSeconds"`
+	TaskTag     string        `json:"taskTag,omitempty"`
+	CreatedUUID string        `json:"createdUUID,omitempty"`
+	Total       int           `json:"total,omitempty"`
+	Failed      int           `json:"failed,omitempty"`
+	Summary     string        `json:"summary"`
+
+	VMs []notification `json:"vms,omitempty"` // per-VM results of a batch
+}
+
+func runNotification(r *runRecord) notification {
+	n := notification{Event: "vm", VM: r.VM, Outcome: r.Outcome, Error: r.Error, Duration: r.Duration,
+		TaskTag: r.TaskTag, CreatedUUID: r.CreatedUUID}
+	n.Summary = summary(n)
+	n.Seconds = n.Duration.Round(time.Second).Seconds()
+	return n
+}
+
+func notifyBatch(total, failed int, d time.Duration, runs []*runRecord) {
+	n := notification{Event: "batch", Outcome: "ok", Duration: d, Total: total, Failed: failed}
+	if failed > 0 {
+		n.Outcome = "failed"
+	}
+	for _, r := range runs {
+		n.VMs = append(n.VMs, runNotification(r))
+	}
+	notify(n)
+}
+
+// notify posts n to every -notify URL and mails it when SMTP is set up,
+// honouring -notify-on. Delivery failures are logged and otherwise ignored.
+func notify(n notification) {
+	if *notifyOn == "failure" && n.Outcome != "failed" {
+		return
+	}
+	n.Summary = summary(n)
+	n.Seconds = n.Duration.Round(time.Second).Seconds()
+	for _, u := range notifyURLs {
+		if err := postNotification(u, n); err != nil {
+			slog.Warn("webhook notification failed", "url", redactURL(u), "err", err)
+		}
+	}
+	if *smtpAddr != "" && (n.Event == "batch" || *mailPerVM) {
+		if err := sendMail(n); err != nil {
+			slog.Warn("email notification failed", "smtp", *smtpAddr, "err", err)
+		}
+	}
+}
+
+func summary(n notification) string {
+	d := n.Duration.Round(time.Second)
+	if n.Event == "batch" {
+		if n.Failed > 0 {
+			return fmt.Sprintf("❌ vm-import batch finished: %d of %d VM(s) failed (%s)", n.Failed, n.Total, d)
+		}
+		return fmt.Sprintf("✅ vm-import batch finished: %d VM(s) ok (%s)", n.Total, d)
+	}
+	if n.Outcome == "failed" {
+		return fmt.Sprintf("❌ %s failed after %s: %s", n.VM, d, n.Error)
+	}
+	s := fmt.Sprintf("✅ %s staged in %s", n.VM, d)
+	if n.CreatedUUID != "" {
+		s += fmt.Sprintf(", import queued (task %s, UUID %s)", n.TaskTag, n.CreatedUUID)
+	}
+	return s
+}
+
+func postNotification(target string, n notification) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	var payload any = n
+	switch {
+	case u.Host == "hooks.slack.com":
+		payload = map[string]string{"text": n.Summary}
+	case strings.HasSuffix(u.Host, ".webhook.office.com") || strings.HasSuffix(u.Host, ".logic.azure.com"):
+		payload = map[string]string{"text": n.Summary}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Timeout: 15 * time.Second, Transport: debugRT(nil)}).Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// redactURL hides the secret path of a webhook URL in logs.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host + "/…"
+}
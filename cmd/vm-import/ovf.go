@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+)
+
+/*--------- OVF descriptor ---------*/
+
+// ovfChoice remembers the descriptor picked for each VM directory holding
+// several, so the operator is asked only once per run.
+var ovfChoice = struct {
+	sync.Mutex
+	byVM map[string]string
+}{byVM: map[string]string{}}
+
+// ovfFile returns vm's OVF descriptor, or "" for exports without one. A
+// directory with several .ovf files is ambiguous: the manifest entry's
+// "ovf" or -ovf-name picks one, else the operator is asked, and without a
+// terminal it is an error rather than an arbitrary pick.
+func ovfFile(vm string) (string, error) {
+	ovfs, err := ova.Glob(path.Join(vm, "*.ovf"))
+	if err != nil || len(ovfs) <= 1 {
+		if len(ovfs) == 1 {
+			return ovfs[0], err
+		}
+		return "", err
+	}
+	sort.Strings(ovfs)
+	sel := *ovfName
+	if v := plan.vm(vm); v != nil && v.OVF != "" {
+		sel = v.OVF
+	}
+	if sel != "" {
+		var match []string
+		for _, o := range ovfs {
+			if ok, _ := path.Match(sel, path.Base(o)); ok || path.Base(o) == sel+".ovf" {
+				match = append(match, o)
+			}
+		}
+		if len(match) != 1 {
+			return "", fmt.Errorf("%q matches %d of the descriptors in %s: %s", sel, len(match), vm, baseNames(ovfs))
+		}
+		return match[0], nil
+	}
+
+	ovfChoice.Lock()
+	defer ovfChoice.Unlock()
+	if o, ok := ovfChoice.byVM[vm]; ok {
+		return o, nil
+	}
+	if !interactive() {
+		return "", fmt.Errorf("%d descriptors in %s (%s); choose one with -ovf-name or the manifest's \"ovf\"", len(ovfs), vm, baseNames(ovfs))
+	}
+	promptMu.Lock()
+	o, err := promptOVF(vm, ovfs, stdin)
+	promptMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	vmLog(vm).Info("using OVF descriptor", "file", path.Base(o))
+	ovfChoice.byVM[vm] = o
+	return o, nil
+}
+
+func promptOVF(vm string, ovfs []string, in *bufio.Reader) (string, error) {
+	fmt.Fprintf(stdout{}, "\n%s holds %d OVF descriptors:\n", vm, len(ovfs))
+	for i, o := range ovfs {
+		fmt.Fprintf(stdout{}, "  %d) %s\n", i+1, path.Base(o))
+	}
+	for {
+		fmt.Fprintf(stdout{}, "Use which one? [1-%d]: ", len(ovfs))
+		line, err := in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", fmt.Errorf("no OVF descriptor chosen for %s", vm)
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(line)); err == nil && n >= 1 && n <= len(ovfs) {
+			return ovfs[n-1], nil
+		}
+	}
+}
+
+func baseNames(names []string) string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = path.Base(n)
+	}
+	return strings.Join(out, ", ")
+}
+
+// readOVF parses vm's OVF descriptor; it returns nil and no error when the
+// export has none (Hyper-V, XVA, Proxmox).
+func readOVF(vm string) (*ovf.Envelope, error) {
+	desc, err := ovfFile(vm)
+	if err != nil || desc == "" {
+		return nil, err
+	}
+	f, err := ova.Open(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env, err := ovf.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path.Base(desc), err)
+	}
+	return env, nil
+}
+
+// sourceNetwork returns what vm's OVF records of the guest's network
+// configuration – hostname, addresses, NICs – for the run history and
+// report, so the network team knows what to expect on HC3. It is nil when
+// there is no OVF or it records nothing.
+func sourceNetwork(vm string) (*ovf.GuestNetwork, error) {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return nil, err
+	}
+	n := env.GuestNetwork()
+	if n.Empty() {
+		return nil, nil
+	}
+	vmLog(vm).Info("🌐 source network", "net", describeNet(n))
+	return &n, nil
+}
+
+// sourcePassthrough returns the PCI and vGPU passthrough devices of vm's
+// OVF, warning about them: they do not exist on HC3, so the guest may
+// need reconfiguring. It is nil when there is no OVF or no such device.
+func sourcePassthrough(vm string) ([]ovf.Passthrough, error) {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return nil, err
+	}
+	devs := env.Passthrough()
+	for _, d := range devs {
+		vmLog(vm).Warn("passthrough device will not exist on HC3 – the guest may need reconfiguring", "device", d.String())
+	}
+	return devs, nil
+}
+
+// sourceClock returns how vm's OVF hints the guest keeps its hardware
+// clock, utc or localtime, for the run history and report; "" without an
+// OVF or a hint.
+func sourceClock(vm string) (string, error) {
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return "", err
+	}
+	return env.Clock(), nil
+}
+
+// describeNet sums up the guest network n on one line.
+func describeNet(n ovf.GuestNetwork) string {
+	var parts []string
+	if n.Hostname != "" {
+		parts = append(parts, "host "+n.Hostname)
+	}
+	if len(n.IPs) > 0 {
+		parts = append(parts, "IPs "+strings.Join(n.IPs, ", "))
+	}
+	for i, nic := range n.NICs {
+		s := fmt.Sprintf("NIC %d", i+1)
+		if nic.Network != "" {
+			s += " on " + nic.Network
+		}
+		if nic.MAC != "" {
+			s += " (" + nic.MAC + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ovfBootOrder reads the boot order from vm's OVF: VMware's bios.bootOrder
+// setting or BootOrderSections, or VirtualBox's <Boot> order. It returns
+// nil when the descriptor has none.
+func ovfBootOrder(vm string) ([]string, error) {
+	desc, err := ovfFile(vm)
+	if err != nil || desc == "" {
+		return nil, err
+	}
+	f, err := ova.Open(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	order, err := ovf.BootOrder(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path.Base(desc), err)
+	}
+	return order, nil
+}
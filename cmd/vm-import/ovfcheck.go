@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+)
+
+/*--------- strict OVF validation ---------*/
+
+// checkOVF is -strict-ovf: it checks vm's OVF descriptor for a sound
+// envelope, references and disk section – and, with -ovf-schema, has
+// xmllint validate it against the DMTF XSD – so a malformed export fails
+// up front instead of surfacing later as odd disk pairing.
+func checkOVF(vm string) error {
+	desc, err := ovfFile(vm)
+	if err != nil || desc == "" {
+		return err // not an OVF export
+	}
+	f, err := ova.Open(desc)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	name := path.Base(desc)
+	if err := ovf.Check(data); err != nil {
+		return fmt.Errorf("invalid OVF %s: %s", name, strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	if *ovfSchema != "" {
+		if err := xmllintSchema(data, *ovfSchema); err != nil {
+			return fmt.Errorf("OVF %s does not validate against %s: %w", name, *ovfSchema, err)
+		}
+	}
+	vmLog(vm).Debug("OVF descriptor valid", "file", name)
+	return nil
+}
+
+// xmllintSchema validates data against the XSD schema with xmllint.
+func xmllintSchema(data []byte, schema string) error {
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		return fmt.Errorf("-ovf-schema needs xmllint (libxml2-utils): %w", err)
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(runCtx, "xmllint", "--noout", "--schema", schema, "-")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		var lines []string
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if l != "" && !strings.HasSuffix(l, "fails to validate") {
+				lines = append(lines, strings.TrimPrefix(l, "-:"))
+			}
+		}
+		if len(lines) > 5 {
+			lines = append(lines[:5], fmt.Sprintf("… %d more", len(lines)-5))
+		}
+		return fmt.Errorf("%s", strings.Join(lines, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/*--------- ovftool orchestration ---------*/
+
+var reOVFToolProgress = regexp.MustCompile(`(?i)progress:\s*(\d+)%`)
+
+// exportWithOVFTool runs ovftool once per selected VM, exporting
+// <ovftool-source><vm> into <ovadir>/<vm>/<vm>.ovf ahead of discovery.
+func exportWithOVFTool(names []string) error {
+	if *ovftoolSrc == "" {
+		return nil
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("ovftool export needs -vms or -manifest")
+	}
+	if _, ok := ova.(localSource); !ok {
+		return fmt.Errorf("ovftool export needs a local -ovadir")
+	}
+	bin := *ovftoolBin
+	if !*dryRun {
+		var err error
+		if bin, err = exec.LookPath(bin); err != nil {
+			return fmt.Errorf("ovftool not found: %w", err)
+		}
+	}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		dir := normalizeName(n)
+		dst := filepath.Join(*ovaDir, dir, filepath.Base(dir)+".ovf")
+		args := append(strings.Fields(*ovftoolArgs), *ovftoolSrc+n, dst)
+		if *dryRun {
+			slog.Info("[dry-run] run ovftool", "cmd", bin+" "+strings.Join(redactArgs(args), " "))
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		slog.Info("⟳ ovftool export", "vm", n)
+		if err := runOVFTool(bin, n, args); err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+		slog.Info("✓ exported", "vm", n)
+	}
+	return nil
+}
+
+// runOVFTool executes ovftool and echoes its progress in 10 % steps.
+func runOVFTool(bin, vm string, args []string) error {
+	cmd := exec.CommandContext(runCtx, bin, args...)
+	cmd.Stdin = os.Stdin // ovftool may prompt for a password
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var tail []string
+	last := -10
+	sc := bufio.NewScanner(out)
+	sc.Split(scanCRLF)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if m := reOVFToolProgress.FindStringSubmatch(line); m != nil {
+			var pct int
+			fmt.Sscan(m[1], &pct)
+			if pct >= last+10 || pct == 100 && last != 100 {
+				slog.Info("ovftool progress", "vm", vm, "percent", pct)
+				last = pct
+			}
+			continue
+		}
+		if tail = append(tail, line); len(tail) > 5 {
+			tail = tail[1:]
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ovftool: %v: %s", err, strings.Join(tail, " | "))
+	}
+	return nil
+}
+
+// scanCRLF splits on \n or \r so ovftool's in-place progress updates
+// arrive as separate tokens.
+func scanCRLF(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// redactArgs hides passwords embedded in vi:// locators.
+func redactArgs(args []string) []string {
+	re := regexp.MustCompile(`(://[^:/@]+:)[^@]*@`)
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = re.ReplaceAllString(a, "${1}***@")
+	}
+	return out
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gcosmiclentil89/ScaleVMFromOVA/ovf"
+)
+
+/*--------- automatic disk pairing ---------*/
+
+// diskInfo is what pairing knows about a disk on either side.
+type diskInfo struct {
+	capacity int64  // virtual size in bytes; 0 when unknown
+	slot     string // sortable controller/slot position; "" when unknown
+}
+
+type confidence int
+
+const (
+	confLow confidence = iota
+	confMedium
+	confHigh
+)
+
+func (c confidence) String() string { return [...]string{"low", "medium", "high"}[c] }
+
+// sizeConfidence rates how well two capacities agree; unknown sizes say
+// nothing either way.
+func sizeConfidence(a, b int64) confidence {
+	if a <= 0 || b <= 0 {
+		return confMedium
+	}
+	switch d := math.Abs(float64(a-b)) / float64(max(a, b)); {
+	case d <= 0.01:
+		return confHigh
+	case d <= 0.10:
+		return confMedium
+	}
+	return confLow
+}
+
+// pairDisks proposes, for each source disk, the index of the Scale disk it
+// goes to (-1 for none) using -pairing, and rates the proposal: the worst
+// size agreement of its pairs, lowered when the strategy lacked the data
+// it needs or the counts differ.
+func pairDisks(vm string, srcFiles, uuids []string) ([]int, confidence) {
+	src := sourceDiskInfo(vm, srcFiles)
+	dst := scaleDiskInfo(vm, uuids)
+	prop := make([]int, len(srcFiles))
+	for i := range prop {
+		prop[i] = -1
+		if i < len(uuids) {
+			prop[i] = i
+		}
+	}
+	conf := confHigh
+	if len(srcFiles) != len(uuids) {
+		conf = confLow
+	}
+	switch *pairing {
+	case "size":
+		if p, ok := pairBySize(src, dst); ok {
+			prop = p
+		} else {
+			vmLog(vm).Warn("pairing by size needs every disk's capacity – pairing by position")
+			conf = confLow
+		}
+	case "slot":
+		if p, ok := pairBySlot(src, dst); ok {
+			prop = p
+		} else {
+			vmLog(vm).Warn("pairing by slot needs every disk's controller slot – pairing by position")
+			conf = confLow
+		}
+	}
+	for i, j := range prop {
+		if j >= 0 {
+			if c := sizeConfidence(src[i].capacity, dst[j].capacity); c < conf {
+				conf = c
+			}
+		}
+	}
+	return prop, conf
+}
+
+// pairBySize finds the pairing whose capacities differ least overall,
+// preferring positional pairs among equally good ones. It tries every
+// pairing, so it gives up beyond 8 disks a side.
+func pairBySize(src, dst []diskInfo) ([]int, bool) {
+	if len(src) > 8 || len(dst) > 8 {
+		return nil, false
+	}
+	for _, d := range append(append([]diskInfo(nil), src...), dst...) {
+		if d.capacity <= 0 {
+			return nil, false
+		}
+	}
+	pairs := min(len(src), len(dst))
+	best, bestCost := []int(nil), math.Inf(1)
+	cur, used := make([]int, len(src)), make([]bool, len(dst))
+	var try func(i, paired int, cost float64)
+	try = func(i, paired int, cost float64) {
+		if cost >= bestCost {
+			return
+		}
+		if i == len(src) {
+			if paired == pairs {
+				best, bestCost = append([]int(nil), cur...), cost
+			}
+			return
+		}
+		for j := range dst {
+			if used[j] {
+				continue
+			}
+			c := math.Abs(float64(src[i].capacity-dst[j].capacity)) / float64(max(src[i].capacity, dst[j].capacity))
+			if i != j {
+				c += 1e-9 // tie-break towards position
+			}
+			used[j], cur[i] = true, j
+			try(i+1, paired+1, cost+c)
+			used[j] = false
+		}
+		if len(src)-i > pairs-paired { // room to leave this one out
+			cur[i] = -1
+			try(i+1, paired, cost)
+		}
+	}
+	try(0, 0, 0)
+	return best, best != nil
+}
+
+// pairBySlot pairs the disks in controller/slot order on both sides.
+func pairBySlot(src, dst []diskInfo) ([]int, bool) {
+	order := func(ds []diskInfo) ([]int, bool) {
+		idx := make([]int, len(ds))
+		for i, d := range ds {
+			if d.slot == "" {
+				return nil, false
+			}
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool { return ds[idx[a]].slot < ds[idx[b]].slot })
+		return idx, true
+	}
+	so, ok1 := order(src)
+	do, ok2 := order(dst)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	prop := make([]int, len(src))
+	for r, i := range so {
+		prop[i] = -1
+		if r < len(do) {
+			prop[i] = do[r]
+		}
+	}
+	return prop, true
+}
+
+// sourceDiskInfo reads capacities and controller slots of an OVF's disks;
+// other sources only give their image size, which is the capacity for raw
+// and qcow2 images and a lower bound otherwise, so it is left out.
+func sourceDiskInfo(vm string, srcFiles []string) []diskInfo {
+	out := make([]diskInfo, len(srcFiles))
+	env, err := readOVF(vm)
+	if env == nil {
+		if err != nil {
+			vmLog(vm).Debug("reading OVF disk details", "err", err)
+		}
+		return out
+	}
+	href := map[string]string{}
+	for _, fl := range env.Files {
+		href[fl.ID], _ = hrefFile(vm, fl.Href) // as diskFilesFromOVF lists it
+	}
+	at := map[string]int{} // href → index in srcFiles
+	for i, s := range srcFiles {
+		at[s] = i
+	}
+	byDisk := map[string]int{} // diskId → index in srcFiles
+	for _, d := range env.Disks {
+		i, ok := at[href[d.FileRef]]
+		if !ok {
+			continue
+		}
+		byDisk[d.ID] = i
+		if c, err := strconv.ParseInt(d.Capacity, 10, 64); err == nil {
+			out[i].capacity = c * ovf.AllocationUnits(d.Units)
+		}
+	}
+	items := env.AllItems()
+	ctrl := map[string]ovf.Item{}
+	for _, it := range items {
+		ctrl[it.InstanceID] = it
+	}
+	for _, it := range items {
+		if it.ResourceType != "17" {
+			continue
+		}
+		i, ok := byDisk[path.Base(it.HostResource)] // ovf:/disk/vmdisk1
+		if !ok {
+			continue
+		}
+		c, ok := ctrl[it.Parent]
+		if !ok {
+			continue
+		}
+		// IDE (5) before SCSI (6) before SATA (20), as firmware usually boots
+		out[i].slot = fmt.Sprintf("%s/%4s/%4s/%4s", controllerRank(c.ResourceType), c.Address, c.InstanceID, it.AddressOnParent)
+	}
+	return out
+}
+
+func controllerRank(rt string) string {
+	switch rt {
+	case "5":
+		return "1"
+	case "6":
+		return "2"
+	case "20":
+		return "3"
+	}
+	return "9"
+}
+
+var reDev = regexp.MustCompile(`^([a-z]+?)d([a-z]+)$`)
+
+// scaleDiskInfo reads the Scale disks' slots from their <target dev> and
+// capacities from a <capacity> in the disk element or, when staging
+// locally, the virtual size in the header of the dummy VM's qcow2 image.
+func scaleDiskInfo(vm string, uuids []string) []diskInfo {
+	out := make([]diskInfo, len(uuids))
+	doc, err := readScaleXML(xmlPath(vm))
+	if err != nil {
+		return out
+	}
+	at := map[string]int{}
+	for i, u := range uuids {
+		at[u] = i
+	}
+	for _, d := range doc.FindAll("disk") {
+		s := d.Find("source")
+		if s == nil {
+			continue
+		}
+		i, ok := at[path.Base(s.Attr("name"))]
+		if !ok {
+			continue
+		}
+		if t := d.Find("target"); t != nil {
+			// vda, vdb, …, vdaa: order by bus, then length, then name
+			if m := reDev.FindStringSubmatch(t.Attr("dev")); m != nil {
+				out[i].slot = fmt.Sprintf("%s/%02d/%s", t.Attr("bus"), len(m[2]), m[2])
+			}
+		}
+		if c := d.Find("capacity"); c != nil {
+			out[i].capacity, _ = strconv.ParseInt(c.InnerText(), 10, 64)
+		}
+		if out[i].capacity == 0 {
+			out[i].capacity = qcow2Size(path.Join(vm, uuids[i]+".qcow2"))
+		}
+	}
+	return out
+}
+
+// qcow2Size returns the virtual size recorded in a staged qcow2 image's
+// header, or 0 when it cannot be read.
+func qcow2Size(name string) int64 {
+	ls, ok := stage.(localStager)
+	if !ok {
+		return 0
+	}
+	f, err := os.Open(ls.path(name))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	var h [32]byte
+	if _, err := f.ReadAt(h[:], 0); err != nil || string(h[:4]) != "QFI\xfb" {
+		return 0
+	}
+	var n int64
+	for _, b := range h[24:32] {
+		n = n<<8 | int64(b)
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+/*--------- atomic staging writes ---------*/
+
+// partMark is in the name of every file being staged,
+// .<name>.part-<pid>-<random>, which sits next to the file it becomes and
+// never matches *.qcow2.
+const partMark = ".part-"
+
+// partName returns a temporary name for staging name, unique to this
+// process and call.
+func partName(name string) string {
+	return path.Join(path.Dir(name), fmt.Sprintf(".%s%s%d-%s", path.Base(name), partMark, os.Getpid(), newUUID()[:8]))
+}
+
+// putStaged writes r to the staged file name under a partName, synced to
+// disk by the local backend, and renames it into place once complete, so
+// a crash or failed copy never leaves a truncated image under name for
+// the next run to take for a good one.
+func putStaged(name string, r io.Reader) error {
+	tmp := partName(name)
+	err := stage.Put(tmp, r)
+	if err == nil {
+		err = stage.Rename(tmp, name)
+	}
+	if err != nil && stage.Exists(tmp) {
+		stage.Remove(tmp)
+	}
+	return err
+}
+
+// removeStaleParts removes the partly staged files of runs that stopped
+// before they could clean up from the VM directory dir. It runs under the
+// VM's lock, so no other run is writing them.
+func removeStaleParts(dir string) error {
+	parts, err := stage.Glob(path.Join(dir, ".*"+partMark+"*"))
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if *dryRun {
+			vmLog(dir).Info("[dry-run] remove partial file", "file", path.Base(p))
+			continue
+		}
+		if err := stage.Remove(p); err != nil {
+			return err
+		}
+		vmLog(dir).Warn("removed a partial file left by an interrupted run", "file", path.Base(p))
+	}
+	return nil
+}
+
+// syncFile flushes the file name, written by another program, to disk.
+func syncFile(name string) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
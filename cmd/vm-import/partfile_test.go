@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3/hc3test"
+)
+
+func TestStalePartsRemoved(t *testing.T) {
+	for _, args := range [][]string{nil, {"-keep-existing"}} {
+		srv := hc3test.NewServer(hc3test.WithCredentials("admin", "secret"))
+		dir := t.TempDir()
+		testExport(t, dir, "vm1")
+		part := filepath.Join(dir, "scale", "vm1", ".22222222-2222-2222-2222-222222222222.qcow2"+partMark+"1-deadbeef")
+		if err := os.WriteFile(part, []byte("partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if code, out := runTool(t, dir, srv, append([]string{"-vms", "vm1"}, args...)...); code != 0 {
+			t.Fatalf("%v: exit status %d:\n%s", args, code, out)
+		}
+		if _, err := os.Stat(part); !os.IsNotExist(err) {
+			t.Errorf("%v: the partial file is still there: %v", args, err)
+		}
+		srv.Close()
+	}
+}
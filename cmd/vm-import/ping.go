@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+	"gcosmiclentil89/ScaleVMFromOVA/smb"
+)
+
+/*--------- ping: check endpoints and credentials ---------*/
+
+// runPing implements "vm-import ping [flags]": it takes the flags of a
+// run, and for every target cluster walks the steps a migration needs –
+// resolving and reaching the API, its certificate, logging in, then the
+// share HC3 reads from – printing each result, so a new profile can be
+// checked without staging anything. The first failing step of a cluster
+// says which setting is wrong; the steps after it are skipped.
+func runPing(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if err := setupContainer(); err != nil {
+		return fmt.Errorf("reading configuration from the environment: %w", err)
+	}
+	if err := setupLogging(); err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	defer startDemo()()
+	if *manifestPath != "" {
+		var err error
+		if plan, err = loadManifest(*manifestPath); err != nil {
+			return fmt.Errorf("loading manifest: %w", err)
+		}
+	}
+	if err := setupClusters(); err != nil {
+		return fmt.Errorf("setting up target clusters: %w", err)
+	}
+
+	failed := 0
+	for _, cl := range allClusters() {
+		fmt.Fprintf(stdout{}, "cluster %s (%s)\n", cl.label(), cl.api())
+		for _, c := range pingChecks(cl) {
+			err := c.run()
+			switch {
+			case errors.Is(err, errSkipped):
+				fmt.Fprintf(stdout{}, "  -  %s: %v\n", c.name, err)
+			case err != nil:
+				fmt.Fprintf(stdout{}, "  ❌ %s: %v\n", c.name, err)
+				failed++
+			default:
+				fmt.Fprintf(stdout{}, "  ✅ %s\n", c.name)
+			}
+			if err != nil && !errors.Is(err, errSkipped) {
+				break
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// errSkipped marks a check that could not be run, which is not a failure.
+var errSkipped = errors.New("skipped")
+
+type pingCheck struct {
+	name string
+	run  func() error
+}
+
+// pingChecks lists the checks for cluster cl in the order they depend on
+// each other.
+func pingChecks(cl *cluster) []pingCheck {
+	u, _ := url.Parse(cl.api()) // validated by setupClusters
+	addr := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	checks := []pingCheck{
+		{"API host " + u.Hostname() + " resolves", func() error {
+			_, err := net.DefaultResolver.LookupHost(runCtx, u.Hostname())
+			if err != nil {
+				return fmt.Errorf("%w (check -api or the cluster's api)", err)
+			}
+			return nil
+		}},
+		{"API answers on " + addr, func() error {
+			c, err := net.DialTimeout("tcp", addr, checkTimeout)
+			if err != nil {
+				return fmt.Errorf("%w (check the port in the API URL and firewalls)", err)
+			}
+			return c.Close()
+		}},
+	}
+	if u.Scheme == "https" {
+		checks = append(checks, pingCheck{"API certificate", func() error { return checkAPICert(cl, addr, u.Hostname()) }})
+	}
+	checks = append(checks,
+		pingCheck{"API login as " + cl.user(), func() error {
+			err := hc3Client(cl, checkTimeout).Ping(runCtx)
+			switch {
+			case errors.Is(err, hc3.ErrUnauthorized):
+				return fmt.Errorf("%w (check -user/-pass or the cluster's user and password)", err)
+			case errors.Is(err, hc3.ErrNotFound):
+				return fmt.Errorf("%w (is -api the REST base URL of an HC3 cluster?)", err)
+			}
+			return err
+		}},
+		pingCheck{"share " + redact(cl.share()) + " answers", func() error { return checkClusterShare(cl) }},
+	)
+	if cl.protocol() == "smb" {
+		checks = append(checks, pingCheck{"share login", func() error { return checkSMBLogin(cl) }})
+	}
+	return checks
+}
+
+// checkAPICert does the TLS handshake with the API. It only fails when a
+// CA is configured, as without one the certificate is not verified.
+func checkAPICert(cl *cluster, addr, host string) error {
+	roots := cl.caRoots()
+	d := tls.Dialer{Config: &tls.Config{RootCAs: roots, ServerName: host, InsecureSkipVerify: roots == nil}}
+	ctx, cancel := context.WithTimeout(runCtx, checkTimeout)
+	defer cancel()
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		if roots != nil {
+			return fmt.Errorf("%w (check -api-ca or the cluster's ca)", err)
+		}
+		return err
+	}
+	c.Close()
+	if roots == nil {
+		return fmt.Errorf("%w: not verified, no -api-ca", errSkipped)
+	}
+	return nil
+}
+
+// checkSMBLogin logs in to the share with its credentials, looks up the
+// directory of the share URI and explains the usual failures.
+func checkSMBLogin(cl *cluster) error {
+	s, err := newSMBStager(cl)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	_, err = s.stat("")
+	var status smb.Status
+	if !errors.As(err, &status) {
+		return err
+	}
+	hint, ok := map[smb.Status]string{
+		smb.StatusLogonFailure:       "user or password in -share rejected",
+		smb.StatusAccountDisabled:    "the share user is disabled",
+		smb.StatusPasswordExpired:    "the share user's password has expired",
+		smb.StatusAccessDenied:       "the share user may not access the share",
+		smb.StatusBadNetworkName:     "no such share on the server (check the share name in -share)",
+		smb.StatusObjectPathNotFound: "no such directory on the share (check the path in -share)",
+		smb.StatusObjectNameNotFound: "no such directory on the share (check the path in -share)",
+	}[status]
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("%s: %s", status, hint)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3/hc3test"
+)
+
+// The pipeline tests run the whole tool, as main, in a child process of
+// the test binary, since a run's flags and state are process-wide.
+func TestMain(m *testing.M) {
+	if os.Getenv("VM_IMPORT_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+const testOVF = `<?xml version="1.0"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1">
+<References><File ovf:id="file1" ovf:href="{{vm}}-disk1.vmdk"/></References>
+<DiskSection><Disk ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:capacity="1048576"/></DiskSection>
+<VirtualSystem ovf:id="{{vm}}"><Name>{{vm}}</Name></VirtualSystem>
+</Envelope>
+`
+
+const testXML = `<domain type="kvm"><name>{{vm}}</name><uuid>11111111-1111-1111-1111-111111111111</uuid>
+<devices><disk type="network" device="disk"><source name="scale/22222222-2222-2222-2222-222222222222"/><target dev="vda" bus="virtio"/><capacity>1048576</capacity></disk></devices>
+</domain>
+`
+
+// testExport lays out vm's OVA export under dir/ova and its dummy VM's
+// Scale XML under dir/scale, and returns the source disk's content.
+func testExport(t *testing.T, dir, vm string) []byte {
+	t.Helper()
+	disk := bytes.Repeat([]byte(vm+" disk "), 8192)
+	files := map[string][]byte{
+		"ova/" + vm + "/" + vm + ".ovf":        []byte(strings.ReplaceAll(testOVF, "{{vm}}", vm)),
+		"ova/" + vm + "/" + vm + "-disk1.vmdk": disk,
+		"scale/" + vm + "/" + vm + ".xml":      []byte(strings.ReplaceAll(testXML, "{{vm}}", vm)),
+	}
+	for name, b := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return disk
+}
+
+// runTool runs the tool with args against the fake cluster srv and
+// returns its exit status and output.
+func runTool(t *testing.T, dir string, srv *hc3test.Server, args ...string) (int, string) {
+	t.Helper()
+	args = append([]string{
+		"-ovadir", filepath.Join(dir, "ova"),
+		"-scaledir", filepath.Join(dir, "scale"),
+		"-api", srv.URL, "-user", "admin", "-pass", "secret",
+		"-settle", "0", "-no-share-check",
+	}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(),
+		"VM_IMPORT_TEST_MAIN=1",
+		"HOME="+dir,
+		"XDG_STATE_HOME="+filepath.Join(dir, "state"),
+		"XDG_CONFIG_HOME="+filepath.Join(dir, "config"),
+		"XDG_CACHE_HOME="+filepath.Join(dir, "cache"),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Fatal(err)
+		}
+	}
+	return cmd.ProcessState.ExitCode(), string(out)
+}
+
+func TestPipelineImports(t *testing.T) {
+	srv := hc3test.NewServer(hc3test.WithCredentials("admin", "secret"))
+	defer srv.Close()
+	dir := t.TempDir()
+	disk := testExport(t, dir, "vm1")
+
+	code, out := runTool(t, dir, srv, "-vms", "vm1", "-import")
+	if code != 0 {
+		t.Fatalf("exit status %d:\n%s", code, out)
+	}
+	staged, err := os.ReadFile(filepath.Join(dir, "scale", "vm1", "22222222-2222-2222-2222-222222222222.qcow2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(staged, disk) {
+		t.Error("the staged image differs from the source disk")
+	}
+	imps := srv.Imports()
+	if len(imps) != 1 {
+		t.Fatalf("%d imports, want 1:\n%s", len(imps), out)
+	}
+	if src := imps[0].Source; src.DefinitionFileName != "vm1.xml" || !strings.HasSuffix(src.PathURI, "/vm1") {
+		t.Errorf("import source %+v", src)
+	}
+}
+
+func TestPipelineDryRun(t *testing.T) {
+	srv := hc3test.NewServer(hc3test.WithCredentials("admin", "secret"))
+	defer srv.Close()
+	dir := t.TempDir()
+	testExport(t, dir, "vm1")
+
+	if code, out := runTool(t, dir, srv, "-vms", "vm1", "-import", "-n"); code != 0 {
+		t.Fatalf("exit status %d:\n%s", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scale", "vm1", "22222222-2222-2222-2222-222222222222.qcow2")); !os.IsNotExist(err) {
+		t.Errorf("dry run staged an image: %v", err)
+	}
+	if n := len(srv.Imports()); n != 0 {
+		t.Errorf("dry run posted %d imports", n)
+	}
+}
+
+func TestPipelineRejectedImport(t *testing.T) {
+	srv := hc3test.NewServer(hc3test.WithCredentials("admin", "secret"))
+	defer srv.Close()
+	dir := t.TempDir()
+	testExport(t, dir, "vm1")
+	srv.FailNextImport(http.StatusBadRequest, "no space left on the cluster")
+
+	code, out := runTool(t, dir, srv, "-vms", "vm1", "-import")
+	if code != classExit[classAPI] {
+		t.Errorf("exit status %d, want %d:\n%s", code, classExit[classAPI], out)
+	}
+	if !strings.Contains(out, "no space left on the cluster") {
+		t.Errorf("the cluster's message is not reported:\n%s", out)
+	}
+	if n := len(srv.VMs()); n != 0 {
+		t.Errorf("%d VMs imported", n)
+	}
+}
+
+func TestPipelineWrongCredentials(t *testing.T) {
+	srv := hc3test.NewServer(hc3test.WithCredentials("admin", "other"))
+	defer srv.Close()
+	dir := t.TempDir()
+	testExport(t, dir, "vm1")
+
+	code, out := runTool(t, dir, srv, "-vms", "vm1", "-import")
+	if code != classExit[classAPI] {
+		t.Errorf("exit status %d, want %d:\n%s", code, classExit[classAPI], out)
+	}
+	if n := len(srv.Imports()); n != 0 {
+		t.Errorf("%d imports accepted", n)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*--------- plain ASCII output ---------*/
+
+// asciiMarks spells the status markers and punctuation of console output
+// in ASCII. Other symbols are decoration and are dropped by plainText.
+var asciiMarks = strings.NewReplacer(
+	"✅", "[ok]", "✓", "[ok]", "❌", "[FAIL]", "✗", "[skip]", "⚠️", "[!]", "⚠", "[!]", "⏳", "[wait]",
+	"–", "-", "→", "->", "←", "<-", "·", "-", "…", "...", "Δ ", "",
+)
+
+// plainOutput reports whether console output is kept to ASCII: with
+// -no-emoji or -no-color, else when stdout is not a terminal – a CI log,
+// a pipe or a file – or TERM=dumb or NO_COLOR is set. -no-emoji=false
+// keeps the symbols regardless.
+var plainOutput = sync.OnceValue(func() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == "no-emoji" || f.Name == "no-color" })
+	if set {
+		return *noEmoji || *noColor
+	}
+	if os.Getenv("TERM") == "dumb" || os.Getenv("NO_COLOR") != "" {
+		return true
+	}
+	fi, err := os.Stdout.Stat()
+	return err != nil || fi.Mode()&os.ModeCharDevice == 0
+})
+
+// plainText returns s with asciiMarks applied and any other symbol and
+// emoji removed, with the space that followed it when it started a line
+// or word. Letters, such as those of VM names, are left alone.
+func plainText(s string) string {
+	s = asciiMarks.Replace(s)
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r <= unicode.MaxASCII || !isDecoration(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if b.Len() == 0 || strings.ContainsAny(b.String()[b.Len()-1:], " \n[") {
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+		}
+	}
+	return b.String()
+}
+
+// isDecoration reports whether r is a symbol, an emoji or a modifier of
+// one, such as the variation selector of ⚠️.
+func isDecoration(r rune) bool {
+	return unicode.In(r, unicode.So, unicode.Sm, unicode.Sk) || unicode.Is(unicode.Variation_Selector, r) || r == '\u200d'
+}
+
+// writePlain writes p to f, through plainText when plainOutput is on.
+func writePlain(f *os.File, p []byte) (int, error) {
+	if !plainOutput() {
+		return f.Write(p)
+	}
+	if _, err := f.WriteString(plainText(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stderr is stdout's counterpart for diagnostics that always go to
+// stderr.
+type stderr struct{}
+
+func (stderr) Write(p []byte) (int, error) { return writePlain(os.Stderr, p) }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- power-on and guest check ---------*/
+
+// guestPollInterval is how often a started VM is asked whether its guest
+// agent reports.
+const guestPollInterval = 5 * time.Second
+
+// powerOnVM waits for HC3 to finish the import q and starts the VM.
+func powerOnVM(ctx context.Context, q queuedImport) error {
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	vmLog(q.vm).Info("⏳ waiting for HC3 to finish the import", "task", q.task)
+	if err := c.WaitTask(ctx, q.task, taskPollInterval); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	task, err := c.Action(ctx, q.uuid, hc3.ActionStart)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	audit("power-on", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return fmt.Errorf("power on: %w", err)
+	}
+	vmLog(q.vm).Info("▶ powered on", "uuid", q.uuid)
+	return nil
+}
+
+// waitForGuest polls the started VM q until its guest agent reports, for
+// at most -wait-guest, and returns the guest's IP addresses. Only then has
+// the VM demonstrably made it across.
+func waitForGuest(ctx context.Context, q queuedImport) ([]string, error) {
+	c, vm := hc3Client(q.cl, hc3.DefaultTimeout), q.vm
+	ctx, cancel := context.WithTimeoutCause(ctx, *waitGuest,
+		fmt.Errorf("guest agent did not report within %s (are the guest tools installed and starting?)", *waitGuest))
+	defer cancel()
+	vmLog(vm).Info("⏳ waiting for the guest agent", "timeout", *waitGuest)
+	for {
+		v, err := c.VM(ctx, q.uuid)
+		if err == nil && v.GuestUp() {
+			vmLog(vm).Info("✅ guest is up", "ips", v.IPs())
+			return v.IPs(), nil
+		}
+		if err != nil {
+			vmLog(vm).Debug("polling VM", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(guestPollInterval):
+		}
+	}
+}
+
+// showConsole logs where the screen of the started VM q is served, so the
+// operator can watch its first boot, and returns it as type://host:port.
+func showConsole(ctx context.Context, q queuedImport) string {
+	v, err := hc3Client(q.cl, hc3.DefaultTimeout).VM(ctx, q.uuid)
+	if err != nil {
+		vmLog(q.vm).Warn("cannot fetch console details", "err", err)
+		return ""
+	}
+	if v.Console == nil || v.Console.Port == 0 {
+		vmLog(q.vm).Warn("HC3 reports no console for the VM")
+		return ""
+	}
+	addr := strings.ToLower(cmp.Or(v.Console.Type, "vnc")) + "://" + v.Console.Addr()
+	args := []any{"console", addr, "ui", q.cl.api()}
+	if v.Console.Password != "" {
+		args = append(args, "password", "set (shown in the HC3 UI)")
+	}
+	vmLog(q.vm).Info("🖥 console", args...)
+	return addr
+}
+
+// waitImports waits for HC3 to finish the imports, for the steps that need
+// the new VMs in place.
+func waitImports(ctx context.Context, imported []queuedImport) error {
+	for _, q := range imported {
+		vmLog(q.vm).Info("⏳ waiting for HC3 to finish the import", "task", q.task)
+		if err := hc3Client(q.cl, hc3.DefaultTimeout).WaitTask(ctx, q.task, taskPollInterval); err != nil {
+			return fmt.Errorf("%s: import: %w", q.vm, err)
+		}
+	}
+	return nil
+}
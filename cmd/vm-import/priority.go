@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*--------- CPU and I/O priority ---------*/
+
+// I/O scheduling classes for -ionice, as ioprio_set(2) numbers them.
+const (
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// parseIONice reads -ionice: idle, or best-effort with an optional level
+// from 0 (highest) to 7 (lowest), e.g. best-effort:7. The real-time class
+// is not offered: it could starve the host it is meant to spare.
+func parseIONice(s string) (class, level int, err error) {
+	name, lv, hasLevel := strings.Cut(s, ":")
+	switch {
+	case name == "idle" && !hasLevel:
+		return ioClassIdle, 0, nil
+	case name == "best-effort":
+		level = 4
+		if hasLevel {
+			if level, err = strconv.Atoi(lv); err != nil || level < 0 || level > 7 {
+				return 0, 0, fmt.Errorf("best-effort level %q: want 0 to 7", lv)
+			}
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return 0, 0, fmt.Errorf("want idle or best-effort[:0-7], not %q", s)
+}
+
+// setupPriority lowers the process's CPU priority to -nice and its I/O
+// priority to -ionice. qemu-img, ssh and the other programs the run
+// starts inherit both.
+func setupPriority() error {
+	if *niceFlag < 0 || *niceFlag > 19 {
+		return fmt.Errorf("-nice: want 0 to 19")
+	}
+	if *niceFlag > 0 {
+		if err := setNice(*niceFlag); err != nil {
+			return fmt.Errorf("-nice: %w", err)
+		}
+		slog.Debug("CPU priority lowered", "nice", *niceFlag)
+	}
+	if *ioniceFlag != "" {
+		class, level, err := parseIONice(*ioniceFlag)
+		if err != nil {
+			return fmt.Errorf("-ionice: %w", err)
+		}
+		if err := setIOPriority(class, level); err != nil {
+			return fmt.Errorf("-ionice: %w", err)
+		}
+		slog.Debug("I/O priority lowered", "ionice", *ioniceFlag)
+	}
+	if *dropCache && !canDropCache {
+		slog.Warn("-drop-cache is not supported on this platform; copies go through the page cache")
+	}
+	return nil
+}
+
+// dropCacheEvery is how much of a source disk is read, or of a staged
+// image flushed, before -drop-cache evicts it from the page cache.
+const dropCacheEvery = 256 << 20
+
+// uncachedReader reads a local source disk f and, with -drop-cache, has
+// the kernel drop what was read from the page cache as it goes, so a seed
+// copy does not push the export host's other workloads out of memory.
+type uncachedReader struct {
+	f          *os.File
+	pos, freed int64
+}
+
+// uncached returns r, with -drop-cache wrapped in an uncachedReader if it
+// is a local file.
+func uncached(r io.Reader) io.Reader {
+	if f, ok := r.(*os.File); ok && *dropCache && canDropCache {
+		return &uncachedReader{f: f}
+	}
+	return r
+}
+
+func (u *uncachedReader) Read(p []byte) (int, error) {
+	n, err := u.f.Read(p)
+	if u.pos += int64(n); u.pos-u.freed >= dropCacheEvery || err == io.EOF {
+		fadviseDontNeed(u.f, u.freed, u.pos-u.freed)
+		u.freed = u.pos
+	}
+	return n, err
+}
+
+// dropCached evicts the file name from the page cache once it has been
+// written and flushed, or read, by another program, with -drop-cache.
+func dropCached(name string) {
+	if !*dropCache || !canDropCache {
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fadviseDontNeed(f, 0, 0)
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// setNice sets the niceness of every thread of the process: on Linux it
+// is per thread, and threads started later inherit it from theirs.
+func setNice(n int) error {
+	return eachThread(func(tid int) error { return syscall.Setpriority(syscall.PRIO_PROCESS, tid, n) })
+}
+
+// setIOPriority sets the I/O scheduling class and level of every thread
+// of the process, which like niceness is per thread on Linux.
+func setIOPriority(class, level int) error {
+	const whoProcess, classShift = 1, 13
+	prio := uintptr(class<<classShift | level)
+	return eachThread(func(tid int) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, whoProcess, uintptr(tid), prio); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// eachThread calls set for each thread of the process.
+func eachThread(set func(tid int) error) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return set(0)
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := set(tid); err != nil && err != syscall.ESRCH { // ESRCH: the thread has exited
+			return err
+		}
+	}
+	return nil
+}
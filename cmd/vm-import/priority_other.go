@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errPriority = errors.New("only supported on Linux")
+
+func setNice(n int) error { return errPriority }
+
+func setIOPriority(class, level int) error { return errPriority }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*--------- progress webhook ---------*/
+
+// progressQueue is how many events may wait for the -progress-webhook
+// before new ones are dropped, so a slow dashboard never holds up a copy.
+const progressQueue = 1024
+
+var (
+	progressMu      sync.Mutex
+	progressEvents  chan []byte // nil until the first event, then until the run ends
+	progressClosed  bool
+	progressDone    = make(chan struct{})
+	progressDropped atomic.Int64
+	progressFailed  atomic.Bool
+)
+
+// queueProgressHook queues the event body b for the -progress-webhook,
+// starting the sender that posts them one at a time, in order.
+func queueProgressHook(b []byte) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if progressClosed {
+		return
+	}
+	if progressEvents == nil {
+		progressEvents = make(chan []byte, progressQueue)
+		go sendProgressHook()
+	}
+	select {
+	case progressEvents <- b:
+	default:
+		progressDropped.Add(1)
+	}
+}
+
+func sendProgressHook() {
+	defer close(progressDone)
+	c := &http.Client{Timeout: 10 * time.Second, Transport: debugRT(nil)}
+	for b := range progressEvents {
+		if err := postProgressEvent(c, b); err != nil {
+			// once at warn level, so a dead dashboard does not flood the log
+			lvl := slog.LevelDebug
+			if !progressFailed.Swap(true) {
+				lvl = slog.LevelWarn
+			}
+			slog.Log(runCtx, lvl, "progress webhook failed", "url", redactURL(*progressHook), "err", err)
+		}
+	}
+}
+
+func postProgressEvent(c *http.Client, b []byte) error {
+	resp, err := c.Post(*progressHook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// flushProgressHook gives the webhook up to half a minute to receive the
+// events still queued as the run ends.
+func flushProgressHook() {
+	progressMu.Lock()
+	progressClosed = true
+	started := progressEvents != nil
+	if started {
+		close(progressEvents)
+	}
+	progressMu.Unlock()
+	if !started {
+		return
+	}
+	select {
+	case <-progressDone:
+	case <-time.After(30 * time.Second):
+		slog.Warn("progress webhook too slow, dropping the remaining events", "url", redactURL(*progressHook))
+	}
+	if n := progressDropped.Load(); n > 0 {
+		slog.Warn("progress webhook fell behind, events dropped", "url", redactURL(*progressHook), "dropped", n)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+/*--------- protobuf wire format ---------*/
+
+// The gRPC interface has a handful of flat messages, so they are encoded
+// by hand rather than through generated code. Fields holding their zero
+// value are omitted, as proto3 does.
+
+// pbMsg builds an encoded protobuf message.
+type pbMsg []byte
+
+func (m *pbMsg) key(field, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|uint64(wire))
+}
+
+func (m *pbMsg) uint(field int, v uint64) {
+	if v != 0 {
+		m.key(field, 0)
+		*m = binary.AppendUvarint(*m, v)
+	}
+}
+
+func (m *pbMsg) int(field int, v int64) { m.uint(field, uint64(v)) }
+
+func (m *pbMsg) bool(field int, v bool) {
+	if v {
+		m.uint(field, 1)
+	}
+}
+
+func (m *pbMsg) bytes(field int, b []byte) {
+	m.key(field, 2)
+	*m = binary.AppendUvarint(*m, uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+func (m *pbMsg) string(field int, s string) {
+	if s != "" {
+		m.bytes(field, []byte(s))
+	}
+}
+
+// msg embeds sub, even when empty, so the field reads as set.
+func (m *pbMsg) msg(field int, sub pbMsg) { m.bytes(field, sub) }
+
+// time encodes t as a google.protobuf.Timestamp; the zero time is left
+// unset.
+func (m *pbMsg) time(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	var ts pbMsg
+	ts.int(1, t.Unix())
+	ts.int(2, int64(t.Nanosecond()))
+	m.msg(field, ts)
+}
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// pbField is one decoded field: v for varints, data for length-delimited
+// ones (fixed-size fields are skipped).
+type pbField struct {
+	num  int
+	wire int
+	v    uint64
+	data []byte
+}
+
+// pbDecode splits an encoded message into its fields.
+func pbDecode(b []byte) ([]pbField, error) {
+	var out []pbField
+	for len(b) > 0 {
+		k, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errBadProto
+		}
+		b = b[n:]
+		f := pbField{num: int(k >> 3), wire: int(k & 7)}
+		switch f.wire {
+		case 0:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				return nil, errBadProto
+			}
+			b = b[n:]
+		case 1, 5:
+			size := 8
+			if f.wire == 5 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, errBadProto
+			}
+			b = b[size:]
+			continue
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errBadProto
+			}
+			f.data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return nil, errBadProto
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*--------- Proxmox source adapter ---------*/
+
+// A Proxmox VM directory holds qemu-server.conf plus one file per disk
+// named disk-drive-<slot>.<raw|qcow2>, the layout `vma extract` produces.
+// It is filled either by pulling from a node over SSH (-proxmox) or by
+// extracting a vzdump backup (*.vma[.gz|.zst]) dropped into the directory.
+// qcow2 disks are staged as-is, raw ones go through qemu-img.
+
+const pveConf = "qemu-server.conf"
+
+var rePVEDisk = regexp.MustCompile(`^(ide|sata|scsi|virtio)(\d+)$`)
+
+type pveDisk struct {
+	slot  string // scsi0, virtio1, …
+	volid string // local-lvm:vm-100-disk-0
+}
+
+type pveConfig struct {
+	name   string
+	cpus   int
+	memory int
+	disks  []pveDisk
+}
+
+// parsePVEConfig reads the main section of a qemu-server config (snapshot
+// sections are ignored) and returns its data disks in boot/bus order.
+func parsePVEConfig(r io.Reader) pveConfig {
+	cfg := pveConfig{cpus: 1}
+	sockets, cores := 1, 1
+	var boot []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch {
+		case key == "name":
+			cfg.name = val
+		case key == "memory":
+			cfg.memory, _ = strconv.Atoi(val)
+		case key == "sockets":
+			sockets, _ = strconv.Atoi(val)
+		case key == "cores":
+			cores, _ = strconv.Atoi(val)
+		case key == "boot":
+			if o, ok := strings.CutPrefix(val, "order="); ok {
+				boot = strings.Split(o, ";")
+			}
+		case rePVEDisk.MatchString(key):
+			volid, opts, _ := strings.Cut(val, ",")
+			if volid == "none" || strings.Contains(","+opts+",", ",media=cdrom,") {
+				continue
+			}
+			cfg.disks = append(cfg.disks, pveDisk{slot: key, volid: volid})
+		}
+	}
+	cfg.cpus = max(1, sockets*cores)
+
+	rank := map[string]int{}
+	for i, b := range boot {
+		rank[b] = i
+	}
+	sort.SliceStable(cfg.disks, func(i, j int) bool {
+		ri, iok := rank[cfg.disks[i].slot]
+		rj, jok := rank[cfg.disks[j].slot]
+		if iok != jok {
+			return iok
+		}
+		if iok {
+			return ri < rj
+		}
+		return pveSlotLess(cfg.disks[i].slot, cfg.disks[j].slot)
+	})
+	return cfg
+}
+
+func pveSlotLess(a, b string) bool {
+	ma, mb := rePVEDisk.FindStringSubmatch(a), rePVEDisk.FindStringSubmatch(b)
+	if ma[1] != mb[1] {
+		return ma[1] < mb[1]
+	}
+	ia, _ := strconv.Atoi(ma[2])
+	ib, _ := strconv.Atoi(mb[2])
+	return ia < ib
+}
+
+func isProxmoxExport(vm string) bool {
+	m, _ := ova.Glob(path.Join(vm, pveConf))
+	return len(m) > 0 || vzdumpFile(vm) != ""
+}
+
+func vzdumpFile(vm string) string {
+	for _, pat := range []string{"*.vma", "*.vma.gz", "*.vma.zst"} {
+		if m, _ := ova.Glob(path.Join(vm, pat)); len(m) > 0 {
+			return m[0]
+		}
+	}
+	return ""
+}
+
+// proxmoxDisks returns the VM's disk files, extracting a vzdump backup
+// first when there is no qemu-server.conf yet.
+func proxmoxDisks(vm string) ([]string, error) {
+	ls, ok := ova.(localSource)
+	if !ok {
+		return nil, fmt.Errorf("Proxmox sources need a local -ovadir")
+	}
+	dir := ls.path(vm)
+	if !fileExists(filepath.Join(dir, pveConf)) {
+		dump := vzdumpFile(vm)
+		if *dryRun {
+			vmLog(vm).Info("[dry-run] extract", "file", path.Base(dump))
+			return nil, nil
+		}
+		if err := extractVzdump(ls.path(dump), dir); err != nil {
+			return nil, fmt.Errorf("extract %s: %w", path.Base(dump), err)
+		}
+	}
+	f, err := os.Open(filepath.Join(dir, pveConf))
+	if err != nil {
+		return nil, err
+	}
+	cfg := parsePVEConfig(f)
+	f.Close()
+	vmLog(vm).Info("Proxmox config", "vcpu", cfg.cpus, "memory_mib", cfg.memory)
+
+	var out []string
+	for _, d := range cfg.disks {
+		m, _ := filepath.Glob(filepath.Join(dir, "disk-drive-"+d.slot+".*"))
+		if len(m) == 0 {
+			return nil, fmt.Errorf("no image for %s (%s)", d.slot, d.volid)
+		}
+		out = append(out, filepath.Base(m[0]))
+	}
+	return out, nil
+}
+
+// extractVzdump unpacks a VMA backup into dir with Proxmox' vma tool.
+func extractVzdump(dump, dir string) error {
+	if _, err := exec.LookPath("vma"); err != nil {
+		return fmt.Errorf("vzdump extraction needs the Proxmox vma tool: %w", err)
+	}
+	vmaPath := dump
+	if !strings.HasSuffix(dump, ".vma") {
+		tmp, err := os.CreateTemp(dir, "vzdump-*.vma")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if err := decompressVzdump(dump, tmp); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		vmaPath = tmp.Name()
+	}
+
+	out := filepath.Join(dir, ".vma-extract")
+	os.RemoveAll(out) // vma refuses an existing target
+	cmd := exec.CommandContext(runCtx, "vma", "extract", vmaPath, out)
+	var errb bytes.Buffer
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("vma extract: %v: %s", err, strings.TrimSpace(errb.String()))
+	}
+	ents, err := os.ReadDir(out)
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		if err := os.Rename(filepath.Join(out, e.Name()), filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	vmLog(filepath.Base(dir)).Info("✓ extracted", "file", filepath.Base(dump))
+	return os.Remove(out)
+}
+
+func decompressVzdump(src string, dst io.Writer) error {
+	if strings.HasSuffix(src, ".zst") {
+		cmd := exec.CommandContext(runCtx, "zstd", "-dc", src)
+		cmd.Stdout = dst
+		var errb bytes.Buffer
+		cmd.Stderr = &errb
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("zstd: %v: %s", err, strings.TrimSpace(errb.String()))
+		}
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, zr)
+	return err
+}
+
+/*--------- pull from a Proxmox node ---------*/
+
+// pullFromProxmox copies config and disks of each selected VM from the
+// node given by -proxmox (ssh://root@pve) into <ovadir>/<vm>/.
+func pullFromProxmox(names []string) error {
+	if *pveNode == "" {
+		return nil
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("Proxmox pull needs -vms or -manifest")
+	}
+	ls, ok := ova.(localSource)
+	if !ok {
+		return fmt.Errorf("Proxmox pull needs a local -ovadir")
+	}
+	node, err := newSSHStager(*pveNode + "/")
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if err := pullPVE(node, n, ls.path(normalizeName(n))); err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+	}
+	return nil
+}
+
+func pullPVE(node *sshStager, name, dir string) error {
+	// find the VM by name across the cluster-wide config tree
+	out, err := node.run(fmt.Sprintf(`grep -l -x %s /etc/pve/nodes/*/qemu-server/*.conf | head -n1`,
+		shellQuote("name: "+name)), nil)
+	if err != nil {
+		return err
+	}
+	confPath := strings.TrimSpace(string(out))
+	if confPath == "" {
+		return fmt.Errorf("no Proxmox VM named %q", name)
+	}
+	conf, err := node.run("cat "+shellQuote(confPath), nil)
+	if err != nil {
+		return err
+	}
+	cfg := parsePVEConfig(bytes.NewReader(conf))
+
+	if *dryRun {
+		for _, d := range cfg.disks {
+			slog.Info("[dry-run] pull", "disk", d.slot, "volid", d.volid, "dst", dir)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, d := range cfg.disks {
+		p, err := node.run("pvesm path "+shellQuote(d.volid), nil)
+		if err != nil {
+			return err
+		}
+		src := strings.TrimSpace(string(p))
+		ext := "raw"
+		if strings.HasSuffix(src, ".qcow2") {
+			ext = "qcow2"
+		}
+		dst := filepath.Join(dir, "disk-drive-"+d.slot+"."+ext)
+		slog.Info("⟳ pulling", "disk", d.slot, "volid", d.volid)
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		if err := node.stream("cat "+shellQuote(src), nil, f); err != nil {
+			f.Close()
+			os.Remove(dst)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		slog.Info("✓ pulled", "file", filepath.Base(dst))
+	}
+	return os.WriteFile(filepath.Join(dir, pveConf), conf, 0o644)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*--------- Prometheus Pushgateway ---------*/
+
+// pushMetrics sends the batch's metrics to -pushgateway, replacing the
+// group job="vm-import", instance=<host> so each host keeps its last run.
+func pushMetrics(gateway string, total, failed int, d time.Duration, runs []*runRecord) error {
+	var b bytes.Buffer
+	metric := func(name, help, typ string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("vm_import_last_run_timestamp_seconds", "When the last batch finished.", "gauge")
+	fmt.Fprintf(&b, "vm_import_last_run_timestamp_seconds %d\n", time.Now().Unix())
+	metric("vm_import_batch_duration_seconds", "Wall time of the last batch.", "gauge")
+	fmt.Fprintf(&b, "vm_import_batch_duration_seconds %g\n", d.Seconds())
+	metric("vm_import_batch_vms", "VMs selected in the last batch.", "gauge")
+	fmt.Fprintf(&b, "vm_import_batch_vms %d\n", total)
+	metric("vm_import_batch_failures", "VMs that failed in the last batch.", "gauge")
+	fmt.Fprintf(&b, "vm_import_batch_failures %d\n", failed)
+
+	var bytesStaged int64
+	for _, r := range runs {
+		for _, dk := range r.Disks {
+			bytesStaged += dk.Size
+		}
+	}
+	metric("vm_import_batch_bytes", "Source disk bytes staged in the last batch.", "gauge")
+	fmt.Fprintf(&b, "vm_import_batch_bytes %d\n", bytesStaged)
+
+	metric("vm_import_vm_success", "1 if the VM was staged (and imported) without error.", "gauge")
+	for _, r := range runs {
+		ok := 0
+		if r.Outcome == "ok" {
+			ok = 1
+		}
+		fmt.Fprintf(&b, "vm_import_vm_success{vm=\"%s\"} %d\n", label(r.VM), ok)
+	}
+	metric("vm_import_vm_duration_seconds", "Time spent on the VM.", "gauge")
+	for _, r := range runs {
+		fmt.Fprintf(&b, "vm_import_vm_duration_seconds{vm=\"%s\"} %g\n", label(r.VM), r.Duration.Seconds())
+	}
+	metric("vm_import_vm_bytes", "Source disk bytes staged for the VM.", "gauge")
+	for _, r := range runs {
+		var n int64
+		for _, dk := range r.Disks {
+			n += dk.Size
+		}
+		fmt.Fprintf(&b, "vm_import_vm_bytes{vm=\"%s\"} %d\n", label(r.VM), n)
+	}
+	metric("vm_import_step_duration_seconds", "Time spent in each pipeline step of the VM.", "gauge")
+	for _, r := range runs {
+		steps := append([]stepRecord(nil), r.Steps...)
+		sort.Slice(steps, func(i, j int) bool { return steps[i].Name < steps[j].Name })
+		for _, s := range steps {
+			fmt.Fprintf(&b, "vm_import_step_duration_seconds{vm=\"%s\",step=\"%s\"} %g\n", label(r.VM), label(s.Name), s.Duration.Seconds())
+		}
+	}
+
+	target := strings.TrimRight(gateway, "/") + "/metrics/job/vm-import"
+	if host, _ := os.Hostname(); host != "" {
+		target += "/instance/" + url.PathEscape(host)
+	}
+	req, err := http.NewRequest("PUT", target, &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := (&http.Client{Timeout: 15 * time.Second, Transport: debugRT(nil)}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label escapes a Prometheus label value.
+func label(v string) string { return labelEscaper.Replace(v) }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*--------- raw device mappings ---------*/
+
+// rdmDescriptorMax bounds the size of a VMDK read to check whether it is
+// a raw device mapping's descriptor; disks with data are far larger.
+const rdmDescriptorMax = 64 << 10
+
+var reRDM = regexp.MustCompile(`(?i)createType\s*=\s*"vmfs(Passthrough)?RawDeviceMap"`)
+
+// isRDM reports whether the source disk file is a placeholder for a raw
+// device mapping rather than a disk: a -rdm.vmdk or -rdmp.vmdk file, or a
+// small VMDK descriptor of an RDM createType. The LUN's data is not in the
+// export.
+func isRDM(file string) bool {
+	name := strings.ToLower(path.Base(file))
+	if strings.HasSuffix(name, "-rdm.vmdk") || strings.HasSuffix(name, "-rdmp.vmdk") {
+		return true
+	}
+	if path.Ext(name) != ".vmdk" {
+		return false
+	}
+	if sz, err := ova.Size(file); err != nil || sz > rdmDescriptorMax {
+		return false
+	}
+	f, err := ova.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b, _ := io.ReadAll(io.LimitReader(f, rdmDescriptorMax))
+	return reRDM.Match(b)
+}
+
+// withoutRDMs drops the raw device mappings from vm's source disk files,
+// so they are neither paired nor copied. An export of nothing else fails.
+func withoutRDMs(vm string, files []string) ([]string, error) {
+	var out []string
+	for _, f := range files {
+		if !isRDM(path.Join(vm, f)) {
+			out = append(out, f)
+		}
+	}
+	if len(out) == 0 && len(files) > 0 {
+		return nil, fmt.Errorf("the export's %d disk(s) are all raw device mappings – their LUN data needs a separate migration path", len(files))
+	}
+	return out, nil
+}
+
+// sourceRDMs returns the raw device mappings among vm's OVF disk files,
+// warning about each: they are left out, and the data of their LUNs must
+// be migrated some other way.
+func sourceRDMs(vm string) ([]string, error) {
+	desc, err := ovfFile(vm)
+	if err != nil || desc == "" {
+		return nil, err
+	}
+	files, err := diskFilesFromOVF(desc)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range files {
+		if isRDM(path.Join(vm, f)) {
+			vmLog(vm).Warn("raw device mapping left out – migrate the LUN's data separately", "file", f)
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
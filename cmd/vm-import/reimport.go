@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+/*--------- reimport: replay a recorded migration ---------*/
+
+// reimport is set by "vm-import reimport [flags] vm", a run that migrates
+// vm again – typically from a refreshed export – with the options, tags
+// and disk mapping of its last successful run.
+var reimport bool
+
+// replayed is the run a reimport replays.
+var replayed *runRecord
+
+// notReplayed are the flags left out of a run's recorded options: the
+// VM selection and modes of the run itself, and secrets, which are never
+// recorded. -tag is replaced by the run's expanded tags.
+var notReplayed = map[string]bool{
+	"vms": true, "manifest": true, "url": true, "watch": true, "listen": true, "grpc-listen": true,
+	"n": true, "demo": true, "confirm-over": true, "i-know-what-im-doing": true, "tag": true,
+	"pass": true, "smb-pass": true, "smtp-pass": true, "vsphere-pass": true, "notify": true, "progress-webhook": true,
+}
+
+// runOptions returns the flags the run was given, as -name=value, for its
+// record; an SMB -share with a password in it is left out.
+func runOptions() []string {
+	var out []string
+	flag.Visit(func(f *flag.Flag) {
+		if notReplayed[f.Name] {
+			return
+		}
+		if f.Name == "share" {
+			u, err := smbShare(f.Value.String())
+			if err != nil {
+				return
+			}
+			if _, secret := u.User.Password(); secret {
+				return
+			}
+		}
+		if l, ok := f.Value.(*stringList); ok {
+			for _, v := range *l {
+				out = append(out, "-"+f.Name+"="+v)
+			}
+			return
+		}
+		out = append(out, "-"+f.Name+"="+f.Value.String())
+	})
+	return out
+}
+
+// diskMapping returns how the source disks src were paired, as a mapping
+// file would say it: mapped to the Scale disk UUID, new or skip. UUIDs
+// -new-uuids renames are given as renamed, as the Scale XML will have them.
+func diskMapping(src, mapped, uuids []string, renamed map[string]string) map[string]string {
+	m := map[string]string{}
+	for _, f := range src {
+		m[f] = "skip"
+	}
+	for i, f := range mapped {
+		m[f] = cmp.Or(renamed[uuids[i]], uuids[i], "new")
+	}
+	return m
+}
+
+// setupReimport looks up the last successful run of the reimport's VM in
+// the history and applies its options to the flags not given on the
+// command line, and its expanded tags unless -tag is. Its disk mapping
+// then takes the place of a mapping file; see readMapping.
+func setupReimport() error {
+	if !reimport {
+		return nil
+	}
+	if flag.NArg() != 1 {
+		return fmt.Errorf("usage: vm-import reimport [flags] vm")
+	}
+	if *watch || *listen != "" || *vmsFlag != "" {
+		return fmt.Errorf("not with -watch, -listen or -vms")
+	}
+	vm := flag.Arg(0)
+	runs, err := readHistory()
+	if err != nil {
+		return err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].VM == vm && runs[i].Outcome == "ok" && runs[i].Mapping != nil {
+			replayed = &runs[i]
+			break
+		}
+	}
+	if replayed == nil {
+		return fmt.Errorf("%s: no successful run with its options recorded in the history", vm)
+	}
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, o := range replayed.Options {
+		name, v, _ := strings.Cut(strings.TrimPrefix(o, "-"), "=")
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("recorded option %s: %w", o, err)
+		}
+	}
+	if !given["tag"] {
+		tagFlags = append(tagFlags, replayed.Tags...)
+	}
+	*vmsFlag = vm
+	return nil
+}
+
+// logReimport logs the run a reimport replays once logging is set up.
+func logReimport() {
+	if replayed == nil {
+		return
+	}
+	slog.Info("↻ replaying the last successful run", "vm", replayed.VM, "run", replayed.Start.Local().Format("2006-01-02 15:04"),
+		"options", strings.Join(replayed.Options, " "), "tags", strings.Join(replayed.Tags, ","))
+}
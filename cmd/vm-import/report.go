@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*--------- batch report artifact ---------*/
+
+// endBatch closes the current batch: writes the -report file, pushes
+// metrics to -pushgateway and sends the batch notification.
+func endBatch(total, failed int, d time.Duration) {
+	batchMu.Lock()
+	runs := batch
+	batch = nil
+	batchMu.Unlock()
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, runs); err != nil {
+			slog.Warn("writing report", "file", *reportPath, "err", err)
+		} else {
+			slog.Info("📄 report written", "file", *reportPath, "vms", len(runs))
+		}
+	}
+	if *checklistPath != "" {
+		if err := writeChecklist(*checklistPath, runs); err != nil {
+			slog.Warn("writing checklist", "file", *checklistPath, "err", err)
+		} else {
+			slog.Info("📋 checklist written", "file", *checklistPath, "vms", len(runs))
+		}
+	}
+	if *pushGateway != "" {
+		if err := pushMetrics(*pushGateway, total, failed, d, runs); err != nil {
+			slog.Warn("pushing metrics", "pushgateway", redact(*pushGateway), "err", err)
+		}
+	}
+	notifyBatch(total, failed, d, runs)
+}
+
+// writeReport lists the batch's VMs with their disks, sizes, checksums,
+// target UUIDs and status – as JSON for a .json file, else as CSV with one
+// row per disk.
+func writeReport(file string, runs []*runRecord) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]any{"generated": time.Now(), "vms": runs}); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"vm", "status", "error", "start", "duration_s", "task_tag", "created_uuid",
+		"disk_source", "disk_target", "disk_mode", "disk_bytes", "disk_sha256", "warnings", "smoke_test",
+		"source_hostname", "source_ips", "source_macs", "passthrough", "rdms", "skipped_devices", "source_clock", "since_seed", "source_state", "error_class"})
+	for _, r := range runs {
+		row := []string{r.VM, r.Outcome, r.Error, r.Start.Format(time.RFC3339),
+			strconv.FormatFloat(r.Duration.Seconds(), 'f', 0, 64), r.TaskTag, r.CreatedUUID}
+		warns := strings.Join(r.Warnings, "; ")
+		var srcNet []string
+		if n := r.SourceNet; n != nil {
+			srcNet = []string{n.Hostname, strings.Join(n.IPs, " "), strings.Join(n.MACs(), " ")}
+		} else {
+			srcNet = []string{"", "", ""}
+		}
+		var pt []string
+		for _, d := range r.Passthrough {
+			pt = append(pt, d.String())
+		}
+		var legacy []string
+		for _, d := range r.Legacy {
+			legacy = append(legacy, d.String())
+		}
+		srcNet = append(srcNet, strings.Join(pt, "; "), strings.Join(r.RDMs, " "), strings.Join(legacy, "; "), r.SourceClock, r.SinceSeed, r.SourceState, r.ErrorClass)
+		if len(r.Disks) == 0 {
+			w.Write(append(append(row, "", "", "", "", "", warns, r.SmokeTest), srcNet...))
+		}
+		for _, d := range r.Disks {
+			w.Write(append(append(row[:len(row):len(row)], d.Source, d.Target, d.Mode, strconv.FormatInt(d.Size, 10), d.SHA256, warns, r.SmokeTest), srcNet...))
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"gcosmiclentil89/ScaleVMFromOVA/scalexml"
+)
+
+/*--------- Scale XML editing ---------*/
+
+// readScaleXML parses a staged Scale definition.
+func readScaleXML(name string) (*scalexml.Node, error) {
+	data, err := stage.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := scalexml.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*--------- maintenance windows & scheduled starts ---------*/
+
+// window is a weekly time range in local time, e.g. "Sat-Sun 22:00-06:00".
+// An end at or before the start runs past midnight into the next day.
+type window struct {
+	days       [7]bool // indexed by time.Weekday; the day the window opens
+	start, end int     // minutes after midnight
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseWindows parses a comma-separated list of windows, each
+// "[Day[-Day]] HH:MM-HH:MM", e.g. "Mon-Fri 20:00-23:00, Sat 22:00-06:00".
+func parseWindows(s string) ([]window, error) {
+	var out []window
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var w window
+		f := strings.Fields(part)
+		switch len(f) {
+		case 1:
+			for i := range w.days {
+				w.days[i] = true
+			}
+		case 2:
+			days, err := parseDays(f[0])
+			if err != nil {
+				return nil, fmt.Errorf("window %q: %w", part, err)
+			}
+			w.days = days
+			f = f[1:]
+		default:
+			return nil, fmt.Errorf("window %q: want [Day[-Day]] HH:MM-HH:MM", part)
+		}
+		from, to, ok := strings.Cut(f[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q: want HH:MM-HH:MM", part)
+		}
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return nil, fmt.Errorf("window %q: %w", part, err)
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, fmt.Errorf("window %q: %w", part, err)
+		}
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	from, to, isRange := strings.Cut(s, "-")
+	a, err := parseWeekday(from)
+	if err != nil {
+		return days, err
+	}
+	b := a
+	if isRange {
+		if b, err = parseWeekday(to); err != nil {
+			return days, err
+		}
+	}
+	for d := a; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == b {
+			break
+		}
+	}
+	return days, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(s)
+	if len(s) >= 3 {
+		for i, d := range weekdays {
+			if strings.HasPrefix(s, d) {
+				return time.Weekday(i), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+// parseClock turns "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || hh > 24 || mm < 0 || mm > 59 || hh == 24 && mm != 0 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+func (w window) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && m >= w.start && m < w.end
+	}
+	return w.days[day] && m >= w.start || w.days[(day+6)%7] && m < w.end
+}
+
+// windows are the parsed -window ranges; none means any time.
+var windows []window
+
+// inWindow reports whether heavy work may start at t.
+func inWindow(t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForWindow blocks until the current time falls inside a -window or
+// the run is interrupted, announcing the wait once.
+func waitForWindow() {
+	if inWindow(time.Now()) {
+		return
+	}
+	slog.Info("⏳ outside maintenance window – waiting", "window", *windowSpec)
+	for !inWindow(time.Now()) {
+		select {
+		case <-runCtx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// parseStartAt interprets a job's requested start: RFC 3339, "HH:MM" or
+// "Day HH:MM", the latter two meaning their next occurrence after now.
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	f := strings.Fields(s)
+	if len(f) == 0 || len(f) > 2 {
+		return time.Time{}, fmt.Errorf("bad start time %q", s)
+	}
+	clock, err := parseClock(f[len(f)-1])
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock/60, clock%60, 0, 0, now.Location())
+	if len(f) == 2 {
+		day, err := parseWeekday(f[0])
+		if err != nil {
+			return time.Time{}, err
+		}
+		t = t.AddDate(0, 0, (int(day)-int(t.Weekday())+7)%7)
+	}
+	for !t.After(now) {
+		if len(f) == 2 {
+			t = t.AddDate(0, 0, 7)
+		} else {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t, nil
+}
+
+/*--------- -start-at ---------*/
+
+// startAt is when a -start-at batch begins; zero to begin at once.
+var startAt time.Time
+
+// preflighted lists the VMs of a -start-at batch whose checks passed when
+// it was queued, so processVM does not ask their questions again when
+// nobody may be there to answer.
+var preflighted = map[string]bool{}
+
+// preflight runs the checks processVM starts with – OVF, firmware, source
+// disks, -max-disk-size and free space – for every VM of a -start-at
+// batch, and logs in to each cluster they go to, as soon as the batch is
+// queued: failures surface while the operator is still around, not when
+// the change window opens.
+func preflight(vms []string) error {
+	failed := 0
+	clusters := map[*cluster]bool{}
+	for _, vm := range vms {
+		vm = strings.TrimSpace(vm)
+		cl, err := clusterFor(vm)
+		if err == nil {
+			clusters[cl] = true
+			err = preflightVM(vm)
+		}
+		if err != nil {
+			vmLog(vm).Error("pre-flight check failed", "err", err)
+			failed++
+			continue
+		}
+		preflighted[vm] = true
+	}
+	for cl := range clusters {
+		if err := hc3Client(cl, checkTimeout).Ping(runCtx); err != nil {
+			slog.Error("pre-flight check failed", "cluster", cl.label(), "err", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed – fix them or leave those VMs out before the window", failed)
+	}
+	slog.Info("✅ pre-flight checks passed", "vms", len(vms))
+	return nil
+}
+
+func preflightVM(vm string) error {
+	if *strictOVF {
+		if err := checkOVF(vm); err != nil {
+			return err
+		}
+	}
+	if err := checkFirmware(vm); err != nil {
+		return err
+	}
+	srcs, err := sourceDisks(vm)
+	if err != nil {
+		return err
+	}
+	if err := checkDiskSizes(vm, srcs); err != nil {
+		return err
+	}
+	if *noSpaceCheck {
+		return nil
+	}
+	return checkFreeSpace(vm, srcs)
+}
+
+// waitForStart blocks until -start-at or until the run is interrupted.
+func waitForStart() {
+	d := time.Until(startAt)
+	if d <= 0 {
+		return
+	}
+	slog.Info("⏰ waiting for -start-at", "at", startAt.Format(time.RFC3339), "in", d.Round(time.Second))
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-runCtx.Done():
+	case <-t.C:
+	}
+}
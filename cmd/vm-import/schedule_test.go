@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns the local time on the given day of the week of 12–18
+// October 2026 (Monday to Sunday).
+func at(day time.Weekday, hh, mm int) time.Time {
+	d := (int(day) + 6) % 7 // Monday first
+	return time.Date(2026, 10, 12+d, hh, mm, 0, 0, time.Local)
+}
+
+func TestParseWindows(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		in, out []time.Time
+	}{
+		{"20:00-23:00",
+			[]time.Time{at(time.Monday, 20, 0), at(time.Sunday, 22, 59)},
+			[]time.Time{at(time.Monday, 19, 59), at(time.Monday, 23, 0)}},
+		{"Mon-Fri 20:00-23:00",
+			[]time.Time{at(time.Monday, 21, 0), at(time.Friday, 20, 0)},
+			[]time.Time{at(time.Saturday, 21, 0), at(time.Sunday, 21, 0)}},
+		{"Sat 22:00-06:00", // past midnight into Sunday
+			[]time.Time{at(time.Saturday, 23, 0), at(time.Sunday, 5, 59)},
+			[]time.Time{at(time.Saturday, 5, 0), at(time.Sunday, 6, 0), at(time.Sunday, 23, 0)}},
+		{"Fri-Mon 00:00-24:00", // a range across the week's end
+			[]time.Time{at(time.Friday, 0, 0), at(time.Sunday, 12, 0), at(time.Monday, 23, 59)},
+			[]time.Time{at(time.Tuesday, 12, 0), at(time.Thursday, 23, 59)}},
+		{"tuesday 08:00-09:00, Thu 08:00-09:00",
+			[]time.Time{at(time.Tuesday, 8, 30), at(time.Thursday, 8, 30)},
+			[]time.Time{at(time.Wednesday, 8, 30)}},
+	} {
+		ws, err := parseWindows(tc.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		windows = ws
+		for _, tm := range tc.in {
+			if !inWindow(tm) {
+				t.Errorf("%q does not contain %s", tc.spec, tm.Format("Mon 15:04"))
+			}
+		}
+		for _, tm := range tc.out {
+			if inWindow(tm) {
+				t.Errorf("%q contains %s", tc.spec, tm.Format("Mon 15:04"))
+			}
+		}
+	}
+	windows = nil
+	if !inWindow(at(time.Monday, 3, 0)) {
+		t.Error("no -window should mean any time")
+	}
+}
+
+func TestParseWindowsErrors(t *testing.T) {
+	for _, spec := range []string{
+		"20:00",
+		"20:00-25:00",
+		"24:30-01:00",
+		"20:60-21:00",
+		"Xyz 20:00-21:00",
+		"Mo 20:00-21:00",
+		"Mon-Xyz 20:00-21:00",
+		"Mon Fri 20:00-21:00",
+	} {
+		if _, err := parseWindows(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestParseStartAt(t *testing.T) {
+	now := at(time.Wednesday, 12, 0)
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"13:00", at(time.Wednesday, 13, 0)},
+		{"11:00", at(time.Thursday, 11, 0)},
+		{"12:00", at(time.Thursday, 12, 0)}, // now is not after now
+		{"Fri 09:30", at(time.Friday, 9, 30)},
+		{"wed 13:00", at(time.Wednesday, 13, 0)},
+		{"Wed 11:00", at(time.Wednesday, 11, 0).AddDate(0, 0, 7)},
+		{"Mon 00:00", at(time.Monday, 0, 0).AddDate(0, 0, 7)},
+		{"2026-11-01T02:00:00Z", time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC)},
+	} {
+		got, err := parseStartAt(tc.spec, now)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%q: got %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+	for _, spec := range []string{"", "tomorrow", "25:00", "Fri", "next Fri 10:00", "Xyz 10:00"} {
+		if _, err := parseStartAt(spec, now); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+/*--------- secret references ---------*/
+
+var (
+	secretMu sync.Mutex
+	secrets  = map[string]string{} // resolved by reference
+)
+
+// secret resolves a credential given as a reference, so it need not be
+// typed where shell history and process listings show it: env:NAME reads
+// the environment variable, file:PATH the file, and cmd:COMMAND ARGS… the
+// output of a command such as a keyring or Vault CLI, e.g. "cmd:secret-tool
+// lookup service smb" or "cmd:vault kv get -field=password secret/smb".
+// Anything else is the secret itself. A trailing newline is dropped, and
+// each reference is resolved once a run.
+func secret(ref string) (string, error) {
+	kind, arg, _ := strings.Cut(ref, ":")
+	if kind != "env" && kind != "file" && kind != "cmd" {
+		return ref, nil
+	}
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	if v, ok := secrets[ref]; ok {
+		return v, nil
+	}
+	var v string
+	switch kind {
+	case "env":
+		var ok bool
+		if v, ok = os.LookupEnv(arg); !ok {
+			return "", fmt.Errorf("environment variable %s is not set", arg)
+		}
+	case "file":
+		b, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		v = string(b)
+	case "cmd":
+		args := strings.Fields(arg)
+		if len(args) == 0 {
+			return "", fmt.Errorf("cmd: names no command")
+		}
+		out, err := exec.CommandContext(runCtx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", args[0], err)
+		}
+		v = string(out)
+	}
+	v = strings.TrimRight(v, "\r\n")
+	secrets[ref] = v
+	return v, nil
+}
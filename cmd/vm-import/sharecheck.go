@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*--------- staging dir and share check ---------*/
+
+// shareProbe is the file checkShares writes to the staging dir and looks
+// for through each cluster's share.
+const shareProbe = ".vm-import-probe"
+
+// checkShares catches the most common import failure – HC3 cannot read
+// what was staged – before any data moves. It writes a probe file to the
+// staging dir, then for every SMB cluster dials the server, logs in with
+// the share's credentials, the ones HC3 uses, and looks for the probe
+// through the share, which shows the share is where the staged files
+// land. -dry-run and -demo skip the SMB checks; NFS exports are dialled
+// regardless.
+func checkShares() error {
+	probe := !*noShareCheck && !*dryRun && !*demo
+	if probe {
+		if err := stage.Put(shareProbe, strings.NewReader("vm-import "+time.Now().Format(time.RFC3339)+"\n")); err != nil {
+			return fmt.Errorf("cannot write to the staging dir: %w", err)
+		}
+		defer stage.Remove(shareProbe)
+	}
+	for _, cl := range allClusters() {
+		var err error
+		switch {
+		case cl.protocol() == "nfs":
+			err = checkNFSExport(cl.share())
+		case probe:
+			if err = checkClusterShare(cl); err == nil {
+				err = checkSMBProbe(cl)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", cl.label(), err)
+		}
+	}
+	return nil
+}
+
+// checkSMBProbe logs in to the SMB share and looks for the shareProbe file
+// the staging dir was just given.
+func checkSMBProbe(cl *cluster) error {
+	if err := checkSMBLogin(cl); err != nil {
+		return fmt.Errorf("share login: %w", err)
+	}
+	s, err := newSMBStager(cl)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	found, err := s.Glob(shareProbe)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("the share %s does not show the files written to the staging dir – -share (or the cluster's share) must be where -scaledir is", redact(cl.share()))
+	}
+	return nil
+}
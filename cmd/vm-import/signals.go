@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*--------- graceful shutdown ---------*/
+
+// errInterrupted is the cause of runCtx once a signal has arrived.
+var errInterrupted = errors.New("interrupted by signal")
+
+// runCtx is cancelled by the first SIGINT/SIGTERM. Copies stop at their
+// next read, partial destination files are removed, and the run winds down
+// through its normal cleanup (history, report, job queue, lock).
+var runCtx, stopRun = context.WithCancelCause(context.Background())
+
+// trapSignals installs the handler; a second signal exits immediately.
+func trapSignals() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		slog.Warn("signal received – aborting the current copy and cleaning up (repeat to force quit)", "signal", sig.String())
+		stopRun(errInterrupted)
+		<-ch
+		slog.Error("forced exit")
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// interrupted returns errInterrupted once a signal has arrived, else nil.
+func interrupted() error {
+	if runCtx.Err() != nil {
+		return context.Cause(runCtx)
+	}
+	return nil
+}
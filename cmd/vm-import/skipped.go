@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+/*--------- discovery explainer ---------*/
+
+// explainDiscovery is -show-skipped: it lists every directory discovery
+// looks at, marking the VMs it offers and giving the reason for each one
+// it leaves out, plus problems that will fail a VM that is offered.
+func explainDiscovery() error {
+	dirs, err := vmDirs()
+	if err != nil {
+		return err
+	}
+	out := stdout{}
+	found, skipped := 0, 0
+	for _, vm := range dirs {
+		reason, fatal := skipReason(vm)
+		switch {
+		case reason == "":
+			found++
+			fmt.Fprintf(out, "  ✓ %s\n", vm)
+		case fatal:
+			skipped++
+			fmt.Fprintf(out, "  ✗ %s: %s\n", vm, reason)
+		default:
+			found++
+			fmt.Fprintf(out, "  ⚠ %s: offered, but will fail: %s\n", vm, reason)
+		}
+	}
+	fmt.Fprintf(out, "%d directories in %s: %d offered, %d skipped\n", len(dirs), *ovaDir, found, skipped)
+	return nil
+}
+
+// skipReason says why vm is not offered (skip true), or what will make it
+// fail once selected; "" when nothing is wrong that can be seen up front.
+func skipReason(vm string) (reason string, skip bool) {
+	ok, err := isExport(vm)
+	switch {
+	case err != nil:
+		return err.Error(), true
+	case !ok:
+		return "no .ovf, Hyper-V, XVA or Proxmox export", true
+	case !stage.Exists(xmlPath(vm)):
+		return "no Scale XML at " + under(*scaleDir, xmlPath(vm)), true
+	}
+	if _, err := readScaleXML(xmlPath(vm)); err != nil {
+		return "Scale XML: " + err.Error(), false
+	}
+	// with several descriptors and no selector, reading the OVF would ask
+	ovfs, _ := ova.Glob(path.Join(vm, "*.ovf"))
+	if v := plan.vm(vm); len(ovfs) > 1 && *ovfName == "" && (v == nil || v.OVF == "") {
+		return fmt.Sprintf("%d OVF descriptors (%s); choose one with -ovf-name", len(ovfs), baseNames(ovfs)), false
+	}
+	if _, err := readOVF(vm); err != nil {
+		return "OVF: " + err.Error(), false
+	}
+	if _, err := sourceDisks(vm); err != nil {
+		return "source disks: " + err.Error(), false
+	}
+	return "", false
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/smb"
+)
+
+/*--------- SMB staging backend ---------*/
+
+// smbTimeout bounds each request to the SMB server; a 1MiB write that
+// takes longer means the server or the network is stuck.
+const smbTimeout = 2 * time.Minute
+
+// smbStager writes staged files straight to the share named by -share
+// with the built-in SMB client, logging in with the share's credentials,
+// so no local mount of the share is needed. A session lost to a network
+// failure or a server restart is dialled again on the next call.
+type smbStager struct {
+	addr  string // host[:port]
+	share string
+	dir   string // directory below the share root
+	opts  []smb.Option
+
+	mu sync.Mutex
+	s  *smb.Session
+	sh *smb.Share
+}
+
+func newSMBStager(cl *cluster) (*smbStager, error) {
+	u, err := cl.smbShare()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	s := &smbStager{addr: u.Host, share: parts[0], opts: []smb.Option{smb.WithTimeout(smbTimeout)}}
+	if len(parts) == 2 {
+		s.dir = parts[1]
+	}
+	if u.User != nil {
+		user := u.User.Username()
+		if i := strings.Index(user, ";"); i >= 0 {
+			s.opts = append(s.opts, smb.WithDomain(user[:i]))
+			user = user[i+1:]
+		}
+		pass, _ := u.User.Password()
+		s.opts = append(s.opts, smb.WithCredentials(user, pass))
+	}
+	return s, nil
+}
+
+// remote converts a staging name into one relative to the share root.
+func (s *smbStager) remote(name string) string { return path.Join(s.dir, name) }
+
+// mount returns the mounted share, dialling the server first if there is
+// no session.
+func (s *smbStager) mount() (*smb.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sh != nil {
+		return s.sh, nil
+	}
+	ctx, cancel := context.WithTimeout(runCtx, checkTimeout)
+	defer cancel()
+	sess, err := smb.Dial(ctx, s.addr, s.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("smb %s: %w", s.addr, err)
+	}
+	sh, err := sess.Mount(s.share)
+	if err != nil {
+		sess.Logoff()
+		return nil, fmt.Errorf("smb %s: %w", s.addr, err)
+	}
+	s.s, s.sh = sess, sh
+	return sh, nil
+}
+
+// do runs op on the share. When op fails because the session is gone –
+// the connection broke or the server dropped it – the session is dropped
+// and, if retry, op runs once more on a new one.
+func (s *smbStager) do(retry bool, op func(sh *smb.Share) error) error {
+	for {
+		sh, err := s.mount()
+		if err != nil {
+			return err
+		}
+		err = op(sh)
+		if !sessionLost(err) {
+			return err
+		}
+		s.mu.Lock()
+		if s.sh == sh {
+			s.s.Logoff()
+			s.s, s.sh = nil, nil
+		}
+		s.mu.Unlock()
+		if !retry {
+			return err
+		}
+		retry = false
+	}
+}
+
+func sessionLost(err error) bool {
+	return errors.Is(err, smb.ErrClosed) || errors.Is(err, smb.StatusUserSessionDeleted) ||
+		errors.Is(err, smb.StatusNetworkSessionExpired) || errors.Is(err, smb.StatusNetworkNameDeleted)
+}
+
+// Glob lists the directory of pattern and matches the names against it;
+// staging patterns have wildcards in the last element only.
+func (s *smbStager) Glob(pattern string) ([]string, error) {
+	dir, base := path.Split(pattern)
+	var fis []fs.FileInfo
+	err := s.do(true, func(sh *smb.Share) (err error) {
+		fis, err = sh.ReadDir(s.remote(dir))
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		if ok, err := path.Match(base, fi.Name()); err != nil {
+			return nil, err
+		} else if ok {
+			names = append(names, path.Join(dir, fi.Name()))
+		}
+	}
+	return names, nil
+}
+
+func (s *smbStager) Exists(name string) bool {
+	_, err := s.stat(name)
+	return err == nil
+}
+
+func (s *smbStager) stat(name string) (fi fs.FileInfo, err error) {
+	err = s.do(true, func(sh *smb.Share) error {
+		fi, err = sh.Stat(s.remote(name))
+		return err
+	})
+	return fi, err
+}
+
+func (s *smbStager) ReadFile(name string) (b []byte, err error) {
+	err = s.do(true, func(sh *smb.Share) error {
+		b, err = sh.ReadFile(s.remote(name))
+		return err
+	})
+	return b, err
+}
+
+// Put streams r to the share. Missing parent directories are created
+// first, as the other backends do. A failed upload is removed; it is not
+// retried, as r cannot be read again.
+func (s *smbStager) Put(name string, r io.Reader) error {
+	if err := s.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	err := s.do(false, func(sh *smb.Share) error {
+		f, err := sh.Create(s.remote(name))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			sh.Remove(s.remote(name))
+			return err
+		}
+		return f.Close()
+	})
+	return err
+}
+
+// mkdirAll creates dir and any missing parents on the share.
+func (s *smbStager) mkdirAll(dir string) error {
+	return s.do(true, func(sh *smb.Share) error { return sh.MkdirAll(s.remote(dir)) })
+}
+
+func (s *smbStager) Remove(name string) error {
+	return s.do(true, func(sh *smb.Share) error { return sh.Remove(s.remote(name)) })
+}
+
+func (s *smbStager) Rename(oldname, newname string) error {
+	return s.do(true, func(sh *smb.Share) error { return sh.Rename(s.remote(oldname), s.remote(newname)) })
+}
+
+func (s *smbStager) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.s == nil {
+		return nil
+	}
+	err := s.s.Logoff()
+	s.s, s.sh = nil, nil
+	return err
+}
+
+// TryLock creates a directory on the share, which fails if it exists.
+func (s *smbStager) TryLock(name, owner string) (func() error, string, error) {
+	if err := s.mkdirAll(path.Dir(name)); err != nil {
+		return nil, "", err
+	}
+	err := s.do(true, func(sh *smb.Share) error { return sh.Mkdir(s.remote(name)) })
+	if errors.Is(err, fs.ErrExist) {
+		holder, _ := s.ReadFile(path.Join(name, "owner"))
+		return nil, strings.TrimSpace(string(holder)), errLocked
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.Put(path.Join(name, "owner"), strings.NewReader(owner+"\n")); err != nil {
+		s.Remove(name)
+		return nil, "", err
+	}
+	return func() error {
+		if err := s.Remove(path.Join(name, "owner")); err != nil {
+			return err
+		}
+		return s.Remove(name)
+	}, "", nil
+}
+
+func (s *smbStager) Free(dir string) (n int64, err error) {
+	err = s.do(true, func(sh *smb.Share) error {
+		n, err = sh.Free(s.remote(dir))
+		return err
+	})
+	return n, err
+}
+
+func (s *smbStager) Size(name string) (int64, error) {
+	fi, err := s.stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3/hc3test"
+	"gcosmiclentil89/ScaleVMFromOVA/smb/smbtest"
+)
+
+func TestSMBBackend(t *testing.T) {
+	srv := hc3test.NewServer(hc3test.WithCredentials("admin", "secret"))
+	defer srv.Close()
+	dir := t.TempDir()
+	testExport(t, dir, "vm1")
+	// the export is staged on the share, with no mount at -scaledir
+	smbSrv := smbtest.NewServer("sambashare", filepath.Join(dir, "scale"), smbtest.WithCredentials("sambauser", "pw"), smbtest.WithSigning())
+	defer smbSrv.Close()
+
+	share := "smb://WORKGROUP;sambauser:pw@" + smbSrv.Addr + "/sambashare/"
+	code, out := runTool(t, dir, srv, "-vms", "vm1", "-backend", "smb", "-share", share, "-scaledir", filepath.Join(dir, "unmounted"))
+	if code != 0 {
+		t.Fatalf("exit status %d:\n%s", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scale", "vm1", "22222222-2222-2222-2222-222222222222.qcow2")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unmounted")); !os.IsNotExist(err) {
+		t.Errorf("the smb backend wrote to -scaledir: %v", err)
+	}
+}
+
+func TestSMBStagerRedials(t *testing.T) {
+	dir := t.TempDir()
+	srv := smbtest.NewServer("sambashare", dir)
+	defer srv.Close()
+	s, err := newSMBStager(&cluster{Share: "smb://" + srv.Addr + "/sambashare/staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Put("vm1/vm1.xml", strings.NewReader("<domain/>")); err != nil {
+		t.Fatal(err)
+	}
+	srv.DropConnections()
+	names, err := s.Glob("vm1/*.xml")
+	if err != nil || strings.Join(names, ",") != "vm1/vm1.xml" {
+		t.Errorf("Glob after a dropped connection = %v, %v", names, err)
+	}
+}
+
+func TestSMBLoginHints(t *testing.T) {
+	srv := smbtest.NewServer("sambashare", t.TempDir(), smbtest.WithCredentials("sambauser", "pw"))
+	defer srv.Close()
+	for share, want := range map[string]string{
+		"smb://sambauser:pw@" + srv.Addr + "/sambashare/":        "",
+		"smb://sambauser:wrong@" + srv.Addr + "/sambashare/":     "STATUS_LOGON_FAILURE: user or password in -share rejected",
+		"smb://sambauser:pw@" + srv.Addr + "/other/":             "STATUS_BAD_NETWORK_NAME: no such share on the server (check the share name in -share)",
+		"smb://sambauser:pw@" + srv.Addr + "/sambashare/nodir/x": "STATUS_OBJECT_PATH_NOT_FOUND: no such directory on the share (check the path in -share)",
+	} {
+		err := checkSMBLogin(&cluster{Share: share})
+		if got := ""; err != nil {
+			if got = err.Error(); got != want {
+				t.Errorf("%s: %v, want %s", share, got, want)
+			}
+		} else if want != "" {
+			t.Errorf("%s: no error, want %s", share, want)
+		}
+	}
+}
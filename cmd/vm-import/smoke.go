@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- smoke test ---------*/
+
+const (
+	// smokeWait is how long -smoke-test waits for the guest agent unless
+	// -wait-guest says otherwise.
+	smokeWait = 10 * time.Minute
+	// smokeCheckWindow bounds the retries of the -smoke-check address, as
+	// services come up a while after the guest agent.
+	smokeCheckWindow = 2 * time.Minute
+	// shutdownTimeout is how long the guest gets to shut down before the
+	// VM is powered off.
+	shutdownTimeout = 2 * time.Minute
+)
+
+// smokeTest checks the started VM q, a copy of vm: its guest agent must
+// report and the -smoke-check address (or the manifest's smokeCheck for
+// vm) must answer. The VM is shut down again afterwards whatever the
+// outcome, so the duplicate doesn't keep running next to the source.
+func smokeTest(ctx context.Context, vm string, q queuedImport) (ips []string, err error) {
+	defer func() { err = errors.Join(err, shutDown(ctx, q)) }()
+	if ips, err = waitForGuest(ctx, q); err != nil {
+		return ips, err
+	}
+	addr := *smokeCheck
+	if v := plan.vm(vm); v != nil && v.SmokeCheck != "" {
+		addr = v.SmokeCheck
+	}
+	if addr == "" {
+		return ips, nil
+	}
+	if strings.Contains(addr, "{{ip}}") {
+		if len(ips) == 0 {
+			return ips, fmt.Errorf("smoke check %s: the guest reports no IP address", addr)
+		}
+		addr = strings.ReplaceAll(addr, "{{ip}}", ips[0])
+	}
+	ctx, cancel := context.WithTimeout(ctx, smokeCheckWindow)
+	defer cancel()
+	for {
+		err := reach(ctx, addr)
+		if err == nil {
+			vmLog(q.vm).Info("✅ smoke check answered", "addr", addr)
+			return ips, nil
+		}
+		vmLog(q.vm).Debug("smoke check", "addr", addr, "err", err)
+		select {
+		case <-ctx.Done():
+			return ips, fmt.Errorf("smoke check %s: no answer within %s: %w", addr, smokeCheckWindow, err)
+		case <-time.After(guestPollInterval):
+		}
+	}
+}
+
+// reach connects to addr if it has a port, else pings it once.
+func reach(ctx context.Context, addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		var d net.Dialer
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		c, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	args := []string{"-c", "1", "-W", "2", addr}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", "2000", addr}
+	}
+	if out, err := exec.CommandContext(ctx, "ping", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ping: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// shutDown asks the guest of q to shut down and powers the VM off if it is
+// still running after shutdownTimeout. It runs even when ctx is done, so
+// an interrupted smoke test doesn't leave the VM running.
+func shutDown(ctx context.Context, q queuedImport) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout+time.Minute)
+	defer cancel()
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	vmLog(q.vm).Info("⏹ shutting down", "uuid", q.uuid)
+	task, err := c.Action(ctx, q.uuid, hc3.ActionShutdown)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	if err == nil {
+		err = waitShutOff(ctx, c, q.uuid)
+	}
+	if err != nil {
+		vmLog(q.vm).Warn("guest did not shut down – powering off", "err", err)
+		if task, err = c.Action(ctx, q.uuid, hc3.ActionStop); err == nil {
+			err = c.WaitTask(ctx, task, taskPollInterval)
+		}
+	}
+	audit("shutdown", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return fmt.Errorf("shut down: %w", err)
+	}
+	return nil
+}
+
+// waitShutOff polls the VM until it is off, for at most shutdownTimeout.
+func waitShutOff(ctx context.Context, c *hc3.Client, uuid string) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, shutdownTimeout, fmt.Errorf("still running after %s", shutdownTimeout))
+	defer cancel()
+	for {
+		v, err := c.VM(ctx, uuid)
+		if err == nil && v.State == "SHUTOFF" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(guestPollInterval):
+		}
+	}
+}
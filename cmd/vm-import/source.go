@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------- OVA sources ---------*/
+
+// ovaSource abstracts where exported OVAs are read from. Names are
+// slash-separated and relative to the OVA root, e.g. "centos7/centos7.ovf".
+type ovaSource interface {
+	Dirs(dir string) ([]string, error) // subdirectories of dir ("" for the root)
+	Glob(pattern string) ([]string, error)
+	Open(name string) (io.ReadCloser, error)
+	Size(name string) (int64, error)
+	ModTime(name string) (time.Time, error)
+}
+
+// ova is the active OVA source, selected by newOVASource.
+var ova ovaSource
+
+func newOVASource() (ovaSource, error) {
+	if strings.HasPrefix(*ovaDir, "s3://") {
+		return newS3Source(*ovaDir)
+	}
+	return localSource{root: *ovaDir}, nil
+}
+
+/*--------- local filesystem ---------*/
+
+type localSource struct{ root string }
+
+func (l localSource) path(name string) string { return filepath.Join(l.root, filepath.FromSlash(name)) }
+
+// contained makes sure the file rel of vm, with symlinks resolved, is
+// inside vm's directory, so a crafted export cannot have other files read.
+func (l localSource) contained(vm, rel string) error {
+	dir, err := filepath.EvalSymlinks(l.path(vm))
+	if err != nil {
+		return err
+	}
+	p, err := filepath.EvalSymlinks(l.path(path.Join(vm, rel)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // reported as missing when it is opened
+		}
+		return err
+	}
+	if !within(dir, p) {
+		return fmt.Errorf("%s resolves to %s, outside the VM directory", rel, p)
+	}
+	return nil
+}
+
+func (l localSource) Dirs(dir string) ([]string, error) {
+	ents, err := os.ReadDir(l.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	seen := map[string]string{}
+	for _, e := range ents {
+		name := path.Join(dir, e.Name())
+		switch {
+		case e.IsDir():
+			out = append(out, name)
+		case e.Type()&fs.ModeSymlink != 0:
+			real, ok := l.followDir(dir, name)
+			if !ok {
+				continue
+			}
+			prev, dup := seen[real]
+			if !dup {
+				seen[real] = name
+				out = append(out, name)
+				continue
+			}
+			// of links to the same directory, the one named like it is kept
+			if e.Name() == filepath.Base(real) {
+				out = slices.Replace(out, slices.Index(out, prev), slices.Index(out, prev)+1, name)
+				seen[real], name, prev = name, prev, name
+			}
+			warnLink(name, "skipping symlink to the directory "+path.Base(prev)+" links to as well", "target", real)
+		}
+	}
+	return out, nil
+}
+
+// warnedLinks are the symlinks warned about, so -watch warns once each.
+var warnedLinks sync.Map
+
+func warnLink(name, msg string, args ...any) {
+	if _, done := warnedLinks.LoadOrStore(name+"\x00"+msg, true); !done {
+		vmLog(name).Warn(msg, args...)
+	}
+}
+
+// followDir reports whether the symlink name in dir is taken for a
+// directory, and where it resolves to. Only with -follow-symlinks, and
+// not when it leads back to dir or above – a loop – or elsewhere into the
+// OVA root, whose directories are listed where they are.
+func (l localSource) followDir(dir, name string) (string, bool) {
+	fi, err := os.Stat(l.path(name))
+	switch {
+	case err != nil:
+		warnLink(name, "skipping broken symlink", "err", err)
+		return "", false
+	case !fi.IsDir():
+		return "", false
+	case !*followLinks:
+		warnLink(name, "skipping symlinked directory; set -follow-symlinks to import it")
+		return "", false
+	}
+	real, err := filepath.EvalSymlinks(l.path(name))
+	if err != nil {
+		warnLink(name, "skipping symlink", "err", err)
+		return "", false
+	}
+	parent, err := filepath.EvalSymlinks(l.path(dir))
+	if err != nil {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(l.root)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case within(real, parent):
+		warnLink(name, "skipping symlink loop", "target", real)
+		return "", false
+	case within(root, real):
+		warnLink(name, "skipping symlink into -ovadir; the directory is listed where it is", "target", real)
+		return "", false
+	}
+	return real, true
+}
+
+// within reports whether p is dir or below it.
+func within(dir, p string) bool {
+	r, err := filepath.Rel(dir, p)
+	return err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator))
+}
+
+// localGlob is filepath.Glob for a slash-separated pattern below root,
+// with wildcards only in its last element: directory names such as
+// "web[1]" are taken literally. Matching ignores case on Windows, as its
+// filesystems do, so *.ovf finds VM.OVF. The matches are slash-separated
+// and relative to root.
+func localGlob(root, pattern string) ([]string, error) {
+	dir, base := path.Split(pattern)
+	if _, err := path.Match(base, ""); err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, nil // like filepath.Glob, which ignores I/O errors
+	}
+	fold := func(s string) string { return s }
+	if runtime.GOOS == "windows" {
+		fold = strings.ToLower
+	}
+	var out []string
+	for _, e := range ents {
+		if ok, _ := path.Match(fold(base), fold(e.Name())); ok {
+			out = append(out, path.Join(dir, e.Name()))
+		}
+	}
+	return out, nil
+}
+
+func (l localSource) Glob(pattern string) ([]string, error) { return localGlob(l.root, pattern) }
+
+func (l localSource) Open(name string) (io.ReadCloser, error) { return os.Open(l.path(name)) }
+
+func (l localSource) ModTime(name string) (time.Time, error) {
+	fi, err := os.Stat(l.path(name))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (l localSource) Size(name string) (int64, error) {
+	fi, err := os.Stat(l.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
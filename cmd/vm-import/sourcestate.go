@@ -0,0 +1,51 @@
+package main
+
+import "log/slog"
+
+/*--------- source power state ---------*/
+
+// vsPowerStates maps the inventory names of vSphere VMs to their power
+// state – running, off or suspended – as read before the run.
+var vsPowerStates map[string]string
+
+// readSourceStates reads the power state of every VM from -vsphere, for
+// -source-state auto. Failing to is only warned about.
+func readSourceStates() {
+	if *srcState != "auto" || *vsURL == "" {
+		return
+	}
+	c, err := newVSphereClient(*vsURL, *vsUser, *vsPass)
+	if err == nil {
+		var objs []vsObject
+		if objs, err = c.vms("runtime.powerState"); err == nil {
+			vsPowerStates = map[string]string{}
+			for _, o := range objs {
+				vsPowerStates[o.prop("name")] = map[string]string{
+					"poweredOn": "running", "poweredOff": "off", "suspended": "suspended",
+				}[o.prop("runtime.powerState")]
+			}
+		}
+	}
+	if err != nil {
+		slog.Warn("cannot read the source VMs' power state from vSphere", "err", err)
+	}
+}
+
+// checkSourceState returns the power state of vm's source – as
+// -source-state says, or vSphere reported it – or "" if unknown, and warns
+// if the source is not off: its export is crash-consistent at best, and
+// stale as soon as the source writes again, so the imported VM would miss
+// those changes.
+func checkSourceState(vm string) string {
+	st := *srcState
+	if st == "auto" {
+		st = vsPowerStates[xmlText(vm)]
+	}
+	switch st {
+	case "running":
+		vmLog(vm).Warn("the source VM is still running – its export is crash-consistent at best and misses what it writes from now on; shut it down and sync again before the final import")
+	case "suspended":
+		vmLog(vm).Warn("the source VM is suspended – its export misses the memory state and what it writes once resumed; shut it down and sync again before the final import")
+	}
+	return st
+}
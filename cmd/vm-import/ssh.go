@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*--------- SSH staging backend ---------*/
+
+// sshStager stages files on another box reachable over SSH, so the
+// conversion host and the machine exposing the share to HC3 can differ.
+// It drives the system ssh client (keys/agent, known_hosts and
+// ~/.ssh/config apply as usual) and streams data through the session.
+type sshStager struct {
+	target string // [user@]host
+	port   string
+	root   string
+
+	codec     string // negotiated -compress codec, set by the first Put
+	codecOnce sync.Once
+}
+
+func newSSHStager(uri string) (*sshStager, error) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return nil, fmt.Errorf("ssh backend needs an ssh client: %w", err)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse scale dir: %w", err)
+	}
+	if u.Hostname() == "" || u.Path == "" {
+		return nil, fmt.Errorf("scale dir %q needs ssh://[user@]host/path", uri)
+	}
+	s := &sshStager{target: u.Hostname(), port: u.Port(), root: u.Path}
+	if u.User != nil {
+		s.target = u.User.Username() + "@" + s.target
+	}
+	return s, nil
+}
+
+func (s *sshStager) path(name string) string { return path.Join(s.root, name) }
+
+func (s *sshStager) run(script string, stdin io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	err := s.stream(script, stdin, &out)
+	return out.Bytes(), err
+}
+
+// stream runs script on the remote side, wiring stdin and stdout through.
+func (s *sshStager) stream(script string, stdin io.Reader, stdout io.Writer) error {
+	args := []string{"-o", "BatchMode=yes"}
+	if s.port != "" {
+		args = append(args, "-p", s.port)
+	}
+	args = append(args, s.target, script)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = stdin
+	var errb bytes.Buffer
+	cmd.Stdout, cmd.Stderr = stdout, &errb
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ssh %s: %v: %s", s.target, err, strings.TrimSpace(errb.String()))
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell on the remote side.
+func shellQuote(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
+
+var reSafeGlob = regexp.MustCompile(`^[A-Za-z0-9*?._-]+$`)
+
+func (s *sshStager) Glob(pattern string) ([]string, error) {
+	dir, base := path.Split(pattern)
+	if !reSafeGlob.MatchString(base) {
+		return nil, fmt.Errorf("unsupported remote glob %q", base)
+	}
+	script := fmt.Sprintf(`for f in %s/%s; do [ -e "$f" ] && printf '%%s\n' "${f##*/}"; done; true`,
+		shellQuote(s.path(dir)), base)
+	out, err := s.run(script, nil)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if n != "" {
+			names = append(names, path.Join(dir, n))
+		}
+	}
+	return names, nil
+}
+
+func (s *sshStager) Exists(name string) bool {
+	_, err := s.run("test -e "+shellQuote(s.path(name)), nil)
+	return err == nil
+}
+
+func (s *sshStager) ReadFile(name string) ([]byte, error) {
+	return s.run("cat "+shellQuote(s.path(name)), nil)
+}
+
+func (s *sshStager) Put(name string, r io.Reader) error {
+	p := s.path(name)
+	codec := s.negotiate()
+	if codec == "" {
+		_, err := s.run(fmt.Sprintf("mkdir -p %s && cat > %s", shellQuote(path.Dir(p)), shellQuote(p)), r)
+		return err
+	}
+	cr, wait, err := compressStream(codec, r)
+	if err != nil {
+		return err
+	}
+	_, err = s.run(fmt.Sprintf("mkdir -p %s && %s -dc > %s", shellQuote(path.Dir(p)), codec, shellQuote(p)), cr)
+	cr.Close()
+	if werr := wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// negotiate settles on the -compress codec once per session, falling back
+// to plain transfers when the remote side cannot decompress it. Parallel
+// Puts wait for the first to settle it.
+func (s *sshStager) negotiate() string {
+	s.codecOnce.Do(func() {
+		c := *compress
+		if c == "" || c == "none" {
+			return
+		}
+		if _, err := s.run("command -v "+shellQuote(c), nil); err != nil {
+			slog.Warn("compressor missing on remote – sending uncompressed", "tool", c, "host", s.target)
+			return
+		}
+		s.codec = c
+	})
+	return s.codec
+}
+
+func (s *sshStager) Remove(name string) error {
+	_, err := s.run("rm "+shellQuote(s.path(name)), nil)
+	return err
+}
+
+func (s *sshStager) Rename(oldname, newname string) error {
+	_, err := s.run("mv -f "+shellQuote(s.path(oldname))+" "+shellQuote(s.path(newname)), nil)
+	return err
+}
+
+func (s *sshStager) Close() error { return nil }
+
+// TryLock uses an atomic mkdir on the remote side; the owner is recorded
+// inside so a stale lock can be identified and removed by hand.
+func (s *sshStager) TryLock(name, owner string) (func() error, string, error) {
+	p := s.path(name)
+	if _, err := s.run(fmt.Sprintf("mkdir -p %s && mkdir %s 2>/dev/null", shellQuote(path.Dir(p)), shellQuote(p)), nil); err != nil {
+		holder, _ := s.run("cat "+shellQuote(p+"/owner"), nil)
+		return nil, strings.TrimSpace(string(holder)), errLocked
+	}
+	if err := s.Put(path.Join(name, "owner"), strings.NewReader(owner+"\n")); err != nil {
+		s.run("rm -rf "+shellQuote(p), nil)
+		return nil, "", err
+	}
+	return func() error {
+		_, err := s.run("rm -rf "+shellQuote(p), nil)
+		return err
+	}, "", nil
+}
+
+func (s *sshStager) Free(dir string) (int64, error) {
+	out, err := s.run("df -Pk "+shellQuote(s.path(dir))+" | tail -n1", nil)
+	if err != nil {
+		return 0, err
+	}
+	f := strings.Fields(string(out))
+	if len(f) < 4 {
+		return 0, fmt.Errorf("unexpected df output %q", strings.TrimSpace(string(out)))
+	}
+	kb, err := strconv.ParseInt(f[3], 10, 64)
+	return kb * 1024, err
+}
+
+func (s *sshStager) Size(name string) (int64, error) {
+	out, err := s.run("wc -c < "+shellQuote(s.path(name)), nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
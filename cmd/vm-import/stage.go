@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*--------- staging backends ---------*/
+
+// stager abstracts the place staged qcow2 images and Scale XML files live.
+// Names are slash-separated and relative to the staging root, e.g.
+// "centos7/centos7.xml".
+type stager interface {
+	Glob(pattern string) ([]string, error)
+	Exists(name string) bool
+	ReadFile(name string) ([]byte, error)
+	Put(name string, r io.Reader) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Close() error
+}
+
+// spaceChecker is implemented by backends that can report free space in a
+// directory and the size of a staged file.
+type spaceChecker interface {
+	Free(dir string) (int64, error)
+	Size(name string) (int64, error)
+}
+
+// stage is the active staging backend, selected by newStager.
+var stage stager
+
+func newStager() (stager, error) {
+	if strings.HasPrefix(*scaleDir, "ssh://") {
+		if *backend != "local" {
+			return nil, fmt.Errorf("-backend %s cannot be combined with an ssh:// scale dir", *backend)
+		}
+		return newSSHStager(*scaleDir)
+	}
+	if *compress != "none" && *compress != "gzip" && *compress != "zstd" {
+		return nil, fmt.Errorf("unknown -compress %q", *compress)
+	}
+	if *compress != "none" && *backend == "smb" {
+		slog.Warn("-compress is not supported by the smb backend – sending uncompressed")
+	}
+	switch *backend {
+	case "local", "":
+		return localStager{root: *scaleDir}, nil
+	case "smb":
+		if *exportProto != "smb" {
+			return nil, fmt.Errorf("smb backend needs -export-protocol=smb")
+		}
+		return newSMBStager(defaultCluster())
+	}
+	return nil, fmt.Errorf("unknown staging backend %q", *backend)
+}
+
+/*--------- local filesystem ---------*/
+
+type localStager struct {
+	root  string
+	netFS string // network filesystem root is on, "" if local; see checkNetworkDirs
+}
+
+func (l localStager) path(name string) string { return filepath.Join(l.root, filepath.FromSlash(name)) }
+
+func (l localStager) Glob(pattern string) ([]string, error) { return localGlob(l.root, pattern) }
+
+func (l localStager) Exists(name string) bool { return fileExists(l.path(name)) }
+
+func (l localStager) ReadFile(name string) ([]byte, error) { return os.ReadFile(l.path(name)) }
+
+func (l localStager) Put(name string, r io.Reader) error {
+	dst := l.path(name)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	switch {
+	case fsyncInterval > 0:
+		w = &syncWriter{f: out, every: fsyncInterval}
+	case *dropCache:
+		w = &syncWriter{f: out, every: dropCacheEvery}
+	}
+	size := int(copyBuffer)
+	if size == 0 && l.netFS != "" {
+		size = netCopyBuffer
+	}
+	if size > 0 {
+		// hide ReadFrom, which would copy in 32 KiB writes regardless
+		_, err = io.CopyBuffer(struct{ io.Writer }{w}, r, make([]byte, size))
+	} else {
+		_, err = io.Copy(w, r)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if err == nil && *dropCache {
+		fadviseDontNeed(out, 0, 0)
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func (l localStager) Remove(name string) error { return os.Remove(l.path(name)) }
+
+func (l localStager) Rename(oldname, newname string) error {
+	return os.Rename(l.path(oldname), l.path(newname))
+}
+
+func (l localStager) Close() error { return nil }
+
+func (l localStager) Free(dir string) (int64, error) { return diskFree(l.path(dir)) }
+
+func (l localStager) Size(name string) (int64, error) {
+	fi, err := os.Stat(l.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
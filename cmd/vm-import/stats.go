@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*--------- batch statistics ---------*/
+
+// modeStats sums up the disks of a batch staged one way: copy, convert
+// or delta.
+type modeStats struct {
+	disks   int
+	bytes   int64         // source bytes
+	written int64         // bytes written to the staging dir
+	time    time.Duration // spent staging them, summed over the disks
+	cpu     time.Duration // qemu-img CPU time
+}
+
+// stepStats sums up a pipeline step over the VMs of a batch.
+type stepStats struct {
+	name    string
+	total   time.Duration
+	slowest time.Duration
+	vm      string // the VM it took longest for
+}
+
+// batchStats are a batch's timings and throughput, for tuning -parallel,
+// -max-copies and -max-conversions and planning the waves still to come.
+type batchStats struct {
+	wall  time.Duration
+	modes map[string]*modeStats
+	steps []*stepStats // slowest first
+}
+
+func newBatchStats(runs []*runRecord, wall time.Duration) batchStats {
+	s := batchStats{wall: wall, modes: map[string]*modeStats{}}
+	steps := map[string]*stepStats{}
+	for _, r := range runs {
+		for _, d := range r.Disks {
+			m := s.modes[d.Mode]
+			if m == nil {
+				m = &modeStats{}
+				s.modes[d.Mode] = m
+			}
+			m.disks++
+			m.bytes += d.Size
+			if d.Mode == "delta" {
+				m.written += d.Written
+			} else {
+				m.written += d.Size
+			}
+			m.time += d.Duration
+			m.cpu += d.CPU
+		}
+		for _, st := range r.Steps {
+			t := steps[st.Name]
+			if t == nil {
+				t = &stepStats{name: st.Name}
+				steps[st.Name] = t
+				s.steps = append(s.steps, t)
+			}
+			t.total += st.Duration
+			if st.Duration > t.slowest {
+				t.slowest, t.vm = st.Duration, r.VM
+			}
+		}
+	}
+	sort.SliceStable(s.steps, func(i, j int) bool { return s.steps[i].total > s.steps[j].total })
+	return s
+}
+
+// total sums the modes up.
+func (s batchStats) total() modeStats {
+	var t modeStats
+	for _, m := range s.modes {
+		t.disks += m.disks
+		t.bytes += m.bytes
+		t.written += m.written
+		t.time += m.time
+		t.cpu += m.cpu
+	}
+	return t
+}
+
+// mbps is the throughput of n bytes in d, in MB/s.
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / 1e6 / d.Seconds()
+}
+
+// print writes the statistics for the operator: the disks staged and the
+// throughput of the batch as a whole and of a single disk on average, by
+// staging mode, then where the time went, step by step.
+func (s batchStats) print(w io.Writer) {
+	t := s.total()
+	if t.disks == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n📊 %d disk(s), %s written in %s: %.0f MB/s for the batch, %.0f MB/s per disk on average\n",
+		t.disks, humanBytes(t.written), s.wall.Round(time.Second), mbps(t.written, s.wall), mbps(t.written, t.time))
+	for _, mode := range []string{"copy", "convert", "delta"} {
+		m := s.modes[mode]
+		if m == nil {
+			continue
+		}
+		fmt.Fprintf(w, "   %-8s %3d disk(s)  %10s in %9s  %5.0f MB/s", mode, m.disks, humanBytes(m.bytes), m.time.Round(time.Second), mbps(m.bytes, m.time))
+		switch mode {
+		case "convert":
+			fmt.Fprintf(w, "  qemu-img CPU %s", m.cpu.Round(time.Second))
+		case "delta":
+			fmt.Fprintf(w, "  %s rewritten", humanBytes(m.written))
+		}
+		fmt.Fprintln(w)
+	}
+	var steps []string
+	for _, st := range s.steps {
+		if st.total < time.Second {
+			continue
+		}
+		steps = append(steps, fmt.Sprintf("%s %s (%s %s)", st.name, st.total.Round(time.Second), st.vm, st.slowest.Round(time.Second)))
+	}
+	if len(steps) > 0 {
+		fmt.Fprintf(w, "   steps, summed over the VMs (slowest VM): %s\n", strings.Join(steps, " · "))
+	}
+}
+
+// log writes the statistics as one record, for unattended runs.
+func (s batchStats) log() {
+	t := s.total()
+	if t.disks == 0 {
+		return
+	}
+	var steps []any
+	for _, st := range s.steps {
+		steps = append(steps, slog.Int(st.name, int(st.total.Seconds())))
+	}
+	slog.Info("batch statistics", "disks", t.disks, "bytes", t.bytes, "written", t.written,
+		"mb_per_second", int(mbps(t.written, s.wall)), "disk_mb_per_second", int(mbps(t.written, t.time)),
+		"convert_cpu_seconds", int(t.cpu.Seconds()), slog.Group("step_seconds", steps...))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- status: what became of recorded imports ---------*/
+
+// runStatus implements "vm-import status [flags] [vm|task ...]": it looks
+// each VM name or task tag up in tasks.json – a VM's newest task, or
+// every task with that tag – and asks its cluster for the task's state
+// and the VM it created. Without arguments it checks the newest task of
+// every recorded VM. It takes the flags of a run for the clusters and
+// their credentials, and fails if a task failed or a cluster could not
+// be asked.
+func runStatus(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if err := setupContainer(); err != nil {
+		return fmt.Errorf("reading configuration from the environment: %w", err)
+	}
+	if err := setupLogging(); err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	defer startDemo()()
+	if err := setupClusters(); err != nil {
+		return fmt.Errorf("setting up target clusters: %w", err)
+	}
+	tasks, err := readTasks()
+	if err != nil {
+		return err
+	}
+
+	type match struct {
+		vm string
+		t  taskRecord
+	}
+	var sel []match
+	if flag.NArg() == 0 {
+		for vm, l := range tasks {
+			sel = append(sel, match{vm, l[len(l)-1]})
+		}
+		sort.Slice(sel, func(i, j int) bool { return sel[i].t.Queued.Before(sel[j].t.Queued) })
+	}
+	for _, arg := range flag.Args() {
+		if l := tasks[arg]; len(l) > 0 {
+			sel = append(sel, match{arg, l[len(l)-1]})
+			continue
+		}
+		n := len(sel)
+		for vm, l := range tasks {
+			for _, t := range l {
+				if t.Task == arg {
+					sel = append(sel, match{vm, t})
+				}
+			}
+		}
+		if len(sel) == n {
+			return fmt.Errorf("%s: no import of a VM or task of that name recorded", arg)
+		}
+	}
+	if len(sel) == 0 {
+		fmt.Fprintln(stdout{}, "no imports recorded")
+		return nil
+	}
+
+	failed, unknown := 0, 0
+	for _, m := range sel {
+		fmt.Fprintf(stdout{}, "%s  task %s on %s, queued %s\n", m.vm, m.t.Task, cmp.Or(m.t.Cluster, "default"), m.t.Queued.Local().Format("2006-01-02 15:04"))
+		cl := defaultCluster()
+		if m.t.Cluster != "" {
+			if cl = clusters[m.t.Cluster]; cl == nil {
+				fmt.Fprintf(stdout{}, "  ❌ cluster %s is not defined; pass its -cluster or -clusters\n", m.t.Cluster)
+				unknown++
+				continue
+			}
+		}
+		if cl.api() != m.t.API {
+			fmt.Fprintf(stdout{}, "  -  recorded against %s, asking %s\n", m.t.API, cl.api())
+		}
+		state, err := importStatus(runCtx, m.vm, m.t, cl)
+		switch {
+		case err != nil:
+			fmt.Fprintf(stdout{}, "  ❌ %v\n", err)
+			unknown++
+		case state == hc3.TaskError:
+			failed++
+		}
+	}
+	switch {
+	case failed > 0:
+		return fmt.Errorf("%d import(s) failed", failed)
+	case unknown > 0:
+		return fmt.Errorf("%d import(s) could not be checked", unknown)
+	}
+	return nil
+}
+
+// importStatus prints the state of the recorded import task t of vm on
+// cl and of the VM it created, returns the task's state and records its
+// end in tasks.json.
+func importStatus(ctx context.Context, vm string, t taskRecord, cl *cluster) (hc3.TaskState, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	c := hc3Client(cl, checkTimeout)
+	st, err := c.Task(ctx, t.Task)
+	switch {
+	case errors.Is(err, hc3.ErrNotFound):
+		fmt.Fprintf(stdout{}, "  -  task gone from HC3; it was %s\n", cmp.Or(t.State, "not seen to end"))
+	case err != nil:
+		return "", fmt.Errorf("task: %w", err)
+	case st.State == hc3.TaskError:
+		fmt.Fprintf(stdout{}, "  ❌ task failed: %s\n", st.FormattedMessage)
+		recordTaskState(vm, t.Task, "failed", cl)
+	case st.State == hc3.TaskComplete:
+		fmt.Fprintf(stdout{}, "  ✅ task finished\n")
+		recordTaskState(vm, t.Task, "finished", cl)
+	default:
+		fmt.Fprintf(stdout{}, "  ⏳ task %s, %d%%\n", st.State, st.ProgressPercent)
+	}
+	if st.State == "" && t.State == "failed" {
+		st.State = hc3.TaskError
+	}
+
+	v, err := c.VM(ctx, t.UUID)
+	switch {
+	case errors.Is(err, hc3.ErrNotFound):
+		fmt.Fprintf(stdout{}, "  -  VM %s not found (deleted, or not created yet)\n", t.UUID)
+	case err != nil:
+		return st.State, fmt.Errorf("VM: %w", err)
+	default:
+		fmt.Fprintf(stdout{}, "  ✅ VM %s (%s), %s\n", v.Name, v.UUID, v.State)
+	}
+	return st.State, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+func systemLogHandler(level slog.Level) (slog.Handler, error) {
+	return nil, errors.New("-syslog and -journald are not supported on this platform")
+}
@@ -0,0 +1,87 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const syslogTag = "vm-import"
+
+// systemLogHandler returns a handler for -syslog and/or -journald.
+func systemLogHandler(level slog.Level) (slog.Handler, error) {
+	var hs multiHandler
+	if *syslogAddr != "" {
+		w, err := dialSyslog(*syslogAddr)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to syslog: %w", err)
+		}
+		hs = append(hs, &consoleHandler{level: level, mu: &sync.Mutex{}, emit: func(l slog.Level, line string) error {
+			switch {
+			case l >= slog.LevelError:
+				return w.Err(line)
+			case l >= slog.LevelWarn:
+				return w.Warning(line)
+			case l >= slog.LevelInfo:
+				return w.Info(line)
+			}
+			return w.Debug(line)
+		}})
+	}
+	if *journald {
+		conn, err := net.Dial("unixgram", "/run/systemd/journal/socket")
+		if err != nil {
+			return nil, fmt.Errorf("connecting to journald: %w", err)
+		}
+		hs = append(hs, &consoleHandler{level: level, mu: &sync.Mutex{}, emit: func(l slog.Level, line string) error {
+			return journalSend(conn, l, line)
+		}})
+	}
+	if len(hs) == 1 {
+		return hs[0], nil
+	}
+	return hs, nil
+}
+
+// dialSyslog connects to the local syslog daemon for "local", otherwise to
+// the udp:// or tcp:// address given.
+func dialSyslog(addr string) (*syslog.Writer, error) {
+	if addr == "local" {
+		return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, syslogTag)
+	}
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("-syslog wants \"local\" or udp://host:port / tcp://host:port, got %q", addr)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(host, "514")
+	}
+	return syslog.Dial(u.Scheme, host, syslog.LOG_DAEMON|syslog.LOG_INFO, syslogTag)
+}
+
+// journalSend writes one entry using the journald native protocol.
+func journalSend(conn net.Conn, l slog.Level, msg string) error {
+	prio := 6 // info
+	switch {
+	case l >= slog.LevelError:
+		prio = 3
+	case l >= slog.LevelWarn:
+		prio = 4
+	case l < slog.LevelInfo:
+		prio = 7
+	}
+	var b strings.Builder
+	b.WriteString("PRIORITY=" + strconv.Itoa(prio) + "\n")
+	b.WriteString("SYSLOG_IDENTIFIER=" + syslogTag + "\n")
+	b.WriteString("MESSAGE=" + strings.ReplaceAll(msg, "\n", " ") + "\n")
+	_, err := conn.Write([]byte(b.String()))
+	return err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*--------- HC3 tags and name ---------*/
+
+// defaultTag is applied when neither -tag nor the manifest names any tags.
+const defaultTag = "imported_by_script"
+
+// batchID identifies this run for {{id}}: -batch-id, else the start time.
+var batchID = time.Now().Format("20060102-150405")
+
+var reTagVar = regexp.MustCompile(`\{\{\s*([a-z]+)\s*\}\}`)
+
+// tagVars are the placeholders a tag may use, given the VM.
+var tagVars = map[string]func(vm string) string{
+	"date":       func(string) string { return time.Now().Format("2006-01-02") },
+	"time":       func(string) string { return time.Now().Format("1504") },
+	"id":         func(string) string { return batchID },
+	"vm":         func(vm string) string { return vm },
+	"hypervisor": hypervisorOf,
+	"host":       func(string) string { h, _ := os.Hostname(); return h },
+	"user":       func(string) string { return operator() },
+}
+
+// expandTag fills in the {{placeholders}} of the tag or name template t
+// for vm.
+func expandTag(t, vm string) (string, error) {
+	var bad string
+	out := reTagVar.ReplaceAllStringFunc(t, func(m string) string {
+		name := reTagVar.FindStringSubmatch(m)[1]
+		f, ok := tagVars[name]
+		if !ok {
+			bad = name
+			return m
+		}
+		return f(vm)
+	})
+	if bad != "" {
+		return "", fmt.Errorf("%q: unknown placeholder {{%s}} (have date, time, id, vm, hypervisor, host, user)", t, bad)
+	}
+	return out, nil
+}
+
+// checkTemplates validates the tag and target name templates from the
+// flags and the manifest up front, so a typo fails the run before
+// anything is staged.
+func checkTemplates() error {
+	if *batchFlag != "" {
+		batchID = *batchFlag
+	}
+	all := append([]string{*targetFlag}, tagFlags...)
+	if plan != nil {
+		all = append(all, plan.Tags...)
+		for _, v := range plan.VMs {
+			all = append(all, v.TargetName)
+			all = append(all, v.Tags...)
+		}
+	}
+	for _, t := range all {
+		for _, m := range reTagVar.FindAllStringSubmatch(t, -1) {
+			if _, ok := tagVars[m[1]]; !ok {
+				return fmt.Errorf("%q: unknown placeholder {{%s}}", t, m[1])
+			}
+		}
+	}
+	return nil
+}
+
+// tagsFor returns the tags vm is imported with: -tag flags, then the
+// manifest's batch tags, then its own, expanded and without duplicates.
+func tagsFor(vm string) ([]string, error) {
+	all := append([]string(nil), tagFlags...)
+	if plan != nil {
+		all = append(all, plan.Tags...)
+	}
+	if v := plan.vm(vm); v != nil {
+		all = append(all, v.Tags...)
+	}
+	for i, t := range all {
+		var err error
+		if all[i], err = expandTag(t, vm); err != nil {
+			return nil, err
+		}
+	}
+	out := uniqueTags(all)
+	if len(out) == 0 {
+		out = []string{defaultTag}
+	}
+	return out, nil
+}
+
+// targetName returns the name vm is to have on HC3 – its manifest
+// targetName, else -target-name, expanded and normalized by -name-rules –
+// or "" to keep the Scale XML's.
+func targetName(vm string) (string, error) {
+	t := *targetFlag
+	if v := plan.vm(vm); v != nil && v.TargetName != "" {
+		t = v.TargetName
+	}
+	name, err := expandTag(t, vm)
+	return normalizeName(strings.TrimSpace(name)), err
+}
+
+// uniqueTags drops blank and repeated tags, keeping the first of each.
+func uniqueTags(tags []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// hypervisorOf names the platform vm was exported from: vmware or
+// virtualbox (by the OVF's system type), ovf for other OVFs, hyperv, xen
+// or proxmox.
+func hypervisorOf(vm string) string {
+	switch {
+	case isHyperVExport(vm):
+		return "hyperv"
+	case xvaFile(vm) != "":
+		return "xen"
+	case isProxmoxExport(vm):
+		return "proxmox"
+	}
+	desc, err := ovfFile(vm)
+	if err == nil && desc == "" {
+		return "unknown"
+	}
+	f, err := ova.Open(desc)
+	if err != nil {
+		return "ovf"
+	}
+	defer f.Close()
+	head, _ := io.ReadAll(io.LimitReader(f, 1<<20))
+	head = bytes.ToLower(head)
+	switch {
+	case bytes.Contains(head, []byte("vmx-")) || bytes.Contains(head, []byte("vmware")):
+		return "vmware"
+	case bytes.Contains(head, []byte("virtualbox")):
+		return "virtualbox"
+	}
+	return "ovf"
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*--------- persisted import tasks ---------*/
+
+// taskRecord is an HC3 import task a run queued, as kept in tasks.json so
+// which task created which VM outlives the terminal.
+type taskRecord struct {
+	Task    string    `json:"task"`
+	UUID    string    `json:"uuid"`
+	Source  string    `json:"source,omitempty"`  // the staged VM, where it differs from the name (-copies)
+	Cluster string    `json:"cluster,omitempty"` // named target cluster; empty for -api
+	API     string    `json:"api"`
+	Batch   string    `json:"batch,omitempty"`
+	Queued  time.Time `json:"queued"`
+	State   string    `json:"state,omitempty"` // finished or failed, once the run saw the task end
+}
+
+// tasksKept is how many import tasks tasks.json keeps per VM name.
+const tasksKept = 20
+
+var tasksMu sync.Mutex
+
+func tasksFile() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tasks.json"), nil
+}
+
+// readTasks returns the recorded import tasks by VM name, oldest first.
+func readTasks() (map[string][]taskRecord, error) {
+	file, err := tasksFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]taskRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tasks := map[string][]taskRecord{}
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return tasks, nil
+}
+
+// updateTasks applies fn to the recorded tasks and writes them back,
+// replacing tasks.json atomically.
+func updateTasks(fn func(map[string][]taskRecord)) error {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	tasks, err := readTasks()
+	if err != nil {
+		return err
+	}
+	fn(tasks)
+	file, _ := tasksFile()
+	data, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// recordTask remembers that the import task of the VM name, staged as vm,
+// was queued on cl and creates the VM uuid. Failing to is only warned
+// about: the task is queued either way.
+func recordTask(name, vm, task, uuid string, cl *cluster) {
+	if *dryRun {
+		return
+	}
+	rec := taskRecord{Task: task, UUID: uuid, Cluster: cl.Name, API: cl.api(), Batch: batchID, Queued: time.Now()}
+	if vm != name {
+		rec.Source = vm
+	}
+	err := updateTasks(func(tasks map[string][]taskRecord) {
+		l := append(tasks[name], rec)
+		tasks[name] = l[max(0, len(l)-tasksKept):]
+	})
+	if err != nil {
+		vmLog(name).Warn("recording the import task", "task", task, "err", err)
+	}
+}
+
+// recordTaskState notes that the import task of the VM name on cl ended
+// in state, finished or failed.
+func recordTaskState(name, task, state string, cl *cluster) {
+	if *dryRun {
+		return
+	}
+	err := updateTasks(func(tasks map[string][]taskRecord) {
+		for i, t := range tasks[name] {
+			if t.Task == task && t.API == cl.api() {
+				tasks[name][i].State = state
+			}
+		}
+	})
+	if err != nil {
+		slog.Debug("recording the import task's state", "vm", name, "task", task, "err", err)
+	}
+}
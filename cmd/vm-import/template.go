@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- golden-image templates ---------*/
+
+// makeTemplate turns the imported VM q into a template by the usual HC3
+// convention, as HC3 has no template type of its own: it is powered off,
+// tagged -template-tag and snapshotted, so it is ready to be cloned
+// rather than used.
+func makeTemplate(ctx context.Context, q queuedImport) error {
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	v, err := c.VM(ctx, q.uuid)
+	if err != nil {
+		return err
+	}
+	if v.State != "SHUTOFF" {
+		if err := shutDown(ctx, q); err != nil {
+			return err
+		}
+	}
+	if tags := v.TagList(); !slices.Contains(tags, *templateTag) {
+		task, err := c.SetTags(ctx, q.uuid, append(tags, *templateTag))
+		if err == nil {
+			err = c.WaitTask(ctx, task, taskPollInterval)
+		}
+		if err != nil {
+			return fmt.Errorf("tag: %w", err)
+		}
+	}
+	label := "template-" + time.Now().Format("2006-01-02")
+	task, err := c.Snapshot(ctx, q.uuid, label)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	audit("template", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	vmLog(q.vm).Info("📐 VM made a template", "uuid", q.uuid, "tag", *templateTag, "snapshot", label)
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------- business-hours throttling ---------*/
+
+// throttle is a -throttle rule: while its window is open, the run's
+// staging copies share at most bw bytes a second, and at most imports of
+// its HC3 import tasks are in progress at once.
+type throttle struct {
+	window
+	bw      int64 // bytes a second, 0: no limit
+	imports int   // 0: no limit
+}
+
+// throttles are the parsed -throttle rules, the first open one applying;
+// none, or none open, means full speed.
+var throttles []throttle
+
+// parseThrottles parses a comma-separated list of rules, each
+// "[Day[-Day]] HH:MM-HH:MM" followed by a bandwidth such as 100MB/s,
+// imports=N, both, or full for no limit, e.g.
+// "22:00-06:00 full, 00:00-24:00 100MB/s imports=1".
+func parseThrottles(s string) ([]throttle, error) {
+	var out []throttle
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var th throttle
+		f := strings.Fields(part)
+		limits := 0
+	fields:
+		for ; len(f) > 0; f = f[:len(f)-1] {
+			v := strings.ToLower(f[len(f)-1])
+			var err error
+			switch {
+			case v == "full":
+			case strings.HasPrefix(v, "imports="):
+				th.imports, err = strconv.Atoi(strings.TrimPrefix(v, "imports="))
+				if err == nil && th.imports < 1 {
+					err = fmt.Errorf("imports must be at least 1")
+				}
+			case strings.HasSuffix(v, "/s"):
+				th.bw, err = parseSize(strings.TrimSuffix(v, "/s"))
+			default:
+				break fields // the window
+			}
+			if err != nil {
+				return nil, fmt.Errorf("throttle %q: %w", part, err)
+			}
+			limits++
+		}
+		if limits == 0 {
+			return nil, fmt.Errorf("throttle %q: want a bandwidth such as 100MB/s, imports=N or full after the window", part)
+		}
+		w, err := parseWindows(strings.Join(f, " "))
+		if err != nil {
+			return nil, err
+		}
+		if len(w) != 1 {
+			return nil, fmt.Errorf("throttle %q: want [Day[-Day]] HH:MM-HH:MM before the limits", part)
+		}
+		th.window = w[0]
+		out = append(out, th)
+	}
+	return out, nil
+}
+
+// throttleAt returns the -throttle rule in force at t, the zero rule for
+// full speed.
+func throttleAt(t time.Time) throttle {
+	for _, th := range throttles {
+		if th.contains(t) {
+			return th
+		}
+	}
+	return throttle{}
+}
+
+// pace spreads the bytes of all throttled copies over time: next is when
+// the bytes read so far will have been paid for at the current bandwidth.
+var pace struct {
+	sync.Mutex
+	next time.Time
+}
+
+// payBandwidth waits until n more bytes fit within the bandwidth of the
+// -throttle rule in force, or ctx is done.
+func payBandwidth(ctx context.Context, n int) error {
+	bw := throttleAt(time.Now()).bw
+	if bw <= 0 {
+		return nil
+	}
+	pace.Lock()
+	now := time.Now()
+	if pace.next.Before(now) {
+		pace.next = now
+	}
+	pace.next = pace.next.Add(time.Duration(float64(n) / float64(bw) * float64(time.Second)))
+	d := pace.next.Sub(now)
+	pace.Unlock()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
+// throttled returns r paced to the -throttle bandwidth, r itself without
+// -throttle.
+func throttled(ctx context.Context, r io.Reader) io.Reader {
+	if len(throttles) == 0 {
+		return r
+	}
+	return throttledReader{ctx, r}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if perr := payBandwidth(t.ctx, n); perr != nil {
+			return n, perr
+		}
+	}
+	return n, err
+}
+
+// importGate counts the run's HC3 import tasks in progress, for the
+// imports= limit of -throttle rules.
+var importGate struct {
+	sync.Mutex
+	n int
+}
+
+// acquireImportGate waits until the -throttle rule in force lets another
+// import task of the run start, checking again every taskPollInterval as
+// tasks finish and rules change, or until ctx is done.
+func acquireImportGate(ctx context.Context, vm string) error {
+	if len(throttles) == 0 {
+		return nil
+	}
+	waiting := false
+	for {
+		importGate.Lock()
+		limit := throttleAt(time.Now()).imports
+		if limit == 0 || importGate.n < limit {
+			importGate.n++
+			importGate.Unlock()
+			return nil
+		}
+		importGate.Unlock()
+		if !waiting {
+			vmLog(vm).Info("⏳ waiting – -throttle allows fewer imports at this hour", "limit", limit)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(taskPollInterval):
+		}
+	}
+}
+
+// releaseImportGate counts an import task taken with acquireImportGate
+// as finished.
+func releaseImportGate() {
+	if len(throttles) == 0 {
+		return
+	}
+	importGate.Lock()
+	importGate.n--
+	importGate.Unlock()
+}
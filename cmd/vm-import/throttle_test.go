@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseThrottles(t *testing.T) {
+	ths, err := parseThrottles("22:00-06:00 full, Mon-Fri 08:00-18:00 100MB/s imports=1, 00:00-24:00 imports=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ths) != 3 {
+		t.Fatalf("%d rules, want 3", len(ths))
+	}
+	mb100, _ := parseSize("100mb")
+	for i, want := range []struct {
+		bw      int64
+		imports int
+	}{{0, 0}, {mb100, 1}, {0, 3}} {
+		if ths[i].bw != want.bw || ths[i].imports != want.imports {
+			t.Errorf("rule %d: bw %d imports %d, want bw %d imports %d", i+1, ths[i].bw, ths[i].imports, want.bw, want.imports)
+		}
+	}
+
+	// the first open rule applies
+	throttles = ths
+	defer func() { throttles = nil }()
+	for _, tc := range []struct {
+		t       time.Time
+		imports int
+	}{
+		{at(time.Tuesday, 23, 0), 0},
+		{at(time.Tuesday, 10, 0), 1},
+		{at(time.Saturday, 10, 0), 3},
+	} {
+		if got := throttleAt(tc.t).imports; got != tc.imports {
+			t.Errorf("%s: imports=%d, want %d", tc.t.Format("Mon 15:04"), got, tc.imports)
+		}
+	}
+}
+
+func TestParseThrottlesErrors(t *testing.T) {
+	for _, spec := range []string{
+		"22:00-06:00",
+		"22:00-06:00 imports=0",
+		"22:00-06:00 imports=x",
+		"22:00-06:00 fastMB/s",
+		"full",
+		"Mon 22:00-06:00 23:00-01:00 full",
+		"Xyz 22:00-06:00 full",
+	} {
+		if _, err := parseThrottles(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
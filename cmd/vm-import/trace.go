@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------- OpenTelemetry tracing ---------*/
+
+// span is a finished or running trace span. Spans are exported with the
+// OTLP/HTTP JSON encoding, which needs nothing beyond the standard library.
+type span struct {
+	traceID, id, parent string
+	name                string
+	start, end          time.Time
+	attrs               map[string]any
+	err                 error
+}
+
+// tracer collects ended spans until flush sends them to -otlp-endpoint.
+type tracer struct {
+	mu       sync.Mutex
+	endpoint string
+	headers  map[string]string
+	spans    []*span
+}
+
+// traces is the active tracer, or nil when tracing is off.
+var traces *tracer
+
+// setupTracing enables tracing for -otlp-endpoint, falling back to the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT / _HEADERS environment variables.
+func setupTracing() {
+	ep := *otlpEndpoint
+	if ep == "" {
+		ep = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		if ep == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+			ep = strings.TrimRight(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/traces"
+		}
+	} else if !strings.HasSuffix(ep, "/v1/traces") {
+		ep = strings.TrimRight(ep, "/") + "/v1/traces"
+	}
+	if ep == "" {
+		return
+	}
+	t := &tracer{endpoint: ep, headers: map[string]string{}}
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			t.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	traces = t
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startSpan opens a span below parent, or a new trace when parent is nil.
+// It returns nil when tracing is off; all span methods accept nil.
+func startSpan(name string, parent *span, kv ...any) *span {
+	if traces == nil {
+		return nil
+	}
+	s := &span{id: randomHex(8), name: name, start: time.Now(), attrs: map[string]any{}}
+	if parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.id
+	} else {
+		s.traceID = randomHex(16)
+	}
+	s.set(kv...)
+	return s
+}
+
+// set adds key/value attribute pairs.
+func (s *span) set(kv ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.attrs[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+}
+
+// finish ends the span with err as its status and queues it for export.
+func (s *span) finish(err error) {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	traces.mu.Lock()
+	traces.spans = append(traces.spans, s)
+	traces.mu.Unlock()
+}
+
+// flush exports the spans ended so far. Failures are logged, not fatal.
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(spans); err != nil {
+		slog.Warn("exporting traces", "endpoint", t.endpoint, "err", err)
+	}
+}
+
+func (t *tracer) export(spans []*span) error {
+	var out []map[string]any
+	for _, s := range spans {
+		js := map[string]any{
+			"traceId":           s.traceID,
+			"spanId":            s.id,
+			"name":              s.name,
+			"kind":              1, // internal
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+			"status":            map[string]any{"code": 1},
+		}
+		if s.parent != "" {
+			js["parentSpanId"] = s.parent
+		}
+		if s.err != nil {
+			js["status"] = map[string]any{"code": 2, "message": s.err.Error()}
+		}
+		out = append(out, js)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttrs(map[string]any{"service.name": "vm-import"})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "vm-import"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second, Transport: debugRT(nil)}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// otlpAttrs encodes attributes as OTLP KeyValues.
+func otlpAttrs(m map[string]any) []any {
+	var out []any
+	for k, v := range m {
+		var val map[string]any
+		switch x := v.(type) {
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case bool:
+			val = map[string]any{"boolValue": x}
+		case float64:
+			val = map[string]any{"doubleValue": x}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
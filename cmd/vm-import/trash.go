@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------- trash and undo ---------*/
+
+// trashDir is where deleted staged files go, in the staging dir: a
+// directory per run, named after its start and process ID, holding them
+// under their staging names, e.g.
+// .vm-import-trash/20240131-220512-4711/centos7/<uuid>.qcow2. The run holds
+// the lock in its directory until it exits, so others leave it alone.
+const trashDir = ".vm-import-trash"
+
+// trashMarker is put in each directory of a run's trash to create it, as
+// Put does on every backend and Rename does not.
+const trashMarker = ".trashed"
+
+// trashRun names this run's trash directory; the process ID keeps
+// instances started in the same second apart.
+var trashRun = fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), os.Getpid())
+
+var (
+	trashMu      sync.Mutex
+	trashCreated = map[string]bool{} // directories of this run's trash
+	trashRelease func()              // of the lock on this run's trash
+)
+
+// removeStaged deletes the staged file name by moving it into this run's
+// trash, or with -trash-keep 0 removes it outright.
+func removeStaged(name string) error {
+	if *trashKeep == 0 {
+		return stage.Remove(name)
+	}
+	dst := path.Join(trashDir, trashRun, name)
+	trashMu.Lock()
+	if trashRelease == nil {
+		release, err := acquireLock(path.Join(trashDir, trashRun, lockName), false)
+		if err != nil {
+			trashMu.Unlock()
+			return fmt.Errorf("trash: %w", err)
+		}
+		trashRelease = release
+	}
+	if !trashCreated[path.Dir(dst)] {
+		if err := stage.Put(path.Join(path.Dir(dst), trashMarker), strings.NewReader(name+"\n")); err != nil {
+			trashMu.Unlock()
+			return fmt.Errorf("trash: %w", err)
+		}
+		trashCreated[path.Dir(dst)] = true
+	}
+	trashMu.Unlock()
+	if err := stage.Rename(name, dst); err != nil {
+		return fmt.Errorf("trash: %w", err)
+	}
+	vmLog(path.Dir(name)).Debug("moved to the trash", "file", path.Base(name), "trash", path.Dir(dst))
+	return nil
+}
+
+// releaseTrash gives up the lock on this run's trash, so later runs may
+// prune it.
+func releaseTrash() {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	if trashRelease != nil {
+		trashRelease()
+		trashRelease = nil
+	}
+}
+
+// trashRuns returns the trash directories of earlier runs, oldest first.
+func trashRuns() ([]string, error) {
+	runs, err := stage.Glob(path.Join(trashDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// trashFiles returns the files in the trash directory run without the
+// markers and its lock. Wildcards only work in the last element, so it goes down
+// directory by directory: those holding trashed files have a marker, those
+// above them, as for nested VM names such as esx1/web01, do not.
+func trashFiles(run string) ([]string, error) {
+	entries, err := stage.Glob(path.Join(run, "*"))
+	if err != nil {
+		return nil, err
+	}
+	leaf := stage.Exists(path.Join(run, trashMarker))
+	var out []string
+	for _, e := range entries {
+		switch {
+		case path.Base(e) == trashMarker, path.Base(e) == lockName:
+		case leaf && !stage.Exists(path.Join(e, trashMarker)):
+			out = append(out, e)
+		default:
+			files, err := trashFiles(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, files...)
+		}
+	}
+	return out, nil
+}
+
+// pruneTrash empties the trash of all but the newest -trash-keep - 1
+// earlier runs, so with this run's own at most -trash-keep are kept. That
+// of a run still going, whose lock is held, is left alone. The local
+// backend removes their directories; the others leave them empty but for
+// the markers.
+func pruneTrash() error {
+	if *trashKeep == 0 || *dryRun {
+		return nil
+	}
+	runs, err := trashRuns()
+	if err != nil {
+		return err
+	}
+	keep := *trashKeep - 1
+	if len(runs) <= keep {
+		return nil
+	}
+	pruned := 0
+	for _, r := range runs[:len(runs)-keep] {
+		release, err := acquireLock(path.Join(r, lockName), false)
+		if err != nil {
+			slog.Debug("kept the trash of a running instance", "run", path.Base(r), "err", err)
+			continue
+		}
+		err = emptyTrash(r)
+		release()
+		if err != nil {
+			return err
+		}
+		pruned++
+	}
+	slog.Debug("emptied old trash", "runs", pruned)
+	return nil
+}
+
+// emptyTrash deletes the files of the trash directory run.
+func emptyTrash(run string) error {
+	if ls, ok := stage.(localStager); ok {
+		return os.RemoveAll(ls.path(run))
+	}
+	files, err := trashFiles(run)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := stage.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runUndo implements "vm-import undo": it moves the files the last run
+// that deleted any put into the trash back to where they were staged,
+// holding the staging lock and that of each VM directory; those in use by
+// a run are skipped. Files staged at the same path since are left
+// alone and reported, or with -force replaced.
+func runUndo(args []string) error {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	fs.StringVar(scaleDir, "scaledir", *scaleDir, "Staging directory holding the trash")
+	list := fs.Bool("list", false, "Only list the trash of each run")
+	force := fs.Bool("force", false, "Replace files staged at the same path since instead of skipping them")
+	fs.BoolVar(dryRun, "n", false, "Print what would be restored without changing anything")
+	fs.StringVar(stateDirFlag, "state-dir", "", "State directory holding the audit log (default $XDG_STATE_HOME/vm-import)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vm-import undo [-scaledir dir] [-list] [-force] [-n] [-state-dir dir]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	var err error
+	if stage, err = newStager(); err != nil {
+		return err
+	}
+	defer stage.Close()
+	if !*dryRun && !*list {
+		release, err := acquireLock(lockName, false)
+		if err != nil {
+			return fmt.Errorf("locking staging dir: %w", err)
+		}
+		defer release()
+	}
+
+	runs, err := trashRuns()
+	if err != nil {
+		return err
+	}
+	var run string
+	var files []string
+	for i := len(runs) - 1; i >= 0; i-- {
+		f, err := trashFiles(runs[i])
+		if err != nil {
+			return err
+		}
+		if *list {
+			fmt.Fprintf(stdout{}, "%s  %d file(s)\n", path.Base(runs[i]), len(f))
+			continue
+		}
+		if len(f) > 0 {
+			run, files = runs[i], f
+			break
+		}
+	}
+	if *list {
+		return nil
+	}
+	if run == "" {
+		fmt.Fprintln(stdout{}, "the trash is empty")
+		return nil
+	}
+
+	restored, skipped := 0, 0
+	locked := map[string]bool{} // VM directories, false if in use by a run
+	for _, f := range files {
+		orig := strings.TrimPrefix(f, run+"/")
+		dir := path.Dir(orig)
+		if _, tried := locked[dir]; !tried {
+			release, err := lockVM(dir, false)
+			if locked[dir] = err == nil; err != nil {
+				fmt.Fprintf(stdout{}, "skipped   %s  (%v)\n", dir, err)
+			} else {
+				defer release()
+			}
+		}
+		if !locked[dir] {
+			skipped++
+			continue
+		}
+		exists := stage.Exists(orig)
+		switch {
+		case exists && !*force:
+			fmt.Fprintf(stdout{}, "skipped   %s  (staged again since; -force replaces it)\n", orig)
+			skipped++
+			continue
+		case *dryRun:
+			fmt.Fprintf(stdout{}, "would restore  %s\n", orig)
+			continue
+		}
+		var err error
+		if exists {
+			err = stage.Remove(orig)
+		}
+		if err == nil {
+			err = stage.Rename(f, orig)
+		}
+		audit("undo", path.Dir(orig), err, auditEntry{Paths: []string{under(*scaleDir, orig)}})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout{}, "restored  %s\n", orig)
+		restored++
+	}
+	if !*dryRun {
+		fmt.Fprintf(stdout{}, "%d file(s) of run %s restored, %d skipped\n", restored, path.Base(run), skipped)
+	}
+	return nil
+}
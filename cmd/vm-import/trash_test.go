@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// testStage stages into a temporary directory for the test.
+func testStage(t *testing.T) localStager {
+	t.Helper()
+	ls := localStager{root: t.TempDir()}
+	old := stage
+	stage = ls
+	t.Cleanup(func() { stage = old })
+	return ls
+}
+
+func TestRemoveStagedLocksTrash(t *testing.T) {
+	ls := testStage(t)
+	t.Cleanup(releaseTrash)
+	for _, name := range []string{"esx1/web01/a.qcow2", "esx1/web01/b.qcow2"} {
+		if err := stage.Put(name, strings.NewReader("disk")); err != nil {
+			t.Fatal(err)
+		}
+		if err := removeStaged(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := path.Join(trashDir, trashRun)
+	files, err := trashFiles(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(files, ","); got != run+"/esx1/web01/a.qcow2,"+run+"/esx1/web01/b.qcow2" {
+		t.Errorf("trashed %s", got)
+	}
+	if _, _, err := ls.TryLock(path.Join(run, lockName), "test"); err != errLocked {
+		t.Errorf("the trash of this run is not locked: %v", err)
+	}
+}
+
+func TestPruneTrashSkipsRunningInstances(t *testing.T) {
+	ls := testStage(t)
+	runs := []string{"20240131-220512-100", "20240131-220512-200", "20240201-080000-300"}
+	for _, r := range runs {
+		if err := stage.Put(path.Join(trashDir, r, "vm1", trashMarker), strings.NewReader("vm1\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := stage.Put(path.Join(trashDir, r, "vm1", "disk.qcow2"), strings.NewReader("disk")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlock, _, err := ls.TryLock(path.Join(trashDir, runs[1], lockName), "still running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	if err := pruneTrash(); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range runs {
+		_, err := os.Stat(ls.path(path.Join(trashDir, r)))
+		if kept := err == nil; kept != (i == 1) {
+			t.Errorf("trash of %s kept: %v", r, kept)
+		}
+	}
+}
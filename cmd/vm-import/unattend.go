@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- Windows unattend ISO ---------*/
+
+// unattendFile returns the unattend.xml template for vm – its manifest
+// unattend, else -unattend – and the per-VM values it may use.
+func unattendFile(vm string) (string, map[string]string) {
+	t := *unattendFlag
+	var vars map[string]string
+	if v := plan.vm(vm); v != nil {
+		if v.Unattend != "" {
+			t = v.Unattend
+		}
+		vars = v.UnattendVars
+	}
+	return t, vars
+}
+
+// renderUnattend fills in the unattend.xml template for vm, imported as
+// target t named name, or returns nil without a template. Besides the tag
+// placeholders it knows {{name}}, the VM's name on HC3, the manifest's
+// unattendVars for vm, such as {{ip}} or {{gateway}}, and {{netsh}}, the
+// RunSynchronousCommand elements of the netsh -net-recipe; values are
+// XML-escaped. The netsh recipe brings its own template when there is
+// none. Without t, as when checking templates up front, {{netsh}} is
+// left empty.
+func renderUnattend(vm, t, name string) ([]byte, error) {
+	file, vars := unattendFile(vm)
+	var netsh string
+	if *netRecipe == "netsh" && t != "" {
+		nics, err := guestNICs(vm, t)
+		if err != nil {
+			return nil, fmt.Errorf("network recipe: %w", err)
+		}
+		netsh = netshCommands(nics)
+	}
+	var tmpl []byte
+	switch {
+	case file != "":
+		var err error
+		if tmpl, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("unattend template: %w", err)
+		}
+	case netsh != "":
+		file, tmpl = "built-in netsh unattend", []byte(netshUnattend)
+	default:
+		return nil, nil
+	}
+	var bad string
+	out := reTagVar.ReplaceAllFunc(tmpl, func(m []byte) []byte {
+		key := string(reTagVar.FindSubmatch(m)[1])
+		v, ok := vars[key]
+		switch {
+		case key == "netsh":
+			return []byte(netsh) // XML already
+		case ok:
+		case key == "name":
+			v = name
+		case tagVars[key] != nil:
+			v = tagVars[key](vm)
+		default:
+			bad = key
+			return m
+		}
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(v))
+		return b.Bytes()
+	})
+	if bad != "" {
+		return nil, fmt.Errorf("%s: unknown placeholder {{%s}} (have name, netsh, %s and the manifest's unattendVars)", file, bad, strings.Join(slices.Sorted(maps.Keys(tagVars)), ", "))
+	}
+	return out, nil
+}
+
+// checkUnattend renders the unattend templates of the flags and the
+// manifest up front, so a missing file or value fails the run before
+// anything is staged.
+func checkUnattend() error {
+	vms := []string{""}
+	if plan != nil && len(plan.VMs) > 0 {
+		vms = nil
+		for _, v := range plan.VMs {
+			vms = append(vms, v.Name)
+		}
+	}
+	for _, vm := range vms {
+		if _, err := renderUnattend(vm, "", vm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachUnattend puts the rendered unattend.xml on an ISO, uploads it to
+// the cluster of the imported VM q as <vm>-unattend.iso and inserts it
+// in a new CD drive, where Windows setup looks for it when the sysprepped
+// guest first boots.
+func attachUnattend(ctx context.Context, q queuedImport, unattend []byte) error {
+	var iso bytes.Buffer
+	if err := writeISO(&iso, "UNATTEND", []isoFile{{"unattend.xml", unattend}}); err != nil {
+		return err
+	}
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	if err := c.WaitTask(ctx, q.task, taskPollInterval); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	img, err := c.UploadISO(ctx, q.vm+"-unattend.iso", &iso, int64(iso.Len()))
+	if err != nil {
+		return fmt.Errorf("upload ISO: %w", err)
+	}
+	task, err := c.AttachCD(ctx, q.uuid, img.Path)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	audit("unattend", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return fmt.Errorf("attach ISO: %w", err)
+	}
+	vmLog(q.vm).Info("💿 unattend ISO attached", "uuid", q.uuid, "iso", img.Name)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gcosmiclentil89/ScaleVMFromOVA/scalexml"
+)
+
+/*--------- Scale XML validation ---------*/
+
+// validateScaleXML checks a Scale definition for what HC3 needs to import
+// it – a name, <scale-metadata>, network disks with sources, no disk or
+// target device used twice – and that its disks are exactly the images
+// staged (UUIDs). All problems are reported together.
+func validateScaleXML(doc *scalexml.Node, staged []string) error {
+	var errs []error
+	bad := func(format string, a ...any) { errs = append(errs, fmt.Errorf(format, a...)) }
+	top := doc.First()
+	if top == nil {
+		return errors.New("no document element")
+	}
+	if n := top.Child("name"); n == nil || n.InnerText() == "" {
+		bad("no <name>")
+	}
+	if doc.Find("scale-metadata") == nil {
+		bad("no <scale-metadata>")
+	}
+	var uuids []string
+	devs := map[string]bool{}
+	for i, d := range doc.FindAll("disk") {
+		if d.Attr("device") == "disk" && d.Attr("type") == "network" {
+			s := d.Find("source")
+			switch {
+			case s == nil:
+				bad("disk %d has no <source>", i+1)
+			case s.Attr("name") == "":
+				bad("disk %d has no source name", i+1)
+			default:
+				u := path.Base(s.Attr("name"))
+				if slices.Contains(uuids, u) {
+					bad("disk %s is listed twice", u)
+				}
+				uuids = append(uuids, u)
+			}
+		}
+		if t := d.Find("target"); t != nil && t.Attr("dev") != "" {
+			if devs[t.Attr("dev")] {
+				bad("target device %s is used twice", t.Attr("dev"))
+			}
+			devs[t.Attr("dev")] = true
+		}
+	}
+	if len(uuids) == 0 {
+		bad("no network disks")
+	}
+	for _, u := range uuids {
+		if !slices.Contains(staged, u) {
+			bad("disk %s has no staged image %s.qcow2", u, u)
+		}
+	}
+	for _, u := range staged {
+		if !slices.Contains(uuids, u) {
+			bad("staged image %s.qcow2 is not a disk of the Scale XML", u)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkStagedXML validates the staged Scale XML of vm against the qcow2
+// images actually in its staging directory, just before it is imported.
+func checkStagedXML(vm string) error {
+	name := xmlPath(vm)
+	doc, err := readScaleXML(name)
+	if err != nil {
+		return err
+	}
+	files, err := stage.Glob(path.Join(vm, "*.qcow2"))
+	if err != nil {
+		return err
+	}
+	staged := make([]string, len(files))
+	for i, f := range files {
+		staged[i] = strings.TrimSuffix(path.Base(f), ".qcow2")
+	}
+	if err := validateScaleXML(doc, staged); err != nil {
+		return invalidXML(name, err)
+	}
+	return nil
+}
+
+// invalidXML lists the validation problems on one line, for the log.
+func invalidXML(name string, err error) error {
+	return classed(classXML, fmt.Errorf("invalid Scale XML %s: %s", name, strings.ReplaceAll(err.Error(), "\n", "; ")))
+}
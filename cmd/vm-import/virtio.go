@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+/*--------- virtio driver ISO ---------*/
+
+var (
+	virtioMu    sync.Mutex
+	virtioPaths = map[*cluster]string{} // path of the -virtio-iso on each cluster
+)
+
+// needsVirtio reports whether vm should get the -virtio-iso: its OVF says
+// it is a Windows guest and the manifest does not say its drivers were
+// injected offline. Exports without an OVF are not detected.
+func needsVirtio(vm string) (bool, error) {
+	if *virtioISO == "" {
+		return false, nil
+	}
+	if v := plan.vm(vm); v != nil && v.DriversInjected {
+		return false, nil
+	}
+	env, err := readOVF(vm)
+	if err != nil || env == nil {
+		return false, err
+	}
+	return env.OS.Windows(), nil
+}
+
+// virtioPath returns the path of the -virtio-iso in cluster cl's ISO
+// library. When the library has no ready ISO of that name and
+// -virtio-iso is a local file, it is uploaded first, once per cluster.
+func virtioPath(ctx context.Context, cl *cluster) (string, error) {
+	virtioMu.Lock()
+	defer virtioMu.Unlock()
+	if p, ok := virtioPaths[cl]; ok {
+		return p, nil
+	}
+	name := filepath.Base(*virtioISO)
+	c := hc3Client(cl, 0) // the upload may take a while
+	isos, err := c.ISOs(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, iso := range isos {
+		if iso.Name == name && iso.ReadyForInsert {
+			virtioPaths[cl] = iso.Path
+			return iso.Path, nil
+		}
+	}
+	f, err := os.Open(*virtioISO)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no ISO %s in the cluster's library, nor a local file", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	slog.Info("⇪ uploading the virtio ISO", "cluster", cl.label(), "iso", name, "size", humanBytes(st.Size()))
+	iso, err := c.UploadISO(ctx, name, f, st.Size())
+	if err != nil {
+		return "", fmt.Errorf("upload %s: %w", name, err)
+	}
+	virtioPaths[cl] = iso.Path
+	return iso.Path, nil
+}
+
+// attachVirtio inserts the -virtio-iso into a new CD drive of the
+// imported VM q, so the drivers are at hand when the guest first boots.
+func attachVirtio(ctx context.Context, q queuedImport) error {
+	c := hc3Client(q.cl, hc3.DefaultTimeout)
+	if err := c.WaitTask(ctx, q.task, taskPollInterval); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	p, err := virtioPath(ctx, q.cl)
+	if err != nil {
+		return err
+	}
+	task, err := c.AttachCD(ctx, q.uuid, p)
+	if err == nil {
+		err = c.WaitTask(ctx, task, taskPollInterval)
+	}
+	audit("virtio-iso", q.vm, err, auditEntry{TaskTag: task, UUID: q.uuid})
+	if err != nil {
+		return err
+	}
+	vmLog(q.vm).Info("💿 virtio driver ISO attached", "uuid", q.uuid, "iso", filepath.Base(*virtioISO))
+	return nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*--------- vSphere SOAP client ---------*/
+
+// vsphereClient is a minimal vim25 SOAP client covering what the tool
+// needs from vCenter/ESXi: login, VM lookup by name and OVF export through
+// an HttpNfcLease.
+type vsphereClient struct {
+	sdk  *url.URL
+	http *http.Client
+	dl   *http.Client // disk downloads: no overall timeout, stopped through runCtx
+	sc   serviceContent
+}
+
+type moRef struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type serviceContent struct {
+	RootFolder        moRef `xml:"rootFolder"`
+	PropertyCollector moRef `xml:"propertyCollector"`
+	ViewManager       moRef `xml:"viewManager"`
+	SessionManager    moRef `xml:"sessionManager"`
+	OvfManager        moRef `xml:"ovfManager"`
+}
+
+type soapFault struct {
+	String string `xml:"faultstring"`
+}
+
+func newVSphereClient(rawURL, user, pass string) (*vsphereClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse vSphere URL: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/sdk"
+	}
+	jar, _ := cookiejar.New(nil)
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: *vsInsecure}, ResponseHeaderTimeout: 60 * time.Second}
+	c := &vsphereClient{
+		sdk:  u,
+		http: &http.Client{Timeout: 60 * time.Second, Transport: debugRT(tr), Jar: jar},
+		dl:   &http.Client{Transport: debugRT(tr), Jar: jar},
+	}
+
+	var sc struct {
+		Returnval serviceContent `xml:"Body>RetrieveServiceContentResponse>returnval"`
+	}
+	if err := c.call(runCtx, `<RetrieveServiceContent xmlns="urn:vim25"><_this type="ServiceInstance">ServiceInstance</_this></RetrieveServiceContent>`, &sc); err != nil {
+		return nil, err
+	}
+	c.sc = sc.Returnval
+
+	login := fmt.Sprintf(`<Login xmlns="urn:vim25">%s<userName>%s</userName><password>%s</password></Login>`,
+		this(c.sc.SessionManager), xmlText(user), xmlText(pass))
+	if err := c.call(runCtx, login, nil); err != nil {
+		return nil, fmt.Errorf("vSphere login: %w", err)
+	}
+	return c, nil
+}
+
+// call posts one SOAP request body and decodes the envelope into out.
+func (c *vsphereClient) call(ctx context.Context, body string, out any) error {
+	env := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<soapenv:Body>` + body + `</soapenv:Body></soapenv:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, "POST", c.sdk.String(), strings.NewReader(env))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "urn:vim25/6.5")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("vSphere call failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		var f struct {
+			Fault soapFault `xml:"Body>Fault"`
+		}
+		if xml.Unmarshal(data, &f) == nil && f.Fault.String != "" {
+			return fmt.Errorf("vSphere fault: %s", f.Fault.String)
+		}
+		return fmt.Errorf("vSphere error %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+func this(m moRef) string {
+	return fmt.Sprintf(`<_this type="%s">%s</_this>`, m.Type, xmlText(m.Value))
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+type vsObject struct {
+	Obj     moRef `xml:"obj"`
+	PropSet []struct {
+		Name string `xml:"name"`
+		Val  struct {
+			Inner string `xml:",innerxml"`
+		} `xml:"val"`
+	} `xml:"propSet"`
+}
+
+// prop returns the raw XML of the named property.
+func (o vsObject) prop(name string) string {
+	for _, p := range o.PropSet {
+		if p.Name == name {
+			return p.Val.Inner
+		}
+	}
+	return ""
+}
+
+type retrieveResult struct {
+	Token   string     `xml:"token"`
+	Objects []vsObject `xml:"objects"`
+}
+
+// retrieve runs a PropertyCollector query, following continuation tokens.
+func (c *vsphereClient) retrieve(specSet string) ([]vsObject, error) {
+	var res struct {
+		Returnval retrieveResult `xml:"Body>RetrievePropertiesExResponse>returnval"`
+	}
+	req := fmt.Sprintf(`<RetrievePropertiesEx xmlns="urn:vim25">%s<specSet>%s</specSet><options/></RetrievePropertiesEx>`,
+		this(c.sc.PropertyCollector), specSet)
+	if err := c.call(runCtx, req, &res); err != nil {
+		return nil, err
+	}
+	objs := res.Returnval.Objects
+	for tok := res.Returnval.Token; tok != ""; {
+		var more struct {
+			Returnval retrieveResult `xml:"Body>ContinueRetrievePropertiesExResponse>returnval"`
+		}
+		req := fmt.Sprintf(`<ContinueRetrievePropertiesEx xmlns="urn:vim25">%s<token>%s</token></ContinueRetrievePropertiesEx>`,
+			this(c.sc.PropertyCollector), xmlText(tok))
+		if err := c.call(runCtx, req, &more); err != nil {
+			return nil, err
+		}
+		objs = append(objs, more.Returnval.Objects...)
+		tok = more.Returnval.Token
+	}
+	return objs, nil
+}
+
+// vms returns every virtual machine in the inventory with the requested
+// properties.
+func (c *vsphereClient) vms(props ...string) ([]vsObject, error) {
+	return c.objects("VirtualMachine", props...)
+}
+
+// objects returns every managed object of the given type in the inventory
+// with the requested properties.
+func (c *vsphereClient) objects(kind string, props ...string) ([]vsObject, error) {
+	var view struct {
+		Returnval moRef `xml:"Body>CreateContainerViewResponse>returnval"`
+	}
+	req := fmt.Sprintf(`<CreateContainerView xmlns="urn:vim25">%s<container type="Folder">%s</container><type>%s</type><recursive>true</recursive></CreateContainerView>`,
+		this(c.sc.ViewManager), xmlText(c.sc.RootFolder.Value), kind)
+	if err := c.call(runCtx, req, &view); err != nil {
+		return nil, err
+	}
+	defer c.call(context.WithoutCancel(runCtx), fmt.Sprintf(`<DestroyView xmlns="urn:vim25">%s</DestroyView>`, this(view.Returnval)), nil)
+
+	var paths strings.Builder
+	for _, p := range append([]string{"name"}, props...) {
+		paths.WriteString("<pathSet>" + p + "</pathSet>")
+	}
+	spec := fmt.Sprintf(`<propSet><type>%s</type>%s</propSet>`+
+		`<objectSet><obj type="ContainerView">%s</obj><skip>true</skip>`+
+		`<selectSet xsi:type="TraversalSpec"><name>view</name><type>ContainerView</type><path>view</path><skip>false</skip></selectSet>`+
+		`</objectSet>`, kind, paths.String(), xmlText(view.Returnval.Value))
+	return c.retrieve(spec)
+}
+
+// findVM looks a virtual machine up by its inventory name.
+func (c *vsphereClient) findVM(name string, props ...string) (vsObject, error) {
+	objs, err := c.vms(props...)
+	if err != nil {
+		return vsObject{}, err
+	}
+	for _, o := range objs {
+		if o.prop("name") == xmlText(name) {
+			return o, nil
+		}
+	}
+	return vsObject{}, fmt.Errorf("VM %q not found in vSphere inventory", name)
+}
+
+/*--------- OVF export via HttpNfcLease ---------*/
+
+type nfcDeviceURL struct {
+	Key      string `xml:"key"`
+	URL      string `xml:"url"`
+	Disk     bool   `xml:"disk"`
+	FileSize int64  `xml:"fileSize"`
+}
+
+type nfcLeaseInfo struct {
+	DeviceURL       []nfcDeviceURL `xml:"deviceUrl"`
+	TotalDiskCapKB  int64          `xml:"totalDiskCapacityInKB"`
+	LeaseTimeoutSec int            `xml:"leaseTimeout"`
+}
+
+// exportVM exports the named VM's disks and a freshly generated OVF
+// descriptor into dir, the layout discoverVMs expects.
+func (c *vsphereClient) exportVM(name, dir string) error {
+	vm, err := c.findVM(name)
+	if err != nil {
+		return err
+	}
+	var exp struct {
+		Returnval moRef `xml:"Body>ExportVmResponse>returnval"`
+	}
+	if err := c.call(runCtx, fmt.Sprintf(`<ExportVm xmlns="urn:vim25">%s</ExportVm>`, this(vm.Obj)), &exp); err != nil {
+		return fmt.Errorf("export %s: %w", name, err)
+	}
+	lease := exp.Returnval
+
+	info, err := c.waitLease(lease)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.leaseAbort(lease)
+		return err
+	}
+
+	var total, done atomic.Int64
+	for _, d := range info.DeviceURL {
+		if d.Disk {
+			total.Add(d.FileSize)
+		}
+	}
+	stop := make(chan struct{})
+	go c.keepLeaseAlive(lease, &done, &total, stop)
+
+	var files strings.Builder
+	n := 0
+	for _, d := range info.DeviceURL {
+		if !d.Disk {
+			continue
+		}
+		n++
+		file := fmt.Sprintf("%s-disk%d.vmdk", name, n)
+		size, err := c.fetchDevice(d, filepath.Join(dir, file), &done)
+		if err != nil {
+			close(stop)
+			c.leaseAbort(lease)
+			return err
+		}
+		slog.Info("✓ exported", "file", file, "bytes", size)
+		fmt.Fprintf(&files, `<ovfFiles><deviceId>%s</deviceId><path>%s</path><size>%d</size></ovfFiles>`,
+			xmlText(d.Key), xmlText(file), size)
+	}
+	close(stop)
+	if err := c.writeDescriptor(vm.Obj, name, dir, files.String()); err != nil {
+		c.leaseAbort(lease)
+		return err
+	}
+	return c.call(runCtx, fmt.Sprintf(`<HttpNfcLeaseComplete xmlns="urn:vim25">%s</HttpNfcLeaseComplete>`, this(lease)), nil)
+}
+
+// writeDescriptor has vSphere generate the OVF descriptor of vm for the
+// disk files listed as ovfFiles elements, and writes it to dir.
+func (c *vsphereClient) writeDescriptor(vm moRef, name, dir, files string) error {
+	var desc struct {
+		Returnval struct {
+			OvfDescriptor string `xml:"ovfDescriptor"`
+			Error         []struct {
+				LocalizedMessage string `xml:"localizedMessage"`
+			} `xml:"error"`
+		} `xml:"Body>CreateDescriptorResponse>returnval"`
+	}
+	req := fmt.Sprintf(`<CreateDescriptor xmlns="urn:vim25">%s<obj type="VirtualMachine">%s</obj><cdp>%s<name>%s</name></cdp></CreateDescriptor>`,
+		this(c.sc.OvfManager), xmlText(vm.Value), files, xmlText(name))
+	if err := c.call(runCtx, req, &desc); err != nil {
+		return fmt.Errorf("create OVF descriptor: %w", err)
+	}
+	if len(desc.Returnval.Error) > 0 {
+		return fmt.Errorf("create OVF descriptor: %s", desc.Returnval.Error[0].LocalizedMessage)
+	}
+	return os.WriteFile(filepath.Join(dir, name+".ovf"), []byte(desc.Returnval.OvfDescriptor), 0o644)
+}
+
+// waitLease polls the lease until it is ready (or failed).
+func (c *vsphereClient) waitLease(lease moRef) (nfcLeaseInfo, error) {
+	spec := fmt.Sprintf(`<propSet><type>HttpNfcLease</type><pathSet>state</pathSet><pathSet>info</pathSet><pathSet>error</pathSet></propSet>`+
+		`<objectSet><obj type="HttpNfcLease">%s</obj><skip>false</skip></objectSet>`, xmlText(lease.Value))
+	for i := 0; i < 600; i++ {
+		objs, err := c.retrieve(spec)
+		if err != nil {
+			return nfcLeaseInfo{}, err
+		}
+		if len(objs) == 0 {
+			return nfcLeaseInfo{}, fmt.Errorf("lease %s vanished", lease.Value)
+		}
+		switch objs[0].prop("state") {
+		case "ready":
+			var info nfcLeaseInfo
+			err := xml.Unmarshal([]byte("<info>"+objs[0].prop("info")+"</info>"), &info)
+			return info, err
+		case "error":
+			var f struct {
+				Msg string `xml:"localizedMessage"`
+			}
+			xml.Unmarshal([]byte("<e>"+objs[0].prop("error")+"</e>"), &f)
+			return nfcLeaseInfo{}, fmt.Errorf("export lease failed: %s", f.Msg)
+		}
+		time.Sleep(time.Second)
+	}
+	return nfcLeaseInfo{}, fmt.Errorf("export lease %s never became ready", lease.Value)
+}
+
+// keepLeaseAlive reports progress so the lease does not time out during
+// long disk downloads.
+func (c *vsphereClient) keepLeaseAlive(lease moRef, done, total *atomic.Int64, stop <-chan struct{}) {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			pct := int64(0)
+			if tot := total.Load(); tot > 0 {
+				pct = done.Load() * 100 / tot
+				if pct > 99 {
+					pct = 99
+				}
+			}
+			c.call(runCtx, fmt.Sprintf(`<HttpNfcLeaseProgress xmlns="urn:vim25">%s<percent>%d</percent></HttpNfcLeaseProgress>`, this(lease), pct), nil)
+		}
+	}
+}
+
+// leaseAbort gives the lease up, also once runCtx is cancelled.
+func (c *vsphereClient) leaseAbort(lease moRef) {
+	c.call(context.WithoutCancel(runCtx), fmt.Sprintf(`<HttpNfcLeaseAbort xmlns="urn:vim25">%s</HttpNfcLeaseAbort>`, this(lease)), nil)
+}
+
+// fetchDevice downloads one lease device URL to dst.
+func (c *vsphereClient) fetchDevice(d nfcDeviceURL, dst string, done *atomic.Int64) (int64, error) {
+	u := strings.Replace(d.URL, "://*/", "://"+c.sdk.Host+"/", 1)
+	req, err := http.NewRequestWithContext(runCtx, "GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.dl.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("download %s: %w", filepath.Base(dst), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("download %s: HTTP %d", filepath.Base(dst), resp.StatusCode)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, io.TeeReader(resp.Body, countWriter{done}))
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return 0, err
+	}
+	return n, out.Close()
+}
+
+type countWriter struct{ n *atomic.Int64 }
+
+func (w countWriter) Write(p []byte) (int, error) { w.n.Add(int64(len(p))); return len(p), nil }
+
+/*--------- source mode ---------*/
+
+// exportFromVSphere exports each selected VM from vCenter/ESXi into
+// <ovadir>/<vm>/ ahead of discovery.
+func exportFromVSphere(names []string) error {
+	if *vsURL == "" {
+		return nil
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("vSphere export needs -vms or -manifest")
+	}
+	if _, ok := ova.(localSource); !ok {
+		return fmt.Errorf("vSphere export needs a local -ovadir")
+	}
+	if *dryRun {
+		for _, n := range names {
+			slog.Info("[dry-run] export from vSphere", "vm", n, "dst", filepath.Join(*ovaDir, normalizeName(n)))
+		}
+		return nil
+	}
+	c, err := newVSphereClient(*vsURL, *vsUser, *vsPass)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if *vsCBT {
+			slog.Info("⟳ syncing changed blocks from vSphere", "vm", n)
+			err = c.syncCBT(n, filepath.Join(*ovaDir, normalizeName(n)))
+		} else {
+			slog.Info("⟳ exporting from vSphere", "vm", n)
+			err = c.exportVM(n, filepath.Join(*ovaDir, normalizeName(n)))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+	}
+	return nil
+}
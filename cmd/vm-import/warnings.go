@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*--------- warnings summary ---------*/
+
+// warnings collects every warning logged during the run, keyed by the
+// VM it was logged for ("" for the run as a whole), so they can be
+// repeated in a summary at the end instead of scrolling away.
+var warnings = struct {
+	mu   sync.Mutex
+	byVM map[string][]string
+}{byVM: map[string][]string{}}
+
+// warnCollector is the slog handler feeding warnings. It sees warnings
+// whatever -log-level says, but not errors, which are reported anyway.
+type warnCollector struct{ attrs []slog.Attr }
+
+func (warnCollector) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= slog.LevelWarn && l < slog.LevelError
+}
+
+func (w warnCollector) Handle(_ context.Context, r slog.Record) error {
+	var vm string
+	var b strings.Builder
+	b.WriteString(r.Message)
+	add := func(a slog.Attr) bool {
+		if a.Key == "vm" {
+			vm = a.Value.String()
+		} else {
+			writeAttr(&b, "", a)
+		}
+		return true
+	}
+	for _, a := range w.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	warnings.mu.Lock()
+	warnings.byVM[vm] = append(warnings.byVM[vm], b.String())
+	warnings.mu.Unlock()
+	return nil
+}
+
+func (w warnCollector) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return warnCollector{append(append([]slog.Attr{}, w.attrs...), attrs...)}
+}
+
+func (w warnCollector) WithGroup(string) slog.Handler { return w }
+
+// warningsFor returns the warnings logged so far for vm.
+func warningsFor(vm string) []string {
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	return append([]string(nil), warnings.byVM[vm]...)
+}
+
+// resetWarnings forgets vm's warnings before it is processed again, as
+// the daemon and -watch do.
+func resetWarnings(vm string) {
+	warnings.mu.Lock()
+	delete(warnings.byVM, vm)
+	warnings.mu.Unlock()
+}
+
+// printWarnings repeats the run's warnings grouped by VM and clears them.
+func printWarnings() {
+	warnings.mu.Lock()
+	byVM := warnings.byVM
+	warnings.byVM = map[string][]string{}
+	warnings.mu.Unlock()
+	n := 0
+	vms := make([]string, 0, len(byVM))
+	for vm, ws := range byVM {
+		vms = append(vms, vm)
+		n += len(ws)
+	}
+	if n == 0 {
+		return
+	}
+	sort.Strings(vms)
+	fmt.Fprintf(stdout{}, "\n⚠️  %d warning(s):\n", n)
+	for _, vm := range vms {
+		name := vm
+		if name == "" {
+			name = "(run)"
+		}
+		for _, w := range byVM[vm] {
+			fmt.Fprintf(stdout{}, "  %s: %s\n", name, w)
+		}
+	}
+}
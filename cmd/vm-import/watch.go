@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+/*--------- watch mode ---------*/
+
+// watchOVAs polls the OVA source for VM directories that appear after
+// start-up and hands each to run once its export has finished. Polling
+// rather than inotify keeps it working for s3:// sources too.
+func watchOVAs(interval time.Duration, run func(vm string)) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid -watch-interval %s", interval)
+	}
+	seen := map[string]bool{}
+	dirs, err := vmDirs()
+	if err != nil {
+		return err
+	}
+	for _, vm := range dirs {
+		seen[vm] = true
+	}
+
+	slog.Info("👀 watching for new exports", "ovadir", *ovaDir, "interval", interval)
+	pending := map[string]string{} // vm → snapshot from the previous poll
+	waiting := map[string]bool{}   // vm → already told the user it lacks a Scale XML
+	for {
+		select {
+		case <-runCtx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		dirs, err := vmDirs()
+		if err != nil {
+			slog.Warn("watch", "err", err)
+			continue
+		}
+		for _, vm := range dirs {
+			if seen[vm] {
+				continue
+			}
+			st, err := exportState(vm)
+			if err != nil {
+				slog.Warn("watch", "vm", vm, "err", err)
+				continue
+			}
+			prev, ok := pending[vm]
+			pending[vm] = st.snap
+			if st.snap == "" || st.lock != "" || !st.done && (!ok || prev != st.snap) {
+				continue
+			}
+			if !stage.Exists(xmlPath(vm)) {
+				if !waiting[vm] {
+					slog.Info("⏳ export finished, waiting for the Scale XML in the staging dir", "vm", vm, "file", xmlPath(vm))
+					waiting[vm] = true
+				}
+				continue
+			}
+			if _, err := sourceDisks(vm); err != nil {
+				continue
+			}
+			seen[vm] = true
+			delete(pending, vm)
+			delete(waiting, vm)
+			run(vm)
+		}
+	}
+}
+
+// exporterLocks match files exporters keep next to an export while they
+// are still writing it: VMware lock files, partial downloads and temp files.
+var exporterLocks = []string{"*.lck", "*.part", "*.partial", "*.tmp", "*.crdownload", "*.filepart", ".~lock.*"}
+
+// exportStatus describes an OVA directory as seen by exportState.
+type exportStatus struct {
+	snap string // name/size of every file, to compare between polls
+	done bool   // the .mf manifest, which exporters write last, is present
+	lock string // an exporter lock or partial file, if any
+}
+
+// exportState summarises the files in an OVA directory as a name/size
+// snapshot, and reports the export as done once its .mf manifest is
+// present and no exporter lock file is left. Otherwise the caller waits
+// for two identical snapshots in a row, and for the lock files to go.
+func exportState(vm string) (exportStatus, error) {
+	var st exportStatus
+	files, err := ova.Glob(path.Join(vm, "*"))
+	if err != nil || len(files) == 0 {
+		return st, err
+	}
+	var b strings.Builder
+	for _, f := range files {
+		sz, err := ova.Size(f)
+		if err != nil {
+			return st, err
+		}
+		fmt.Fprintf(&b, "%s=%d;", f, sz)
+		if strings.EqualFold(path.Ext(f), ".mf") {
+			st.done = true
+		}
+		for _, pat := range exporterLocks {
+			if ok, _ := path.Match(pat, strings.ToLower(path.Base(f))); ok && st.lock == "" {
+				st.lock = path.Base(f)
+			}
+		}
+	}
+	st.snap = b.String()
+	if st.lock != "" {
+		st.done = false
+	}
+	return st, nil
+}
+
+// waitForExport holds vm back while its export is still being written:
+// exporter lock files must be gone and, unless the .mf manifest is there,
+// the directory must look the same across -settle. It gives up after
+// -settle-timeout rather than convert a half-written disk.
+func waitForExport(ctx context.Context, vm string) error {
+	if *settle <= 0 {
+		return nil
+	}
+	lg := vmLog(vm)
+	deadline := time.Now().Add(*settleTimeout)
+	prev, told := "", false
+	for {
+		st, err := exportState(vm)
+		if err != nil {
+			return err
+		}
+		if st.lock == "" && (st.done || st.snap != "" && st.snap == prev) {
+			if told {
+				lg.Info("✓ export finished")
+			}
+			return nil
+		}
+		why := "files still changing"
+		switch {
+		case st.lock != "":
+			why = "exporter lock file " + st.lock + " present"
+		case prev == "":
+			why = "no .mf manifest; checking the files are stable"
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("export still in progress after %s: %s", *settleTimeout, why)
+		}
+		if prev == "" && st.lock == "" {
+			lg.Debug("waiting for the export to settle", "reason", why, "for", *settle)
+		} else if !told {
+			lg.Info("⏳ export still in progress – waiting", "reason", why)
+			told = true
+		}
+		prev = st.snap
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(*settle):
+		}
+	}
+}
+
+// runNow is the watch callback outside daemon mode: process the VM in
+// place once a maintenance window allows.
+func runNow(vm string) {
+	waitForWindow()
+	if interrupted() != nil {
+		return
+	}
+	if err := processVM(runCtx, vm, *autoImp); err != nil {
+		slog.Error("VM failed", "vm", vm, "err", err)
+	}
+}
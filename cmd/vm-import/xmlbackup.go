@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+/*--------- Scale XML backups ---------*/
+
+// backupSep separates a Scale XML's name from the timestamp of a backup,
+// as in centos7/centos7.xml.bak-20240131-220512.
+const backupSep = ".bak-"
+
+// xmlBackups lists the backups of the Scale XML name, oldest first.
+func xmlBackups(name string) ([]string, error) {
+	return stage.Glob(name + backupSep + "*") // timestamps sort in time order
+}
+
+// backupXML copies the Scale XML name next to itself before it is
+// rewritten and drops all but the newest -xml-backups copies.
+func backupXML(name string) error {
+	if *keepBackups <= 0 {
+		return nil
+	}
+	data, err := stage.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	stamp := time.Now().Format("20060102-150405")
+	bak := name + backupSep + stamp
+	for i := 1; stage.Exists(bak); i++ { // a second backup within the same second
+		bak = fmt.Sprintf("%s%s%s.%d", name, backupSep, stamp, i)
+	}
+	if err := stage.Put(bak, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	vmLog(path.Dir(name)).Info("💾 backed up Scale XML", "file", path.Base(bak))
+	baks, err := xmlBackups(name)
+	if err != nil {
+		return err
+	}
+	for len(baks) > *keepBackups {
+		if err := stage.Remove(baks[0]); err != nil {
+			return err
+		}
+		baks = baks[1:]
+	}
+	return nil
+}
+
+// runRestoreXML implements "vm-import restore-xml": it puts a backup taken
+// by backupXML back in place of each VM's Scale XML, the newest one unless
+// -at names another, holding the VM's lock. The current file is backed up
+// first, so a restore can itself be undone.
+func runRestoreXML(args []string) error {
+	fs := flag.NewFlagSet("restore-xml", flag.ExitOnError)
+	fs.StringVar(scaleDir, "scaledir", *scaleDir, "Staging directory holding the Scale XML")
+	at := fs.String("at", "", "Restore the backup with this timestamp (e.g. 20240131-220512) instead of the newest")
+	list := fs.Bool("list", false, "Only list the VMs' backups")
+	fs.BoolVar(dryRun, "n", false, "Print what would be restored without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vm-import restore-xml [-scaledir dir] [-at timestamp | -list] [-n] vm...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no VM given")
+	}
+	var err error
+	if stage, err = newStager(); err != nil {
+		return err
+	}
+	defer stage.Close()
+
+	for _, vm := range fs.Args() {
+		name := xmlPath(vm)
+		baks, err := xmlBackups(name)
+		if err != nil {
+			return err
+		}
+		if *list {
+			for _, b := range baks {
+				fmt.Fprintf(stdout{}, "%s  %s\n", vm, strings.TrimPrefix(b, name+backupSep))
+			}
+			continue
+		}
+		if len(baks) == 0 {
+			return fmt.Errorf("%s: no backups of %s", vm, name)
+		}
+		from := baks[len(baks)-1]
+		if *at != "" {
+			from = name + backupSep + *at
+			if !stage.Exists(from) {
+				return fmt.Errorf("%s: no backup %s (see -list)", vm, path.Base(from))
+			}
+		}
+		release, err := lockVM(vm, false)
+		if err != nil {
+			return err
+		}
+		err = restoreXML(name, from)
+		release()
+		if err != nil {
+			return fmt.Errorf("%s: %w", vm, err)
+		}
+	}
+	return nil
+}
+
+func restoreXML(name, from string) error {
+	lg := vmLog(path.Dir(name))
+	if *dryRun {
+		lg.Info("[dry-run] would restore Scale XML", "file", path.Base(name), "from", path.Base(from))
+		return nil
+	}
+	data, err := stage.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if stage.Exists(name) {
+		if err := backupXML(name); err != nil {
+			return fmt.Errorf("backing up current %s: %w", path.Base(name), err)
+		}
+	}
+	err = putStaged(name, bytes.NewReader(data))
+	audit("restore-xml", path.Dir(name), err, auditEntry{Paths: []string{under(*scaleDir, name), under(*scaleDir, from)}})
+	if err != nil {
+		return err
+	}
+	lg.Info("♻️ restored Scale XML", "file", path.Base(name), "from", path.Base(from))
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*--------- XenServer / XCP-ng XVA source adapter ---------*/
+
+// An XVA is a tar archive holding ova.xml (XML-RPC encoded metadata) plus
+// each disk as 1 MiB chunks named Ref:<vdi>/<8-digit index>, with optional
+// <chunk>.checksum SHA-1 files. Chunks that are all zero are omitted.
+
+const xvaChunk = 1 << 20
+
+var reXVAChunk = regexp.MustCompile(`^(Ref:[^/]+)/(\d{8})(\.checksum)?$`)
+
+func xvaFile(vm string) string {
+	m, _ := ova.Glob(path.Join(vm, "*.xva"))
+	if len(m) == 0 {
+		return ""
+	}
+	return m[0]
+}
+
+type xvaDisk struct {
+	ref    string // VDI reference used as chunk directory
+	device int    // VBD userdevice, defines attach order
+	size   int64  // virtual size in bytes
+	file   string // reassembled raw image, relative to the VM dir
+}
+
+// xvaDisks reassembles every disk of the VM's XVA into <vm>-xvd<N>.raw
+// next to the archive (reusing earlier results) and returns their names.
+func xvaDisks(vm, archive string) ([]string, error) {
+	ls, ok := ova.(localSource)
+	if !ok {
+		return nil, fmt.Errorf("XVA sources need a local -ovadir")
+	}
+	disks, err := readXVA(ls.path(archive), vm, !*dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path.Base(archive), err)
+	}
+	out := make([]string, len(disks))
+	for i, d := range disks {
+		out[i] = d.file
+	}
+	return out, nil
+}
+
+func openXVA(p string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{zr, f}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, f}, nil
+}
+
+// readXVA walks the archive once: ova.xml first, then the chunks, which
+// are written into sparse raw images when extract is set.
+func readXVA(p, vm string, extract bool) ([]xvaDisk, error) {
+	r, err := openXVA(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dir := filepath.Dir(p)
+	var disks []xvaDisk
+	byRef := map[string]*xvaDisk{}
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	sums := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == "ova.xml" {
+			if disks, err = parseXVAMeta(tr, vm); err != nil {
+				return nil, fmt.Errorf("ova.xml: %w", err)
+			}
+			for i := range disks {
+				byRef[disks[i].ref] = &disks[i]
+			}
+			if !extract {
+				return disks, nil
+			}
+			continue
+		}
+		m := reXVAChunk.FindStringSubmatch(hdr.Name)
+		if m == nil {
+			continue
+		}
+		d := byRef[m[1]]
+		if d == nil {
+			continue // CD images and the like
+		}
+		if fileExists(filepath.Join(dir, d.file)) && files[d.ref] == nil {
+			continue // reassembled on an earlier run
+		}
+		if m[3] != "" {
+			b, _ := io.ReadAll(tr)
+			sums[m[1]+"/"+m[2]] = strings.TrimSpace(string(b))
+			continue
+		}
+		f := files[d.ref]
+		if f == nil {
+			tmp := filepath.Join(dir, d.file+".part")
+			if f, err = os.Create(tmp); err != nil {
+				return nil, err
+			}
+			files[d.ref] = f
+		}
+		idx, _ := strconv.ParseInt(m[2], 10, 64)
+		h := sha1.New()
+		w := io.NewOffsetWriter(f, idx*xvaChunk)
+		if _, err := io.Copy(io.MultiWriter(w, h), tr); err != nil {
+			return nil, err
+		}
+		if want, ok := sums[m[1]+"/"+m[2]]; ok && !strings.EqualFold(want, hex.EncodeToString(h.Sum(nil))) {
+			return nil, fmt.Errorf("chunk %s: checksum mismatch", hdr.Name)
+		}
+		sums[m[1]+"/"+m[2]+"#got"] = hex.EncodeToString(h.Sum(nil))
+	}
+	if disks == nil {
+		return nil, fmt.Errorf("no ova.xml in archive")
+	}
+
+	// checksum files may follow their chunk in the archive
+	for k, want := range sums {
+		if got, ok := sums[k+"#got"]; ok && !strings.EqualFold(got, want) {
+			return nil, fmt.Errorf("chunk %s: checksum mismatch", k)
+		}
+	}
+	for ref, f := range files {
+		d := byRef[ref]
+		if err := f.Truncate(d.size); err != nil {
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		delete(files, ref)
+		if err := os.Rename(f.Name(), filepath.Join(dir, d.file)); err != nil {
+			return nil, err
+		}
+		vmLog(vm).Info("✓ reassembled", "file", d.file, "bytes", d.size)
+	}
+	return disks, nil
+}
+
+// parseXVAMeta pulls the VM's disk VBDs out of ova.xml.
+func parseXVAMeta(r io.Reader, vm string) ([]xvaDisk, error) {
+	v, err := decodeXMLRPC(xml.NewDecoder(r))
+	if err != nil {
+		return nil, err
+	}
+	root, _ := v.(map[string]any)
+	objs, _ := root["objects"].([]any)
+
+	sizes := map[string]int64{}
+	type vbd struct {
+		vdi    string
+		device int
+	}
+	var vbds []vbd
+	for _, o := range objs {
+		obj, _ := o.(map[string]any)
+		snap, _ := obj["snapshot"].(map[string]any)
+		id, _ := obj["id"].(string)
+		switch obj["class"] {
+		case "VDI":
+			s, _ := snap["virtual_size"].(string)
+			sizes[id], _ = strconv.ParseInt(s, 10, 64)
+		case "VBD":
+			if t, _ := snap["type"].(string); !strings.EqualFold(t, "Disk") {
+				continue
+			}
+			vdi, _ := snap["VDI"].(string)
+			dev, _ := strconv.Atoi(fmt.Sprint(snap["userdevice"]))
+			vbds = append(vbds, vbd{vdi, dev})
+		}
+	}
+	sort.Slice(vbds, func(i, j int) bool { return vbds[i].device < vbds[j].device })
+
+	var out []xvaDisk
+	for _, b := range vbds {
+		size, ok := sizes[b.vdi]
+		if !ok {
+			continue
+		}
+		out = append(out, xvaDisk{
+			ref:    b.vdi,
+			device: b.device,
+			size:   size,
+			file:   fmt.Sprintf("%s-xvd%c.raw", vm, 'a'+rune(b.device)),
+		})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no disk VBDs found")
+	}
+	return out, nil
+}
+
+// decodeXMLRPC decodes the next XML-RPC <value> into string, []any or
+// map[string]any.
+func decodeXMLRPC(dec *xml.Decoder) (any, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "value" {
+			return decodeXMLRPCValue(dec)
+		}
+	}
+}
+
+func decodeXMLRPCValue(dec *xml.Decoder) (any, error) {
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement: // </value> with bare text
+			return text.String(), nil
+		case xml.StartElement:
+			var v any
+			switch t.Name.Local {
+			case "struct":
+				m := map[string]any{}
+				for {
+					name, val, done, err := decodeXMLRPCMember(dec)
+					if err != nil {
+						return nil, err
+					}
+					if done {
+						break
+					}
+					m[name] = val
+				}
+				v = m
+			case "array":
+				var a []any
+				for {
+					tok, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "value" {
+						e, err := decodeXMLRPCValue(dec)
+						if err != nil {
+							return nil, err
+						}
+						a = append(a, e)
+					}
+					if ee, ok := tok.(xml.EndElement); ok && ee.Name.Local == "array" {
+						break
+					}
+				}
+				v = a
+			default: // typed scalar: <string>, <int>, <boolean>, …
+				var s string
+				if err := dec.DecodeElement(&s, &t); err != nil {
+					return nil, err
+				}
+				v = s
+			}
+			// consume up to </value>
+			if err := dec.Skip(); err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
+	}
+}
+
+// decodeXMLRPCMember reads one <member> of a struct; done is set at </struct>.
+func decodeXMLRPCMember(dec *xml.Decoder) (name string, val any, done bool, err error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", nil, false, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "name":
+				if err := dec.DecodeElement(&name, &t); err != nil {
+					return "", nil, false, err
+				}
+			case "value":
+				if val, err = decodeXMLRPCValue(dec); err != nil {
+					return "", nil, false, err
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "member":
+				return name, val, false, nil
+			case "struct":
+				return "", nil, true, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+// Package hc3test runs a fake HC3 REST API for tests and demos, in the
+// spirit of net/http/httptest: imports are accepted and recorded, their
+// tasks run to completion over a few polls, and the imported VMs are
+// listed under VirDomain. Started VMs report a guest agent and an IP
+// address after a few polls, as a booting guest would.
+//
+//	srv := hc3test.NewServer()
+//	defer srv.Close()
+//	c := hc3.New(srv.URL)
+//	res, _ := c.Import(ctx, req)
+//	st, _ := c.Task(ctx, res.TaskTag) // RUNNING, then COMPLETE on the next poll
+package hc3test
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+
+	"gcosmiclentil89/ScaleVMFromOVA/hc3"
+)
+
+// Server is a fake HC3 cluster listening on a local port.
+type Server struct {
+	URL string // base URL for hc3.New, e.g. http://127.0.0.1:41234
+
+	srv            *httptest.Server
+	user, password string
+	version        string // HyperCore version the Cluster endpoint reports
+
+	mu      sync.Mutex
+	imports []hc3.ImportRequest
+	tasks   map[string]*task
+	vms     []VirDomain
+	boots   map[string]int // polls of each booting VM so far
+	snaps   []hc3.SnapshotRequest
+	isos    []hc3.ISO
+	fail    []failure
+}
+
+// VirDomain is what the fake lists for an imported VM.
+type VirDomain = hc3.VM
+
+type task struct {
+	status hc3.TaskStatus
+	vm     string // UUID of the VM the task is for
+	done   func() // applies the task's effect once it completes
+}
+
+// guestBootPolls is how many times a started VM is polled before its
+// guest agent answers.
+const guestBootPolls = 2
+
+type failure struct {
+	status int
+	msg    string
+}
+
+// Option configures a Server in NewServer.
+type Option func(*Server)
+
+// WithCredentials makes the server answer 401 unless requests carry this
+// basic auth user and password.
+func WithCredentials(user, password string) Option {
+	return func(s *Server) { s.user, s.password = user, password }
+}
+
+// WithVersion makes the server report this HyperCore version, 9.4.0 by
+// default.
+func WithVersion(v string) Option {
+	return func(s *Server) { s.version = v }
+}
+
+// NewServer starts a fake cluster; Close stops it.
+func NewServer(opts ...Option) *Server {
+	s := &Server{tasks: map[string]*task{}, boots: map[string]int{}, version: "9.4.0"}
+	for _, o := range opts {
+		o(s)
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() { s.srv.Close() }
+
+// FailNextImport makes the next import answer with status and msg instead
+// of queueing a task.
+func (s *Server) FailNextImport(status int, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = append(s.fail, failure{status, msg})
+}
+
+// Snapshots returns the snapshots taken so far, in order.
+func (s *Server) Snapshots() []hc3.SnapshotRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hc3.SnapshotRequest(nil), s.snaps...)
+}
+
+// ISOs returns the ISO library, with images uploaded so far.
+func (s *Server) ISOs() []hc3.ISO {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hc3.ISO(nil), s.isos...)
+}
+
+// AddVM lists vm as if it already existed on the cluster, such as the
+// dummy VM an export was taken from.
+func (s *Server) AddVM(vm VirDomain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vms = append(s.vms, vm)
+}
+
+// Imports returns the import requests received so far, in order.
+func (s *Server) Imports() []hc3.ImportRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hc3.ImportRequest(nil), s.imports...)
+}
+
+// VMs returns the VMs whose import task has completed.
+func (s *Server) VMs() []VirDomain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]VirDomain(nil), s.vms...)
+}
+
+// ServeHTTP implements the endpoints: GET ping, GET Cluster, POST VirDomain/import,
+// POST VirDomain/action, GET TaskTag/{tag}, GET VirDomain, GET, PATCH
+// (tags, machine type and description) and DELETE VirDomain/{uuid}, POST
+// VirDomainSnapshot and VirDomainBlockDevice, PATCH (capacity)
+// VirDomainBlockDevice/{uuid}, and GET and POST ISO, GET ISO/{uuid} and
+// PUT ISO/{uuid}/data, all under /rest/v1. Deleting the VM of an unfinished
+// import fails its task.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.user != "" {
+		if u, p, ok := r.BasicAuth(); !ok || u != s.user || p != s.password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	p, ok := strings.CutPrefix(r.URL.Path, "/rest/v1/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "GET" && p == "ping":
+		reply(w, map[string]string{"status": "Active"})
+	case r.Method == "GET" && p == "Cluster":
+		reply(w, []hc3.ClusterInfo{{UUID: "hc3test", Name: "hc3test", IcosVersion: s.version}})
+	case r.Method == "POST" && p == "VirDomain/import":
+		s.importVM(w, r)
+	case r.Method == "POST" && p == "VirDomain/action":
+		s.action(w, r)
+	case r.Method == "GET" && strings.HasPrefix(p, "TaskTag/"):
+		t, ok := s.tasks[strings.TrimPrefix(p, "TaskTag/")]
+		if !ok {
+			reply(w, []hc3.TaskStatus{})
+			return
+		}
+		s.advance(t)
+		reply(w, []hc3.TaskStatus{t.status})
+	case r.Method == "GET" && p == "VirDomain":
+		reply(w, append([]VirDomain{}, s.vms...))
+	case r.Method == "GET" && strings.HasPrefix(p, "VirDomain/"):
+		id := strings.TrimPrefix(p, "VirDomain/")
+		if vm := s.vm(id); vm != nil {
+			s.boot(vm)
+			reply(w, []VirDomain{*vm})
+			return
+		}
+		http.Error(w, "no such VM "+id, http.StatusNotFound)
+	case r.Method == "PATCH" && strings.HasPrefix(p, "VirDomain/"):
+		id := strings.TrimPrefix(p, "VirDomain/")
+		var req struct {
+			Tags        *string `json:"tags"`
+			MachineType *string `json:"machineType"`
+			Description *string `json:"description"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.vm(id) == nil {
+			http.Error(w, "no such VM "+id, http.StatusNotFound)
+			return
+		}
+		reply(w, hc3.ImportResult{TaskTag: s.queue(id, func() {
+			vm := s.vm(id)
+			if vm != nil && req.Tags != nil {
+				vm.Tags = *req.Tags
+			}
+			if vm != nil && req.MachineType != nil {
+				vm.MachineType = *req.MachineType
+			}
+			if vm != nil && req.Description != nil {
+				vm.Description = *req.Description
+			}
+		})})
+	case r.Method == "POST" && p == "VirDomainSnapshot":
+		var req hc3.SnapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.vm(req.DomainUUID) == nil {
+			http.Error(w, "no such VM "+req.DomainUUID, http.StatusNotFound)
+			return
+		}
+		reply(w, hc3.ImportResult{TaskTag: s.queue(req.DomainUUID, func() { s.snaps = append(s.snaps, req) })})
+	case r.Method == "POST" && p == "VirDomainBlockDevice":
+		var req hc3.BlockDeviceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.vm(req.VirDomainUUID) == nil {
+			http.Error(w, "no such VM "+req.VirDomainUUID, http.StatusNotFound)
+			return
+		}
+		dev := hc3.BlockDevice{UUID: newUUID(), Type: req.Type, Path: req.Path}
+		reply(w, hc3.ImportResult{CreatedUUID: dev.UUID, TaskTag: s.queue(req.VirDomainUUID, func() {
+			if vm := s.vm(req.VirDomainUUID); vm != nil {
+				vm.BlockDevs = append(vm.BlockDevs, dev)
+			}
+		})})
+	case r.Method == "PATCH" && strings.HasPrefix(p, "VirDomainBlockDevice/"):
+		id := strings.TrimPrefix(p, "VirDomainBlockDevice/")
+		var req struct {
+			Capacity int64 `json:"capacity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		vm, dev := s.blockDev(id)
+		if dev == nil {
+			http.Error(w, "no such block device "+id, http.StatusNotFound)
+			return
+		}
+		if req.Capacity < dev.Capacity {
+			http.Error(w, "block devices cannot shrink", http.StatusBadRequest)
+			return
+		}
+		reply(w, hc3.ImportResult{TaskTag: s.queue(vm.UUID, func() {
+			if _, dev := s.blockDev(id); dev != nil {
+				dev.Capacity = req.Capacity
+			}
+		})})
+	case r.Method == "GET" && p == "ISO":
+		reply(w, append([]hc3.ISO{}, s.isos...))
+	case r.Method == "POST" && p == "ISO":
+		var iso hc3.ISO
+		if err := json.NewDecoder(r.Body).Decode(&iso); err != nil || iso.Name == "" {
+			http.Error(w, "want an ISO with a name", http.StatusBadRequest)
+			return
+		}
+		iso.UUID, iso.ReadyForInsert, iso.Path = newUUID(), false, ""
+		s.isos = append(s.isos, iso)
+		reply(w, hc3.ImportResult{CreatedUUID: iso.UUID})
+	case r.Method == "GET" && strings.HasPrefix(p, "ISO/"):
+		id := strings.TrimPrefix(p, "ISO/")
+		if iso := s.iso(id); iso != nil {
+			reply(w, []hc3.ISO{*iso})
+			return
+		}
+		http.Error(w, "no such ISO "+id, http.StatusNotFound)
+	case r.Method == "PUT" && strings.HasPrefix(p, "ISO/") && strings.HasSuffix(p, "/data"):
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "ISO/"), "/data")
+		iso := s.iso(id)
+		if iso == nil {
+			http.Error(w, "no such ISO "+id, http.StatusNotFound)
+			return
+		}
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil || n != iso.Size {
+			http.Error(w, fmt.Sprintf("got %d bytes of %d", n, iso.Size), http.StatusBadRequest)
+			return
+		}
+		iso.ReadyForInsert, iso.Path = true, "scribe/"+iso.UUID
+		reply(w, map[string]string{})
+	case r.Method == "DELETE" && strings.HasPrefix(p, "VirDomain/"):
+		id := strings.TrimPrefix(p, "VirDomain/")
+		for i, vm := range s.vms {
+			if vm.UUID == id {
+				s.vms = append(s.vms[:i], s.vms[i+1:]...)
+				reply(w, map[string]string{})
+				return
+			}
+		}
+		for _, t := range s.tasks {
+			if t.vm == id && !t.status.State.Done() {
+				t.status.State, t.status.FormattedMessage = hc3.TaskError, "VM deleted during import"
+				reply(w, map[string]string{})
+				return
+			}
+		}
+		http.Error(w, "no such VM "+id, http.StatusNotFound)
+	default:
+		http.Error(w, "not implemented by hc3test", http.StatusNotImplemented)
+	}
+}
+
+func (s *Server) importVM(w http.ResponseWriter, r *http.Request) {
+	var req hc3.ImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Source.PathURI == "" || req.Source.DefinitionFileName == "" {
+		http.Error(w, "source.pathURI and source.definitionFileName are required", http.StatusBadRequest)
+		return
+	}
+	s.imports = append(s.imports, req)
+	if len(s.fail) > 0 {
+		f := s.fail[0]
+		s.fail = s.fail[1:]
+		http.Error(w, f.msg, f.status)
+		return
+	}
+	name := strings.TrimSuffix(path.Base(req.Source.DefinitionFileName), ".xml")
+	if req.Template != nil && req.Template.Name != "" {
+		name = req.Template.Name
+	}
+	vm := VirDomain{UUID: newUUID(), Name: name, State: "SHUTOFF"}
+	tag := s.queue(vm.UUID, func() { s.vms = append(s.vms, vm) })
+	reply(w, hc3.ImportResult{TaskTag: tag, CreatedUUID: vm.UUID})
+}
+
+// action starts, shuts down or stops VMs once their task completes.
+func (s *Server) action(w http.ResponseWriter, r *http.Request) {
+	var req []hc3.ActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req) != 1 {
+		http.Error(w, "want a list of one action", http.StatusBadRequest)
+		return
+	}
+	id := req[0].VirDomainUUID
+	if s.vm(id) == nil {
+		http.Error(w, "no such VM "+id, http.StatusNotFound)
+		return
+	}
+	var done func()
+	switch req[0].ActionType {
+	case hc3.ActionStart:
+		done = func() {
+			if vm := s.vm(id); vm != nil {
+				vm.State, s.boots[id] = "RUNNING", 0
+				vm.Console = &hc3.Console{Type: "VNC", IP: "127.0.0.1", Port: 5900 + len(s.boots), Keymap: "en-us"}
+			}
+		}
+	case hc3.ActionShutdown, hc3.ActionStop:
+		done = func() {
+			if vm := s.vm(id); vm != nil {
+				vm.State, vm.GuestAgentState, vm.NetDevs, vm.Console = "SHUTOFF", "", nil, nil
+			}
+		}
+	default:
+		http.Error(w, "unknown actionType "+string(req[0].ActionType), http.StatusBadRequest)
+		return
+	}
+	reply(w, hc3.ImportResult{TaskTag: s.queue(id, done)})
+}
+
+// queue adds a task for VM uuid that runs done when it completes.
+func (s *Server) queue(uuid string, done func()) string {
+	tag := fmt.Sprint(len(s.tasks) + 1)
+	s.tasks[tag] = &task{status: hc3.TaskStatus{TaskTag: tag, State: hc3.TaskQueued}, vm: uuid, done: done}
+	return tag
+}
+
+// vm returns the VM with the given UUID, or nil.
+func (s *Server) vm(uuid string) *VirDomain {
+	for i := range s.vms {
+		if s.vms[i].UUID == uuid {
+			return &s.vms[i]
+		}
+	}
+	return nil
+}
+
+// blockDev returns the block device with the given UUID and its VM, or
+// nils.
+func (s *Server) blockDev(uuid string) (*VirDomain, *hc3.BlockDevice) {
+	for i := range s.vms {
+		for j := range s.vms[i].BlockDevs {
+			if s.vms[i].BlockDevs[j].UUID == uuid {
+				return &s.vms[i], &s.vms[i].BlockDevs[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// iso returns the ISO with the given UUID, or nil.
+func (s *Server) iso(uuid string) *hc3.ISO {
+	for i := range s.isos {
+		if s.isos[i].UUID == uuid {
+			return &s.isos[i]
+		}
+	}
+	return nil
+}
+
+// boot counts a poll of a running VM, whose guest agent answers with an
+// address after guestBootPolls of them.
+func (s *Server) boot(vm *VirDomain) {
+	if vm.State != "RUNNING" || vm.GuestUp() {
+		return
+	}
+	if s.boots[vm.UUID]++; s.boots[vm.UUID] >= guestBootPolls {
+		vm.GuestAgentState = "AVAILABLE"
+		vm.NetDevs = []hc3.NetDev{{MACAddress: "7c:4c:58:00:00:01", IPv4Addresses: []string{fmt.Sprintf("10.0.0.%d", 10+len(s.boots))}}}
+	}
+}
+
+// advance moves a queued t to running and a running one to complete,
+// applying its effect.
+func (s *Server) advance(t *task) {
+	switch t.status.State {
+	case hc3.TaskQueued:
+		t.status.State, t.status.ProgressPercent = hc3.TaskRunning, 50
+	case hc3.TaskRunning:
+		t.status.State, t.status.ProgressPercent = hc3.TaskComplete, 100
+		t.done()
+	}
+}
+
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,84 @@
+package hc3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ISO is an entry of the cluster's ISO library, which CD devices of VMs
+// are backed by.
+type ISO struct {
+	UUID           string `json:"uuid"`
+	Name           string `json:"name"`
+	Size           int64  `json:"size"`
+	ReadyForInsert bool   `json:"readyForInsert"` // set once the data is uploaded
+	Path           string `json:"path"`           // for BlockDeviceRequest.Path
+}
+
+// BlockDevice is a disk or CD device of a VM.
+type BlockDevice struct {
+	UUID     string `json:"uuid"`
+	Type     string `json:"type"` // VIRTIO_DISK, IDE_CDROM, ...
+	Path     string `json:"path,omitempty"`
+	Slot     int    `json:"slot"`
+	Capacity int64  `json:"capacity,omitempty"` // bytes
+}
+
+// BlockDeviceRequest is the body of VirDomainBlockDevice.
+type BlockDeviceRequest struct {
+	VirDomainUUID string `json:"virDomainUUID"`
+	Type          string `json:"type"`
+	Path          string `json:"path"`
+	Capacity      int64  `json:"capacity"`
+}
+
+// CDROM is the BlockDevice type of a CD drive.
+const CDROM = "IDE_CDROM"
+
+// UploadISO adds an ISO image called name to the cluster's library from
+// the size bytes of data and returns it, ready to be inserted.
+func (c *Client) UploadISO(ctx context.Context, name string, data io.Reader, size int64) (ISO, error) {
+	var created ImportResult
+	in := ISO{Name: name, Size: size}
+	if err := c.call(ctx, "POST", "/rest/v1/ISO", in, &created); err != nil {
+		return ISO{}, err
+	}
+	p := "/rest/v1/ISO/" + url.PathEscape(created.CreatedUUID)
+	if err := c.send(ctx, "PUT", p+"/data", data, "application/octet-stream", size, nil); err != nil {
+		return ISO{}, err
+	}
+	var out []ISO
+	if err := c.call(ctx, "GET", p, nil, &out); err != nil {
+		return ISO{}, err
+	}
+	if len(out) == 0 {
+		return ISO{}, &APIError{Method: "GET", Path: p, StatusCode: http.StatusNotFound}
+	}
+	return out[0], nil
+}
+
+// ISOs lists the cluster's ISO library.
+func (c *Client) ISOs(ctx context.Context) ([]ISO, error) {
+	var out []ISO
+	err := c.call(ctx, "GET", "/rest/v1/ISO", nil, &out)
+	return out, err
+}
+
+// AttachCD adds a CD drive holding the ISO at path to the VM and returns
+// the queued task tag.
+func (c *Client) AttachCD(ctx context.Context, uuid, path string) (string, error) {
+	var out ImportResult
+	err := c.call(ctx, "POST", "/rest/v1/VirDomainBlockDevice", BlockDeviceRequest{VirDomainUUID: uuid, Type: CDROM, Path: path}, &out)
+	return out.TaskTag, err
+}
+
+// ResizeDisk grows the block device with the given UUID to capacity bytes
+// and returns the queued task tag. Disks cannot shrink.
+func (c *Client) ResizeDisk(ctx context.Context, uuid string, capacity int64) (string, error) {
+	var out ImportResult
+	in := map[string]int64{"capacity": capacity}
+	err := c.call(ctx, "PATCH", "/rest/v1/VirDomainBlockDevice/"+url.PathEscape(uuid), in, &out)
+	return out.TaskTag, err
+}
@@ -0,0 +1,83 @@
+package ovf
+
+import (
+	"encoding/xml"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BootDevices maps the boot device names of VMware (bios.bootOrder,
+// BootOrderSection) and VirtualBox descriptors to disk, cdrom, network and
+// floppy.
+var BootDevices = map[string]string{
+	"hdd": "disk", "disk": "disk", "harddisk": "disk",
+	"cdrom": "cdrom", "dvd": "cdrom",
+	"ethernet": "network", "net": "network", "network": "network",
+	"floppy": "floppy",
+}
+
+// BootOrder reads the boot order from the descriptor in r: VMware's
+// bios.bootOrder setting or BootOrderSections, or VirtualBox's <Boot>
+// order, as BootDevices names. It returns nil when the descriptor has none.
+func BootOrder(r io.Reader) ([]string, error) {
+	attr := func(se xml.StartElement, local string) string {
+		for _, a := range se.Attr {
+			if a.Name.Local == local {
+				return a.Value
+			}
+		}
+		return ""
+	}
+	var bios, sections []string
+	vbox := map[int]string{}
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "Config", "ExtraConfig":
+			if attr(se, "key") == "bios.bootOrder" {
+				bios = strings.Split(attr(se, "value"), ",")
+			}
+		case "BootOrderSection":
+			sections = append(sections, attr(se, "type"))
+		case "Order":
+			if p, err := strconv.Atoi(attr(se, "position")); err == nil {
+				vbox[p] = attr(se, "device")
+			}
+		}
+	}
+	raw := bios
+	if raw == nil {
+		raw = sections
+	}
+	if raw == nil && len(vbox) > 0 {
+		ps := make([]int, 0, len(vbox))
+		for p := range vbox {
+			ps = append(ps, p)
+		}
+		sort.Ints(ps)
+		for _, p := range ps {
+			raw = append(raw, vbox[p])
+		}
+	}
+	var order []string
+	for _, d := range raw {
+		if dev, ok := BootDevices[strings.ToLower(strings.TrimSpace(d))]; ok && !slices.Contains(order, dev) {
+			order = append(order, dev)
+		}
+	}
+	return order, nil
+}
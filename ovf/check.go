@@ -0,0 +1,117 @@
+package ovf
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Namespaces are the envelope namespaces of OVF 1.x and 2.x.
+var Namespaces = []string{"http://schemas.dmtf.org/ovf/envelope/1", "http://schemas.dmtf.org/ovf/envelope/2"}
+
+// Check checks the structure of the descriptor in data: the envelope,
+// unique file references with safe hrefs, disks that refer to those files
+// with numeric capacities, and hardware disks that refer to those disks.
+// All problems are reported together.
+func Check(data []byte) error {
+	var env struct {
+		XMLName xml.Name
+		Files   []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+			Size string `xml:"size,attr"`
+		} `xml:"References>File"`
+		DiskSections []struct {
+			Disks []struct {
+				ID       string `xml:"diskId,attr"`
+				FileRef  string `xml:"fileRef,attr"`
+				Capacity string `xml:"capacity,attr"`
+			} `xml:"Disk"`
+		} `xml:"DiskSection"`
+		Systems []struct {
+			ID    string `xml:"id,attr"`
+			Items []Item `xml:"VirtualHardwareSection>Item"`
+		} `xml:"VirtualSystem"`
+		Collections []struct{} `xml:"VirtualSystemCollection"`
+	}
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return fmt.Errorf("not well-formed: %w", err)
+	}
+	var errs []error
+	bad := func(format string, a ...any) { errs = append(errs, fmt.Errorf(format, a...)) }
+	if env.XMLName.Local != "Envelope" {
+		bad("document element is <%s>, not <Envelope>", env.XMLName.Local)
+	} else if !strings.HasPrefix(env.XMLName.Space, Namespaces[0]) && !strings.HasPrefix(env.XMLName.Space, Namespaces[1]) {
+		bad("<Envelope> is not in an OVF namespace (%q)", env.XMLName.Space)
+	}
+
+	files := map[string]bool{}
+	for i, fl := range env.Files {
+		switch {
+		case fl.ID == "":
+			bad("File %d has no ovf:id", i+1)
+		case files[fl.ID]:
+			bad("File id %s is used twice", fl.ID)
+		}
+		files[fl.ID] = true
+		if fl.Href == "" {
+			bad("File %s has no ovf:href", fl.ID)
+		} else if _, err := SafeHref(fl.Href); err != nil {
+			bad("File %s: %v", fl.ID, err)
+		}
+		if _, err := strconv.ParseInt(fl.Size, 10, 64); fl.Size != "" && err != nil {
+			bad("File %s has a non-numeric ovf:size %q", fl.ID, fl.Size)
+		}
+	}
+
+	if len(env.DiskSections) > 1 {
+		bad("%d DiskSections, want at most one", len(env.DiskSections))
+	}
+	disks := map[string]bool{}
+	for _, ds := range env.DiskSections {
+		for i, d := range ds.Disks {
+			switch {
+			case d.ID == "":
+				bad("Disk %d has no ovf:diskId", i+1)
+			case disks[d.ID]:
+				bad("Disk id %s is used twice", d.ID)
+			}
+			disks[d.ID] = true
+			if d.FileRef != "" && !files[d.FileRef] {
+				bad("Disk %s refers to missing File %s", d.ID, d.FileRef)
+			}
+			if _, err := strconv.ParseInt(d.Capacity, 10, 64); err != nil {
+				bad("Disk %s has a non-numeric ovf:capacity %q", d.ID, d.Capacity)
+			}
+		}
+	}
+
+	if len(env.Systems) == 0 {
+		if len(env.Collections) > 0 {
+			bad("VirtualSystemCollection (multi-VM OVF) is not supported")
+		} else {
+			bad("no VirtualSystem")
+		}
+	}
+	for _, vs := range env.Systems {
+		for _, it := range vs.Items {
+			if it.ResourceType != "17" || it.HostResource == "" {
+				continue
+			}
+			ref := it.HostResource
+			switch {
+			case strings.HasPrefix(ref, "ovf:/disk/"):
+				if !disks[strings.TrimPrefix(ref, "ovf:/disk/")] {
+					bad("hard disk %q refers to a missing Disk", ref)
+				}
+			case strings.HasPrefix(ref, "ovf:/file/"):
+				if !files[strings.TrimPrefix(ref, "ovf:/file/")] {
+					bad("hard disk %q refers to a missing File", ref)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
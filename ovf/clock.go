@@ -0,0 +1,26 @@
+package ovf
+
+import "strings"
+
+// Clock says how the guest expects its hardware clock to be kept: "utc",
+// "localtime", or "" when the descriptor gives no hint. VirtualBox records
+// it; VMware only records a deliberate offset (rtc.diffFromUTC), so
+// Windows guests, which keep the clock in local time unless told
+// otherwise, are taken to want localtime.
+func (e *Envelope) Clock() string {
+	switch strings.ToLower(e.VBox.RTC.LocalOrUTC) {
+	case "utc":
+		return "utc"
+	case "local":
+		return "localtime"
+	}
+	for _, p := range append(append([]Property(nil), e.Config...), e.ExtraConfig...) {
+		if strings.EqualFold(p.Key, "rtc.diffFromUTC") && strings.TrimSpace(p.Value) != "" && strings.TrimSpace(p.Value) != "0" {
+			return "localtime"
+		}
+	}
+	if e.OS.Windows() {
+		return "localtime"
+	}
+	return ""
+}
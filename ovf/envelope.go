@@ -0,0 +1,145 @@
+// Package ovf reads OVF descriptors (DMTF DSP0243, versions 1 and 2) as
+// written by VMware, VirtualBox and other exporters: the file references,
+// the disk section and the guest OS and virtual hardware of the virtual
+// system.
+package ovf
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Item is a virtual hardware item (RASD) of an OVF, or a StorageItem
+// (SASD) in OVF 2 descriptors. Elements match whatever their namespace.
+type Item struct {
+	ResourceType    string `xml:"ResourceType"`
+	ResourceSubType string `xml:"ResourceSubType"`
+	InstanceID      string `xml:"InstanceID"`
+	ElementName     string `xml:"ElementName"`
+	Parent          string `xml:"Parent"`
+	Address         string `xml:"Address"`
+	AddressOnParent string `xml:"AddressOnParent"`
+	HostResource    string `xml:"HostResource"`
+	Connection      string `xml:"Connection"`
+	VirtualQuantity string `xml:"VirtualQuantity"`
+	AllocationUnits string `xml:"AllocationUnits"`
+}
+
+// File is a file reference of the descriptor.
+type File struct {
+	ID   string `xml:"id,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// Disk is a virtual disk of the disk section, backed by the File FileRef.
+type Disk struct {
+	ID       string `xml:"diskId,attr"`
+	FileRef  string `xml:"fileRef,attr"`
+	Capacity string `xml:"capacity,attr"`
+	Units    string `xml:"capacityAllocationUnits,attr"`
+}
+
+// OperatingSystem is the guest OS section of the virtual system.
+type OperatingSystem struct {
+	ID          string `xml:"id,attr"`     // CIM OS type, e.g. 1 for other
+	OSType      string `xml:"osType,attr"` // VMware's guest ID, e.g. windows9Server64Guest
+	Description string `xml:"Description"`
+	VBoxType    string `xml:"OSType"` // VirtualBox's guest type, e.g. Windows10_64
+}
+
+// Windows reports whether the section names a Windows guest. The CIM
+// type alone is not trusted, as exporters disagree on it; VMware's,
+// VirtualBox's and the description's names are.
+func (o OperatingSystem) Windows() bool {
+	for _, s := range []string{o.OSType, o.VBoxType, o.Description} {
+		s = strings.ToLower(s)
+		if strings.HasPrefix(s, "win") || strings.Contains(s, "windows") {
+			return true
+		}
+	}
+	return false
+}
+
+// Property is a key/value pair of a ProductSection (vApp properties) or
+// of VMware's ExtraConfig.
+type Property struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Envelope holds the parts of an OVF descriptor read here: files, disks
+// and the first virtual system's guest OS, hardware (VirtualBox's own
+// machine section included), annotation and properties.
+type Envelope struct {
+	Files       []File          `xml:"References>File"`
+	Disks       []Disk          `xml:"DiskSection>Disk"`
+	OS          OperatingSystem `xml:"VirtualSystem>OperatingSystemSection"`
+	Items       []Item          `xml:"VirtualSystem>VirtualHardwareSection>Item"`
+	Storage     []Item          `xml:"VirtualSystem>VirtualHardwareSection>StorageItem"`
+	ExtraConfig []Property      `xml:"VirtualSystem>VirtualHardwareSection>ExtraConfig"`
+	Config      []Property      `xml:"VirtualSystem>VirtualHardwareSection>Config"`
+	VBox        VBoxHardware    `xml:"VirtualSystem>Machine>Hardware"`
+	Annotation  string          `xml:"VirtualSystem>AnnotationSection>Annotation"`
+	Properties  []Property      `xml:"VirtualSystem>ProductSection>Property"`
+}
+
+// Parse decodes the descriptor read from r.
+func Parse(r io.Reader) (*Envelope, error) {
+	var env Envelope
+	if err := xml.NewDecoder(r).Decode(&env); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// AllItems returns all hardware items, storage items included.
+func (e *Envelope) AllItems() []Item {
+	return append(append([]Item(nil), e.Items...), e.Storage...)
+}
+
+// Hardware returns the virtual system's CPU count and memory size in
+// bytes; 0 for what the descriptor does not say.
+func (e *Envelope) Hardware() (cpus int, mem int64) {
+	for _, it := range e.Items {
+		n, err := strconv.ParseInt(strings.TrimSpace(it.VirtualQuantity), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch it.ResourceType {
+		case "3":
+			cpus = int(n)
+		case "4":
+			mem = n * AllocationUnits(it.AllocationUnits)
+			if it.AllocationUnits == "" {
+				mem = n << 20 // MB when unstated, as VirtualBox writes it
+			}
+		}
+	}
+	return cpus, mem
+}
+
+var reAllocUnits = regexp.MustCompile(`^byte\s*\*\s*2\^(\d+)$`)
+
+// AllocationUnits turns an OVF capacityAllocationUnits or AllocationUnits
+// such as "byte * 2^30" or "MegaBytes" into a multiplier; a missing value
+// means bytes.
+func AllocationUnits(u string) int64 {
+	u = strings.TrimSpace(u)
+	if m := reAllocUnits.FindStringSubmatch(u); m != nil {
+		if e, _ := strconv.Atoi(m[1]); e < 63 {
+			return 1 << e
+		}
+	}
+	switch strings.ToLower(u) {
+	case "kilobytes", "kb":
+		return 1 << 10
+	case "megabytes", "mb":
+		return 1 << 20
+	case "gigabytes", "gb":
+		return 1 << 30
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+package ovf
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var reFileNum = regexp.MustCompile(`file(\d+)`)
+
+// FileRefs lists the File references of the descriptor in r, wherever
+// they are, ordered by the number in their ids (file1, file2, …) as
+// exporters number them in disk order. Unlike Parse it does not need a
+// well-formed envelope.
+func FileRefs(r io.Reader) ([]File, error) {
+	var files []File
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "File" {
+			var f File
+			for _, a := range se.Attr {
+				if a.Name.Local == "id" {
+					f.ID = a.Value
+				} else if a.Name.Local == "href" {
+					f.Href = a.Value
+				}
+			}
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		li, lj := reFileNum.FindStringSubmatch(files[i].ID), reFileNum.FindStringSubmatch(files[j].ID)
+		if len(li) == 2 && len(lj) == 2 {
+			ni, erri := strconv.Atoi(li[1])
+			nj, errj := strconv.Atoi(lj[1])
+			if erri == nil && errj == nil {
+				return ni < nj
+			}
+			return li[1] < lj[1]
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files, nil
+}
@@ -0,0 +1,30 @@
+package ovf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileRefsOrder(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`<Envelope xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1"><References>`)
+	for _, id := range []string{"file10", "file2", "file1", "file11", "file3", "nvram"} {
+		b.WriteString(`<File ovf:id="` + id + `" ovf:href="` + id + `.vmdk"/>`)
+	}
+	b.WriteString(`</References></Envelope>`)
+
+	files, err := FileRefs(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range files {
+		ids = append(ids, f.ID)
+		if f.Href != f.ID+".vmdk" {
+			t.Errorf("%s: href %q", f.ID, f.Href)
+		}
+	}
+	if got, want := strings.Join(ids, ","), "file1,file2,file3,file10,file11,nvram"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package ovf
+
+import "strings"
+
+// VBoxHardware is the part of VirtualBox's own machine description
+// (vbox:Machine) read here.
+type VBoxHardware struct {
+	Firmware struct {
+		Type string `xml:"type,attr"` // BIOS, EFI, EFI64, ...
+	} `xml:"Firmware"`
+	SecureBoot struct {
+		Enabled string `xml:"enabled,attr"`
+	} `xml:"NVRAM>SecureBoot"`
+	TPM struct {
+		Type string `xml:"type,attr"` // None, v1_2, v2_0, ...
+	} `xml:"TrustedPlatformModule"`
+	RTC struct {
+		LocalOrUTC string `xml:"localOrUTC,attr"` // local or UTC
+	} `xml:"RTC"`
+}
+
+// Firmware is what a descriptor tells about the virtual system's
+// firmware.
+type Firmware struct {
+	EFI        bool // boots UEFI rather than BIOS
+	SecureBoot bool // with secure boot
+	TPM        bool // has a virtual TPM
+}
+
+// Firmware reads the firmware from the descriptor: VMware's firmware and
+// secure-boot settings and vTPM device, or VirtualBox's firmware, secure
+// boot and TPM settings. A descriptor that says nothing yields BIOS.
+func (e *Envelope) Firmware() Firmware {
+	var f Firmware
+	for _, p := range append(append([]Property(nil), e.Config...), e.ExtraConfig...) {
+		v := strings.ToLower(strings.TrimSpace(p.Value))
+		switch strings.ToLower(p.Key) {
+		case "firmware":
+			f.EFI = f.EFI || v == "efi"
+		case "bootoptions.efisecurebootenabled", "uefi.secureboot.enabled":
+			f.SecureBoot = f.SecureBoot || v == "true"
+		case "tpm2.present", "vtpm.present":
+			f.TPM = f.TPM || v == "true"
+		}
+	}
+	for _, it := range e.AllItems() {
+		if strings.Contains(strings.ToLower(it.ResourceSubType), "tpm") ||
+			strings.Contains(strings.ToLower(it.ElementName), "tpm") {
+			f.TPM = true
+		}
+	}
+	if strings.HasPrefix(strings.ToUpper(e.VBox.Firmware.Type), "EFI") {
+		f.EFI = true
+	}
+	if strings.EqualFold(e.VBox.SecureBoot.Enabled, "true") {
+		f.SecureBoot = true
+	}
+	if t := e.VBox.TPM.Type; t != "" && !strings.EqualFold(t, "none") {
+		f.TPM = true
+	}
+	// secure boot and a TPM 2.0 need UEFI firmware
+	f.EFI = f.EFI || f.SecureBoot || f.TPM
+	return f
+}
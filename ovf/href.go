@@ -0,0 +1,48 @@
+package ovf
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SafeHref checks a file href and returns it cleaned and with any
+// percent-encoding decoded, so disks/my%20disk.vmdk names the file
+// "my disk.vmdk" in the disks subdirectory. The descriptor is untrusted
+// input: hrefs must be relative paths that stay inside the export's
+// directory, not URLs, absolute paths or ../ escapes, before and after
+// decoding.
+func SafeHref(href string) (string, error) {
+	c, err := cleanHref(href, href)
+	if err != nil {
+		return "", err
+	}
+	if d, err := url.PathUnescape(c); err == nil && d != c {
+		return cleanHref(href, d)
+	}
+	return c, nil
+}
+
+// LiteralHref is SafeHref without the decoding, for exports whose files
+// are actually named with the percent signs.
+func LiteralHref(href string) (string, error) { return cleanHref(href, href) }
+
+func cleanHref(href, h string) (string, error) {
+	h = strings.ReplaceAll(h, `\`, "/")
+	switch {
+	case h == "":
+		return "", fmt.Errorf("empty href")
+	case strings.Contains(h, "://") || strings.HasPrefix(strings.ToLower(h), "file:"):
+		return "", fmt.Errorf("href %q is a URL, not a file in the export", href)
+	case path.IsAbs(h) || len(h) > 1 && h[1] == ':':
+		return "", fmt.Errorf("href %q is an absolute path", href)
+	case strings.ContainsRune(h, 0):
+		return "", fmt.Errorf("href %q contains a NUL byte", href)
+	}
+	c := path.Clean(h)
+	if c == "." || c == ".." || strings.HasPrefix(c, "../") {
+		return "", fmt.Errorf("href %q points outside the VM directory", href)
+	}
+	return c, nil
+}
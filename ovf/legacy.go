@@ -0,0 +1,51 @@
+package ovf
+
+import "strings"
+
+// LegacyDevice is a floppy drive or a serial or parallel port of the
+// virtual system. Old exports carry them; the target does without.
+type LegacyDevice struct {
+	Kind string `json:"kind"`           // floppy, serial or parallel
+	Name string `json:"name,omitempty"` // as the descriptor calls it
+	Href string `json:"href,omitempty"` // of the exported image backing a floppy drive
+}
+
+// String describes the device, e.g. "floppy (Floppy drive 1, boot.flp)".
+func (d LegacyDevice) String() string {
+	var more []string
+	for _, s := range []string{d.Name, d.Href} {
+		if s != "" {
+			more = append(more, s)
+		}
+	}
+	if len(more) == 0 {
+		return d.Kind
+	}
+	return d.Kind + " (" + strings.Join(more, ", ") + ")"
+}
+
+// legacyKinds maps the CIM resource types of legacy devices to their kind.
+var legacyKinds = map[string]string{"14": "floppy", "21": "serial", "22": "parallel"}
+
+// LegacyDevices lists the floppy drives and serial and parallel ports of
+// the hardware section, with the href of the file backing a floppy drive
+// when the export includes its image.
+func (e *Envelope) LegacyDevices() []LegacyDevice {
+	hrefs := map[string]string{}
+	for _, f := range e.Files {
+		hrefs[f.ID] = f.Href
+	}
+	var out []LegacyDevice
+	for _, it := range e.AllItems() {
+		kind, ok := legacyKinds[it.ResourceType]
+		if !ok {
+			continue
+		}
+		d := LegacyDevice{Kind: kind, Name: strings.TrimSpace(it.ElementName)}
+		if id, ok := strings.CutPrefix(it.HostResource, "ovf:/file/"); ok {
+			d.Href = hrefs[id]
+		}
+		out = append(out, d)
+	}
+	return out
+}
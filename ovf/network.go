@@ -0,0 +1,113 @@
+package ovf
+
+import (
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// NIC is a network adapter of the virtual system.
+type NIC struct {
+	Network string `json:"network,omitempty"` // the Connection it is attached to
+	MAC     string `json:"mac,omitempty"`     // only when the exporter kept it
+}
+
+// GuestNetwork is what a descriptor tells about the guest's network
+// configuration. Exporters differ in what they record, so any part may be
+// empty.
+type GuestNetwork struct {
+	Hostname string   `json:"hostname,omitempty"`
+	IPs      []string `json:"ips,omitempty"`
+	NICs     []NIC    `json:"nics,omitempty"`
+}
+
+// Empty reports whether the descriptor told nothing.
+func (g GuestNetwork) Empty() bool { return g.Hostname == "" && len(g.IPs) == 0 && len(g.NICs) == 0 }
+
+// MACs returns the MAC addresses of the NICs that have one.
+func (g GuestNetwork) MACs() []string {
+	var out []string
+	for _, n := range g.NICs {
+		if n.MAC != "" {
+			out = append(out, n.MAC)
+		}
+	}
+	return out
+}
+
+var (
+	reIPv4      = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	reHostLabel = regexp.MustCompile(`(?i)\b(?:hostname|host name|fqdn)\s*[:=]\s*([a-z0-9][a-z0-9.-]*)`)
+)
+
+// GuestNetwork collects the guest's network configuration from the
+// descriptor: the NICs of the hardware section with their networks and
+// MACs, the hostname and addresses of vApp properties and VMware
+// guestinfo settings (keys such as hostname, guestinfo.ipaddress or
+// ip0), and what the annotation mentions – "hostname: web01" and IPv4
+// addresses.
+func (e *Envelope) GuestNetwork() GuestNetwork {
+	var g GuestNetwork
+	for _, it := range e.Items {
+		if it.ResourceType != "10" {
+			continue
+		}
+		n := NIC{Network: strings.TrimSpace(it.Connection)}
+		if mac, err := net.ParseMAC(strings.TrimSpace(it.Address)); err == nil {
+			n.MAC = mac.String()
+		}
+		g.NICs = append(g.NICs, n)
+	}
+	for _, p := range append(slices.Clone(e.Properties), e.ExtraConfig...) {
+		key, val := strings.ToLower(p.Key), strings.TrimSpace(p.Value)
+		switch {
+		case val == "":
+		case strings.HasSuffix(key, "hostname") || strings.HasSuffix(key, "fqdn"):
+			if g.Hostname == "" {
+				g.Hostname = val
+			}
+		case strings.Contains(key, "ip") && !notOwnAddress(key):
+			for _, f := range strings.FieldsFunc(val, func(r rune) bool { return r == ',' || r == ' ' }) {
+				if ip, _, err := net.ParseCIDR(f); err == nil {
+					f = ip.String()
+				}
+				if net.ParseIP(f) != nil {
+					g.IPs = append(g.IPs, f)
+				}
+			}
+		}
+	}
+	if m := reHostLabel.FindStringSubmatch(e.Annotation); m != nil && g.Hostname == "" {
+		g.Hostname = strings.TrimRight(m[1], ".")
+	}
+	for _, s := range reIPv4.FindAllString(e.Annotation, -1) {
+		if net.ParseIP(s) != nil {
+			g.IPs = append(g.IPs, s)
+		}
+	}
+	g.IPs = unique(g.IPs)
+	return g
+}
+
+// notOwnAddress reports whether a property key names an address other
+// than the guest's own: its netmask, gateway or name servers.
+func notOwnAddress(key string) bool {
+	for _, s := range []string{"mask", "gateway", "dns", "prefix"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// unique drops repeated strings, keeping the first of each.
+func unique(ss []string) []string {
+	var out []string
+	for _, s := range ss {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
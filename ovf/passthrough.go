@@ -0,0 +1,96 @@
+package ovf
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Passthrough is a host device the virtual system has passed through: a
+// PCI device or a vGPU. Neither exists on the target, so the guest may
+// need reconfiguring.
+type Passthrough struct {
+	Kind   string `json:"kind"`             // pci or vgpu
+	Name   string `json:"name"`             // as the descriptor calls it
+	Detail string `json:"detail,omitempty"` // vGPU profile or device ID, when given
+}
+
+// String describes the device, e.g. "vgpu grid_t4-4q (PCI device 0)".
+func (p Passthrough) String() string {
+	s := p.Kind
+	if p.Detail != "" {
+		s += " " + p.Detail
+	}
+	if p.Name != "" {
+		s += " (" + p.Name + ")"
+	}
+	return s
+}
+
+var rePCIPassthru = regexp.MustCompile(`(?i)^(pcipassthru\d+)\.(present|vgpu|deviceid|id)$`)
+
+// Passthrough lists the passthrough devices of the descriptor: hardware
+// items of VMware's vmware.pcipassthrough subtype or named as PCI, GPU or
+// vGPU devices, detailed by VMware's pciPassthruN settings (a vGPU when
+// they name a profile), or those settings alone when there are no such
+// items.
+func (e *Envelope) Passthrough() []Passthrough {
+	var out []Passthrough
+	for _, it := range e.AllItems() {
+		sub, name := strings.ToLower(it.ResourceSubType), strings.ToLower(it.ElementName)
+		if !strings.Contains(sub, "passthrough") && !strings.Contains(sub, "vgpu") &&
+			!strings.Contains(name, "pci device") && !strings.Contains(name, "gpu") {
+			continue
+		}
+		p := Passthrough{Kind: "pci", Name: strings.TrimSpace(it.ElementName)}
+		if strings.Contains(sub, "vgpu") || strings.Contains(name, "vgpu") {
+			p.Kind = "vgpu"
+		}
+		out = append(out, p)
+	}
+	cfg := map[string]*Passthrough{}
+	var order []string
+	for _, c := range append(append([]Property(nil), e.Config...), e.ExtraConfig...) {
+		m := rePCIPassthru.FindStringSubmatch(c.Key)
+		if m == nil {
+			continue
+		}
+		dev := strings.ToLower(m[1])
+		p, ok := cfg[dev]
+		if !ok {
+			p = &Passthrough{Kind: "pci", Name: m[1]}
+			cfg[dev] = p
+			order = append(order, dev)
+		}
+		switch v := strings.TrimSpace(c.Value); strings.ToLower(m[2]) {
+		case "present":
+			if !strings.EqualFold(v, "true") {
+				p.Kind = "" // configured but not present
+			}
+		case "vgpu":
+			if p.Kind != "" {
+				p.Kind = "vgpu"
+			}
+			p.Detail = v
+		default:
+			if p.Detail == "" {
+				p.Detail = v
+			}
+		}
+	}
+	var set []Passthrough
+	for _, dev := range order {
+		if p := cfg[dev]; p.Kind != "" {
+			set = append(set, *p)
+		}
+	}
+	if len(out) == 0 {
+		return set
+	}
+	// VMware writes both, in the same order: the settings add the details
+	for i := range out {
+		if i < len(set) {
+			out[i].Kind, out[i].Detail = set[i].Kind, set[i].Detail
+		}
+	}
+	return out
+}
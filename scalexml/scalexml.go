@@ -0,0 +1,348 @@
+// Package scalexml reads and edits Scale Computing HC3 VM definitions (the
+// libvirt-style XML HC3 exports next to a VM's disk images) without
+// disturbing the parts that are not edited: a document written back is
+// byte-for-byte the one read, except where it was changed.
+package scalexml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Node is an element or a run of anything else (text, CDATA, comments,
+// processing instructions) in a document read by Parse. Nodes keep
+// the bytes they were read from, so a document written back by Bytes is
+// unchanged except where it was edited; only edited start tags and new
+// elements are generated.
+type Node struct {
+	parent   *Node
+	children []*Node
+
+	elem  bool
+	name  xml.Name // as written, with the prefix in Space
+	attrs []xml.Attr
+	text  string // decoded character data of a non-element
+
+	raw   []byte // verbatim start tag, or the whole non-element
+	end   []byte // verbatim end tag; empty for <empty/> elements
+	dirty bool   // start tag must be regenerated from name and attrs
+}
+
+// Parse reads a document into a tree whose root holds the prolog,
+// the document element and anything after it. NUL bytes, which HC3 exports
+// sometimes contain, are dropped.
+func Parse(data []byte) (*Node, error) {
+	data = bytes.ReplaceAll(data, []byte{0}, nil)
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := &Node{elem: true}
+	cur := root
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw := data[start:dec.InputOffset()]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &Node{parent: cur, elem: true, name: t.Name, attrs: t.Copy().Attr, raw: raw}
+			cur.children = append(cur.children, n)
+			cur = n
+		case xml.EndElement:
+			if cur == root || cur.name != t.Name {
+				return nil, fmt.Errorf("unexpected </%s> at offset %d", qname(t.Name), start)
+			}
+			cur.end = raw
+			cur = cur.parent
+		case xml.CharData:
+			cur.children = append(cur.children, &Node{parent: cur, text: string(t), raw: raw})
+		default:
+			cur.children = append(cur.children, &Node{parent: cur, raw: raw})
+		}
+	}
+	if cur != root {
+		return nil, fmt.Errorf("unclosed <%s>", qname(cur.name))
+	}
+	if root.First() == nil {
+		return nil, fmt.Errorf("no document element")
+	}
+	return root, nil
+}
+
+func qname(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// First returns the first child element, if any.
+func (n *Node) First() *Node {
+	for _, c := range n.children {
+		if c.elem {
+			return c
+		}
+	}
+	return nil
+}
+
+// Child returns the first child element with the local name, or nil.
+func (n *Node) Child(local string) *Node {
+	for _, c := range n.children {
+		if c.elem && c.name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// FindAll returns every element below n with the local name, in document
+// order. Prefixes are ignored, as in all lookups here.
+func (n *Node) FindAll(local string) []*Node {
+	var out []*Node
+	for _, c := range n.children {
+		if !c.elem {
+			continue
+		}
+		if c.name.Local == local {
+			out = append(out, c)
+		}
+		out = append(out, c.FindAll(local)...)
+	}
+	return out
+}
+
+// Find returns the first element below n with the local name, or nil.
+func (n *Node) Find(local string) *Node {
+	if all := n.FindAll(local); len(all) > 0 {
+		return all[0]
+	}
+	return nil
+}
+
+// Name returns n's local name, without any namespace prefix; "" for
+// anything but an element.
+func (n *Node) Name() string { return n.name.Local }
+
+// Parent returns the element n is in, or nil for the root and for nodes
+// that were removed.
+func (n *Node) Parent() *Node { return n.parent }
+
+// Attr returns the value of the attribute with the local name, or "".
+func (n *Node) Attr(local string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// SetAttr sets or adds an attribute, keeping the others in their order.
+func (n *Node) SetAttr(local, value string) {
+	n.dirty = true
+	for i, a := range n.attrs {
+		if a.Name.Local == local {
+			n.attrs[i].Value = value
+			return
+		}
+	}
+	n.attrs = append(n.attrs, xml.Attr{Name: xml.Name{Local: local}, Value: value})
+}
+
+// Remove drops the child element c together with the whitespace that
+// indented it, so no blank line is left behind.
+func (n *Node) Remove(c *Node) {
+	for i, x := range n.children {
+		if x != c {
+			continue
+		}
+		lo := i
+		if i > 0 && isBlank(n.children[i-1]) {
+			lo = i - 1
+		}
+		n.children = append(n.children[:lo], n.children[i+1:]...)
+		c.parent = nil
+		return
+	}
+}
+
+// Clone returns a deep copy of n without a parent, for inserting
+// elsewhere.
+func (n *Node) Clone() *Node {
+	c := *n
+	c.parent = nil
+	c.attrs = append([]xml.Attr(nil), n.attrs...)
+	c.children = make([]*Node, len(n.children))
+	for i, x := range n.children {
+		c.children[i] = x.Clone()
+		c.children[i].parent = &c
+	}
+	return &c
+}
+
+// InsertAfter puts c right after the child element ref, on a line of its
+// own indented like ref.
+func (n *Node) InsertAfter(ref, c *Node) {
+	for i, x := range n.children {
+		if x != ref {
+			continue
+		}
+		c.parent = n
+		ws := "\n" + ref.indent()
+		ins := []*Node{{parent: n, text: ws, raw: []byte(ws)}, c}
+		n.children = append(n.children[:i+1], append(ins, n.children[i+1:]...)...)
+		return
+	}
+}
+
+// Clear removes all of n's content.
+func (n *Node) Clear() {
+	for _, c := range n.children {
+		c.parent = nil
+	}
+	n.children = nil
+}
+
+// InnerText returns the character data directly inside n, trimmed.
+func (n *Node) InnerText() string {
+	var b strings.Builder
+	for _, c := range n.children {
+		if !c.elem {
+			b.WriteString(c.text)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// SetText replaces n's content with the character data s.
+func (n *Node) SetText(s string) {
+	n.Clear()
+	var esc bytes.Buffer
+	xml.EscapeText(&esc, []byte(s))
+	n.children = []*Node{{parent: n, text: s, raw: esc.Bytes()}}
+	if len(n.end) == 0 {
+		n.dirty = true
+	}
+}
+
+func isBlank(n *Node) bool {
+	return !n.elem && strings.TrimSpace(n.text) == "" && len(bytes.TrimSpace(n.raw)) == 0
+}
+
+// indent returns the whitespace before n's own line, as used in its
+// document; "" when it is not on a line of its own.
+func (n *Node) indent() string {
+	p := n.parent
+	if p == nil {
+		return ""
+	}
+	for i, c := range p.children {
+		if c == n && i > 0 && isBlank(p.children[i-1]) {
+			ws := p.children[i-1].text
+			if j := strings.LastIndexByte(ws, '\n'); j >= 0 {
+				return ws[j+1:]
+			}
+		}
+	}
+	return ""
+}
+
+// Add appends a new element after n's last child element, indented like
+// its siblings (or one step deeper than n), and returns it. The element
+// takes n's namespace prefix.
+func (n *Node) Add(local string, attrs ...xml.Attr) *Node {
+	c := &Node{parent: n, elem: true, name: xml.Name{Space: n.name.Space, Local: local}, attrs: attrs, dirty: true}
+	ind := n.indent()
+	childInd := ind + "  "
+	var last *Node
+	for _, x := range n.children {
+		if x.elem {
+			last = x
+		}
+	}
+	if last != nil {
+		if li := last.indent(); li != "" {
+			childInd = li
+		}
+	}
+	ws := func(s string) *Node { return &Node{parent: n, text: s, raw: []byte(s)} }
+
+	// insert before the whitespace that indents n's end tag
+	at := len(n.children)
+	if at > 0 && isBlank(n.children[at-1]) {
+		at--
+	} else {
+		n.children = append(n.children, ws("\n"+ind))
+	}
+	ins := []*Node{ws("\n" + childInd), c}
+	n.children = append(n.children[:at], append(ins, n.children[at:]...)...)
+	if len(n.end) == 0 {
+		n.dirty = true // was <n/>; needs a separate end tag now
+	}
+	return c
+}
+
+// Bytes serialises the tree, verbatim where nothing was changed.
+func (n *Node) Bytes() []byte {
+	var b bytes.Buffer
+	for _, c := range n.children {
+		c.write(&b)
+	}
+	return b.Bytes()
+}
+
+func (n *Node) write(b *bytes.Buffer) {
+	if !n.elem {
+		b.Write(n.raw)
+		return
+	}
+	if !n.dirty {
+		b.Write(n.raw)
+	} else {
+		b.WriteString("<" + qname(n.name))
+		for _, a := range n.attrs {
+			b.WriteString(" " + qname(a.Name) + `="`)
+			xml.EscapeText(b, []byte(a.Value))
+			b.WriteString(`"`)
+		}
+		if len(n.children) == 0 {
+			b.WriteString("/>")
+			return
+		}
+		b.WriteString(">")
+	}
+	for _, c := range n.children {
+		c.write(b)
+	}
+	if len(n.end) > 0 {
+		b.Write(n.end)
+	} else if n.dirty {
+		b.WriteString("</" + qname(n.name) + ">")
+	}
+}
+
+// DiskUUIDs lists the block-device UUIDs of the network disks of the
+// definition below n, the last element of each disk's source name, in
+// document order.
+func (n *Node) DiskUUIDs() []string {
+	var uuids []string
+	for _, d := range n.FindAll("disk") {
+		if d.Attr("type") != "network" || d.Attr("device") != "disk" {
+			continue
+		}
+		for _, s := range d.FindAll("source") {
+			if v := s.Attr("name"); v != "" {
+				uuids = append(uuids, path.Base(v))
+			}
+		}
+	}
+	return uuids
+}
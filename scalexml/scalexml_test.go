@@ -0,0 +1,120 @@
+package scalexml
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+const doc = `<?xml version='1.0' encoding='UTF-8'?>
+<!-- exported by HC3 -->
+<domain type='kvm' xmlns:scale="urn:scale">
+  <name>web01</name>
+  <description><![CDATA[a <b>bold</b> note]]></description>
+  <devices>
+    <disk type="network"   device="disk">
+      <source name="scale/11111111-1111-1111-1111-111111111111"/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type="network" device="cdrom"><source name="scale/iso"/></disk>
+    <disk type='network' device='disk'>
+      <source name='scale/22222222-2222-2222-2222-222222222222'/>
+    </disk>
+  </devices>
+  <scale:metadata>
+    <scale:tags/>
+  </scale:metadata>
+</domain>
+`
+
+func parse(t *testing.T, s string) *Node {
+	t.Helper()
+	n, err := Parse([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRoundTrip(t *testing.T) {
+	if got := string(parse(t, doc).Bytes()); got != doc {
+		t.Errorf("unedited document changed:\n%s", got)
+	}
+}
+
+func TestParseDropsNUL(t *testing.T) {
+	got := string(parse(t, "<domain>\x00<name>a</name></domain>").Bytes())
+	if want := "<domain><name>a</name></domain>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"", "<!-- nothing -->", "<domain>", "<domain></name>"} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("Parse(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSetAttrOnlyRewritesThatTag(t *testing.T) {
+	n := parse(t, doc)
+	n.Find("target").SetAttr("bus", "ide")
+	want := strings.Replace(doc, `<target dev='vda' bus='virtio'/>`, `<target dev="vda" bus="ide"/>`, 1)
+	if got := string(n.Bytes()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSetText(t *testing.T) {
+	n := parse(t, doc)
+	n.Find("name").SetText("a&b")
+	want := strings.Replace(doc, "<name>web01</name>", "<name>a&amp;b</name>", 1)
+	if got := string(n.Bytes()); got != want {
+		t.Errorf("got\n%s", got)
+	}
+	if got := n.Find("name").InnerText(); got != "a&b" {
+		t.Errorf("InnerText = %q", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	n := parse(t, doc)
+	devices := n.Find("devices")
+	devices.Remove(devices.FindAll("disk")[1])
+	want := strings.Replace(doc, "\n    <disk type=\"network\" device=\"cdrom\"><source name=\"scale/iso\"/></disk>", "", 1)
+	if got := string(n.Bytes()); got != want {
+		t.Errorf("got\n%s", got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	n := parse(t, doc)
+	n.Find("tags").Add("tag", xml.Attr{Name: xml.Name{Local: "name"}, Value: "imported"})
+	want := strings.Replace(doc, "    <scale:tags/>", "    <scale:tags>\n      <scale:tag name=\"imported\"/>\n    </scale:tags>", 1)
+	if got := string(n.Bytes()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInsertAfter(t *testing.T) {
+	n := parse(t, doc)
+	devices := n.Find("devices")
+	disks := devices.FindAll("disk")
+	devices.InsertAfter(disks[2], disks[0].Clone())
+	if got := len(parse(t, string(n.Bytes())).FindAll("disk")); got != 4 {
+		t.Errorf("%d disks after inserting a clone, want 4", got)
+	}
+	want := "    </disk>\n    <disk type=\"network\"   device=\"disk\">\n      <source name=\"scale/11111111"
+	if got := string(n.Bytes()); !strings.Contains(got, want) {
+		t.Errorf("clone not indented like its sibling:\n%s", got)
+	}
+}
+
+func TestDiskUUIDs(t *testing.T) {
+	got := parse(t, doc).DiskUUIDs()
+	want := []string{"11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,369 @@
+package smb
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"gcosmiclentil89/ScaleVMFromOVA/smb/internal/sign"
+)
+
+// Commands (MS-SMB2 2.2.1).
+const (
+	cmdNegotiate      = 0x00
+	cmdSessionSetup   = 0x01
+	cmdLogoff         = 0x02
+	cmdTreeConnect    = 0x03
+	cmdTreeDisconnect = 0x04
+	cmdCreate         = 0x05
+	cmdClose          = 0x06
+	cmdFlush          = 0x07
+	cmdRead           = 0x08
+	cmdWrite          = 0x09
+	cmdQueryDirectory = 0x0e
+	cmdQueryInfo      = 0x10
+	cmdSetInfo        = 0x11
+)
+
+// Header flags.
+const (
+	flagResponse = 0x00000001
+	flagAsync    = 0x00000002
+	flagSigned   = 0x00000008
+)
+
+const (
+	headerSize = 64
+
+	// creditUnit is what one credit pays for of a large read or write.
+	creditUnit = 64 << 10
+
+	// wantCredits is the balance the client asks the server to keep it at,
+	// enough for a few 1MiB writes.
+	wantCredits = 128
+
+	// maxMessage bounds a message from the server; NetBIOS framing allows
+	// 16MiB and reads are never asked for more than maxChunk.
+	maxMessage = 1<<24 - 1
+
+	// maxChunk bounds each read and write, whatever the server allows.
+	maxChunk = 1 << 20
+)
+
+var errMalformed = errors.New("smb: malformed response")
+
+// ErrClosed is wrapped by the errors of a session whose connection broke
+// or was closed; dial a new one.
+var ErrClosed = errors.New("smb: connection closed")
+
+// conn is the transport of a session: SMB2 messages over TCP, one request
+// at a time.
+type conn struct {
+	nc      net.Conn
+	timeout time.Duration
+
+	mu      sync.Mutex // held for a round trip
+	msgID   uint64
+	credits int
+	err     error // sticky: the connection is unusable once set
+
+	dialect        uint16
+	requireSigning bool // the server's security mode asks for it
+	largeMTU       bool
+	maxRead        uint32
+	maxWrite       uint32
+	maxTransact    uint32
+	preauth        []byte // SMB 3.1.1 preauth integrity hash of the negotiation
+}
+
+// request is one command to send; s and tree identify what it is for.
+type request struct {
+	cmd     uint16
+	s       *Session
+	tree    uint32
+	body    []byte
+	payload int     // bytes read or written, for the credit charge
+	preauth *[]byte // hash to extend with the request, during setup
+	sign    bool    // sign even if the session does not
+}
+
+// response is a final response to a request.
+type response struct {
+	status    Status
+	flags     uint32
+	sessionID uint64
+	treeID    uint32
+	raw       []byte // the whole message
+	body      []byte // after the header
+}
+
+// chunk returns how much of want one read or write should move, within
+// limit, the server's maximum for the command.
+func (c *conn) chunk(want int, limit uint32) int {
+	n := min(want, int(limit), maxChunk)
+	if !c.largeMTU {
+		n = min(n, creditUnit)
+	}
+	c.mu.Lock()
+	credits := c.credits
+	c.mu.Unlock()
+	return max(min(n, credits*creditUnit), 1)
+}
+
+// roundTrip sends r and waits for its final response, skipping the
+// interim ones of requests the server finishes asynchronously. Errors of
+// the transport break the connection for good; a response with a failure
+// status is returned as it is.
+func (c *conn) roundTrip(r request) (*response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	charge := 1
+	if c.largeMTU && r.payload > creditUnit {
+		charge = (r.payload-1)/creditUnit + 1
+	}
+	if charge > c.credits {
+		return nil, fmt.Errorf("smb: %d credits needed, %d granted", charge, c.credits)
+	}
+	id := c.msgID
+	c.msgID += uint64(charge)
+	c.credits -= charge
+
+	body := r.body
+	if size := int(get16(body)); len(body) < size {
+		// odd structure sizes count a byte of the variable part
+		body = append(body, make([]byte, size-len(body))...)
+	}
+	m := make([]byte, 4+headerSize, 4+headerSize+len(body))
+	h := m[4:]
+	copy(h, "\xfeSMB")
+	put16(h[4:], headerSize)
+	if c.dialect > sign.SMB202 {
+		put16(h[6:], uint16(charge))
+	}
+	put16(h[12:], r.cmd)
+	put16(h[14:], uint16(max(charge, wantCredits-c.credits)))
+	put64(h[24:], id)
+	put32(h[36:], r.tree)
+	var signKey []byte
+	if r.s != nil {
+		put64(h[40:], r.s.id)
+		if r.s.signing || r.sign {
+			signKey = r.s.signKey
+		}
+	}
+	m = append(m, body...)
+	msg := m[4:]
+	if signKey != nil {
+		put32(msg[16:], get32(msg[16:])|flagSigned)
+		sign.Sign(c.dialect, signKey, msg)
+	}
+	if r.preauth != nil {
+		*r.preauth = preauthHash(*r.preauth, msg)
+	}
+	binary.BigEndian.PutUint32(m, uint32(len(msg)))
+
+	c.deadline()
+	if _, err := c.nc.Write(m); err != nil {
+		return nil, c.fail(err)
+	}
+	for {
+		c.deadline()
+		resp, err := c.read()
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		if get64(resp.raw[24:]) == 0xffffffffffffffff {
+			continue // an oplock break; none are asked for
+		}
+		if get64(resp.raw[24:]) != id {
+			return nil, c.fail(fmt.Errorf("smb: response to message %d, want %d", get64(resp.raw[24:]), id))
+		}
+		c.credits += int(get16(resp.raw[14:]))
+		if resp.status == StatusPending && resp.flags&flagAsync != 0 {
+			continue
+		}
+		if signKey != nil {
+			if resp.flags&flagSigned != 0 && !sign.Verify(c.dialect, signKey, resp.raw) {
+				return nil, c.fail(errors.New("smb: bad signature on a response"))
+			}
+			if resp.flags&flagSigned == 0 && resp.status == StatusSuccess {
+				return nil, c.fail(errors.New("smb: unsigned response in a signed session"))
+			}
+		}
+		return resp, nil
+	}
+}
+
+// read reads one message.
+func (c *conn) read() (*response, error) {
+	var nb [4]byte
+	if _, err := io.ReadFull(c.nc, nb[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(nb[:])
+	if nb[0] != 0 || n < headerSize || n > maxMessage {
+		return nil, errMalformed
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(c.nc, raw); err != nil {
+		return nil, err
+	}
+	if string(raw[:4]) != "\xfeSMB" {
+		if string(raw[:4]) == "\xfdSMB" {
+			return nil, errors.New("smb: the server sent an encrypted message, which is not supported")
+		}
+		return nil, errMalformed
+	}
+	r := &response{
+		status:    Status(get32(raw[8:])),
+		flags:     get32(raw[16:]),
+		sessionID: get64(raw[40:]),
+		raw:       raw,
+		body:      raw[headerSize:],
+	}
+	if r.flags&flagResponse == 0 {
+		return nil, errMalformed
+	}
+	if r.flags&flagAsync == 0 {
+		r.treeID = get32(raw[36:])
+	}
+	return r, nil
+}
+
+func (c *conn) deadline() {
+	if c.timeout > 0 {
+		c.nc.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+// fail breaks the connection with err.
+func (c *conn) fail(err error) error {
+	c.err = fmt.Errorf("%w: %v", ErrClosed, err)
+	c.nc.Close()
+	return c.err
+}
+
+// close closes the connection; later requests fail.
+func (c *conn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	return c.nc.Close()
+}
+
+// dialects are those offered, oldest first.
+var dialects = []uint16{sign.SMB202, sign.SMB210, sign.SMB300, sign.SMB302, sign.SMB311}
+
+// Security modes and capabilities.
+const (
+	signingEnabled  = 0x01
+	signingRequired = 0x02
+	capLargeMTU     = 0x00000004
+)
+
+// negotiate agrees on a dialect and the limits of the connection.
+func (c *conn) negotiate() error {
+	b := make([]byte, 36)
+	put16(b, 36)
+	put16(b[2:], uint16(len(dialects)))
+	put16(b[4:], signingEnabled)
+	put32(b[8:], capLargeMTU)
+	rand.Read(b[12:28]) // client GUID
+	for _, d := range dialects {
+		b = binary.LittleEndian.AppendUint16(b, d)
+	}
+	// SMB 3.1.1 needs a preauth integrity context: SHA-512 with a salt
+	for (headerSize+len(b))%8 != 0 {
+		b = append(b, 0)
+	}
+	put32(b[28:], uint32(headerSize+len(b)))
+	put16(b[32:], 1)
+	ctx := make([]byte, 8+38)
+	put16(ctx, 1)
+	put16(ctx[2:], 38)
+	put16(ctx[8:], 1)
+	put16(ctx[10:], 32)
+	put16(ctx[12:], 1)
+	rand.Read(ctx[14:])
+	b = append(b, ctx...)
+
+	preauth := make([]byte, 64)
+	resp, err := c.roundTrip(request{cmd: cmdNegotiate, body: b, preauth: &preauth})
+	if err != nil {
+		return err
+	}
+	if resp.status != StatusSuccess {
+		return resp.status
+	}
+	rb := resp.body
+	if len(rb) < 64 {
+		return errMalformed
+	}
+	c.dialect = get16(rb[4:])
+	c.requireSigning = get16(rb[2:])&signingRequired != 0
+	c.largeMTU = c.dialect > sign.SMB202 && get32(rb[24:])&capLargeMTU != 0
+	c.maxTransact, c.maxRead, c.maxWrite = get32(rb[28:]), get32(rb[32:]), get32(rb[36:])
+	switch c.dialect {
+	case sign.SMB202, sign.SMB210, sign.SMB300, sign.SMB302:
+	case sign.SMB311:
+		if err := checkPreauthContext(resp.raw, int(get32(rb[60:])), int(get16(rb[6:]))); err != nil {
+			return err
+		}
+		c.preauth = preauthHash(preauth, resp.raw)
+	default:
+		return fmt.Errorf("smb: the server chose dialect %#x, which was not offered", c.dialect)
+	}
+	if c.maxRead < 1 || c.maxWrite < 1 || c.maxTransact < 1 {
+		return errMalformed
+	}
+	return nil
+}
+
+// checkPreauthContext checks the negotiate contexts of an SMB 3.1.1
+// response settle on SHA-512 for preauth integrity.
+func checkPreauthContext(msg []byte, off, count int) error {
+	for i := 0; i < count; i++ {
+		if off+8 > len(msg) {
+			return errMalformed
+		}
+		typ, n := get16(msg[off:]), int(get16(msg[off+2:]))
+		if off+8+n > len(msg) {
+			return errMalformed
+		}
+		if typ == 1 {
+			if n < 6 || get16(msg[off+8:]) != 1 || get16(msg[off+12:]) != 1 {
+				return errors.New("smb: the server's preauth integrity hash is not SHA-512")
+			}
+			return nil
+		}
+		off += (8 + n + 7) &^ 7
+	}
+	return errors.New("smb: no preauth integrity context in the SMB 3.1.1 negotiation")
+}
+
+// preauthHash extends the SMB 3.1.1 preauth integrity hash h with msg.
+func preauthHash(h, msg []byte) []byte {
+	s := sha512.New()
+	s.Write(h)
+	s.Write(msg)
+	return s.Sum(nil)
+}
+
+func get16(b []byte) uint16    { return binary.LittleEndian.Uint16(b) }
+func get32(b []byte) uint32    { return binary.LittleEndian.Uint32(b) }
+func get64(b []byte) uint64    { return binary.LittleEndian.Uint64(b) }
+func put16(b []byte, v uint16) { binary.LittleEndian.PutUint16(b, v) }
+func put32(b []byte, v uint32) { binary.LittleEndian.PutUint32(b, v) }
+func put64(b []byte, v uint64) { binary.LittleEndian.PutUint64(b, v) }
@@ -0,0 +1,307 @@
+// Package ntlm implements NTLMv2 authentication (MS-NLMP) as SMB carries
+// it inside SPNEGO: the client's negotiate and authenticate messages and,
+// for the smbtest server, the challenge and its check.
+//
+// Only NTLMv2 responses are produced or accepted; LM and NTLMv1 are not.
+package ntlm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Negotiate flags (MS-NLMP 2.2.2.5) this package uses.
+const (
+	flagUnicode          = 0x00000001
+	flagRequestTarget    = 0x00000004
+	flagSign             = 0x00000010
+	flagNTLM             = 0x00000200
+	flagAnonymous        = 0x00000800
+	flagAlwaysSign       = 0x00008000
+	flagExtendedSecurity = 0x00080000
+	flagTargetInfo       = 0x00800000
+	flag128              = 0x20000000
+	flagKeyExch          = 0x40000000
+	flag56               = 0x80000000
+)
+
+const clientFlags = flagUnicode | flagRequestTarget | flagSign | flagNTLM | flagAlwaysSign |
+	flagExtendedSecurity | flagTargetInfo | flag128 | flagKeyExch | flag56
+
+// AV_PAIR ids of the target info.
+const (
+	avEOL            = 0
+	avNbComputerName = 1
+	avNbDomainName   = 2
+	avTimestamp      = 7
+)
+
+var signature = []byte("NTLMSSP\x00")
+
+// ErrLogonFailure is returned by Verify when the response does not match
+// the user's password, or the user is unknown.
+var ErrLogonFailure = errors.New("ntlm: logon failure")
+
+var errMalformed = errors.New("ntlm: malformed message")
+
+// Client authenticates as User in Domain. With neither a user nor a
+// password it logs in anonymously.
+type Client struct {
+	User, Password, Domain string
+	Workstation            string
+
+	rand io.Reader        // crypto/rand.Reader if nil
+	now  func() time.Time // time.Now if nil
+}
+
+// Negotiate returns the first message, which opens the exchange.
+func (c *Client) Negotiate() []byte {
+	m := make([]byte, 32)
+	copy(m, signature)
+	put32(m[8:], 1)
+	put32(m[12:], clientFlags)
+	put32(m[20:], 32) // empty domain and workstation fields
+	put32(m[28:], 32)
+	return m
+}
+
+// Authenticate answers the server's challenge message. It returns the
+// message and the session key, nil for an anonymous login.
+func (c *Client) Authenticate(challenge []byte) (msg, sessionKey []byte, err error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], signature) || get32(challenge[8:]) != 2 {
+		return nil, nil, errMalformed
+	}
+	flags := get32(challenge[20:])&clientFlags | flagExtendedSecurity
+	if flags&flagUnicode == 0 {
+		return nil, nil, errors.New("ntlm: the server does not speak Unicode")
+	}
+	var serverChallenge [8]byte
+	copy(serverChallenge[:], challenge[24:32])
+	targetInfo, err := field(challenge, 40)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lm, nt, encryptedKey []byte
+	if c.User == "" && c.Password == "" {
+		flags |= flagAnonymous
+		flags &^= flagKeyExch
+		lm = []byte{0}
+	} else {
+		r := c.rand
+		if r == nil {
+			r = rand.Reader
+		}
+		var clientChallenge [8]byte
+		if _, err := io.ReadFull(r, clientChallenge[:]); err != nil {
+			return nil, nil, err
+		}
+		ts, haveTS := avPair(targetInfo, avTimestamp)
+		if !haveTS {
+			now := time.Now
+			if c.now != nil {
+				now = c.now
+			}
+			ts = binary.LittleEndian.AppendUint64(nil, filetime(now()))
+		}
+		owf := ntowfv2(c.User, c.Password, c.Domain)
+		nt = response(owf, serverChallenge, clientChallenge, ts, targetInfo)
+		if haveTS {
+			lm = make([]byte, 24) // MS-NLMP 3.1.5.1.2: no LMv2 with a timestamp
+		} else {
+			lm = append(hmacMD5(owf, serverChallenge[:], clientChallenge[:]), clientChallenge[:]...)
+		}
+		sessionKey = hmacMD5(owf, nt[:16])
+		if flags&flagKeyExch != 0 {
+			exported := make([]byte, 16)
+			if _, err := io.ReadFull(r, exported); err != nil {
+				return nil, nil, err
+			}
+			encryptedKey = rc4Crypt(sessionKey, exported)
+			sessionKey = exported
+		}
+	}
+
+	m := make([]byte, 64)
+	copy(m, signature)
+	put32(m[8:], 3)
+	put32(m[60:], flags)
+	for i, f := range [][]byte{lm, nt, utf16le(c.Domain), utf16le(c.User), utf16le(c.Workstation), encryptedKey} {
+		at := 12 + 8*i
+		put16(m[at:], uint16(len(f)))
+		put16(m[at+2:], uint16(len(f)))
+		put32(m[at+4:], uint32(len(m)))
+		m = append(m, f...)
+	}
+	return m, sessionKey, nil
+}
+
+// Challenge returns the server's answer to a negotiate message, naming
+// its NetBIOS domain and computer and carrying serverChallenge.
+func Challenge(serverChallenge [8]byte, domain, computer string, now time.Time) []byte {
+	var info []byte
+	for _, p := range []struct {
+		id uint16
+		v  []byte
+	}{
+		{avNbDomainName, utf16le(domain)},
+		{avNbComputerName, utf16le(computer)},
+		{avTimestamp, binary.LittleEndian.AppendUint64(nil, filetime(now))},
+		{avEOL, nil},
+	} {
+		info = binary.LittleEndian.AppendUint16(info, p.id)
+		info = binary.LittleEndian.AppendUint16(info, uint16(len(p.v)))
+		info = append(info, p.v...)
+	}
+	name := utf16le(domain)
+	m := make([]byte, 48)
+	copy(m, signature)
+	put32(m[8:], 2)
+	put16(m[12:], uint16(len(name)))
+	put16(m[14:], uint16(len(name)))
+	put32(m[16:], 48)
+	put32(m[20:], clientFlags|flagTargetInfo)
+	copy(m[24:], serverChallenge[:])
+	put16(m[40:], uint16(len(info)))
+	put16(m[42:], uint16(len(info)))
+	put32(m[44:], uint32(48+len(name)))
+	return append(append(m, name...), info...)
+}
+
+// Verify checks the client's authenticate message against the challenge
+// message it answers, looking up the user's password with password. It
+// returns the user and the session key, nil for an anonymous login.
+func Verify(challenge, authenticate []byte, password func(user, domain string) (string, bool)) (user string, sessionKey []byte, err error) {
+	if len(challenge) < 48 || len(authenticate) < 64 || !bytes.Equal(authenticate[:8], signature) || get32(authenticate[8:]) != 3 {
+		return "", nil, errMalformed
+	}
+	var f [6][]byte
+	for i := range f {
+		if f[i], err = field(authenticate, 12+8*i); err != nil {
+			return "", nil, err
+		}
+	}
+	lm, nt, domain, encryptedKey := f[0], f[1], fromUTF16le(f[2]), f[5]
+	user = fromUTF16le(f[3])
+	flags := get32(authenticate[60:])
+	if user == "" && len(nt) == 0 && len(lm) <= 1 {
+		return "", nil, nil
+	}
+	pass, ok := password(user, domain)
+	if !ok || len(nt) < 16+28 {
+		return "", nil, ErrLogonFailure
+	}
+	var serverChallenge [8]byte
+	copy(serverChallenge[:], challenge[24:32])
+	owf := ntowfv2(user, pass, domain)
+	temp := nt[16:]
+	if !hmac.Equal(hmacMD5(owf, serverChallenge[:], temp)[:16], nt[:16]) {
+		return "", nil, ErrLogonFailure
+	}
+	sessionKey = hmacMD5(owf, nt[:16])
+	if flags&flagKeyExch != 0 {
+		if len(encryptedKey) != 16 {
+			return "", nil, errMalformed
+		}
+		sessionKey = rc4Crypt(sessionKey, encryptedKey)
+	}
+	return user, sessionKey, nil
+}
+
+// ntowfv2 is the NTLMv2 response key of MS-NLMP 3.3.2.
+func ntowfv2(user, password, domain string) []byte {
+	return hmacMD5(md4(utf16le(password)), utf16le(strings.ToUpper(user)+domain))
+}
+
+// response is the NTLMv2 NtChallengeResponse: the proof followed by the
+// client blob it covers.
+func response(owf []byte, serverChallenge, clientChallenge [8]byte, timestamp, targetInfo []byte) []byte {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge[:]...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	return append(hmacMD5(owf, serverChallenge[:], temp), temp...)
+}
+
+// avPair returns the value of the AV_PAIR id in targetInfo.
+func avPair(targetInfo []byte, id uint16) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		pid, n := get16(targetInfo), int(get16(targetInfo[2:]))
+		if pid == avEOL || len(targetInfo) < 4+n {
+			break
+		}
+		if pid == id {
+			return targetInfo[4 : 4+n], true
+		}
+		targetInfo = targetInfo[4+n:]
+	}
+	return nil, false
+}
+
+// field returns the payload a length/offset field at m[at:] points to.
+func field(m []byte, at int) ([]byte, error) {
+	if len(m) < at+8 {
+		return nil, errMalformed
+	}
+	n, off := int(get16(m[at:])), int(get32(m[at+4:]))
+	if off > len(m) || n > len(m)-off {
+		return nil, errMalformed
+	}
+	return m[off : off+n], nil
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func rc4Crypt(key, data []byte) []byte {
+	c, err := rc4.NewCipher(key)
+	if err != nil {
+		panic(fmt.Sprintf("ntlm: %v", err)) // only for bad key sizes
+	}
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+// filetime converts t to 100ns intervals since 1601, as Windows counts.
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func fromUTF16le(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = get16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func get16(b []byte) uint16    { return binary.LittleEndian.Uint16(b) }
+func get32(b []byte) uint32    { return binary.LittleEndian.Uint32(b) }
+func put16(b []byte, v uint16) { binary.LittleEndian.PutUint16(b, v) }
+func put32(b []byte, v uint32) { binary.LittleEndian.PutUint32(b, v) }
@@ -0,0 +1,111 @@
+package ntlm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestMD4(t *testing.T) {
+	// RFC 1320, appendix A.5
+	for in, want := range map[string]string{
+		"":               "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"a":              "bde52cb31de33e46245e05fbdbd6fb24",
+		"abc":            "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest": "d9130a8164549fe818874806e1c7014b",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		if got := hex.EncodeToString(md4([]byte(in))); got != want {
+			t.Errorf("md4(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+// TestNTLMv2 checks against the example of MS-NLMP 4.2.4.
+func TestNTLMv2(t *testing.T) {
+	owf := ntowfv2("User", "Password", "Domain")
+	if want := unhex("0c868a403bfd7a93a3001ef22ef02e3f"); !bytes.Equal(owf, want) {
+		t.Fatalf("NTOWFv2 %x, want %x", owf, want)
+	}
+	serverChallenge := [8]byte(unhex("0123456789abcdef"))
+	clientChallenge := [8]byte(unhex("aaaaaaaaaaaaaaaa"))
+	info := unhex("02000c0044006f006d00610069006e0001000c005300650072007600650072000000000000000000")
+	nt := response(owf, serverChallenge, clientChallenge, make([]byte, 8), info[:len(info)-4])
+	if want := unhex("68cd0ab851e51c96aabc927bebef6a1c"); !bytes.Equal(nt[:16], want) {
+		t.Errorf("NTProofStr %x, want %x", nt[:16], want)
+	}
+	if got, want := hmacMD5(owf, nt[:16]), unhex("8de40ccadbc14a82f15cb0ad0de95ca3"); !bytes.Equal(got, want) {
+		t.Errorf("session base key %x, want %x", got, want)
+	}
+	lm := hmacMD5(owf, serverChallenge[:], clientChallenge[:])
+	if want := unhex("86c35097ac9cec102554764a57cccc19"); !bytes.Equal(lm, want) {
+		t.Errorf("LMv2 response %x, want %x", lm, want)
+	}
+	if got, want := rc4Crypt(hmacMD5(owf, nt[:16]), unhex("55555555555555555555555555555555")), unhex("c5dad2544fc9799094ce1ce90bc9d03e"); !bytes.Equal(got, want) {
+		t.Errorf("encrypted session key %x, want %x", got, want)
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	users := func(user, domain string) (string, bool) { return "s3cret", user == "svc-import" }
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		c       Client
+		user    string
+		err     error
+		withKey bool // the client has a session key
+	}{
+		{"user", Client{User: "svc-import", Password: "s3cret", Domain: "CORP"}, "svc-import", nil, true},
+		{"wrong password", Client{User: "svc-import", Password: "guess", Domain: "CORP"}, "", ErrLogonFailure, true},
+		{"unknown user", Client{User: "nobody", Password: "s3cret"}, "", ErrLogonFailure, true},
+		{"anonymous", Client{}, "", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			neg := tc.c.Negotiate()
+			if !bytes.HasPrefix(neg, signature) {
+				t.Fatalf("negotiate message % x", neg)
+			}
+			ch := Challenge([8]byte{1, 2, 3, 4, 5, 6, 7, 8}, "CORP", "FILER", now)
+			auth, clientKey, err := tc.c.Authenticate(ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, serverKey, err := Verify(ch, auth, users)
+			if !errors.Is(err, tc.err) || user != tc.user {
+				t.Fatalf("Verify = %q, %v; want %q, %v", user, err, tc.user, tc.err)
+			}
+			if err == nil && !bytes.Equal(clientKey, serverKey) {
+				t.Errorf("session keys differ: %x and %x", clientKey, serverKey)
+			}
+			if (len(clientKey) == 16) != tc.withKey {
+				t.Errorf("session key %x", clientKey)
+			}
+		})
+	}
+}
+
+func TestAuthenticateMalformed(t *testing.T) {
+	c := Client{User: "u", Password: "p"}
+	ch := Challenge([8]byte{}, "D", "S", time.Now())
+	for _, b := range [][]byte{nil, ch[:40], append([]byte("NTLMSSP\x00\x03"), ch[9:]...)} {
+		if _, _, err := c.Authenticate(b); err == nil {
+			t.Errorf("accepted % x", b)
+		}
+	}
+	bad := bytes.Clone(ch)
+	put32(bad[44:], uint32(len(bad))) // target info past the end
+	if _, _, err := c.Authenticate(bad); err == nil {
+		t.Error("accepted a target info past the end of the message")
+	}
+}
@@ -0,0 +1,123 @@
+// Package sign computes the signatures of SMB2 and SMB3 messages
+// (MS-SMB2 3.1.4.1), for the smb client and the smbtest server: HMAC-SHA256
+// with the session key for SMB 2.x, AES-128-CMAC with a derived key from
+// SMB 3.0 on.
+package sign
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+// Dialects, as negotiated.
+const (
+	SMB202 = 0x0202
+	SMB210 = 0x0210
+	SMB300 = 0x0300
+	SMB302 = 0x0302
+	SMB311 = 0x0311
+)
+
+// sigOff is where the 16-byte signature sits in the SMB2 header.
+const sigOff = 48
+
+// Key derives the signing key of a session with sessionKey: the key itself
+// for SMB 2.x, else by the KDF of SP 800-108, with the session's preauth
+// integrity hash as the context for SMB 3.1.1.
+func Key(dialect uint16, sessionKey, preauthHash []byte) []byte {
+	key := make([]byte, 16)
+	copy(key, sessionKey) // NTLM session keys are 16 bytes
+	switch {
+	case dialect < SMB300:
+		return key
+	case dialect < SMB311:
+		return kdf(key, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	default:
+		return kdf(key, []byte("SMBSigningKey\x00"), preauthHash)
+	}
+}
+
+// Sign writes the signature of msg, a whole SMB2 message, into its header.
+func Sign(dialect uint16, key, msg []byte) {
+	copy(msg[sigOff:sigOff+16], sum(dialect, key, msg))
+}
+
+// Verify reports whether msg carries the signature key gives it.
+func Verify(dialect uint16, key, msg []byte) bool {
+	if len(msg) < 64 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(msg[sigOff:sigOff+16], sum(dialect, key, msg)) == 1
+}
+
+// sum computes the signature of msg as if its signature field were zero.
+func sum(dialect uint16, key, msg []byte) []byte {
+	m := append([]byte(nil), msg...)
+	clear(m[sigOff : sigOff+16])
+	if dialect < SMB300 {
+		h := hmac.New(sha256.New, key)
+		h.Write(m)
+		return h.Sum(nil)[:16]
+	}
+	return cmac(key, m)
+}
+
+// kdf is the counter-mode KDF of SP 800-108 with HMAC-SHA256, deriving a
+// 128-bit key as MS-SMB2 3.1.4.2 does.
+func kdf(key, label, context []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(label)
+	h.Write([]byte{0})
+	h.Write(context)
+	h.Write([]byte{0, 0, 0, 128})
+	return h.Sum(nil)[:16]
+}
+
+// cmac is AES-CMAC (RFC 4493).
+func cmac(key, msg []byte) []byte {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic("sign: " + err.Error()) // keys are always 16 bytes
+	}
+	k1 := make([]byte, 16)
+	c.Encrypt(k1, k1)
+	k1 = double(k1)
+	k2 := double(k1)
+
+	n := (len(msg) + 15) / 16
+	last := make([]byte, 16)
+	if n > 0 && len(msg)%16 == 0 {
+		copy(last, msg[16*(n-1):])
+		subtle.XORBytes(last, last, k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[16*(n-1):]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+	x := make([]byte, 16)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[16*i:16*i+16])
+		c.Encrypt(x, x)
+	}
+	subtle.XORBytes(x, x, last)
+	c.Encrypt(x, x)
+	return x
+}
+
+// double multiplies b by x in GF(2^128), deriving the CMAC subkeys.
+func double(b []byte) []byte {
+	hi, lo := binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])
+	carry := hi >> 63
+	out := make([]byte, 16)
+	binary.BigEndian.PutUint64(out, hi<<1|lo>>63)
+	binary.BigEndian.PutUint64(out[8:], lo<<1^carry*0x87)
+	return out
+}
@@ -0,0 +1,61 @@
+package sign
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestCMAC(t *testing.T) {
+	// RFC 4493, section 4
+	key := unhex("2b7e151628aed2a6abf7158809cf4f3c")
+	msg := unhex("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		if got := hex.EncodeToString(cmac(key, msg[:tc.n])); got != tc.want {
+			t.Errorf("%d bytes: %s, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	msg := make([]byte, 100)
+	copy(msg, "\xfeSMB")
+	for i := 64; i < len(msg); i++ {
+		msg[i] = byte(i)
+	}
+	sessionKey := unhex("00112233445566778899aabbccddeeff")
+	for _, d := range []uint16{SMB202, SMB210, SMB300, SMB302, SMB311} {
+		key := Key(d, sessionKey, bytes.Repeat([]byte{7}, 64))
+		m := bytes.Clone(msg)
+		Sign(d, key, m)
+		if bytes.Equal(m, msg) {
+			t.Errorf("%#x: not signed", d)
+		}
+		if !Verify(d, key, m) {
+			t.Errorf("%#x: signature does not verify", d)
+		}
+		m[70] ^= 1
+		if Verify(d, key, m) {
+			t.Errorf("%#x: a changed message verifies", d)
+		}
+	}
+	if bytes.Equal(Key(SMB302, sessionKey, nil), Key(SMB311, sessionKey, nil)) {
+		t.Error("SMB 3.0.2 and 3.1.1 derive the same key")
+	}
+}
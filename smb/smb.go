@@ -0,0 +1,725 @@
+// Package smb is a client for SMB2 and SMB3 file shares (MS-SMB2), enough
+// to stage files on a share without mounting it: list, read, write,
+// rename and delete files and directories, and ask for the free space.
+//
+// It logs in with NTLMv2 and signs its messages when the server asks for
+// it, speaking dialects 2.0.2 to 3.1.1. Encryption is not supported; a
+// share that requires it fails to mount.
+//
+// Requests on a session go one at a time, so a Session and its Shares are
+// safe for concurrent use. Names are slash-separated and relative to the
+// share root. Failed calls return an *fs.PathError, whose Err is a Status
+// when the server answered with one; errors.Is matches those against
+// fs.ErrNotExist, fs.ErrExist and fs.ErrPermission. Once the connection
+// breaks every call fails with ErrClosed and the session must be dialled
+// again.
+//
+//	s, err := smb.Dial(ctx, "fileserver", smb.WithCredentials("svc", "secret"))
+//	sh, err := s.Mount("sambashare")
+//	f, err := sh.Create("vm1/vm1.xml")
+package smb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"gcosmiclentil89/ScaleVMFromOVA/smb/internal/ntlm"
+	"gcosmiclentil89/ScaleVMFromOVA/smb/internal/sign"
+)
+
+// Port is where SMB servers listen unless the address says otherwise.
+const Port = "445"
+
+type options struct {
+	user, password, domain string
+	timeout                time.Duration
+}
+
+// Option configures a session in Dial.
+type Option func(*options)
+
+// WithCredentials sets the user and password to log in with; without it
+// the session is anonymous.
+func WithCredentials(user, password string) Option {
+	return func(o *options) { o.user, o.password = user, password }
+}
+
+// WithDomain sets the user's domain (or workgroup).
+func WithDomain(domain string) Option {
+	return func(o *options) { o.domain = domain }
+}
+
+// WithTimeout bounds each request: a server that neither answers nor
+// reports it pending for this long breaks the connection. 0 means no
+// limit.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// Session is a logged-in connection to a server.
+type Session struct {
+	c       *conn
+	host    string
+	id      uint64
+	signing bool
+	signKey []byte
+}
+
+// Session flags.
+const (
+	sessionGuest   = 0x0001
+	sessionNull    = 0x0002
+	sessionEncrypt = 0x0004
+)
+
+// Dial connects to the server at addr, host or host:port, and logs in. ctx
+// bounds the connection and login, not the session.
+func Dial(ctx context.Context, addr string, opts ...Option) (*Session, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+		addr = net.JoinHostPort(host, Port)
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{nc: nc, timeout: o.timeout, credits: 1}
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+
+	s, err := c.login(host, o)
+	if err != nil {
+		nc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// login negotiates the dialect and sets up the session with NTLM.
+func (c *conn) login(host string, o options) (*Session, error) {
+	if err := c.negotiate(); err != nil {
+		return nil, fmt.Errorf("smb: negotiate: %w", err)
+	}
+	s := &Session{c: c, host: host}
+	var preauth *[]byte
+	if c.dialect == sign.SMB311 {
+		h := c.preauth
+		preauth = &h
+	}
+	nc := ntlm.Client{User: o.user, Password: o.password, Domain: o.domain}
+	setup := func(tok []byte) (*response, error) {
+		b := make([]byte, 24)
+		put16(b, 25)
+		b[3] = signingEnabled
+		put16(b[12:], headerSize+24)
+		put16(b[14:], uint16(len(tok)))
+		return c.roundTrip(request{cmd: cmdSessionSetup, s: s, body: append(b, tok...), preauth: preauth})
+	}
+	secBuf := func(r *response) ([]byte, error) {
+		if len(r.body) < 8 {
+			return nil, errMalformed
+		}
+		off, n := int(get16(r.body[4:])), int(get16(r.body[6:]))
+		if n == 0 {
+			return nil, nil
+		}
+		if off < headerSize+8 || off+n > len(r.raw) {
+			return nil, errMalformed
+		}
+		return r.raw[off : off+n], nil
+	}
+
+	resp, err := setup(spnegoInit(nc.Negotiate()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.status != StatusMoreProcessingRequired {
+		return nil, fmt.Errorf("smb: session setup: %w", resp.status)
+	}
+	s.id = resp.sessionID
+	if preauth != nil {
+		*preauth = preauthHash(*preauth, resp.raw)
+	}
+	buf, err := secBuf(resp)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := spnegoToken(buf)
+	if err != nil {
+		return nil, err
+	}
+	auth, key, err := nc.Authenticate(challenge)
+	if err != nil {
+		return nil, err
+	}
+	if resp, err = setup(spnegoResp(auth)); err != nil {
+		return nil, err
+	}
+	if resp.status != StatusSuccess {
+		return nil, fmt.Errorf("smb: session setup: %w", resp.status)
+	}
+	if len(resp.body) < 8 {
+		return nil, errMalformed
+	}
+	flags := get16(resp.body[2:])
+	if flags&sessionEncrypt != 0 {
+		return nil, errors.New("smb: the server requires encryption, which is not supported")
+	}
+	if key != nil && flags&(sessionGuest|sessionNull) == 0 {
+		var h []byte
+		if preauth != nil {
+			h = *preauth
+		}
+		s.signKey = sign.Key(c.dialect, key, h)
+		if resp.flags&flagSigned != 0 && !sign.Verify(c.dialect, s.signKey, resp.raw) {
+			return nil, errors.New("smb: bad signature on the session setup response")
+		}
+		s.signing = c.requireSigning
+	} else if c.requireSigning {
+		return nil, errors.New("smb: the server requires signing, which a guest or anonymous session cannot do")
+	}
+	return s, nil
+}
+
+// Logoff ends the session and closes its connection.
+func (s *Session) Logoff() error {
+	_, err := s.c.roundTrip(request{cmd: cmdLogoff, s: s, body: []byte{4, 0, 0, 0}})
+	if cerr := s.c.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Share is a mounted share of a session.
+type Share struct {
+	s    *Session
+	tree uint32
+	name string
+}
+
+// Share flags and types.
+const (
+	shareTypeDisk    = 0x01
+	shareEncryptData = 0x00008000
+)
+
+// Mount connects to the share called name on the session's server.
+func (s *Session) Mount(name string) (*Share, error) {
+	unc := utf16le(`\\` + s.host + `\` + name)
+	b := make([]byte, 8)
+	put16(b, 9)
+	put16(b[4:], headerSize+8)
+	put16(b[6:], uint16(len(unc)))
+	// SMB 3.1.1 has tree connects signed whatever the session does, in
+	// place of the negotiation validation of 3.0
+	signed := s.c.dialect == sign.SMB311 && s.signKey != nil
+	resp, err := s.c.roundTrip(request{cmd: cmdTreeConnect, s: s, body: append(b, unc...), sign: signed})
+	if err == nil && resp.status != StatusSuccess {
+		err = resp.status
+	}
+	if err == nil && len(resp.body) < 16 {
+		err = errMalformed
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "mount", Path: name, Err: err}
+	}
+	switch {
+	case resp.body[2] != shareTypeDisk:
+		err = errors.New("not a disk share")
+	case get32(resp.body[4:])&shareEncryptData != 0:
+		err = errors.New("the share requires encryption, which is not supported")
+	}
+	sh := &Share{s: s, tree: resp.treeID, name: name}
+	if err != nil {
+		sh.Umount()
+		return nil, &fs.PathError{Op: "mount", Path: name, Err: err}
+	}
+	return sh, nil
+}
+
+// Umount disconnects from the share.
+func (sh *Share) Umount() error {
+	_, err := sh.roundTrip(cmdTreeDisconnect, []byte{4, 0, 0, 0}, 0)
+	return err
+}
+
+func (sh *Share) roundTrip(cmd uint16, body []byte, payload int) (*response, error) {
+	resp, err := sh.s.c.roundTrip(request{cmd: cmd, s: sh.s, tree: sh.tree, body: body, payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	if resp.status != StatusSuccess {
+		return resp, resp.status
+	}
+	return resp, nil
+}
+
+// Access rights, share modes, dispositions and options of CREATE.
+const (
+	accessReadData        = 0x00000001
+	accessWriteData       = 0x00000002
+	accessAppendData      = 0x00000004
+	accessReadAttributes  = 0x00000080
+	accessWriteAttributes = 0x00000100
+	accessDelete          = 0x00010000
+	accessSynchronize     = 0x00100000
+
+	shareAll = 0x7 // read, write and delete
+
+	dispOpen        = 1
+	dispCreate      = 2
+	dispOverwriteIf = 5
+
+	optDirectory     = 0x00000001
+	optNonDirectory  = 0x00000040
+	optDeleteOnClose = 0x00001000
+
+	attrReadonly  = 0x00000001
+	attrDirectory = 0x00000010
+	attrNormal    = 0x00000080
+)
+
+// File is an open file or directory on a share.
+type File struct {
+	sh   *Share
+	id   []byte // persistent and volatile parts
+	name string
+	info fileInfo
+	off  int64
+}
+
+// create opens name as CREATE asks.
+func (sh *Share) create(name string, access, disposition, options, attrs uint32) (*File, error) {
+	n := utf16le(winPath(name))
+	b := make([]byte, 56)
+	put16(b, 57)
+	put32(b[4:], 2) // impersonation
+	put32(b[24:], access)
+	put32(b[28:], attrs)
+	put32(b[32:], shareAll)
+	put32(b[36:], disposition)
+	put32(b[40:], options)
+	put16(b[44:], headerSize+56)
+	put16(b[46:], uint16(len(n)))
+	resp, err := sh.roundTrip(cmdCreate, append(b, n...), 0)
+	if err != nil {
+		return nil, err
+	}
+	rb := resp.body
+	if len(rb) < 88 {
+		return nil, errMalformed
+	}
+	return &File{
+		sh:   sh,
+		id:   append([]byte(nil), rb[64:80]...),
+		name: name,
+		info: fileInfo{
+			name:  path.Base(name),
+			size:  int64(get64(rb[48:])),
+			attrs: get32(rb[56:]),
+			mtime: fromFiletime(get64(rb[24:])),
+		},
+	}, nil
+}
+
+// Open opens the file name for reading.
+func (sh *Share) Open(name string) (*File, error) {
+	f, err := sh.create(name, accessReadData|accessReadAttributes|accessSynchronize, dispOpen, optNonDirectory, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// Create creates or truncates the file name and opens it for writing.
+// The directory it is in must exist.
+func (sh *Share) Create(name string) (*File, error) {
+	access := uint32(accessReadData | accessWriteData | accessAppendData | accessReadAttributes | accessWriteAttributes | accessSynchronize)
+	f, err := sh.create(name, access, dispOverwriteIf, optNonDirectory, attrNormal)
+	if err != nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	b := make([]byte, 24)
+	put16(b, 24)
+	copy(b[8:], f.id)
+	if _, err := f.sh.roundTrip(cmdClose, b, 0); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// Stat returns what the file was like when it was opened.
+func (f *File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Read reads from the file's current offset.
+func (f *File) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c := f.sh.s.c
+	n := c.chunk(len(p), c.maxRead)
+	b := make([]byte, 48)
+	put16(b, 49)
+	b[2] = headerSize + 16 // where the data should go
+	put32(b[4:], uint32(n))
+	put64(b[8:], uint64(f.off))
+	copy(b[16:], f.id)
+	resp, err := f.sh.roundTrip(cmdRead, b, n)
+	if err == StatusEndOfFile {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	if len(resp.body) < 16 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errMalformed}
+	}
+	off, size := int(resp.body[2]), int(get32(resp.body[4:]))
+	if size > n || off+size > len(resp.raw) {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errMalformed}
+	}
+	copy(p, resp.raw[off:off+size])
+	f.off += int64(size)
+	if size == 0 {
+		return 0, io.EOF
+	}
+	return size, nil
+}
+
+// Write writes p at the file's current offset.
+func (f *File) Write(p []byte) (int, error) {
+	c := f.sh.s.c
+	written := 0
+	for written < len(p) {
+		n := c.chunk(len(p)-written, c.maxWrite)
+		b := make([]byte, 48, 48+n)
+		put16(b, 49)
+		put16(b[2:], headerSize+48)
+		put32(b[4:], uint32(n))
+		put64(b[8:], uint64(f.off))
+		copy(b[16:], f.id)
+		resp, err := f.sh.roundTrip(cmdWrite, append(b, p[written:written+n]...), n)
+		if err == nil && len(resp.body) < 8 {
+			err = errMalformed
+		}
+		if err != nil {
+			return written, &fs.PathError{Op: "write", Path: f.name, Err: err}
+		}
+		count := int(get32(resp.body[4:]))
+		if count == 0 || count > n {
+			return written, &fs.PathError{Op: "write", Path: f.name, Err: io.ErrShortWrite}
+		}
+		written += count
+		f.off += int64(count)
+	}
+	return written, nil
+}
+
+// ReadFrom writes what r yields to the file in chunks as large as the
+// server takes, for io.Copy.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	c := f.sh.s.c
+	buf := make([]byte, min(int(c.maxWrite), maxChunk))
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			w, werr := f.Write(buf[:n])
+			total += int64(w)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// ReadFile returns the content of the file name.
+func (sh *Share) ReadFile(name string) ([]byte, error) {
+	f, err := sh.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := make([]byte, 0, f.info.size)
+	for {
+		if len(b) == cap(b) {
+			b = slices.Grow(b, 4096)
+		}
+		n, err := f.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stat returns the file info of name.
+func (sh *Share) Stat(name string) (fs.FileInfo, error) {
+	f, err := sh.create(name, accessReadAttributes|accessSynchronize, dispOpen, 0, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	f.Close()
+	return f.info, nil
+}
+
+// ReadDir lists the directory name, sorted by file name, without "." and
+// "..".
+func (sh *Share) ReadDir(name string) ([]fs.FileInfo, error) {
+	d, err := sh.create(name, accessReadData|accessReadAttributes|accessSynchronize, dispOpen, optDirectory, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	defer d.Close()
+	c := sh.s.c
+	pattern := utf16le("*")
+	var out []fs.FileInfo
+	for first := true; ; first = false {
+		size := min(c.maxTransact, creditUnit)
+		b := make([]byte, 32)
+		put16(b, 33)
+		b[2] = 1 // FileDirectoryInformation
+		if first {
+			b[3] = 1 // restart the scan
+		}
+		copy(b[8:], d.id)
+		put16(b[24:], headerSize+32)
+		put16(b[26:], uint16(len(pattern)))
+		put32(b[28:], size)
+		resp, err := sh.roundTrip(cmdQueryDirectory, append(b, pattern...), int(size))
+		if err == StatusNoMoreFiles || (first && err == StatusNoSuchFile) {
+			break
+		}
+		if err == nil && len(resp.body) < 8 {
+			err = errMalformed
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		off, n := int(get16(resp.body[2:])), int(get32(resp.body[4:]))
+		if off+n > len(resp.raw) {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errMalformed}
+		}
+		entries, err := parseDirectory(resp.raw[off : off+n])
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		out = append(out, entries...)
+	}
+	slices.SortFunc(out, func(a, b fs.FileInfo) int { return strings.Compare(a.Name(), b.Name()) })
+	return out, nil
+}
+
+// parseDirectory decodes FILE_DIRECTORY_INFORMATION entries.
+func parseDirectory(b []byte) ([]fs.FileInfo, error) {
+	var out []fs.FileInfo
+	for len(b) > 0 {
+		if len(b) < 64 {
+			return nil, errMalformed
+		}
+		next, n := int(get32(b)), int(get32(b[60:]))
+		if 64+n > len(b) || (next != 0 && (next < 64+n || next > len(b))) {
+			return nil, errMalformed
+		}
+		fi := fileInfo{
+			name:  fromUTF16le(b[64 : 64+n]),
+			size:  int64(get64(b[40:])),
+			attrs: get32(b[56:]),
+			mtime: fromFiletime(get64(b[24:])),
+		}
+		if fi.name != "." && fi.name != ".." {
+			out = append(out, fi)
+		}
+		if next == 0 {
+			break
+		}
+		b = b[next:]
+	}
+	return out, nil
+}
+
+// Mkdir creates the directory name; its parent must exist.
+func (sh *Share) Mkdir(name string) error {
+	d, err := sh.create(name, accessReadAttributes|accessSynchronize, dispCreate, optDirectory, attrDirectory)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return d.Close()
+}
+
+// MkdirAll creates the directory name and any missing parents.
+func (sh *Share) MkdirAll(name string) error {
+	name = path.Clean(name)
+	if name == "." || name == "/" {
+		return nil
+	}
+	if fi, err := sh.Stat(name); err == nil {
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: StatusNotADirectory}
+		}
+		return nil
+	}
+	if err := sh.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := sh.Mkdir(name); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the file or empty directory name.
+func (sh *Share) Remove(name string) error {
+	f, err := sh.create(name, accessDelete|accessReadAttributes|accessSynchronize, dispOpen, optDeleteOnClose, 0)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if err := f.Close(); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.Unwrap(err)}
+	}
+	return nil
+}
+
+// Rename moves oldname to newname, replacing a file there.
+func (sh *Share) Rename(oldname, newname string) error {
+	f, err := sh.create(oldname, accessDelete|accessReadAttributes|accessSynchronize, dispOpen, 0, 0)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	defer f.Close()
+	n := utf16le(winPath(newname))
+	info := make([]byte, 20)
+	info[0] = 1 // replace if exists
+	put32(info[16:], uint32(len(n)))
+	info = append(info, n...)
+	b := make([]byte, 32)
+	put16(b, 33)
+	b[2] = 1  // file
+	b[3] = 10 // FileRenameInformation
+	put32(b[4:], uint32(len(info)))
+	put16(b[8:], headerSize+32)
+	copy(b[16:], f.id)
+	if _, err := sh.roundTrip(cmdSetInfo, append(b, info...), 0); err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	return nil
+}
+
+// Free returns the bytes available to the user on the volume holding the
+// directory name.
+func (sh *Share) Free(name string) (int64, error) {
+	d, err := sh.create(name, accessReadAttributes|accessSynchronize, dispOpen, optDirectory, 0)
+	if err != nil {
+		return 0, &fs.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	defer d.Close()
+	b := make([]byte, 40)
+	put16(b, 41)
+	b[2] = 2 // filesystem
+	b[3] = 7 // FileFsFullSizeInformation
+	put32(b[4:], 32)
+	copy(b[24:], d.id)
+	resp, err := sh.roundTrip(cmdQueryInfo, b, 0)
+	if err == nil && len(resp.body) < 8 {
+		err = errMalformed
+	}
+	if err != nil {
+		return 0, &fs.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	off, n := int(get16(resp.body[2:])), int(get32(resp.body[4:]))
+	if n < 32 || off+n > len(resp.raw) {
+		return 0, &fs.PathError{Op: "statfs", Path: name, Err: errMalformed}
+	}
+	info := resp.raw[off:]
+	units := get64(info[8:]) // available to the caller
+	return int64(units) * int64(get32(info[24:])) * int64(get32(info[28:])), nil
+}
+
+// fileInfo is what CREATE and QUERY_DIRECTORY tell of a file.
+type fileInfo struct {
+	name  string
+	size  int64
+	attrs uint32
+	mtime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi fileInfo) IsDir() bool        { return fi.attrs&attrDirectory != 0 }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	m := fs.FileMode(0o666)
+	if fi.IsDir() {
+		m = fs.ModeDir | 0o777
+	}
+	if fi.attrs&attrReadonly != 0 {
+		m &^= 0o222
+	}
+	return m
+}
+
+// winPath turns a slash-separated name into one relative to the share
+// root as SMB has it, e.g. vm1\vm1.xml; the root is the empty name.
+func winPath(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return strings.ReplaceAll(name, "/", `\`)
+}
+
+func utf16le(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, v := range u {
+		put16(b[2*i:], v)
+	}
+	return b
+}
+
+func fromUTF16le(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = get16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// fromFiletime converts 100ns intervals since 1601 to a time.
+func fromFiletime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ft-116444736000000000)*100)
+}
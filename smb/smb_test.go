@@ -0,0 +1,163 @@
+package smb_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gcosmiclentil89/ScaleVMFromOVA/smb"
+	"gcosmiclentil89/ScaleVMFromOVA/smb/smbtest"
+)
+
+func mount(t *testing.T, opts ...smbtest.Option) (*smb.Share, string) {
+	t.Helper()
+	dir := t.TempDir()
+	srv := smbtest.NewServer("share", dir, append(opts, smbtest.WithCredentials("svc", "secret"))...)
+	t.Cleanup(srv.Close)
+	s, err := smb.Dial(context.Background(), srv.Addr, smb.WithCredentials("svc", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Logoff() })
+	sh, err := s.Mount("share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sh, dir
+}
+
+func TestShare(t *testing.T) {
+	big := make([]byte, 3<<20+123) // a few chunks, the last one short
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	for _, d := range []uint16{0x0202, 0x0210, 0x0300, 0x0302, 0x0311} {
+		for _, signing := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%#04x/signing=%v", d, signing), func(t *testing.T) {
+				opts := []smbtest.Option{smbtest.WithDialect(d)}
+				if signing {
+					opts = append(opts, smbtest.WithSigning())
+				}
+				sh, dir := mount(t, opts...)
+
+				if err := sh.MkdirAll("vm1/sub"); err != nil {
+					t.Fatal(err)
+				}
+				f, err := sh.Create("vm1/.disk.part")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n, err := io.Copy(f, bytes.NewReader(big)); err != nil || n != int64(len(big)) {
+					t.Fatalf("copy: %d, %v", n, err)
+				}
+				if err := f.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if err := sh.Rename("vm1/.disk.part", "vm1/disk.qcow2"); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := os.ReadFile(filepath.Join(dir, "vm1", "disk.qcow2")); err != nil || !bytes.Equal(got, big) {
+					t.Fatalf("file on the server: %d bytes, %v", len(got), err)
+				}
+				if got, err := sh.ReadFile("vm1/disk.qcow2"); err != nil || !bytes.Equal(got, big) {
+					t.Fatalf("ReadFile: %d bytes, %v", len(got), err)
+				}
+
+				fis, err := sh.ReadDir("vm1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, fi := range fis {
+					names = append(names, fmt.Sprintf("%s:%v:%d", fi.Name(), fi.IsDir(), fi.Size()))
+				}
+				if want := fmt.Sprintf("[disk.qcow2:false:%d sub:true:0]", len(big)); fmt.Sprint(names) != want {
+					t.Errorf("ReadDir = %v, want %s", names, want)
+				}
+
+				if err := sh.Remove("vm1"); !errors.Is(err, smb.StatusDirectoryNotEmpty) {
+					t.Errorf("Remove of a full directory: %v", err)
+				}
+				for _, name := range []string{"vm1/disk.qcow2", "vm1/sub"} {
+					if err := sh.Remove(name); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := sh.Stat("vm1/disk.qcow2"); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Stat of a removed file: %v", err)
+				}
+				if _, err := sh.Open("nodir/x"); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Open in a missing directory: %v", err)
+				}
+				if err := sh.Mkdir("vm1"); !errors.Is(err, fs.ErrExist) {
+					t.Errorf("Mkdir of an existing directory: %v", err)
+				}
+				if free, err := sh.Free(""); err != nil || free != 1<<40 {
+					t.Errorf("Free = %d, %v", free, err)
+				}
+			})
+		}
+	}
+}
+
+func TestReadDirMany(t *testing.T) {
+	sh, dir := mount(t)
+	// more entries than one 64KiB query returns
+	for i := range 2000 {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-with-a-long-name-%04d", i)), nil, 0o666)
+	}
+	fis, err := sh.ReadDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2000 || fis[1999].Name() != "file-with-a-long-name-1999" {
+		t.Errorf("ReadDir returned %d entries", len(fis))
+	}
+}
+
+func TestLogin(t *testing.T) {
+	srv := smbtest.NewServer("share", t.TempDir(), smbtest.WithCredentials("svc", "secret"))
+	defer srv.Close()
+	ctx := context.Background()
+	if _, err := smb.Dial(ctx, srv.Addr, smb.WithCredentials("svc", "wrong")); !errors.Is(err, smb.StatusLogonFailure) {
+		t.Errorf("wrong password: %v", err)
+	}
+	if _, err := smb.Dial(ctx, srv.Addr); !errors.Is(err, smb.StatusLogonFailure) {
+		t.Errorf("anonymous: %v", err)
+	}
+	s, err := smb.Dial(ctx, srv.Addr, smb.WithCredentials("SVC", "secret"), smb.WithDomain("WORKGROUP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Logoff()
+	if _, err := s.Mount("other"); !errors.Is(err, smb.StatusBadNetworkName) {
+		t.Errorf("Mount of a missing share: %v", err)
+	}
+}
+
+func TestDroppedConnection(t *testing.T) {
+	dir := t.TempDir()
+	srv := smbtest.NewServer("share", dir)
+	defer srv.Close()
+	s, err := smb.Dial(context.Background(), srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := s.Mount("share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.DropConnections()
+	if _, err := sh.ReadDir(""); err == nil {
+		t.Fatal("ReadDir succeeded on a dropped connection")
+	}
+	if _, err := sh.ReadDir(""); !errors.Is(err, smb.ErrClosed) {
+		t.Errorf("ReadDir after the drop = %v, want ErrClosed", err)
+	}
+}
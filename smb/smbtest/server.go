@@ -0,0 +1,857 @@
+// Package smbtest runs a fake SMB2/SMB3 file server for tests and demos,
+// in the spirit of net/http/httptest: it serves one share backed by a
+// local directory, logs users in with NTLMv2 and signs when asked to.
+// It speaks what the smb client uses, nothing more, and trusts the client
+// to send one request at a time.
+//
+//	dir := t.TempDir()
+//	srv := smbtest.NewServer("sambashare", dir, smbtest.WithCredentials("svc", "secret"))
+//	defer srv.Close()
+//	s, _ := smb.Dial(ctx, srv.Addr, smb.WithCredentials("svc", "secret"))
+//	sh, _ := s.Mount("sambashare") // files land in dir
+package smbtest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+
+	"gcosmiclentil89/ScaleVMFromOVA/smb"
+	"gcosmiclentil89/ScaleVMFromOVA/smb/internal/ntlm"
+	"gcosmiclentil89/ScaleVMFromOVA/smb/internal/sign"
+)
+
+// Server is a fake SMB server listening on a local port.
+type Server struct {
+	Addr string // host:port for smb.Dial, e.g. 127.0.0.1:41234
+
+	ln             net.Listener
+	share, dir     string
+	user, password string
+	dialect        uint16 // the highest dialect to agree on
+	signing        bool   // require signing
+	free           int64
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// Option configures a Server in NewServer.
+type Option func(*Server)
+
+// WithCredentials makes the server accept only this user and password;
+// without it only anonymous logins are accepted.
+func WithCredentials(user, password string) Option {
+	return func(s *Server) { s.user, s.password = user, password }
+}
+
+// WithDialect caps the dialect the server agrees on, e.g. 0x0210 for SMB
+// 2.1; SMB 3.1.1 (0x0311) by default.
+func WithDialect(d uint16) Option {
+	return func(s *Server) { s.dialect = d }
+}
+
+// WithSigning makes the server require signed messages.
+func WithSigning() Option {
+	return func(s *Server) { s.signing = true }
+}
+
+// WithFree makes the server report this many bytes free on the share,
+// 1TiB by default.
+func WithFree(n int64) Option {
+	return func(s *Server) { s.free = n }
+}
+
+// NewServer starts serving dir as the share called share; Close stops it.
+func NewServer(share, dir string, opts ...Option) *Server {
+	s := &Server{share: share, dir: dir, dialect: sign.SMB311, free: 1 << 40, conns: map[net.Conn]bool{}}
+	for _, o := range opts {
+		o(s)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("smbtest: " + err.Error())
+	}
+	s.ln, s.Addr = ln, ln.Addr().String()
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close shuts the server down and drops its connections.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// DropConnections closes the connections open so far, as a server
+// restart or a network failure would; the server keeps accepting new
+// ones.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for nc := range s.conns {
+		nc.Close()
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[nc] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c := &conn{srv: s, nc: nc, sessions: map[uint64]*session{}, trees: map[uint32]bool{}, files: map[uint64]*file{}}
+			c.serve()
+			nc.Close()
+			s.mu.Lock()
+			delete(s.conns, nc)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// conn is the state of one client connection.
+type conn struct {
+	srv     *Server
+	nc      net.Conn
+	dialect uint16
+	preauth []byte
+
+	sessions map[uint64]*session
+	trees    map[uint32]bool
+	files    map[uint64]*file
+	nextID   uint64
+}
+
+type session struct {
+	challenge []byte // the NTLM challenge message sent
+	preauth   []byte
+	done      bool
+	signing   bool
+	signKey   []byte
+}
+
+// file is an open file or directory.
+type file struct {
+	name    string // slash-separated, relative to the share root
+	f       *os.File
+	dir     bool
+	del     bool     // delete on close
+	entries []string // of a directory being listed, nil before the first query
+}
+
+// msg is a request being answered.
+type msg struct {
+	raw     []byte
+	cmd     uint16
+	id      uint64
+	session uint64
+	tree    uint32
+	credits uint16
+	signed  bool
+	body    []byte
+}
+
+func (c *conn) serve() {
+	for {
+		var nb [4]byte
+		if _, err := io.ReadFull(c.nc, nb[:]); err != nil {
+			return
+		}
+		raw := make([]byte, binary.BigEndian.Uint32(nb[:]))
+		if _, err := io.ReadFull(c.nc, raw); err != nil || len(raw) < 64 || string(raw[:4]) != "\xfeSMB" {
+			return
+		}
+		m := &msg{
+			raw:     raw,
+			cmd:     get16(raw[12:]),
+			credits: get16(raw[14:]),
+			id:      get64(raw[24:]),
+			tree:    get32(raw[36:]),
+			session: get64(raw[40:]),
+			body:    raw[64:],
+		}
+		if !c.handle(m) {
+			return
+		}
+	}
+}
+
+// handle answers m; false drops the connection.
+func (c *conn) handle(m *msg) bool {
+	if m.cmd == 0 { // NEGOTIATE
+		return c.negotiate(m)
+	}
+	if m.cmd == 1 { // SESSION_SETUP
+		return c.sessionSetup(m)
+	}
+	s := c.sessions[m.session]
+	if s == nil || !s.done {
+		return c.reply(m, nil, smb.StatusUserSessionDeleted, nil)
+	}
+	m.signed = get32(m.raw[16:])&0x8 != 0
+	if m.signed && (s.signKey == nil || !sign.Verify(c.dialect, s.signKey, m.raw)) {
+		return false
+	}
+	if s.signing && !m.signed {
+		return c.reply(m, s, smb.StatusAccessDenied, nil)
+	}
+	switch m.cmd {
+	case 2: // LOGOFF
+		delete(c.sessions, m.session)
+		return c.reply(m, s, smb.StatusSuccess, []byte{4, 0, 0, 0})
+	case 3: // TREE_CONNECT
+		return c.treeConnect(m, s)
+	}
+	if !c.trees[m.tree] {
+		return c.reply(m, s, smb.StatusNetworkNameDeleted, nil)
+	}
+	var body []byte
+	var err error
+	switch m.cmd {
+	case 4: // TREE_DISCONNECT
+		delete(c.trees, m.tree)
+		body = []byte{4, 0, 0, 0}
+	case 5:
+		body, err = c.create(m.body, m.raw)
+	case 6:
+		body, err = c.close(m.body)
+	case 8:
+		body, err = c.read(m.body)
+	case 9:
+		body, err = c.write(m.body, m.raw)
+	case 0x0e:
+		body, err = c.queryDirectory(m.body)
+	case 0x10:
+		body, err = c.queryInfo(m.body)
+	case 0x11:
+		body, err = c.setInfo(m.body, m.raw)
+	default:
+		err = smb.StatusNotSupported
+	}
+	if err != nil {
+		return c.reply(m, s, status(err), nil)
+	}
+	return c.reply(m, s, smb.StatusSuccess, body)
+}
+
+// reply sends the response to m, signed if the session signs or m was.
+func (c *conn) reply(m *msg, s *session, st smb.Status, body []byte) bool {
+	_, ok := c.send(m, s, st, body, s != nil && (s.signing || m.signed))
+	return ok
+}
+
+func (c *conn) send(m *msg, s *session, st smb.Status, body []byte, signed bool) ([]byte, bool) {
+	if body == nil {
+		body = make([]byte, 9) // error response
+		put16(body, 9)
+	}
+	out := make([]byte, 4+64, 4+64+len(body))
+	h := out[4:]
+	copy(h, "\xfeSMB")
+	put16(h[4:], 64)
+	copy(h[6:8], m.raw[6:8]) // credit charge
+	put32(h[8:], uint32(st))
+	put16(h[12:], m.cmd)
+	put16(h[14:], max(m.credits, 1))
+	put32(h[16:], 0x1)
+	put64(h[24:], m.id)
+	put32(h[36:], m.tree)
+	put64(h[40:], m.session)
+	out = append(out, body...)
+	if signed {
+		put32(out[4+16:], 0x1|0x8)
+		sign.Sign(c.dialect, s.signKey, out[4:])
+	}
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	_, err := c.nc.Write(out)
+	return out[4:], err == nil
+}
+
+func (c *conn) negotiate(m *msg) bool {
+	b := m.body
+	if len(b) < 36 {
+		return false
+	}
+	n := int(get16(b[2:]))
+	if len(b) < 36+2*n {
+		return false
+	}
+	c.dialect = 0
+	for i := 0; i < n; i++ {
+		if d := get16(b[36+2*i:]); d <= c.srv.dialect && d > c.dialect {
+			c.dialect = d
+		}
+	}
+	if c.dialect == 0 {
+		return c.reply(m, nil, smb.StatusNotSupported, nil)
+	}
+	r := make([]byte, 64, 128)
+	put16(r, 65)
+	mode := uint16(0x1)
+	if c.srv.signing {
+		mode |= 0x2
+	}
+	put16(r[2:], mode)
+	put16(r[4:], c.dialect)
+	rand.Read(r[8:24])
+	if c.dialect > sign.SMB202 {
+		put32(r[24:], 0x4) // large MTU
+	}
+	put32(r[28:], 1<<20)
+	put32(r[32:], 1<<20)
+	put32(r[36:], 1<<20)
+	put64(r[40:], filetime(time.Now()))
+	put16(r[56:], 128)
+	if c.dialect == sign.SMB311 {
+		c.preauth = hash(make([]byte, 64), m.raw)
+		put16(r[6:], 1)
+		put32(r[60:], 128)
+		ctx := make([]byte, 8+38)
+		put16(ctx, 1)
+		put16(ctx[2:], 38)
+		put16(ctx[8:], 1)
+		put16(ctx[10:], 32)
+		put16(ctx[12:], 1)
+		rand.Read(ctx[14:])
+		r = append(r, ctx...)
+	} else {
+		r = append(r, 0)
+	}
+	sent, ok := c.send(m, nil, smb.StatusSuccess, r, false)
+	if c.dialect == sign.SMB311 {
+		c.preauth = hash(c.preauth, sent)
+	}
+	return ok
+}
+
+// sessionSetup runs the NTLM exchange: a challenge for the negotiate
+// message, then the check of the authenticate message.
+func (c *conn) sessionSetup(m *msg) bool {
+	b := m.body
+	if len(b) < 24 {
+		return false
+	}
+	off, n := int(get16(b[12:])), int(get16(b[14:]))
+	if off+n > len(m.raw) {
+		return false
+	}
+	buf := m.raw[off : off+n]
+	i := bytes.Index(buf, []byte("NTLMSSP\x00"))
+	if i < 0 || len(buf) < i+12 {
+		return c.reply(m, nil, smb.StatusInvalidParameter, nil)
+	}
+	tok := buf[i:]
+
+	switch get32(tok[8:]) {
+	case 1:
+		c.nextID++
+		m.session = c.nextID<<32 | 0x1
+		var sc [8]byte
+		rand.Read(sc[:])
+		s := &session{challenge: ntlm.Challenge(sc, "WORKGROUP", "SMBTEST", time.Now()), preauth: c.preauth}
+		c.sessions[m.session] = s
+		if s.preauth != nil {
+			s.preauth = hash(s.preauth, m.raw)
+		}
+		sent, ok := c.send(m, nil, smb.StatusMoreProcessingRequired, setupBody(negTokenResp(1, s.challenge)), false)
+		if s.preauth != nil {
+			s.preauth = hash(s.preauth, sent)
+		}
+		return ok
+	case 3:
+		s := c.sessions[m.session]
+		if s == nil || s.done {
+			return c.reply(m, nil, smb.StatusUserSessionDeleted, nil)
+		}
+		user, key, err := ntlm.Verify(s.challenge, tok, func(user, domain string) (string, bool) {
+			return c.srv.password, c.srv.user != "" && strings.EqualFold(user, c.srv.user)
+		})
+		if err == nil && key == nil && c.srv.user != "" {
+			err = ntlm.ErrLogonFailure // anonymous
+		}
+		if err != nil {
+			delete(c.sessions, m.session)
+			return c.reply(m, nil, smb.StatusLogonFailure, nil)
+		}
+		if s.preauth != nil {
+			s.preauth = hash(s.preauth, m.raw)
+		}
+		body := setupBody(negTokenResp(0, nil))
+		if user == "" {
+			put16(body[2:], 0x2) // null session
+		} else {
+			s.signKey = sign.Key(c.dialect, key, s.preauth)
+			s.signing = c.srv.signing
+		}
+		s.done = true
+		_, ok := c.send(m, s, smb.StatusSuccess, body, s.signKey != nil)
+		return ok
+	}
+	return c.reply(m, nil, smb.StatusInvalidParameter, nil)
+}
+
+func setupBody(tok []byte) []byte {
+	b := make([]byte, 8)
+	put16(b, 9)
+	put16(b[4:], 64+8)
+	put16(b[6:], uint16(len(tok)))
+	return append(b, tok...)
+}
+
+// negTokenResp wraps an NTLM message in an SPNEGO NegTokenResp with
+// negState (0 accept-completed, 1 accept-incomplete).
+func negTokenResp(state byte, tok []byte) []byte {
+	elems := [][]byte{der(0xa0, der(0x0a, []byte{state}))}
+	if tok != nil {
+		ntlmOID := []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+		elems = append(elems, der(0xa1, der(0x06, ntlmOID)), der(0xa2, der(0x04, tok)))
+	}
+	return der(0xa1, der(0x30, elems...))
+}
+
+func der(tag byte, contents ...[]byte) []byte {
+	v := bytes.Join(contents, nil)
+	b := []byte{tag}
+	switch n := len(v); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, v...)
+}
+
+func (c *conn) treeConnect(m *msg, s *session) bool {
+	b := m.body
+	if len(b) < 8 {
+		return false
+	}
+	off, n := int(get16(b[4:])), int(get16(b[6:]))
+	if off+n > len(m.raw) {
+		return false
+	}
+	if c.dialect == sign.SMB311 && s.signKey != nil && !m.signed {
+		return c.reply(m, s, smb.StatusAccessDenied, nil) // must be signed in 3.1.1
+	}
+	unc := fromUTF16le(m.raw[off : off+n])
+	if !strings.EqualFold(unc[strings.LastIndex(unc, `\`)+1:], c.srv.share) {
+		return c.reply(m, s, smb.StatusBadNetworkName, nil)
+	}
+	c.nextID++
+	m.tree = uint32(c.nextID)
+	c.trees[m.tree] = true
+	r := make([]byte, 16)
+	put16(r, 16)
+	r[2] = 1 // disk
+	put32(r[12:], 0x001f01ff)
+	return c.reply(m, s, smb.StatusSuccess, r)
+}
+
+// path maps a name of the share to the local file system.
+func (c *conn) path(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	for _, e := range strings.Split(name, "/") {
+		if e == ".." {
+			return "", smb.StatusObjectNameInvalid
+		}
+	}
+	return filepath.Join(c.srv.dir, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+func (c *conn) create(b, raw []byte) ([]byte, error) {
+	if len(b) < 56 {
+		return nil, smb.StatusInvalidParameter
+	}
+	access, disposition, options := get32(b[24:]), get32(b[36:]), get32(b[40:])
+	off, n := int(get16(b[44:])), int(get16(b[46:]))
+	if off+n > len(raw) {
+		return nil, smb.StatusInvalidParameter
+	}
+	name := strings.ReplaceAll(fromUTF16le(raw[off:off+n]), `\`, "/")
+	p, err := c.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Dir(p)); err != nil {
+		return nil, smb.StatusObjectPathNotFound
+	}
+	wantDir := options&0x1 != 0
+	fi, err := os.Stat(p)
+	exists := err == nil
+	switch {
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	case exists && disposition == 2: // CREATE
+		return nil, smb.StatusObjectNameCollision
+	case !exists && disposition == 1: // OPEN
+		return nil, smb.StatusObjectNameNotFound
+	case exists && wantDir && !fi.IsDir():
+		return nil, smb.StatusNotADirectory
+	case exists && options&0x40 != 0 && fi.IsDir():
+		return nil, smb.StatusFileIsADirectory
+	}
+
+	fl := &file{name: name, del: options&0x1000 != 0}
+	action := uint32(1) // opened
+	if !exists {
+		action = 2 // created
+		if wantDir {
+			err = os.Mkdir(p, 0o777)
+		} else {
+			err = os.WriteFile(p, nil, 0o666)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fi, err = os.Stat(p); err != nil {
+		return nil, err
+	}
+	if fl.dir = fi.IsDir(); !fl.dir {
+		flag := os.O_RDONLY
+		if access&0x6 != 0 { // write or append data
+			flag = os.O_RDWR
+			if exists && disposition == 5 { // OVERWRITE_IF
+				flag |= os.O_TRUNC
+				action = 3 // overwritten
+			}
+		}
+		if fl.f, err = os.OpenFile(p, flag, 0); err != nil {
+			return nil, err
+		}
+		if fi, err = fl.f.Stat(); err != nil {
+			fl.f.Close()
+			return nil, err
+		}
+	}
+	c.nextID++
+	id := c.nextID
+	c.files[id] = fl
+
+	r := make([]byte, 89)
+	put16(r, 89)
+	put32(r[4:], action)
+	mtime := filetime(fi.ModTime())
+	for _, at := range []int{8, 16, 24, 32} {
+		put64(r[at:], mtime)
+	}
+	put64(r[40:], size(fi))
+	put64(r[48:], size(fi))
+	put32(r[56:], attrs(fi))
+	put64(r[64:], id)
+	put64(r[72:], id)
+	return r, nil
+}
+
+// fileID returns the open file the FileId at b names.
+func (c *conn) fileID(b []byte) (uint64, *file, error) {
+	id := get64(b[8:])
+	f := c.files[id]
+	if f == nil || get64(b) != id {
+		return 0, nil, smb.StatusFileClosed
+	}
+	return id, f, nil
+}
+
+func (c *conn) close(b []byte) ([]byte, error) {
+	if len(b) < 24 {
+		return nil, smb.StatusInvalidParameter
+	}
+	id, f, err := c.fileID(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	delete(c.files, id)
+	if f.f != nil {
+		f.f.Close()
+	}
+	if f.del {
+		p, _ := c.path(f.name)
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	r := make([]byte, 60)
+	put16(r, 60)
+	return r, nil
+}
+
+func (c *conn) read(b []byte) ([]byte, error) {
+	if len(b) < 48 {
+		return nil, smb.StatusInvalidParameter
+	}
+	_, f, err := c.fileID(b[16:])
+	if err != nil {
+		return nil, err
+	}
+	if f.f == nil {
+		return nil, smb.StatusFileIsADirectory
+	}
+	buf := make([]byte, min(get32(b[4:]), 1<<20))
+	n, err := f.f.ReadAt(buf, int64(get64(b[8:])))
+	if n == 0 {
+		if err == io.EOF || err == nil {
+			return nil, smb.StatusEndOfFile
+		}
+		return nil, err
+	}
+	r := make([]byte, 16, 16+n)
+	put16(r, 17)
+	r[2] = 64 + 16
+	put32(r[4:], uint32(n))
+	return append(r, buf[:n]...), nil
+}
+
+func (c *conn) write(b, raw []byte) ([]byte, error) {
+	if len(b) < 48 {
+		return nil, smb.StatusInvalidParameter
+	}
+	_, f, err := c.fileID(b[16:])
+	if err != nil {
+		return nil, err
+	}
+	off, n := int(get16(b[2:])), int(get32(b[4:]))
+	if off+n > len(raw) {
+		return nil, smb.StatusInvalidParameter
+	}
+	if f.f == nil {
+		return nil, smb.StatusFileIsADirectory
+	}
+	if _, err := f.f.WriteAt(raw[off:off+n], int64(get64(b[8:]))); err != nil {
+		return nil, err
+	}
+	r := make([]byte, 17)
+	put16(r, 17)
+	put32(r[4:], uint32(n))
+	return r, nil
+}
+
+func (c *conn) queryDirectory(b []byte) ([]byte, error) {
+	if len(b) < 32 {
+		return nil, smb.StatusInvalidParameter
+	}
+	if b[2] != 1 { // FileDirectoryInformation
+		return nil, smb.StatusNotSupported
+	}
+	_, f, err := c.fileID(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	if !f.dir {
+		return nil, smb.StatusNotADirectory
+	}
+	p, _ := c.path(f.name)
+	if f.entries == nil || b[3]&0x1 != 0 { // restart
+		des, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = []string{".", ".."}
+		for _, de := range des {
+			f.entries = append(f.entries, de.Name())
+		}
+		sort.Strings(f.entries[2:])
+	}
+	if len(f.entries) == 0 {
+		return nil, smb.StatusNoMoreFiles
+	}
+	limit := int(get32(b[28:]))
+	var out []byte
+	last := -1
+	for len(f.entries) > 0 {
+		fi, err := os.Stat(filepath.Join(p, f.entries[0]))
+		if err != nil {
+			f.entries = f.entries[1:] // gone since the listing
+			continue
+		}
+		name := utf16le(f.entries[0])
+		e := make([]byte, 64, 64+len(name)+8)
+		mtime := filetime(fi.ModTime())
+		for _, at := range []int{8, 16, 24, 32} {
+			put64(e[at:], mtime)
+		}
+		put64(e[40:], size(fi))
+		put64(e[48:], size(fi))
+		put32(e[56:], attrs(fi))
+		put32(e[60:], uint32(len(name)))
+		e = append(e, name...)
+		pad := (8 - len(out)%8) % 8
+		if len(out)+pad+len(e) > limit {
+			break
+		}
+		out = append(out, make([]byte, pad)...)
+		if last >= 0 {
+			put32(out[last:], uint32(len(out)-last))
+		}
+		last = len(out)
+		out = append(out, e...)
+		f.entries = f.entries[1:]
+	}
+	if last < 0 {
+		return nil, smb.Status(0x80000005) // STATUS_BUFFER_OVERFLOW
+	}
+	r := make([]byte, 8, 8+len(out))
+	put16(r, 9)
+	put16(r[2:], 64+8)
+	put32(r[4:], uint32(len(out)))
+	return append(r, out...), nil
+}
+
+func (c *conn) queryInfo(b []byte) ([]byte, error) {
+	if len(b) < 40 {
+		return nil, smb.StatusInvalidParameter
+	}
+	if _, _, err := c.fileID(b[24:]); err != nil {
+		return nil, err
+	}
+	if b[2] != 2 || b[3] != 7 { // FileFsFullSizeInformation
+		return nil, smb.StatusNotSupported
+	}
+	const unit = 4096
+	info := make([]byte, 32)
+	put64(info, uint64(c.srv.free/unit)*2)
+	put64(info[8:], uint64(c.srv.free/unit))
+	put64(info[16:], uint64(c.srv.free/unit))
+	put32(info[24:], 8)
+	put32(info[28:], unit/8)
+	r := make([]byte, 8, 40)
+	put16(r, 9)
+	put16(r[2:], 64+8)
+	put32(r[4:], uint32(len(info)))
+	return append(r, info...), nil
+}
+
+func (c *conn) setInfo(b, raw []byte) ([]byte, error) {
+	if len(b) < 32 {
+		return nil, smb.StatusInvalidParameter
+	}
+	if b[2] != 1 || b[3] != 10 { // FileRenameInformation
+		return nil, smb.StatusNotSupported
+	}
+	n, off := int(get32(b[4:])), int(get16(b[8:]))
+	if off+n > len(raw) || n < 20 {
+		return nil, smb.StatusInvalidParameter
+	}
+	info := raw[off : off+n]
+	ln := int(get32(info[16:]))
+	if 20+ln > len(info) {
+		return nil, smb.StatusInvalidParameter
+	}
+	_, f, err := c.fileID(b[16:])
+	if err != nil {
+		return nil, err
+	}
+	to := strings.ReplaceAll(fromUTF16le(info[20:20+ln]), `\`, "/")
+	from, _ := c.path(f.name)
+	p, err := c.path(to)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(p); err == nil && info[0] == 0 {
+		return nil, smb.StatusObjectNameCollision
+	}
+	if _, err := os.Stat(filepath.Dir(p)); err != nil {
+		return nil, smb.StatusObjectPathNotFound
+	}
+	if err := os.Rename(from, p); err != nil {
+		return nil, err
+	}
+	f.name = to
+	return []byte{2, 0}, nil
+}
+
+// status maps a local error to the status a Windows server would send.
+func status(err error) smb.Status {
+	var st smb.Status
+	switch {
+	case errors.As(err, &st):
+		return st
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return smb.StatusDirectoryNotEmpty
+	case errors.Is(err, fs.ErrNotExist):
+		return smb.StatusObjectNameNotFound
+	case errors.Is(err, fs.ErrExist):
+		return smb.StatusObjectNameCollision
+	case errors.Is(err, fs.ErrPermission):
+		return smb.StatusAccessDenied
+	case errors.Is(err, syscall.ENOSPC):
+		return smb.StatusDiskFull
+	}
+	return smb.Status(0xc0000001) // STATUS_UNSUCCESSFUL
+}
+
+func attrs(fi fs.FileInfo) uint32 {
+	if fi.IsDir() {
+		return 0x10
+	}
+	return 0x80
+}
+
+// size is the file size a Windows server reports, 0 for directories.
+func size(fi fs.FileInfo) uint64 {
+	if fi.IsDir() {
+		return 0
+	}
+	return uint64(fi.Size())
+}
+
+func hash(h, msg []byte) []byte {
+	s := sha512.New()
+	s.Write(h)
+	s.Write(msg)
+	return s.Sum(nil)
+}
+
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func fromUTF16le(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = get16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func get16(b []byte) uint16    { return binary.LittleEndian.Uint16(b) }
+func get32(b []byte) uint32    { return binary.LittleEndian.Uint32(b) }
+func get64(b []byte) uint64    { return binary.LittleEndian.Uint64(b) }
+func put16(b []byte, v uint16) { binary.LittleEndian.PutUint16(b, v) }
+func put32(b []byte, v uint32) { binary.LittleEndian.PutUint32(b, v) }
+func put64(b []byte, v uint64) { binary.LittleEndian.PutUint64(b, v) }
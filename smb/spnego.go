@@ -0,0 +1,107 @@
+package smb
+
+import (
+	"bytes"
+	"errors"
+)
+
+// SMB wraps its NTLM messages in SPNEGO (RFC 4178), DER-encoded. Only
+// NTLM is offered, so the server never picks another mechanism and no
+// mechListMIC is needed.
+
+var (
+	oidSPNEGO = []byte{0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLM   = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+// spnegoInit wraps the NTLM negotiate message in a NegTokenInit.
+func spnegoInit(tok []byte) []byte {
+	mechs := der(0xa0, der(0x30, der(0x06, oidNTLM)))
+	return der(0x60, der(0x06, oidSPNEGO), der(0xa0, der(0x30, mechs, der(0xa2, der(0x04, tok)))))
+}
+
+// spnegoResp wraps the NTLM authenticate message in a NegTokenResp.
+func spnegoResp(tok []byte) []byte {
+	return der(0xa1, der(0x30, der(0xa2, der(0x04, tok))))
+}
+
+// spnegoToken returns the mechanism token of the server's NegTokenResp,
+// nil if it has none. A bare NTLM message is taken as it is.
+func spnegoToken(b []byte) ([]byte, error) {
+	if bytes.HasPrefix(b, []byte("NTLMSSP\x00")) {
+		return b, nil
+	}
+	tag, v, _, err := derNext(b)
+	if err != nil || tag != 0xa1 {
+		return nil, errMalformed
+	}
+	if tag, v, _, err = derNext(v); err != nil || tag != 0x30 {
+		return nil, errMalformed
+	}
+	var tok []byte
+	for len(v) > 0 {
+		var elem []byte
+		if tag, elem, v, err = derNext(v); err != nil {
+			return nil, errMalformed
+		}
+		switch tag {
+		case 0xa0: // negState
+			if _, st, _, err := derNext(elem); err != nil || len(st) != 1 {
+				return nil, errMalformed
+			} else if st[0] == 2 {
+				return nil, errors.New("smb: the server rejected NTLM authentication")
+			}
+		case 0xa2: // responseToken
+			if tag, tok, _, err = derNext(elem); err != nil || tag != 0x04 {
+				return nil, errMalformed
+			}
+		}
+	}
+	return tok, nil
+}
+
+// der encodes a DER element of tag holding the concatenated contents.
+func der(tag byte, contents ...[]byte) []byte {
+	n := 0
+	for _, c := range contents {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range contents {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// derNext splits the first DER element off b.
+func derNext(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n >= 0x80 {
+		k := n & 0x7f
+		if k == 0 || k > 3 || len(b) < k {
+			return 0, nil, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[:k] {
+			n = n<<8 | int(c)
+		}
+		b = b[k:]
+	}
+	if n > len(b) {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
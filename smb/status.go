@@ -0,0 +1,118 @@
+package smb
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Status is an NTSTATUS code a server failed a request with. errors.Is
+// matches the usual ones against fs.ErrNotExist, fs.ErrExist and
+// fs.ErrPermission.
+type Status uint32
+
+// Status codes with a meaning to callers.
+const (
+	StatusSuccess                 Status = 0x00000000
+	StatusPending                 Status = 0x00000103
+	StatusNoMoreFiles             Status = 0x80000006
+	StatusInvalidParameter        Status = 0xc000000d
+	StatusNoSuchFile              Status = 0xc000000f
+	StatusEndOfFile               Status = 0xc0000011
+	StatusMoreProcessingRequired  Status = 0xc0000016
+	StatusAccessDenied            Status = 0xc0000022
+	StatusObjectNameInvalid       Status = 0xc0000033
+	StatusObjectNameNotFound      Status = 0xc0000034
+	StatusObjectNameCollision     Status = 0xc0000035
+	StatusObjectPathNotFound      Status = 0xc000003a
+	StatusSharingViolation        Status = 0xc0000043
+	StatusDeletePending           Status = 0xc0000056
+	StatusLogonFailure            Status = 0xc000006d
+	StatusAccountRestriction      Status = 0xc000006e
+	StatusPasswordExpired         Status = 0xc0000071
+	StatusAccountDisabled         Status = 0xc0000072
+	StatusDiskFull                Status = 0xc000007f
+	StatusFileIsADirectory        Status = 0xc00000ba
+	StatusNotSupported            Status = 0xc00000bb
+	StatusBadNetworkName          Status = 0xc00000cc
+	StatusDirectoryNotEmpty       Status = 0xc0000101
+	StatusNotADirectory           Status = 0xc0000103
+	StatusCancelled               Status = 0xc0000120
+	StatusUserSessionDeleted      Status = 0xc0000203
+	StatusNetworkSessionExpired   Status = 0xc000035c
+	StatusAccountLockedOut        Status = 0xc0000234
+	StatusPasswordMustChange      Status = 0xc0000224
+	StatusFileClosed              Status = 0xc0000128
+	StatusNetworkNameDeleted      Status = 0xc00000c9
+	StatusInsufficientResources   Status = 0xc000009a
+	StatusRequestNotAccepted      Status = 0xc00000d0
+	StatusInvalidDeviceRequest    Status = 0xc0000010
+	StatusNotSameDevice           Status = 0xc00000d4
+	StatusObjectPathSyntaxBad     Status = 0xc000003b
+	StatusCannotDelete            Status = 0xc0000121
+	StatusFileNotAvailable        Status = 0xc0000467
+	StatusInvalidNetworkResponse  Status = 0xc00000c3
+	StatusAccessDeniedNetworkPath Status = 0xc00000ca
+)
+
+var statusNames = map[Status]string{
+	StatusSuccess:                 "STATUS_SUCCESS",
+	StatusPending:                 "STATUS_PENDING",
+	StatusNoMoreFiles:             "STATUS_NO_MORE_FILES",
+	StatusInvalidParameter:        "STATUS_INVALID_PARAMETER",
+	StatusNoSuchFile:              "STATUS_NO_SUCH_FILE",
+	StatusEndOfFile:               "STATUS_END_OF_FILE",
+	StatusMoreProcessingRequired:  "STATUS_MORE_PROCESSING_REQUIRED",
+	StatusAccessDenied:            "STATUS_ACCESS_DENIED",
+	StatusObjectNameInvalid:       "STATUS_OBJECT_NAME_INVALID",
+	StatusObjectNameNotFound:      "STATUS_OBJECT_NAME_NOT_FOUND",
+	StatusObjectNameCollision:     "STATUS_OBJECT_NAME_COLLISION",
+	StatusObjectPathNotFound:      "STATUS_OBJECT_PATH_NOT_FOUND",
+	StatusSharingViolation:        "STATUS_SHARING_VIOLATION",
+	StatusDeletePending:           "STATUS_DELETE_PENDING",
+	StatusLogonFailure:            "STATUS_LOGON_FAILURE",
+	StatusAccountRestriction:      "STATUS_ACCOUNT_RESTRICTION",
+	StatusPasswordExpired:         "STATUS_PASSWORD_EXPIRED",
+	StatusAccountDisabled:         "STATUS_ACCOUNT_DISABLED",
+	StatusDiskFull:                "STATUS_DISK_FULL",
+	StatusFileIsADirectory:        "STATUS_FILE_IS_A_DIRECTORY",
+	StatusNotSupported:            "STATUS_NOT_SUPPORTED",
+	StatusBadNetworkName:          "STATUS_BAD_NETWORK_NAME",
+	StatusDirectoryNotEmpty:       "STATUS_DIRECTORY_NOT_EMPTY",
+	StatusNotADirectory:           "STATUS_NOT_A_DIRECTORY",
+	StatusCancelled:               "STATUS_CANCELLED",
+	StatusUserSessionDeleted:      "STATUS_USER_SESSION_DELETED",
+	StatusNetworkSessionExpired:   "STATUS_NETWORK_SESSION_EXPIRED",
+	StatusAccountLockedOut:        "STATUS_ACCOUNT_LOCKED_OUT",
+	StatusPasswordMustChange:      "STATUS_PASSWORD_MUST_CHANGE",
+	StatusFileClosed:              "STATUS_FILE_CLOSED",
+	StatusNetworkNameDeleted:      "STATUS_NETWORK_NAME_DELETED",
+	StatusInsufficientResources:   "STATUS_INSUFFICIENT_RESOURCES",
+	StatusRequestNotAccepted:      "STATUS_REQUEST_NOT_ACCEPTED",
+	StatusInvalidDeviceRequest:    "STATUS_INVALID_DEVICE_REQUEST",
+	StatusNotSameDevice:           "STATUS_NOT_SAME_DEVICE",
+	StatusObjectPathSyntaxBad:     "STATUS_OBJECT_PATH_SYNTAX_BAD",
+	StatusCannotDelete:            "STATUS_CANNOT_DELETE",
+	StatusFileNotAvailable:        "STATUS_FILE_NOT_AVAILABLE",
+	StatusInvalidNetworkResponse:  "STATUS_INVALID_NETWORK_RESPONSE",
+	StatusAccessDeniedNetworkPath: "STATUS_NETWORK_ACCESS_DENIED",
+}
+
+func (s Status) Error() string {
+	if n, ok := statusNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("NTSTATUS %#08x", uint32(s))
+}
+
+// Is maps the status onto the fs errors callers check for.
+func (s Status) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return s == StatusObjectNameNotFound || s == StatusObjectPathNotFound || s == StatusNoSuchFile
+	case fs.ErrExist:
+		return s == StatusObjectNameCollision
+	case fs.ErrPermission:
+		return s == StatusAccessDenied || s == StatusAccessDeniedNetworkPath
+	}
+	return false
+}
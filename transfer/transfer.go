@@ -0,0 +1,166 @@
+// Package transfer moves disk images: readers that stop when a context is
+// cancelled or read ahead of the writes, and a block-level delta sync that
+// rewrites only what changed in an image staged before.
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ContextReader returns a reader that fails with ctx's error once ctx is
+// done, so a long copy stops at its next read.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader { return ctxReader{ctx, r} }
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// DeltaStats counts the blocks a DeltaSync compared and rewrote.
+type DeltaStats struct{ Changed, Total int64 }
+
+// Target is what DeltaSync updates, typically an *os.File opened O_RDWR.
+type Target interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+}
+
+// DeltaSync brings dst in line with src by comparing both block by block
+// and rewriting only the blocks that differ, then truncating dst to the
+// source length. An interrupted sync leaves dst partly updated; running it
+// again picks up from there.
+func DeltaSync(src io.Reader, dst Target, bs int) (DeltaStats, error) {
+	var st DeltaStats
+	if bs <= 0 {
+		return st, fmt.Errorf("invalid block size %d", bs)
+	}
+	sbuf, dbuf := make([]byte, bs), make([]byte, bs)
+	var off int64
+	for {
+		n, err := io.ReadFull(src, sbuf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return st, err
+		}
+		m, derr := dst.ReadAt(dbuf[:n], off)
+		if derr != nil && derr != io.EOF {
+			return st, derr
+		}
+		st.Total++
+		if m != n || !bytes.Equal(sbuf[:n], dbuf[:n]) {
+			if _, err := dst.WriteAt(sbuf[:n], off); err != nil {
+				return st, err
+			}
+			st.Changed++
+		}
+		off += int64(n)
+		if n < bs {
+			break
+		}
+	}
+	return st, dst.Truncate(off)
+}
+
+// ReadAhead returns a reader that reads r in the background in chunks of
+// size bytes, up to ahead bytes before they are asked for, so that a slow
+// source and a slow destination overlap rather than take turns. Close
+// stops it once the current read of r returns; r is not closed.
+func ReadAhead(r io.Reader, size, ahead int) io.ReadCloser {
+	n := max(ahead/size, 1)
+	ra := &readAhead{chunks: make(chan chunk, n), free: make(chan []byte, n+1), done: make(chan struct{})}
+	go ra.fill(r, size)
+	return ra
+}
+
+type chunk struct {
+	b   []byte
+	err error
+}
+
+type readAhead struct {
+	chunks chan chunk
+	free   chan []byte // buffers read out, for reuse
+	done   chan struct{}
+	once   sync.Once
+	cur    []byte // what is left of the chunk being read out
+	buf    []byte // its buffer
+	err    error
+}
+
+func (ra *readAhead) fill(r io.Reader, size int) {
+	defer close(ra.chunks)
+	for {
+		var b []byte
+		select {
+		case b = <-ra.free:
+		default:
+			b = make([]byte, size)
+		}
+		n, err := io.ReadFull(r, b)
+		if n > 0 && !ra.send(chunk{b: b[:n]}) {
+			return
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			ra.send(chunk{err: err})
+			return
+		}
+	}
+}
+
+func (ra *readAhead) send(c chunk) bool {
+	select {
+	case ra.chunks <- c:
+		return true
+	case <-ra.done:
+		return false
+	}
+}
+
+func (ra *readAhead) Read(p []byte) (int, error) {
+	for len(ra.cur) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		if ra.buf != nil {
+			select {
+			case ra.free <- ra.buf[:cap(ra.buf)]:
+			default:
+			}
+			ra.buf = nil
+		}
+		c, ok := <-ra.chunks
+		switch {
+		case !ok:
+			ra.err = io.EOF
+		case c.err != nil:
+			ra.err = c.err
+		default:
+			ra.cur, ra.buf = c.b, c.b
+		}
+	}
+	n := copy(p, ra.cur)
+	ra.cur = ra.cur[n:]
+	return n, nil
+}
+
+func (ra *readAhead) Close() error {
+	ra.once.Do(func() { close(ra.done) })
+	return nil
+}
@@ -0,0 +1,111 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// target returns a file holding data, for DeltaSync to update.
+func target(t *testing.T, data []byte) *os.File {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "dst")
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func content(t *testing.T, f *os.File) []byte {
+	t.Helper()
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDeltaSync(t *testing.T) {
+	src := bytes.Repeat([]byte("0123456789abcdef"), 64) // 1 KiB, 4 blocks of 256
+	changed := bytes.Clone(src)
+	changed[300] = 'x'
+	for _, tc := range []struct {
+		name          string
+		dst           []byte
+		changed, want int64
+	}{
+		{"identical", src, 0, 4},
+		{"one block differs", changed, 1, 4},
+		{"empty target", nil, 4, 4},
+		{"target shorter", src[:600], 2, 4},
+		{"target longer", append(bytes.Clone(src), "tail"...), 0, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := target(t, tc.dst)
+			st, err := DeltaSync(bytes.NewReader(src), f, 256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Changed != tc.changed || st.Total != tc.want {
+				t.Errorf("stats %+v, want %d changed of %d", st, tc.changed, tc.want)
+			}
+			if !bytes.Equal(content(t, f), src) {
+				t.Error("target differs from the source after the sync")
+			}
+		})
+	}
+}
+
+func TestDeltaSyncPartialLastBlock(t *testing.T) {
+	src := bytes.Repeat([]byte{7}, 1000)
+	f := target(t, bytes.Repeat([]byte{7}, 768))
+	st, err := DeltaSync(bytes.NewReader(src), f, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Changed != 1 || st.Total != 4 {
+		t.Errorf("stats %+v, want 1 changed of 4", st)
+	}
+	if !bytes.Equal(content(t, f), src) {
+		t.Error("target differs from the source after the sync")
+	}
+}
+
+func TestDeltaSyncBadBlockSize(t *testing.T) {
+	if _, err := DeltaSync(bytes.NewReader(nil), target(t, nil), 0); err == nil {
+		t.Error("block size 0 accepted")
+	}
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := ContextReader(ctx, bytes.NewReader([]byte("data")))
+	cancel()
+	if _, err := r.Read(make([]byte, 4)); !errors.Is(err, context.Canceled) {
+		t.Errorf("read after cancel: %v", err)
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	src := bytes.Repeat([]byte("abcdefgh"), 10000)
+	ra := ReadAhead(bytes.NewReader(src), 1000, 4000)
+	got, err := io.ReadAll(ra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("read %d bytes differing from the source", len(got))
+	}
+	if err := ra.Close(); err != nil {
+		t.Error(err)
+	}
+}